@@ -0,0 +1,193 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// AuthorizationDetailsError reports authorization details that are malformed, of an unregistered type,
+// do not match the schema of their type or were not granted. It maps to the invalid_authorization_details
+// error of RFC 9396.
+type AuthorizationDetailsError struct {
+	Description string
+}
+
+func (e *AuthorizationDetailsError) Error() string {
+	return "invalid_authorization_details: " + e.Description
+}
+
+// authorizationDetailsError returns an AuthorizationDetailsError with a formatted description.
+func authorizationDetailsError(format string, args ...interface{}) *AuthorizationDetailsError {
+	return &AuthorizationDetailsError{Description: fmt.Sprintf(format, args...)}
+}
+
+// AuthorizationDetail is one entry of the authorization_details parameter of RFC 9396, as decoded
+// by encoding/json. Every entry has a type; the other fields are described by the schema of the type.
+type AuthorizationDetail map[string]interface{}
+
+// Type returns the type of the entry.
+func (d AuthorizationDetail) Type() string {
+	typ, _ := d["type"].(string)
+	return typ
+}
+
+// ParseAuthorizationDetails parses the authorization_details parameter: a JSON array of objects that
+// each have a type. An empty parameter yields no details.
+func ParseAuthorizationDetails(param string) ([]AuthorizationDetail, error) {
+	if strings.TrimSpace(param) == "" {
+		return nil, nil
+	}
+	var details []AuthorizationDetail
+	if err := json.Unmarshal([]byte(param), &details); err != nil {
+		return nil, authorizationDetailsError("authorization_details必须是JSON对象数组")
+	}
+	for i, detail := range details {
+		if detail == nil || detail.Type() == "" {
+			return nil, authorizationDetailsError("authorization_details[%d] 缺少type", i)
+		}
+	}
+	return details, nil
+}
+
+// NarrowAuthorizationDetails returns the grant data of a token issued from g for the requested details.
+// Each requested entry must equal a granted one, and an empty request restores all granted details,
+// as with Narrow for resources. A grant without details takes the requested ones, which the caller
+// validates against the registered types.
+func (g GrantData) NarrowAuthorizationDetails(requested []AuthorizationDetail) (GrantData, error) {
+	switch {
+	case len(g.AuthorizationDetails) == 0:
+		g.AuthorizationDetails = requested
+	case len(requested) == 0:
+		g.TokenDetails = nil
+	default:
+		for i, detail := range requested {
+			if !containsDetail(g.AuthorizationDetails, detail) {
+				return g, authorizationDetailsError("authorization_details[%d] 不在授权范围内", i)
+			}
+		}
+		g.TokenDetails = requested
+	}
+	return g, nil
+}
+
+// containsDetail reports whether details contain an entry equal to detail.
+func containsDetail(details []AuthorizationDetail, detail AuthorizationDetail) bool {
+	for _, d := range details {
+		if jsonEqual(d, detail) {
+			return true
+		}
+	}
+	return false
+}
+
+// AuthorizationDetailType is a registered type of authorization details. Schema is a JSON Schema,
+// of the subset compileSchema supports, that every entry of the type must match. The type field of an
+// entry is always allowed and need not be described by the schema.
+type AuthorizationDetailType struct {
+	Type        string          `json:"type"`
+	Description string          `json:"description"`
+	Schema      json.RawMessage `json:"schema"`
+}
+
+// AuthorizationDetailTypeStorage persists the registered authorization detail types, keyed by type.
+type AuthorizationDetailTypeStorage = RegistryStorage[AuthorizationDetailType]
+
+// AuthorizationDetailRegistry validates authorization details against the registered types.
+// Like ScopeRegistry it is cached in process for the configured expiry, with the schemas compiled.
+type AuthorizationDetailRegistry struct {
+	*cachedRegistry[AuthorizationDetailType, compiledDetailType]
+}
+
+// compiledDetailType is a registered type with its compiled schema.
+type compiledDetailType struct {
+	AuthorizationDetailType
+	schema *jsonSchema
+}
+
+// NewAuthorizationDetailRegistry returns a registry reading types from types and caching them for expiry.
+func NewAuthorizationDetailRegistry(types AuthorizationDetailTypeStorage, expiry time.Duration) (*AuthorizationDetailRegistry, error) {
+	registry, err := newCachedRegistry("authorization_detail_type", types, expiry, detailTypeName, compileDetailType, checkDetailType)
+	if err != nil {
+		return nil, err
+	}
+	return &AuthorizationDetailRegistry{registry}, nil
+}
+
+// detailTypeName returns the key of detailType.
+func detailTypeName(detailType AuthorizationDetailType) string {
+	return detailType.Type
+}
+
+// checkDetailType checks the schema of a type to register. A type without a schema accepts any fields.
+func checkDetailType(detailType AuthorizationDetailType) (AuthorizationDetailType, error) {
+	if detailType.Type == "" {
+		return detailType, authorizationDetailsError("类型不能为空")
+	}
+	if len(detailType.Schema) == 0 {
+		detailType.Schema = json.RawMessage(`{}`)
+	}
+	if _, err := compileSchema(detailType.Schema); err != nil {
+		return detailType, authorizationDetailsError("类型 %s 的schema不合法: %v", detailType.Type, err)
+	}
+	return detailType, nil
+}
+
+// compileDetailType compiles the schema of a registered type.
+func compileDetailType(detailType AuthorizationDetailType) (compiledDetailType, error) {
+	schema, err := compileSchema(detailType.Schema)
+	if err != nil {
+		return compiledDetailType{}, fmt.Errorf("授权详情类型 %s 的schema不合法: %v", detailType.Type, err)
+	}
+	return compiledDetailType{AuthorizationDetailType: detailType, schema: schema}, nil
+}
+
+// Lookup returns the registered types by type.
+func (r *AuthorizationDetailRegistry) Lookup(ctx context.Context) (map[string]AuthorizationDetailType, error) {
+	compiled, err := r.lookup(ctx)
+	if err != nil {
+		return nil, err
+	}
+	types := make(map[string]AuthorizationDetailType, len(compiled))
+	for typ, detailType := range compiled {
+		types[typ] = detailType.AuthorizationDetailType
+	}
+	return types, nil
+}
+
+// Validate checks that every entry is of a registered type and matches the schema of its type.
+func (r *AuthorizationDetailRegistry) Validate(ctx context.Context, details []AuthorizationDetail) error {
+	if len(details) == 0 {
+		return nil
+	}
+	compiled, err := r.lookup(ctx)
+	if err != nil {
+		return err
+	}
+	for i, detail := range details {
+		detailType, ok := compiled[detail.Type()]
+		if !ok {
+			return authorizationDetailsError("未注册的授权详情类型 %s", detail.Type())
+		}
+		fields := make(map[string]interface{}, len(detail))
+		for name, value := range detail {
+			if name != "type" {
+				fields[name] = value
+			}
+		}
+		if err := detailType.schema.validate(fmt.Sprintf("authorization_details[%d]", i), fields); err != nil {
+			return authorizationDetailsError("%v", err)
+		}
+	}
+	return nil
+}
+
+// NewMemoryAuthorizationDetailTypeStorage returns an empty in-memory type registry.
+func NewMemoryAuthorizationDetailTypeStorage() *MemoryRegistryStorage[AuthorizationDetailType] {
+	return newMemoryRegistryStorage(detailTypeName, func(detailType AuthorizationDetailType) AuthorizationDetailType {
+		detailType.Schema = append(json.RawMessage(nil), detailType.Schema...)
+		return detailType
+	})
+}
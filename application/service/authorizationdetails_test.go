@@ -0,0 +1,147 @@
+package service_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"oauth2/application/service"
+)
+
+const paymentSchema = `{
+	"type": "object",
+	"required": ["instructedAmount", "creditorAccount"],
+	"additionalProperties": false,
+	"properties": {
+		"actions": {"type": "array", "items": {"type": "string", "enum": ["initiate", "status"]}, "minItems": 1},
+		"instructedAmount": {
+			"type": "object",
+			"required": ["currency", "amount"],
+			"properties": {
+				"currency": {"type": "string", "pattern": "^[A-Z]{3}$"},
+				"amount": {"type": "number", "minimum": 0.01, "maximum": 10000}
+			}
+		},
+		"creditorAccount": {"type": "object", "properties": {"iban": {"type": "string", "minLength": 15}}}
+	}
+}`
+
+func TestAuthorizationDetailRegistry(t *testing.T) {
+	ctx := context.Background()
+	registry, err := service.NewAuthorizationDetailRegistry(service.NewMemoryAuthorizationDetailTypeStorage(), time.Minute)
+	if err != nil {
+		t.Fatalf("NewAuthorizationDetailRegistry: %v", err)
+	}
+	if err := registry.Save(ctx, service.AuthorizationDetailType{Type: "payment_initiation", Schema: json.RawMessage(paymentSchema)}); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if err := registry.Save(ctx, service.AuthorizationDetailType{Type: "account_information"}); err != nil {
+		t.Fatalf("Save without a schema: %v", err)
+	}
+	// 不支持的关键字和不合法的正则在注册时拒绝
+	for _, schema := range []string{`{"oneOf": []}`, `{"type": "date"}`, `{"pattern": "("}`, `[]`} {
+		requireError[*service.AuthorizationDetailsError](t, registry.Save(ctx, service.AuthorizationDetailType{Type: "bad", Schema: json.RawMessage(schema)}))
+	}
+
+	parse := func(param string) []service.AuthorizationDetail {
+		t.Helper()
+		details, err := service.ParseAuthorizationDetails(param)
+		if err != nil {
+			t.Fatalf("ParseAuthorizationDetails(%s): %v", param, err)
+		}
+		return details
+	}
+	valid := parse(`[{"type":"payment_initiation","actions":["initiate"],
+		"instructedAmount":{"currency":"EUR","amount":123.5},"creditorAccount":{"iban":"DE02100100109307118603"}},
+		{"type":"account_information","anything":true}]`)
+	if err := registry.Validate(ctx, valid); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+
+	for _, param := range []string{
+		`[{"type":"unknown"}]`,
+		`[{"type":"payment_initiation","creditorAccount":{}}]`,
+		`[{"type":"payment_initiation","instructedAmount":{"currency":"euro","amount":1},"creditorAccount":{}}]`,
+		`[{"type":"payment_initiation","instructedAmount":{"currency":"EUR","amount":0},"creditorAccount":{}}]`,
+		`[{"type":"payment_initiation","instructedAmount":{"currency":"EUR","amount":1},"creditorAccount":{"iban":1}}]`,
+		`[{"type":"payment_initiation","instructedAmount":{"currency":"EUR","amount":1},"creditorAccount":{},"extra":1}]`,
+		`[{"type":"payment_initiation","instructedAmount":{"currency":"EUR","amount":1},"creditorAccount":{},"actions":["delete"]}]`,
+	} {
+		requireError[*service.AuthorizationDetailsError](t, registry.Validate(ctx, parse(param)))
+	}
+	for _, param := range []string{`{"type":"payment_initiation"}`, `[{"amount":1}]`, `[1]`} {
+		_, err := service.ParseAuthorizationDetails(param)
+		requireError[*service.AuthorizationDetailsError](t, err)
+	}
+
+	// 删除后立即失效
+	if err := registry.Remove(ctx, "account_information"); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	requireError[*service.AuthorizationDetailsError](t, registry.Validate(ctx, valid))
+}
+
+func TestGrantDataNarrowAuthorizationDetails(t *testing.T) {
+	payment := service.AuthorizationDetail{"type": "payment_initiation", "amount": 10.0}
+	account := service.AuthorizationDetail{"type": "account_information"}
+
+	grant, err := service.GrantData{UserId: "alice"}.NarrowAuthorizationDetails([]service.AuthorizationDetail{payment})
+	if err != nil || len(grant.AuthorizationDetails) != 1 || grant.UserOnly() {
+		t.Fatalf("NarrowAuthorizationDetails of a grant without details: %+v, %v", grant, err)
+	}
+
+	granted := service.GrantData{AuthorizationDetails: []service.AuthorizationDetail{payment, account}}
+	grant, err = granted.NarrowAuthorizationDetails([]service.AuthorizationDetail{{"type": "account_information"}})
+	if err != nil || !reflect.DeepEqual(grant.TokenAuthorizationDetails(), []service.AuthorizationDetail{account}) {
+		t.Fatalf("NarrowAuthorizationDetails(account): %+v, %v", grant, err)
+	}
+	if grant, err = grant.NarrowAuthorizationDetails(nil); err != nil || len(grant.TokenAuthorizationDetails()) != 2 {
+		t.Fatalf("NarrowAuthorizationDetails without a request: %+v, %v", grant, err)
+	}
+	// 金额不同的授权详情不在授权范围内
+	_, err = granted.NarrowAuthorizationDetails([]service.AuthorizationDetail{{"type": "payment_initiation", "amount": 20.0}})
+	requireError[*service.AuthorizationDetailsError](t, err)
+}
+
+func TestConsentSigner(t *testing.T) {
+	ctx := context.Background()
+	replay := service.NewReplayGuard(nil)
+	signer, err := service.NewConsentSigner("secret", time.Minute, replay)
+	if err != nil {
+		t.Fatalf("NewConsentSigner: %v", err)
+	}
+	params := url.Values{"client_id": {"app"}, "scope": {"payments"}, "resource": {"https://a.example.com", "https://b.example.com"}}
+	ticket := signer.Sign("alice", params)
+
+	// 被篡改或签名不符的请求不会占用票据
+	tampered := url.Values{"client_id": {"app"}, "scope": {"payments admin"}, "resource": params["resource"]}
+	if _, err := signer.Verify(ctx, ticket, tampered); !errors.Is(err, service.ErrConsentTicket) {
+		t.Fatalf("Verify with tampered params: %v", err)
+	}
+	other, _ := service.NewConsentSigner("other", time.Minute, replay)
+	if _, err := other.Verify(ctx, ticket, params); !errors.Is(err, service.ErrConsentTicket) {
+		t.Fatalf("Verify with another key: %v", err)
+	}
+
+	// 参数顺序不影响票据
+	reordered, _ := url.ParseQuery("scope=payments&resource=https://a.example.com&client_id=app&resource=https://b.example.com")
+	if userID, err := signer.Verify(ctx, ticket, reordered); err != nil || userID != "alice" {
+		t.Fatalf("Verify: %q, %v", userID, err)
+	}
+	// 票据只能使用一次，同一请求再次签发的票据不受影响
+	if _, err := signer.Verify(ctx, ticket, params); !errors.Is(err, service.ErrConsentTicket) {
+		t.Fatalf("Verify of a used ticket: %v", err)
+	}
+	if userID, err := signer.Verify(ctx, signer.Sign("alice", params), params); err != nil || userID != "alice" {
+		t.Fatalf("Verify of a new ticket: %q, %v", userID, err)
+	}
+
+	expired, _ := service.NewConsentSigner("secret", -time.Minute, replay)
+	if _, err := signer.Verify(ctx, expired.Sign("alice", params), params); !errors.Is(err, service.ErrConsentTicket) {
+		t.Fatalf("Verify of an expired ticket: %v", err)
+	}
+}
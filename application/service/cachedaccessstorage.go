@@ -0,0 +1,255 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/openshift/osin"
+	"github.com/zeromicro/go-zero/core/collection"
+	"github.com/zeromicro/go-zero/core/logx"
+	"github.com/zeromicro/go-zero/core/metric"
+)
+
+const cacheAccessKey = "access:%s"
+
+// AccessInvalidationChannel returns the pub/sub channel announcing the digests of revoked access tokens
+// of the deployment using tablePrefix.
+func AccessInvalidationChannel(tablePrefix string) string {
+	return cacheNamespace(tablePrefix) + "access:invalidate"
+}
+
+// metricAccessCache counts access token cache lookups by tier (local, redis) and result (hit, miss).
+var metricAccessCache = metric.NewCounterVec(&metric.CounterVecOpts{
+	Namespace: "oauth2",
+	Subsystem: "access_cache",
+	Name:      "lookups_total",
+	Help:      "access token validation cache lookups.",
+	Labels:    []string{"tier", "result"},
+})
+
+// CachedAccessStorage caches validated access tokens of the wrapped storage in process and in Redis.
+// An entry never outlives the token. RemoveAccess deletes both copies and broadcasts the token digest,
+// so that other replicas stop accepting a revoked token right away.
+// Clients are still resolved through the wrapped storage, so removed clients are rejected as before.
+type CachedAccessStorage struct {
+	OAuthStorage
+	rdb         *redis.Client
+	namespace   string
+	hasher      *TokenHasher
+	local       *collection.Cache
+	invalidator *Invalidator
+	expiry      time.Duration
+	localExpiry time.Duration
+	stop        func()
+}
+
+// NewCachedAccessStorage returns a token cache in front of storage. expiry bounds the Redis tier,
+// localExpiry and localLimit the in-process tier. Keys are namespaced by opts.TablePrefix.
+func NewCachedAccessStorage(storage OAuthStorage, rdb *redis.Client, invalidator *Invalidator, opts Options,
+	expiry, localExpiry time.Duration, localLimit int) (*CachedAccessStorage, error) {
+	local, err := collection.NewCache(localExpiry, collection.WithLimit(localLimit), collection.WithName("access"))
+	if err != nil {
+		return nil, err
+	}
+	return &CachedAccessStorage{
+		OAuthStorage: storage,
+		rdb:          rdb,
+		namespace:    cacheNamespace(opts.TablePrefix),
+		hasher:       opts.Hasher,
+		local:        local,
+		invalidator:  invalidator,
+		expiry:       expiry,
+		localExpiry:  localExpiry,
+	}, nil
+}
+
+// Unwrap returns the wrapped storage.
+func (s *CachedAccessStorage) Unwrap() OAuthStorage {
+	return s.OAuthStorage
+}
+
+// Start subscribes to revocations published by other replicas.
+func (s *CachedAccessStorage) Start() error {
+	stop, err := s.invalidator.Subscribe(context.Background(), func(key string) {
+		s.local.Del(key)
+	})
+	if err != nil {
+		return err
+	}
+	s.stop = stop
+	return nil
+}
+
+// Stop ends the revocation subscription.
+func (s *CachedAccessStorage) Stop() {
+	if s.stop != nil {
+		s.stop()
+	}
+}
+
+// LoadAccess retrieves access data by token, from the in-process cache, Redis or the wrapped storage in that order.
+func (s *CachedAccessStorage) LoadAccess(token string) (*osin.AccessData, error) {
+	key := s.hasher.Hash(token)
+
+	record, err := s.cached(key)
+	if err != nil {
+		return nil, err
+	}
+	if record == nil {
+		data, err := s.OAuthStorage.LoadAccess(token)
+		if err != nil {
+			return nil, err
+		}
+		s.store(key, data)
+		return data, nil
+	}
+
+	if record.expired(time.Now()) {
+		s.local.Del(key)
+		return nil, fmt.Errorf("访问令牌已过期")
+	}
+	data := record.accessData()
+	data.AccessToken = token
+	if data.Client, err = s.OAuthStorage.GetClient(record.ClientId); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// RemoveAccess revokes or deletes an AccessData and drops it from the caches of all replicas.
+func (s *CachedAccessStorage) RemoveAccess(token string) error {
+	if err := s.OAuthStorage.RemoveAccess(token); err != nil {
+		return err
+	}
+	return s.invalidate(context.Background(), s.hasher.Hash(token))
+}
+
+// RemoveRefresh revokes or deletes refresh AccessData together with the access token issued with it,
+// and drops that access token from the caches of all replicas.
+func (s *CachedAccessStorage) RemoveRefresh(token string) error {
+	_, err := s.RevokeRefresh(context.Background(), token)
+	return err
+}
+
+// RevokeRefresh revokes a refresh token and its access token in the wrapped storage and drops the access token
+// from the caches of all replicas.
+func (s *CachedAccessStorage) RevokeRefresh(ctx context.Context, token string) (RevokeResult, error) {
+	result, err := s.OAuthStorage.RevokeRefresh(ctx, token)
+	if err != nil {
+		return result, err
+	}
+	return result, s.invalidateRevoked(ctx, result)
+}
+
+// RevokeUserTokens revokes the tokens of a user in the wrapped storage and drops the revoked
+// access tokens from the caches of all replicas.
+func (s *CachedAccessStorage) RevokeUserTokens(ctx context.Context, userID, clientID string) (RevokeResult, error) {
+	result, err := s.OAuthStorage.RevokeUserTokens(ctx, userID, clientID)
+	if err != nil {
+		return result, err
+	}
+	return result, s.invalidateRevoked(ctx, result)
+}
+
+// RevokeUserGrant revokes one grant of a user in the wrapped storage and drops its access tokens
+// from the caches of all replicas.
+func (s *CachedAccessStorage) RevokeUserGrant(ctx context.Context, userID, grantID string) (RevokeResult, error) {
+	result, err := s.OAuthStorage.RevokeUserGrant(ctx, userID, grantID)
+	if err != nil {
+		return result, err
+	}
+	return result, s.invalidateRevoked(ctx, result)
+}
+
+// invalidateRevoked drops the access tokens of a revocation from the caches of all replicas.
+func (s *CachedAccessStorage) invalidateRevoked(ctx context.Context, result RevokeResult) error {
+	for _, key := range result.AccessTokens {
+		if err := s.invalidate(ctx, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// invalidate drops the access token digest key from both tiers and broadcasts it to the other replicas.
+func (s *CachedAccessStorage) invalidate(ctx context.Context, key string) error {
+	s.local.Del(key)
+	if err := s.rdb.Del(ctx, s.redisKey(key)).Err(); err != nil {
+		return fmt.Errorf("删除访问令牌缓存失败: %v", err)
+	}
+	if err := s.invalidator.Publish(ctx, key); err != nil {
+		// 其他副本的进程内缓存会在过期后自动失效
+		logx.Errorf("广播访问令牌失效失败: %v", err)
+	}
+	return nil
+}
+
+// redisKey returns the Redis key of the access token digest key.
+func (s *CachedAccessStorage) redisKey(key string) string {
+	return s.namespace + fmt.Sprintf(cacheAccessKey, key)
+}
+
+// cached returns the record of key from the in-process cache or Redis, or nil if neither has it.
+func (s *CachedAccessStorage) cached(key string) (*tokenRecord, error) {
+	if v, ok := s.local.Get(key); ok {
+		metricAccessCache.Inc("local", "hit")
+		return v.(*tokenRecord), nil
+	}
+	metricAccessCache.Inc("local", "miss")
+
+	value, err := s.rdb.Get(context.Background(), s.redisKey(key)).Bytes()
+	if err == redis.Nil {
+		metricAccessCache.Inc("redis", "miss")
+		return nil, nil
+	} else if err != nil {
+		// Redis不可用时回退到存储
+		logx.Errorf("读取访问令牌缓存失败: %v", err)
+		return nil, nil
+	}
+
+	var record tokenRecord
+	if err := json.Unmarshal(value, &record); err != nil {
+		return nil, fmt.Errorf("解析访问令牌缓存失败: %v", err)
+	}
+	metricAccessCache.Inc("redis", "hit")
+	if ttl := s.ttl(&record, s.localExpiry); ttl > 0 {
+		s.local.SetWithExpire(key, &record, ttl)
+	}
+	return &record, nil
+}
+
+// store caches data loaded from the wrapped storage in both tiers.
+func (s *CachedAccessStorage) store(key string, data *osin.AccessData) {
+	record := &tokenRecord{
+		ClientId:    data.Client.GetId(),
+		ExpiresIn:   data.ExpiresIn,
+		Scope:       data.Scope,
+		RedirectUri: data.RedirectUri,
+		Extra:       toString(data.UserData),
+		CreatedAt:   data.CreatedAt,
+	}
+
+	if ttl := s.ttl(record, s.expiry); ttl > 0 {
+		value, err := json.Marshal(record)
+		if err == nil {
+			err = s.rdb.Set(context.Background(), s.redisKey(key), value, ttl).Err()
+		}
+		if err != nil {
+			logx.Errorf("写入访问令牌缓存失败: %v", err)
+		}
+	}
+	if ttl := s.ttl(record, s.localExpiry); ttl > 0 {
+		s.local.SetWithExpire(key, record, ttl)
+	}
+}
+
+// ttl returns limit, shortened to the remaining lifetime of the token.
+func (s *CachedAccessStorage) ttl(record *tokenRecord, limit time.Duration) time.Duration {
+	if remaining := time.Until(record.CreatedAt.Add(time.Duration(record.ExpiresIn) * time.Second)); remaining < limit {
+		return remaining
+	}
+	return limit
+}
@@ -0,0 +1,201 @@
+package service_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"oauth2/application/service"
+	"oauth2/application/service/storagetest"
+
+	"github.com/alicebob/miniredis/v2"
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/openshift/osin"
+)
+
+func TestCachedAccessStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T, opts service.Options) service.OAuthStorage {
+		return newAccessCache(t, miniredis.RunT(t), service.NewMemoryStorage(opts), opts)
+	})
+}
+
+func TestCachedAccessStorageRevocation(t *testing.T) {
+	mr := miniredis.RunT(t)
+	opts := service.Options{Hasher: service.NewTokenHasher("")}
+	storage := service.NewMemoryStorage(opts)
+	first := newAccessCache(t, mr, storage, opts)
+	second := newAccessCache(t, mr, storage, opts)
+
+	client := &osin.DefaultClient{Id: "app", Secret: "secret", RedirectUri: "http://localhost"}
+	if err := storage.CreateClient(client); err != nil {
+		t.Fatalf("CreateClient: %v", err)
+	}
+	err := storage.SaveAccess(&osin.AccessData{Client: client, AccessToken: "token", ExpiresIn: 3600, CreatedAt: time.Now()})
+	if err != nil {
+		t.Fatalf("SaveAccess: %v", err)
+	}
+	if _, err := first.LoadAccess("token"); err != nil {
+		t.Fatalf("LoadAccess: %v", err)
+	}
+
+	// 绕过缓存删除令牌，另一个副本仍从Redis读到缓存
+	if err := storage.RemoveAccess("token"); err != nil {
+		t.Fatalf("RemoveAccess: %v", err)
+	}
+	if _, err := second.LoadAccess("token"); err != nil {
+		t.Fatalf("LoadAccess from the Redis tier: %v", err)
+	}
+
+	if err := first.RemoveAccess("token"); err != nil {
+		t.Fatalf("RemoveAccess: %v", err)
+	}
+	eventually(t, func() bool {
+		_, err := second.LoadAccess("token")
+		return err != nil
+	})
+}
+
+func TestCachedAccessStorageRevokeUser(t *testing.T) {
+	mr := miniredis.RunT(t)
+	opts := service.Options{Hasher: service.NewTokenHasher("")}
+	storage := service.NewMemoryStorage(opts)
+	first := newAccessCache(t, mr, storage, opts)
+	second := newAccessCache(t, mr, storage, opts)
+
+	client := &osin.DefaultClient{Id: "app", Secret: "secret", RedirectUri: "http://localhost"}
+	if err := storage.CreateClient(client); err != nil {
+		t.Fatalf("CreateClient: %v", err)
+	}
+	err := storage.SaveAccess(&osin.AccessData{Client: client, AccessToken: "token", ExpiresIn: 3600, CreatedAt: time.Now(), UserData: "alice"})
+	if err != nil {
+		t.Fatalf("SaveAccess: %v", err)
+	}
+	if _, err := second.LoadAccess("token"); err != nil {
+		t.Fatalf("LoadAccess: %v", err)
+	}
+
+	if _, err := first.RevokeUserTokens(context.Background(), "alice", ""); err != nil {
+		t.Fatalf("RevokeUserTokens: %v", err)
+	}
+	eventually(t, func() bool {
+		_, err := second.LoadAccess("token")
+		return err != nil
+	})
+}
+
+func TestCachedAccessStorageRemoveRefresh(t *testing.T) {
+	mr := miniredis.RunT(t)
+	opts := service.Options{Hasher: service.NewTokenHasher("")}
+	storage := service.NewMemoryStorage(opts)
+	first := newAccessCache(t, mr, storage, opts)
+	second := newAccessCache(t, mr, storage, opts)
+
+	client := &osin.DefaultClient{Id: "app", Secret: "secret", RedirectUri: "http://localhost"}
+	if err := storage.CreateClient(client); err != nil {
+		t.Fatalf("CreateClient: %v", err)
+	}
+	err := storage.SaveAccess(&osin.AccessData{Client: client, AccessToken: "token", RefreshToken: "refresh", ExpiresIn: 3600, CreatedAt: time.Now()})
+	if err != nil {
+		t.Fatalf("SaveAccess: %v", err)
+	}
+	if _, err := second.LoadAccess("token"); err != nil {
+		t.Fatalf("LoadAccess: %v", err)
+	}
+
+	// 刷新时删除旧的刷新令牌，同时签发的访问令牌也从各副本的缓存中清除
+	if err := first.RemoveRefresh("refresh"); err != nil {
+		t.Fatalf("RemoveRefresh: %v", err)
+	}
+	eventually(t, func() bool {
+		_, err := second.LoadAccess("token")
+		return err != nil
+	})
+}
+
+func TestCachedAccessStorageTTL(t *testing.T) {
+	mr := miniredis.RunT(t)
+	opts := service.Options{Hasher: service.NewTokenHasher("")}
+	storage := service.NewMemoryStorage(opts)
+	cached := newAccessCache(t, mr, storage, opts)
+
+	client := &osin.DefaultClient{Id: "app", Secret: "secret", RedirectUri: "http://localhost"}
+	if err := storage.CreateClient(client); err != nil {
+		t.Fatalf("CreateClient: %v", err)
+	}
+	// 剩余有效期约10秒，短于缓存有效期
+	err := storage.SaveAccess(&osin.AccessData{Client: client, AccessToken: "short", ExpiresIn: 70, CreatedAt: time.Now().Add(-time.Minute)})
+	if err != nil {
+		t.Fatalf("SaveAccess: %v", err)
+	}
+	if _, err := cached.LoadAccess("short"); err != nil {
+		t.Fatalf("LoadAccess: %v", err)
+	}
+
+	ttl := mr.TTL(fmt.Sprintf("oauth2:cache:access:%s", opts.Hasher.Hash("short")))
+	if ttl <= 0 || ttl > 10*time.Second {
+		t.Fatalf("cache ttl %v is not bounded by the remaining token lifetime", ttl)
+	}
+}
+
+func TestCachedAccessStorageTablePrefix(t *testing.T) {
+	mr := miniredis.RunT(t)
+	hasher := service.NewTokenHasher("")
+	caches := map[string]*service.CachedAccessStorage{}
+	for _, prefix := range []string{"a_", "b_"} {
+		opts := service.Options{TablePrefix: prefix, Hasher: hasher}
+		storage := service.NewMemoryStorage(opts)
+		client := &osin.DefaultClient{Id: prefix + "app", Secret: "secret", RedirectUri: "http://localhost"}
+		if err := storage.CreateClient(client); err != nil {
+			t.Fatalf("CreateClient: %v", err)
+		}
+		err := storage.SaveAccess(&osin.AccessData{Client: client, AccessToken: "token", ExpiresIn: 3600, CreatedAt: time.Now()})
+		if err != nil {
+			t.Fatalf("SaveAccess: %v", err)
+		}
+		caches[prefix] = newAccessCache(t, mr, storage, opts)
+	}
+
+	// 共用Redis的两套部署互不读取对方缓存的令牌
+	for _, prefix := range []string{"a_", "b_"} {
+		data, err := caches[prefix].LoadAccess("token")
+		if err != nil {
+			t.Fatalf("LoadAccess %s: %v", prefix, err)
+		}
+		if data.Client.GetId() != prefix+"app" {
+			t.Fatalf("LoadAccess %s returned client %s", prefix, data.Client.GetId())
+		}
+	}
+	keyB := "oauth2:cache:b:access:" + hasher.Hash("token")
+	if !mr.Exists("oauth2:cache:a:access:"+hasher.Hash("token")) || !mr.Exists(keyB) {
+		t.Fatalf("cache keys are not namespaced by table prefix: %v", mr.Keys())
+	}
+
+	if err := caches["a_"].RemoveAccess("token"); err != nil {
+		t.Fatalf("RemoveAccess: %v", err)
+	}
+	if !mr.Exists(keyB) {
+		t.Fatal("revocation in one deployment dropped the cache entry of the other")
+	}
+	if _, err := caches["b_"].LoadAccess("token"); err != nil {
+		t.Fatalf("LoadAccess after revocation in the other deployment: %v", err)
+	}
+}
+
+// newAccessCache returns a started access token cache in front of storage backed by mr.
+func newAccessCache(t *testing.T, mr *miniredis.Miniredis, storage service.OAuthStorage, opts service.Options) *service.CachedAccessStorage {
+	t.Helper()
+	rdb := goredis.NewClient(&goredis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+
+	cached, err := service.NewCachedAccessStorage(storage, rdb, service.NewInvalidator(rdb, service.AccessInvalidationChannel(opts.TablePrefix)),
+		opts, time.Minute, time.Minute, 100)
+	if err != nil {
+		t.Fatalf("NewCachedAccessStorage: %v", err)
+	}
+	if err := cached.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	t.Cleanup(cached.Stop)
+	return cached
+}
@@ -0,0 +1,185 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/openshift/osin"
+	"github.com/zeromicro/go-zero/core/collection"
+	"github.com/zeromicro/go-zero/core/logx"
+	"github.com/zeromicro/go-zero/core/stores/cache"
+)
+
+const cacheClientKey = "client:%s"
+
+// ClientInvalidationChannel returns the pub/sub channel announcing changed client ids
+// of the deployment using tablePrefix.
+func ClientInvalidationChannel(tablePrefix string) string {
+	return cacheNamespace(tablePrefix) + "client:invalidate"
+}
+
+// CachedClientStorage is a read-through cache in front of a ClientStorage.
+// Clients are cached in process and in Redis. Creating, updating or removing a client deletes
+// both copies and broadcasts the id, so that other replicas drop their in-process copy as well.
+// Redis only holds the digest of the client secret: clients restored from it match secrets
+// with ClientSecretMatches but return an empty GetSecret.
+type CachedClientStorage struct {
+	clients     ClientStorage
+	cache       cache.Cache
+	namespace   string
+	hasher      *TokenHasher
+	local       *collection.Cache
+	invalidator *Invalidator
+	stop        func()
+}
+
+// cachedClient is the Redis copy of a client, with the digest of the secret instead of the secret.
+type cachedClient struct {
+	Client
+	SecretDigest string `json:"secretDigest,omitempty"`
+}
+
+// NewCachedClientStorage returns a cache in front of clients. c must report osin.ErrNotFound for missing
+// clients; localExpiry and localLimit bound the in-process tier. Keys are namespaced by opts.TablePrefix
+// and secrets are digested with opts.Hasher.
+func NewCachedClientStorage(clients ClientStorage, c cache.Cache, invalidator *Invalidator, opts Options,
+	localExpiry time.Duration, localLimit int) (*CachedClientStorage, error) {
+	local, err := collection.NewCache(localExpiry, collection.WithLimit(localLimit), collection.WithName("client"))
+	if err != nil {
+		return nil, err
+	}
+	return &CachedClientStorage{
+		clients:     clients,
+		cache:       c,
+		namespace:   cacheNamespace(opts.TablePrefix),
+		hasher:      opts.Hasher,
+		local:       local,
+		invalidator: invalidator,
+	}, nil
+}
+
+// Start subscribes to invalidations published by other replicas.
+func (s *CachedClientStorage) Start() error {
+	stop, err := s.invalidator.Subscribe(context.Background(), func(id string) {
+		s.local.Del(id)
+	})
+	if err != nil {
+		return err
+	}
+	s.stop = stop
+	return nil
+}
+
+// Stop ends the invalidation subscription.
+func (s *CachedClientStorage) Stop() {
+	if s.stop != nil {
+		s.stop()
+	}
+}
+
+// GetClient loads the client by id, from the in-process cache, Redis or the wrapped storage in that order.
+func (s *CachedClientStorage) GetClient(id string) (osin.Client, error) {
+	v, err := s.local.Take(id, func() (any, error) {
+		var (
+			cached cachedClient
+			loaded *Client
+		)
+		err := s.cache.Take(&cached, s.redisKey(id), func(val any) error {
+			client, err := s.clients.GetClient(id)
+			if err != nil {
+				return err
+			}
+			loaded = AsClient(client)
+			*val.(*cachedClient) = s.digest(loaded)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		// 从存储读到的客户端在进程内保留明文密钥
+		if loaded != nil {
+			return loaded, nil
+		}
+		return s.restore(&cached), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// 返回副本，调用方修改客户端不影响缓存
+	return AsClient(v.(*Client)), nil
+}
+
+// Uncached returns a view of the storage that reads clients, with their secrets, from the wrapped storage
+// and still invalidates the caches on writes. Dynamic client registration uses it to return the secret.
+func (s *CachedClientStorage) Uncached() ClientStorage {
+	return uncachedClients{s}
+}
+
+// CreateClient stores the client. The cached not-found marker of the id is dropped.
+func (s *CachedClientStorage) CreateClient(c osin.Client) error {
+	if err := s.clients.CreateClient(c); err != nil {
+		return err
+	}
+	return s.invalidate(c.GetId())
+}
+
+// UpdateClient updates the client (identified by it's id) and replaces the values with the values of client.
+func (s *CachedClientStorage) UpdateClient(c osin.Client) error {
+	if err := s.clients.UpdateClient(c); err != nil {
+		return err
+	}
+	return s.invalidate(c.GetId())
+}
+
+// RemoveClient removes a client (identified by id).
+func (s *CachedClientStorage) RemoveClient(id string) error {
+	if err := s.clients.RemoveClient(id); err != nil {
+		return err
+	}
+	return s.invalidate(id)
+}
+
+// invalidate deletes the cached client everywhere.
+func (s *CachedClientStorage) invalidate(id string) error {
+	s.local.Del(id)
+	if err := s.cache.Del(s.redisKey(id)); err != nil {
+		return fmt.Errorf("删除客户端缓存失败: %v", err)
+	}
+	if err := s.invalidator.Publish(context.Background(), id); err != nil {
+		// 其他副本的进程内缓存会在过期后自动失效
+		logx.Errorf("广播客户端缓存失效失败: %v", err)
+	}
+	return nil
+}
+
+// redisKey returns the Redis key of the client id.
+func (s *CachedClientStorage) redisKey(id string) string {
+	return s.namespace + fmt.Sprintf(cacheClientKey, id)
+}
+
+// digest returns the Redis copy of client.
+func (s *CachedClientStorage) digest(client *Client) cachedClient {
+	c := cachedClient{Client: *AsClient(client)}
+	if c.Secret != "" {
+		c.SecretDigest, c.Secret = s.hasher.Hash(c.Secret), ""
+	}
+	return c
+}
+
+// restore returns the client of a Redis copy, matching secrets against the digest.
+func (s *CachedClientStorage) restore(c *cachedClient) *Client {
+	client := c.Client
+	client.secretDigest, client.secretHasher = c.SecretDigest, s.hasher
+	return &client
+}
+
+// uncachedClients reads clients from the storage wrapped by a CachedClientStorage.
+type uncachedClients struct {
+	*CachedClientStorage
+}
+
+// GetClient loads the client by id from the wrapped storage.
+func (s uncachedClients) GetClient(id string) (osin.Client, error) {
+	return s.clients.GetClient(id)
+}
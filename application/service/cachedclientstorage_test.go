@@ -0,0 +1,170 @@
+package service_test
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"oauth2/application/service"
+	"oauth2/application/service/storagetest"
+
+	"github.com/alicebob/miniredis/v2"
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/openshift/osin"
+	"github.com/zeromicro/go-zero/core/stores/cache"
+	"github.com/zeromicro/go-zero/core/stores/redis"
+	"github.com/zeromicro/go-zero/core/stores/sqlx"
+	"github.com/zeromicro/go-zero/core/syncx"
+)
+
+func TestCachedClientStorage(t *testing.T) {
+	mr := miniredis.RunT(t)
+	conn := sqlx.NewSqlConn(service.DriverSQLite, service.SQLiteDataSource("file:"+filepath.Join(t.TempDir(), "oauth2.db"), true))
+
+	var n int32
+	storagetest.Run(t, func(t *testing.T, opts service.Options) service.OAuthStorage {
+		opts.Driver = service.DriverSQLite
+		opts.TablePrefix = fmt.Sprintf("t%d_", atomic.AddInt32(&n, 1))
+		opts.Clients = newClientCache(t, mr, service.NewSQLClientStorage(conn, opts), opts)
+		storage := service.NewStorage(conn, opts)
+		if err := storage.CreateSchemas(); err != nil {
+			t.Fatalf("CreateSchemas: %v", err)
+		}
+		return storage
+	})
+}
+
+func TestCachedClientStorageInvalidation(t *testing.T) {
+	mr := miniredis.RunT(t)
+	conn := sqlx.NewSqlConn(service.DriverSQLite, service.SQLiteDataSource("file:"+filepath.Join(t.TempDir(), "oauth2.db"), true))
+	opts := service.Options{Driver: service.DriverSQLite, TablePrefix: "osin_", Hasher: service.NewTokenHasher("")}
+	if err := service.NewStorage(conn, opts).CreateSchemas(); err != nil {
+		t.Fatalf("CreateSchemas: %v", err)
+	}
+
+	// 两个副本共享数据库和Redis
+	db := service.NewSQLClientStorage(conn, opts)
+	first := newClientCache(t, mr, db, opts)
+	second := newClientCache(t, mr, db, opts)
+
+	if err := first.CreateClient(&osin.DefaultClient{Id: "app", Secret: "v1", RedirectUri: "http://localhost"}); err != nil {
+		t.Fatalf("CreateClient: %v", err)
+	}
+	requireSecret(t, second, "v1")
+
+	// 绕过缓存直接修改数据库，副本仍然读到缓存中的旧值
+	if err := db.UpdateClient(&osin.DefaultClient{Id: "app", Secret: "v2", RedirectUri: "http://localhost"}); err != nil {
+		t.Fatalf("UpdateClient: %v", err)
+	}
+	requireSecret(t, second, "v1")
+
+	if err := first.UpdateClient(&osin.DefaultClient{Id: "app", Secret: "v3", RedirectUri: "http://localhost"}); err != nil {
+		t.Fatalf("UpdateClient: %v", err)
+	}
+	eventually(t, func() bool { return secretOf(second, "app") == "v3" })
+
+	if err := first.RemoveClient("app"); err != nil {
+		t.Fatalf("RemoveClient: %v", err)
+	}
+	eventually(t, func() bool {
+		_, err := second.GetClient("app")
+		return err == osin.ErrNotFound
+	})
+}
+
+func TestCachedClientStorageSecret(t *testing.T) {
+	mr := miniredis.RunT(t)
+	conn := sqlx.NewSqlConn(service.DriverSQLite, service.SQLiteDataSource("file:"+filepath.Join(t.TempDir(), "oauth2.db"), true))
+	opts := service.Options{Driver: service.DriverSQLite, TablePrefix: "osin_", Hasher: service.NewTokenHasher("pepper")}
+	if err := service.NewStorage(conn, opts).CreateSchemas(); err != nil {
+		t.Fatalf("CreateSchemas: %v", err)
+	}
+	db := service.NewSQLClientStorage(conn, opts)
+	first := newClientCache(t, mr, db, opts)
+	second := newClientCache(t, mr, db, opts)
+
+	if err := first.CreateClient(&service.Client{Id: "app", Secret: "plaintext-secret", RedirectUri: "http://localhost"}); err != nil {
+		t.Fatalf("CreateClient: %v", err)
+	}
+	requireSecret(t, first, "plaintext-secret")
+
+	// Redis中只有按表前缀区分的键和密钥摘要
+	value, err := mr.Get("oauth2:cache:osin:client:app")
+	if err != nil {
+		t.Fatalf("client is not cached under the table prefix: %v (keys %v)", err, mr.Keys())
+	}
+	if strings.Contains(value, "plaintext-secret") {
+		t.Fatalf("Redis holds the plaintext secret: %s", value)
+	}
+
+	// 另一个副本从Redis恢复的客户端仍能校验密钥
+	got, err := second.GetClient("app")
+	if err != nil {
+		t.Fatalf("GetClient: %v", err)
+	}
+	client := service.AsClient(got)
+	if client.GetSecret() != "" || !client.HasSecret() {
+		t.Fatalf("client restored from Redis: secret %q, has secret %v", client.GetSecret(), client.HasSecret())
+	}
+	if !client.ClientSecretMatches("plaintext-secret") {
+		t.Fatal("secret does not match the cached digest")
+	}
+	for _, secret := range []string{"", "wrong", opts.Hasher.Hash("plaintext-secret")} {
+		if client.ClientSecretMatches(secret) {
+			t.Fatalf("secret %q matches the cached digest", secret)
+		}
+	}
+
+	// 注册管理接口绕过缓存读到明文密钥
+	if got, err := second.Uncached().GetClient("app"); err != nil || got.GetSecret() != "plaintext-secret" {
+		t.Fatalf("Uncached().GetClient: secret %v, %v", got, err)
+	}
+}
+
+// newClientCache returns a started client cache in front of clients backed by mr.
+func newClientCache(t *testing.T, mr *miniredis.Miniredis, clients service.ClientStorage, opts service.Options) *service.CachedClientStorage {
+	t.Helper()
+	rdb := goredis.NewClient(&goredis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+
+	redisCache := cache.New(cache.CacheConf{{RedisConf: redis.RedisConf{Host: mr.Addr(), Type: redis.NodeType}, Weight: 100}},
+		syncx.NewSingleFlight(), cache.NewStat("client"), osin.ErrNotFound)
+	cached, err := service.NewCachedClientStorage(clients, redisCache,
+		service.NewInvalidator(rdb, service.ClientInvalidationChannel(opts.TablePrefix)), opts, time.Minute, 100)
+	if err != nil {
+		t.Fatalf("NewCachedClientStorage: %v", err)
+	}
+	if err := cached.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	t.Cleanup(cached.Stop)
+	return cached
+}
+
+func secretOf(clients service.ClientStorage, id string) string {
+	client, err := clients.GetClient(id)
+	if err != nil {
+		return ""
+	}
+	return client.GetSecret()
+}
+
+func requireSecret(t *testing.T, clients service.ClientStorage, want string) {
+	t.Helper()
+	if got := secretOf(clients, "app"); got != want {
+		t.Fatalf("secret: got %q, want %q", got, want)
+	}
+}
+
+func eventually(t *testing.T, condition func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if condition() {
+			return
+		}
+	}
+	t.Fatal("condition not met within 2s")
+}
@@ -0,0 +1,161 @@
+package service
+
+import (
+	"crypto/subtle"
+	"strings"
+
+	"oauth2/common/util"
+
+	"github.com/openshift/osin"
+)
+
+// Client types of RFC 6749 section 2.1.
+const (
+	ClientTypeConfidential = "confidential"
+	ClientTypePublic       = "public"
+)
+
+// Client statuses. Only active clients can be authorized.
+const (
+	ClientStatusActive    = "active"
+	ClientStatusSuspended = "suspended"
+)
+
+// Client is the client model of the server and implements osin.Client.
+// The typed fields describe what the client may do and are stored in their own columns;
+// UserData only carries data private to whoever created the client and is stored as JSON.
+type Client struct {
+	Id                      string      `json:"id"`
+	Secret                  string      `json:"secret"`
+	RedirectUri             string      `json:"redirectUri"` // 多个重定向URI以 RedirectUriSeparator 分隔
+	Type                    string      `json:"type"`
+	Name                    string      `json:"name,omitempty"`
+	GrantTypes              []string    `json:"grantTypes,omitempty"`    // 为空时不限制授权类型
+	Scopes                  []string    `json:"scopes,omitempty"`        // 为空时不限制权限范围
+	ResponseModes           []string    `json:"responseModes,omitempty"` // 为空时不限制授权响应的响应模式
+	TokenEndpointAuthMethod string      `json:"authMethod,omitempty"`
+	AccessTokenLifetime     int32       `json:"accessTokenLifetime,omitempty"`  // 访问令牌有效期(秒)，0 使用服务端配置
+	RefreshTokenLifetime    int32       `json:"refreshTokenLifetime,omitempty"` // 刷新令牌有效期(秒)，0 使用服务端配置
+	RequirePkce             bool        `json:"requirePkce,omitempty"`
+	RequirePar              bool        `json:"requirePar,omitempty"`           // 授权请求必须先通过RFC 9126推送
+	RequireSignedRequest    bool        `json:"requireSignedRequest,omitempty"` // 授权请求必须使用RFC 9101签名的请求对象
+	JwksUri                 string      `json:"jwksUri,omitempty"`              // 客户端公钥集地址，与Jwks二选一
+	Jwks                    string      `json:"jwks,omitempty"`                 // 客户端公钥集(JWK Set JSON)
+	Status                  string      `json:"status"`
+	OwnerId                 string      `json:"ownerId,omitempty"`
+	LogoUri                 string      `json:"logoUri,omitempty"`
+	PolicyUri               string      `json:"policyUri,omitempty"`
+	TosUri                  string      `json:"tosUri,omitempty"`
+	UserData                interface{} `json:"userData,omitempty"`
+
+	// 从Redis客户端缓存恢复的客户端只有密钥摘要，没有明文密钥
+	secretDigest string
+	secretHasher *TokenHasher
+}
+
+// AsClient returns a copy of c as a Client. Clients of other types are confidential and active.
+func AsClient(c osin.Client) *Client {
+	if client, ok := c.(*Client); ok {
+		copied := *client
+		copied.GrantTypes = append([]string(nil), client.GrantTypes...)
+		copied.Scopes = append([]string(nil), client.Scopes...)
+		copied.ResponseModes = append([]string(nil), client.ResponseModes...)
+		return &copied
+	}
+	return &Client{
+		Id:          c.GetId(),
+		Secret:      c.GetSecret(),
+		RedirectUri: c.GetRedirectUri(),
+		Type:        ClientTypeConfidential,
+		Status:      ClientStatusActive,
+		UserData:    c.GetUserData(),
+	}
+}
+
+// GetId returns the client id.
+func (c *Client) GetId() string {
+	return c.Id
+}
+
+// GetSecret returns the client secret. Clients restored from the Redis tier of CachedClientStorage
+// only know the digest of their secret and return an empty secret; check secrets with ClientSecretMatches.
+func (c *Client) GetSecret() string {
+	return c.Secret
+}
+
+// GetRedirectUri returns the redirect URIs of the client.
+func (c *Client) GetRedirectUri() string {
+	return c.RedirectUri
+}
+
+// GetUserData returns the data private to the creator of the client.
+func (c *Client) GetUserData() interface{} {
+	return c.UserData
+}
+
+// ClientSecretMatches implements osin.ClientSecretMatcher. Public clients have no secret to match.
+func (c *Client) ClientSecretMatches(secret string) bool {
+	if c.IsPublic() {
+		return secret == ""
+	}
+	if c.Secret == "" && c.secretDigest != "" {
+		return secret != "" && subtle.ConstantTimeCompare([]byte(c.secretHasher.Hash(secret)), []byte(c.secretDigest)) == 1
+	}
+	return c.Secret != "" && subtle.ConstantTimeCompare([]byte(secret), []byte(c.Secret)) == 1
+}
+
+// HasSecret reports whether the client has a secret, also when only its digest is known.
+func (c *Client) HasSecret() bool {
+	return c.Secret != "" || c.secretDigest != ""
+}
+
+// IsPublic reports whether the client cannot keep a secret.
+func (c *Client) IsPublic() bool {
+	return c.Type == ClientTypePublic
+}
+
+// IsActive reports whether the client can be authorized. An empty status counts as active.
+func (c *Client) IsActive() bool {
+	return c.Status == "" || c.Status == ClientStatusActive
+}
+
+// AllowsGrantType reports whether the client may use grantType.
+func (c *Client) AllowsGrantType(grantType string) bool {
+	return len(c.GrantTypes) == 0 || util.InArray(c.GrantTypes, grantType)
+}
+
+// AllowsResponseMode reports whether the client may receive authorization responses in mode.
+// jwt is the same mode as query.jwt.
+func (c *Client) AllowsResponseMode(mode string) bool {
+	if len(c.ResponseModes) == 0 {
+		return true
+	}
+	mode = NormalizeResponseMode(mode)
+	for _, allowed := range c.ResponseModes {
+		if NormalizeResponseMode(allowed) == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsScope reports whether every scope in the space separated scope is allowed for the client.
+func (c *Client) AllowsScope(scope string) bool {
+	if len(c.Scopes) == 0 {
+		return true
+	}
+	for _, s := range strings.Fields(scope) {
+		if !util.InArray(c.Scopes, s) {
+			return false
+		}
+	}
+	return true
+}
+
+// refreshLifetime returns the refresh token lifetime of client, falling back to def.
+func refreshLifetime(client osin.Client, def int32) int32 {
+	if c, ok := client.(*Client); ok && c.RefreshTokenLifetime > 0 {
+		return c.RefreshTokenLifetime
+	}
+	return def
+}
@@ -0,0 +1,124 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/zeromicro/go-zero/core/collection"
+)
+
+// maxFetchSize limits the size of documents fetched from clients, such as key sets and request objects.
+const maxFetchSize = 64 << 10
+
+// minKeysRefreshInterval is how often a client's jwks_uri may be fetched again for an unknown key,
+// so requests signed with made-up key ids cannot make the server flood the client.
+const minKeysRefreshInterval = time.Minute
+
+// ParseKeySet parses a JWK Set that may only hold public keys, as registered with the jwks client metadata.
+func ParseKeySet(data []byte) (*jose.JSONWebKeySet, error) {
+	var set jose.JSONWebKeySet
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("无法解析JWK Set: %v", err)
+	}
+	for i, key := range set.Keys {
+		if !key.Valid() || !key.IsPublic() {
+			return nil, fmt.Errorf("keys[%d] 不是有效的公钥", i)
+		}
+	}
+	return &set, nil
+}
+
+// ClientKeys resolves the public keys of clients, registered inline as jwks or by reference as jwks_uri.
+// Key sets fetched from jwks_uri are cached in process and fetched again when a key is not found,
+// so clients can rotate their keys.
+type ClientKeys struct {
+	client *http.Client
+	cache  *collection.Cache
+
+	mu        sync.Mutex
+	refreshed map[string]time.Time // jwks_uri 最近一次强制刷新的时间
+}
+
+// NewClientKeys returns a resolver fetching key sets with client and caching them for expiry.
+func NewClientKeys(client *http.Client, expiry time.Duration) (*ClientKeys, error) {
+	cache, err := collection.NewCache(expiry, collection.WithName("client_keys"))
+	if err != nil {
+		return nil, err
+	}
+	return &ClientKeys{client: client, cache: cache, refreshed: make(map[string]time.Time)}, nil
+}
+
+// KeySet returns the key set of client. refresh asks for jwks_uri to be fetched again instead of
+// served from the cache, which is honoured at most once every minKeysRefreshInterval.
+func (k *ClientKeys) KeySet(ctx context.Context, client *Client, refresh bool) (*jose.JSONWebKeySet, error) {
+	switch {
+	case client.Jwks != "":
+		return ParseKeySet([]byte(client.Jwks))
+	case client.JwksUri == "":
+		return nil, errors.New("客户端未注册公钥")
+	}
+
+	if refresh && k.allowRefresh(client.JwksUri) {
+		k.cache.Del(client.JwksUri)
+	}
+	v, err := k.cache.Take(client.JwksUri, func() (any, error) {
+		data, err := fetch(ctx, k.client, client.JwksUri, "application/jwk-set+json, application/json")
+		if err != nil {
+			return nil, err
+		}
+		return ParseKeySet(data)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*jose.JSONWebKeySet), nil
+}
+
+// allowRefresh reports whether uri may be fetched again now, and records the refresh if so.
+func (k *ClientKeys) allowRefresh(uri string) bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	now := time.Now()
+	if last, ok := k.refreshed[uri]; ok && now.Sub(last) < minKeysRefreshInterval {
+		return false
+	}
+	for u, last := range k.refreshed {
+		if now.Sub(last) >= minKeysRefreshInterval {
+			delete(k.refreshed, u)
+		}
+	}
+	k.refreshed[uri] = now
+	return true
+}
+
+// fetch GETs uri and returns the body of a 200 response, which may not exceed maxFetchSize.
+func fetch(ctx context.Context, client *http.Client, uri, accept string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", accept)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s 返回 %s", uri, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxFetchSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxFetchSize {
+		return nil, fmt.Errorf("%s 的内容超过 %d 字节", uri, maxFetchSize)
+	}
+	return data, nil
+}
@@ -0,0 +1,66 @@
+package service
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// Connection is a grant of a user as the user sees it: an application or device currently holding tokens.
+type Connection struct {
+	GrantId    string     `json:"grant_id"`
+	ClientId   string     `json:"client_id"`
+	Scope      string     `json:"scope"`                  // 最近签发的令牌的权限范围
+	CreatedAt  time.Time  `json:"created_at"`             // 最早的未过期令牌的签发时间
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`   // 最晚过期的令牌的过期时间，为空表示永不过期
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"` // 尚未使用时为空
+	LastUsedIp string     `json:"last_used_ip,omitempty"`
+}
+
+// UserConnections returns the grants of a user that still hold unexpired tokens, most recently used first.
+func UserConnections(ctx context.Context, storage UserTokenStorage, userID string) ([]Connection, error) {
+	clients, err := storage.ListUserTokens(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		connections []Connection
+		index       = make(map[string]int)
+	)
+	for _, client := range clients {
+		// 同一客户端的令牌按签发时间排序
+		for _, token := range client.Tokens {
+			i, ok := index[token.GrantId]
+			if !ok {
+				index[token.GrantId] = len(connections)
+				connections = append(connections, Connection{
+					GrantId:    token.GrantId,
+					ClientId:   client.ClientId,
+					CreatedAt:  token.CreatedAt,
+					ExpiresAt:  token.ExpiresAt,
+					LastUsedAt: token.LastUsedAt,
+					LastUsedIp: token.LastUsedIp,
+				})
+				i = len(connections) - 1
+			}
+			conn := &connections[i]
+			conn.Scope = token.Scope
+			if conn.ExpiresAt != nil && (token.ExpiresAt == nil || token.ExpiresAt.After(*conn.ExpiresAt)) {
+				conn.ExpiresAt = token.ExpiresAt
+			}
+		}
+	}
+
+	sort.SliceStable(connections, func(i, j int) bool {
+		a, b := connections[i], connections[j]
+		if (a.LastUsedAt == nil) != (b.LastUsedAt == nil) {
+			return a.LastUsedAt != nil
+		}
+		if a.LastUsedAt != nil && !a.LastUsedAt.Equal(*b.LastUsedAt) {
+			return a.LastUsedAt.After(*b.LastUsedAt)
+		}
+		return a.CreatedAt.After(b.CreatedAt)
+	})
+	return connections, nil
+}
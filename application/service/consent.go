@@ -0,0 +1,110 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ErrConsentTicket is returned for a consent ticket that is malformed, expired, already used or issued
+// for another request.
+var ErrConsentTicket = errors.New("同意页面已过期或请求被篡改，请重新发起授权")
+
+// ConsentSigner issues and checks the tickets of the consent page. A ticket binds the decision posted
+// from the page to the user the page was shown to and to the exact parameters of the authorization
+// request, so the decision needs no server side session and cannot be forged for another request.
+// Every ticket carries a random id recorded on use, so a decision is only accepted once.
+type ConsentSigner struct {
+	key    []byte
+	expiry time.Duration
+	replay *ReplayGuard
+}
+
+// consentTicket is the signed payload of a ticket.
+type consentTicket struct {
+	Id        string `json:"i"`
+	UserId    string `json:"u,omitempty"`
+	Request   string `json:"r"` // 授权请求参数的摘要
+	ExpiresAt int64  `json:"e"`
+}
+
+// NewConsentSigner returns a signer with the HMAC key secret whose tickets are valid for expiry and
+// are only accepted once, as recorded by replay. An empty secret generates a random key, which only
+// suits a single replica since tickets then do not survive a restart.
+func NewConsentSigner(secret string, expiry time.Duration, replay *ReplayGuard) (*ConsentSigner, error) {
+	key := []byte(secret)
+	if secret == "" {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+	}
+	return &ConsentSigner{key: key, expiry: expiry, replay: replay}, nil
+}
+
+// Sign returns a ticket for userID to decide on the authorization request with params.
+func (s *ConsentSigner) Sign(userID string, params url.Values) string {
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	payload, _ := json.Marshal(consentTicket{
+		Id:        base64.RawURLEncoding.EncodeToString(id),
+		UserId:    userID,
+		Request:   requestDigest(params),
+		ExpiresAt: time.Now().Add(s.expiry).Unix(),
+	})
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(s.mac(encoded))
+}
+
+// Verify checks that ticket was signed for the authorization request with params, has not expired and
+// has not been used before, and returns the user it was issued for.
+func (s *ConsentSigner) Verify(ctx context.Context, ticket string, params url.Values) (string, error) {
+	encoded, signature, ok := strings.Cut(ticket, ".")
+	if !ok {
+		return "", ErrConsentTicket
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(mac, s.mac(encoded)) {
+		return "", ErrConsentTicket
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", ErrConsentTicket
+	}
+	var t consentTicket
+	if err := json.Unmarshal(payload, &t); err != nil || t.Id == "" || time.Now().Unix() > t.ExpiresAt ||
+		!hmac.Equal([]byte(t.Request), []byte(requestDigest(params))) {
+		return "", ErrConsentTicket
+	}
+	// 校验通过后才记录票据，被篡改的请求不会占用它
+	fresh, err := s.replay.Use(ctx, "consent:"+t.Id, time.Unix(t.ExpiresAt, 0))
+	if err != nil {
+		return "", fmt.Errorf("记录同意票据失败: %v", err)
+	}
+	if !fresh {
+		return "", ErrConsentTicket
+	}
+	return t.UserId, nil
+}
+
+// mac returns the HMAC-SHA256 of data.
+func (s *ConsentSigner) mac(data string) []byte {
+	h := hmac.New(sha256.New, s.key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// requestDigest returns a digest of params. url.Values.Encode sorts the keys, so the digest does not
+// depend on the order the parameters were sent in.
+func requestDigest(params url.Values) string {
+	sum := sha256.Sum256([]byte(params.Encode()))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
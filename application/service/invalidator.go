@@ -0,0 +1,45 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/zeromicro/go-zero/core/threading"
+)
+
+// Invalidator broadcasts cache invalidations to all replicas over a Redis pub/sub channel.
+// Messages published while a replica is disconnected are lost, so in-process caches
+// relying on it must still expire their entries.
+type Invalidator struct {
+	rdb     *redis.Client
+	channel string
+}
+
+// NewInvalidator returns an invalidator publishing on channel.
+func NewInvalidator(rdb *redis.Client, channel string) *Invalidator {
+	return &Invalidator{rdb: rdb, channel: channel}
+}
+
+// Publish announces to all replicas, including this one, that key changed.
+func (i *Invalidator) Publish(ctx context.Context, key string) error {
+	return i.rdb.Publish(ctx, i.channel, key).Err()
+}
+
+// Subscribe calls handler with every published key until the returned stop function is called.
+func (i *Invalidator) Subscribe(ctx context.Context, handler func(key string)) (stop func(), err error) {
+	pubsub := i.rdb.Subscribe(ctx, i.channel)
+	// 等待订阅确认，保证返回后发布的消息都能收到
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return nil, fmt.Errorf("订阅 %s 失败: %v", i.channel, err)
+	}
+
+	messages := pubsub.Channel()
+	threading.GoSafe(func() {
+		for msg := range messages {
+			handler(msg.Payload)
+		}
+	})
+	return func() { pubsub.Close() }, nil
+}
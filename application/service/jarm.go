@@ -0,0 +1,145 @@
+package service
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Response modes of OAuth 2.0 Multiple Response Type Encoding Practices, OAuth 2.0 Form Post Response Mode
+// and JARM. The JWT modes return the authorization response as a JWT signed by the server.
+const (
+	ResponseModeQuery       = "query"
+	ResponseModeFragment    = "fragment"
+	ResponseModeFormPost    = "form_post"
+	ResponseModeJWT         = "jwt"
+	ResponseModeQueryJWT    = "query.jwt"
+	ResponseModeFragmentJWT = "fragment.jwt"
+	ResponseModeFormPostJWT = "form_post.jwt"
+)
+
+// responseModeJWTSuffix is the suffix of the JWT response modes.
+const responseModeJWTSuffix = ".jwt"
+
+// plainResponseModes are the response modes that do not need a ResponseSigner.
+var plainResponseModes = []string{ResponseModeQuery, ResponseModeFragment, ResponseModeFormPost}
+
+// jwtResponseModes are the response modes of JARM.
+var jwtResponseModes = []string{ResponseModeJWT, ResponseModeQueryJWT, ResponseModeFragmentJWT, ResponseModeFormPostJWT}
+
+// ResponseModes returns the supported response modes. The JWT modes of JARM are only supported with a
+// ResponseSigner.
+func ResponseModes(jarm bool) []string {
+	modes := append([]string(nil), plainResponseModes...)
+	if jarm {
+		modes = append(modes, jwtResponseModes...)
+	}
+	return modes
+}
+
+// NormalizeResponseMode returns the response mode of an authorization code request with response_mode
+// requested: query when it is empty, and query.jwt for jwt as JARM section 2.3.4 defines for the code
+// response type. Unknown modes are returned unchanged.
+func NormalizeResponseMode(requested string) string {
+	switch requested {
+	case "":
+		return ResponseModeQuery
+	case ResponseModeJWT:
+		return ResponseModeQueryJWT
+	default:
+		return requested
+	}
+}
+
+// IsJWTResponseMode reports whether mode returns the authorization response as a JWT.
+func IsJWTResponseMode(mode string) bool {
+	return strings.HasSuffix(mode, responseModeJWTSuffix) || mode == ResponseModeJWT
+}
+
+// BaseResponseMode returns how the response of mode is delivered: query, fragment or form_post.
+func BaseResponseMode(mode string) string {
+	return strings.TrimSuffix(NormalizeResponseMode(mode), responseModeJWTSuffix)
+}
+
+// ResponseSigner signs authorization responses as described by JARM (JWT Secured Authorization Response
+// Mode for OAuth 2.0). The key is published in the key set of the server so clients can verify responses.
+type ResponseSigner struct {
+	issuer string
+	key    crypto.PrivateKey
+	method jwt.SigningMethod
+	public jose.JSONWebKey
+	expiry time.Duration
+}
+
+// NewResponseSigner returns a signer issuing responses as issuer that expire after expiry. pemKey is a
+// PEM encoded RSA key, used with RS256, or EC key, used with the ES algorithm of its curve.
+func NewResponseSigner(issuer, pemKey string, expiry time.Duration) (*ResponseSigner, error) {
+	s := &ResponseSigner{issuer: issuer, expiry: expiry}
+	var public crypto.PublicKey
+	if key, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(pemKey)); err == nil {
+		s.key, public, s.method = key, &key.PublicKey, jwt.SigningMethodRS256
+	} else if key, err := jwt.ParseECPrivateKeyFromPEM([]byte(pemKey)); err == nil {
+		s.key, public = key, &key.PublicKey
+		if s.method = ecdsaSigningMethod(key); s.method == nil {
+			return nil, fmt.Errorf("不支持的椭圆曲线: %s", key.Curve.Params().Name)
+		}
+	} else {
+		return nil, errors.New("授权响应签名密钥必须是PEM格式的RSA或EC私钥")
+	}
+
+	s.public = jose.JSONWebKey{Key: public, Algorithm: s.method.Alg(), Use: "sig"}
+	thumbprint, err := s.public.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return nil, err
+	}
+	s.public.KeyID = base64.RawURLEncoding.EncodeToString(thumbprint)
+	return s, nil
+}
+
+// ecdsaSigningMethod returns the signing method of the curve of key, nil for an unsupported curve.
+func ecdsaSigningMethod(key *ecdsa.PrivateKey) jwt.SigningMethod {
+	switch key.Curve.Params().BitSize {
+	case 256:
+		return jwt.SigningMethodES256
+	case 384:
+		return jwt.SigningMethodES384
+	case 521:
+		return jwt.SigningMethodES512
+	default:
+		return nil
+	}
+}
+
+// Algorithm returns the signing algorithm of responses.
+func (s *ResponseSigner) Algorithm() string {
+	return s.method.Alg()
+}
+
+// PublicKey returns the key that verifies responses.
+func (s *ResponseSigner) PublicKey() jose.JSONWebKey {
+	return s.public
+}
+
+// Sign returns the JWT of an authorization response with params for the client clientID. The JWT carries
+// the response parameters, such as code and state or error and error_description, together with iss,
+// aud and exp as JARM section 2.1 requires.
+func (s *ResponseSigner) Sign(clientID string, params map[string]interface{}) (string, error) {
+	claims := make(jwt.MapClaims, len(params)+3)
+	for name, value := range params {
+		claims[name] = value
+	}
+	claims["iss"] = s.issuer
+	claims["aud"] = clientID
+	claims["exp"] = time.Now().Add(s.expiry).Unix()
+
+	token := jwt.NewWithClaims(s.method, claims)
+	token.Header["kid"] = s.public.KeyID
+	return token.SignedString(s.key)
+}
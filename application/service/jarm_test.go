@@ -0,0 +1,94 @@
+package service_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"oauth2/application/service"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func TestResponseSigner(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	ecDER, err := x509.MarshalECPrivateKey(ecKey)
+	if err != nil {
+		t.Fatalf("MarshalECPrivateKey: %v", err)
+	}
+
+	for alg, pemKey := range map[string][]byte{
+		"RS256": pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}),
+		"ES384": pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: ecDER}),
+	} {
+		signer, err := service.NewResponseSigner("https://auth.example.com", string(pemKey), time.Minute)
+		if err != nil {
+			t.Fatalf("%s: NewResponseSigner: %v", alg, err)
+		}
+		public := signer.PublicKey()
+		if signer.Algorithm() != alg || public.KeyID == "" || !public.IsPublic() || public.Use != "sig" {
+			t.Fatalf("%s: unexpected key %s %+v", alg, signer.Algorithm(), public)
+		}
+
+		// 响应JWT包含响应参数和iss、aud、exp，可以用公布的公钥校验
+		response, err := signer.Sign("client-1", map[string]interface{}{"code": "abc", "state": "xyz"})
+		if err != nil {
+			t.Fatalf("%s: Sign: %v", alg, err)
+		}
+		claims := jwt.MapClaims{}
+		token, err := jwt.ParseWithClaims(response, claims, func(token *jwt.Token) (interface{}, error) {
+			return public.Key, nil
+		})
+		if err != nil || token.Header["kid"] != public.KeyID {
+			t.Fatalf("%s: verify response: %v", alg, err)
+		}
+		if claims["iss"] != "https://auth.example.com" || claims["aud"] != "client-1" ||
+			claims["code"] != "abc" || claims["state"] != "xyz" || !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+			t.Fatalf("%s: unexpected claims %v", alg, claims)
+		}
+	}
+
+	if _, err := service.NewResponseSigner("https://auth.example.com", "not a key", time.Minute); err == nil {
+		t.Fatal("expected an error for an invalid key")
+	}
+}
+
+func TestResponseModes(t *testing.T) {
+	for requested, want := range map[string]string{
+		"":             service.ResponseModeQuery,
+		"jwt":          service.ResponseModeQueryJWT,
+		"form_post":    service.ResponseModeFormPost,
+		"fragment.jwt": service.ResponseModeFragmentJWT,
+	} {
+		if got := service.NormalizeResponseMode(requested); got != want {
+			t.Errorf("NormalizeResponseMode(%q) = %q, want %q", requested, got, want)
+		}
+	}
+	if service.BaseResponseMode("form_post.jwt") != service.ResponseModeFormPost || service.BaseResponseMode("jwt") != service.ResponseModeQuery {
+		t.Error("unexpected base response modes")
+	}
+	if len(service.ResponseModes(false)) != 3 || len(service.ResponseModes(true)) != 7 {
+		t.Errorf("unexpected supported modes: %v", service.ResponseModes(true))
+	}
+
+	client := &service.Client{}
+	if !client.AllowsResponseMode("form_post") {
+		t.Error("a client without response modes should allow every mode")
+	}
+	client.ResponseModes = []string{"query.jwt", "form_post"}
+	if !client.AllowsResponseMode("jwt") || !client.AllowsResponseMode("form_post") || client.AllowsResponseMode("") {
+		t.Errorf("unexpected modes allowed for %v", client.ResponseModes)
+	}
+}
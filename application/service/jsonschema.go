@@ -0,0 +1,192 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+
+	"oauth2/common/util"
+)
+
+// jsonSchema is the subset of JSON Schema that authorization detail types are described with.
+// Keywords outside the subset are rejected when the schema is compiled rather than silently ignored,
+// so a schema never validates less than it appears to.
+type jsonSchema struct {
+	Type                 string
+	Properties           map[string]*jsonSchema
+	Required             []string
+	AdditionalProperties *bool
+	Items                *jsonSchema
+	Enum                 []interface{}
+	Minimum              *float64
+	Maximum              *float64
+	MinLength            *int
+	MaxLength            *int
+	MinItems             *int
+	MaxItems             *int
+	Pattern              *regexp.Regexp
+}
+
+// jsonSchemaTypes are the supported values of the type keyword.
+var jsonSchemaTypes = []string{"object", "array", "string", "number", "integer", "boolean"}
+
+// compileSchema parses a schema of the supported subset.
+func compileSchema(raw []byte) (*jsonSchema, error) {
+	var keywords map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &keywords); err != nil {
+		return nil, fmt.Errorf("schema必须是JSON对象: %v", err)
+	}
+
+	schema := &jsonSchema{}
+	for keyword, value := range keywords {
+		var err error
+		switch keyword {
+		case "$schema", "$id", "title", "description", "examples", "default":
+			// 注释性关键字，不参与校验
+		case "type":
+			err = json.Unmarshal(value, &schema.Type)
+			if err == nil && !util.InArray(jsonSchemaTypes, schema.Type) {
+				err = fmt.Errorf("不支持的类型 %q", schema.Type)
+			}
+		case "properties":
+			var properties map[string]json.RawMessage
+			if err = json.Unmarshal(value, &properties); err == nil {
+				schema.Properties = make(map[string]*jsonSchema, len(properties))
+				for name, property := range properties {
+					if schema.Properties[name], err = compileSchema(property); err != nil {
+						return nil, fmt.Errorf("properties.%s: %v", name, err)
+					}
+				}
+			}
+		case "items":
+			schema.Items, err = compileSchema(value)
+		case "required":
+			err = json.Unmarshal(value, &schema.Required)
+		case "additionalProperties":
+			err = json.Unmarshal(value, &schema.AdditionalProperties)
+		case "enum":
+			err = json.Unmarshal(value, &schema.Enum)
+		case "minimum":
+			err = json.Unmarshal(value, &schema.Minimum)
+		case "maximum":
+			err = json.Unmarshal(value, &schema.Maximum)
+		case "minLength":
+			err = json.Unmarshal(value, &schema.MinLength)
+		case "maxLength":
+			err = json.Unmarshal(value, &schema.MaxLength)
+		case "minItems":
+			err = json.Unmarshal(value, &schema.MinItems)
+		case "maxItems":
+			err = json.Unmarshal(value, &schema.MaxItems)
+		case "pattern":
+			var pattern string
+			if err = json.Unmarshal(value, &pattern); err == nil {
+				schema.Pattern, err = regexp.Compile(pattern)
+			}
+		default:
+			err = fmt.Errorf("不支持的关键字")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", keyword, err)
+		}
+	}
+	return schema, nil
+}
+
+// validate checks value, decoded by encoding/json, against the schema. path names value in errors.
+func (s *jsonSchema) validate(path string, value interface{}) error {
+	if s.Type != "" && !jsonTypeMatches(s.Type, value) {
+		return fmt.Errorf("%s 必须是 %s", path, s.Type)
+	}
+	if len(s.Enum) > 0 && !jsonEnumContains(s.Enum, value) {
+		return fmt.Errorf("%s 不是允许的取值", path)
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				return fmt.Errorf("%s 缺少 %s", path, name)
+			}
+		}
+		// 按名称排序，使错误信息稳定
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			property, ok := s.Properties[name]
+			if !ok {
+				if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+					return fmt.Errorf("%s 不允许字段 %s", path, name)
+				}
+				continue
+			}
+			if err := property.validate(path+"."+name, v[name]); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		if s.MinItems != nil && len(v) < *s.MinItems || s.MaxItems != nil && len(v) > *s.MaxItems {
+			return fmt.Errorf("%s 的元素个数超出范围", path)
+		}
+		if s.Items != nil {
+			for i, item := range v {
+				if err := s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item); err != nil {
+					return err
+				}
+			}
+		}
+	case string:
+		length := len([]rune(v))
+		if s.MinLength != nil && length < *s.MinLength || s.MaxLength != nil && length > *s.MaxLength {
+			return fmt.Errorf("%s 的长度超出范围", path)
+		}
+		if s.Pattern != nil && !s.Pattern.MatchString(v) {
+			return fmt.Errorf("%s 的格式不正确", path)
+		}
+	case float64:
+		if s.Minimum != nil && v < *s.Minimum || s.Maximum != nil && v > *s.Maximum {
+			return fmt.Errorf("%s 超出取值范围", path)
+		}
+	}
+	return nil
+}
+
+// jsonTypeMatches reports whether value, decoded by encoding/json, is of the JSON Schema type typ.
+func jsonTypeMatches(typ string, value interface{}) bool {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return typ == "object"
+	case []interface{}:
+		return typ == "array"
+	case string:
+		return typ == "string"
+	case bool:
+		return typ == "boolean"
+	case float64:
+		return typ == "number" || typ == "integer" && v == math.Trunc(v)
+	default:
+		return false
+	}
+}
+
+// jsonEnumContains reports whether value equals one of the enum values.
+func jsonEnumContains(enum []interface{}, value interface{}) bool {
+	for _, allowed := range enum {
+		if jsonEqual(allowed, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonEqual compares two values decoded by encoding/json.
+func jsonEqual(a, b interface{}) bool {
+	x, errX := json.Marshal(a)
+	y, errY := json.Marshal(b)
+	return errX == nil && errY == nil && string(x) == string(y)
+}
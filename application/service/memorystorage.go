@@ -0,0 +1,463 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/openshift/osin"
+)
+
+// MemoryStorage is a thread-safe in-memory osin.Storage for tests and single-node development.
+// Expired codes and tokens are rejected on load and removed by PurgeExpired.
+type MemoryStorage struct {
+	mu                sync.RWMutex
+	hasher            *TokenHasher
+	refreshExpiration int32
+	clients           map[string]*Client
+	codes             map[string]*tokenRecord
+	access            map[string]*tokenRecord
+	refresh           map[string]*tokenRecord
+	usage             map[string]grantUsage     // 按授权ID记录的最近使用情况
+	pushed            map[string]*PushedRequest // 按request_uri摘要保存的推送授权请求
+}
+
+// NewMemoryStorage returns a new, empty in-memory storage instance.
+func NewMemoryStorage(opts Options) *MemoryStorage {
+	return &MemoryStorage{
+		hasher:            opts.Hasher,
+		refreshExpiration: opts.RefreshExpiration,
+		clients:           make(map[string]*Client),
+		codes:             make(map[string]*tokenRecord),
+		access:            make(map[string]*tokenRecord),
+		refresh:           make(map[string]*tokenRecord),
+		usage:             make(map[string]grantUsage),
+		pushed:            make(map[string]*PushedRequest),
+	}
+}
+
+// Clone the storage if needed. Can return itself if not a problem.
+func (s *MemoryStorage) Clone() osin.Storage {
+	return s
+}
+
+// Close the resources the Storage potentially holds (using Clone for example)
+func (s *MemoryStorage) Close() {
+}
+
+// GetClient loads the client by id
+func (s *MemoryStorage) GetClient(id string) (osin.Client, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.getClient(id)
+}
+
+func (s *MemoryStorage) getClient(id string) (osin.Client, error) {
+	c, ok := s.clients[id]
+	if !ok {
+		return nil, osin.ErrNotFound
+	}
+	return AsClient(c), nil
+}
+
+// CreateClient stores the client. Returns an error if a client with the same id exists.
+func (s *MemoryStorage) CreateClient(c osin.Client) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.clients[c.GetId()]; ok {
+		return fmt.Errorf("客户端已存在: %s", c.GetId())
+	}
+	s.clients[c.GetId()] = AsClient(c)
+	return nil
+}
+
+// UpdateClient updates the client (identified by it's id) and replaces the values with the values of client.
+// Returns osin.ErrNotFound if there is no client with the id.
+func (s *MemoryStorage) UpdateClient(c osin.Client) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.clients[c.GetId()]; !ok {
+		return osin.ErrNotFound
+	}
+	s.clients[c.GetId()] = AsClient(c)
+	return nil
+}
+
+// RemoveClient removes a client (identified by id) together with its codes and tokens.
+func (s *MemoryStorage) RemoveClient(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.clients, id)
+	for _, records := range []map[string]*tokenRecord{s.codes, s.access, s.refresh} {
+		for key, record := range records {
+			if record.ClientId == id {
+				delete(records, key)
+			}
+		}
+	}
+	for key, request := range s.pushed {
+		if request.ClientId == id {
+			delete(s.pushed, key)
+		}
+	}
+	return nil
+}
+
+// SaveAuthorize saves authorize data.
+func (s *MemoryStorage) SaveAuthorize(data *osin.AuthorizeData) error {
+	grantID, err := newGrantID()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.clients[data.Client.GetId()]; !ok {
+		return fmt.Errorf("保存授权数据失败: 客户端不存在")
+	}
+	s.codes[s.hasher.Hash(data.Code)] = &tokenRecord{
+		GrantId:     grantID,
+		UserId:      userIDOf(data.UserData),
+		ClientId:    data.Client.GetId(),
+		ExpiresIn:   data.ExpiresIn,
+		Scope:       data.Scope,
+		RedirectUri: data.RedirectUri,
+		State:       data.State,
+		Extra:       toString(data.UserData),
+		CreatedAt:   data.CreatedAt,
+
+		CodeChallenge:       data.CodeChallenge,
+		CodeChallengeMethod: data.CodeChallengeMethod,
+	}
+	return nil
+}
+
+// LoadAuthorize looks up AuthorizeData by a code.
+// Client information MUST be loaded together.
+// Optionally can return error if expired.
+func (s *MemoryStorage) LoadAuthorize(code string) (*osin.AuthorizeData, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	record, ok := s.codes[s.hasher.Hash(code)]
+	if !ok {
+		return nil, osin.ErrNotFound
+	}
+	if record.expired(time.Now()) {
+		return nil, fmt.Errorf("授权码已过期")
+	}
+
+	client, err := s.getClient(record.ClientId)
+	if err != nil {
+		return nil, err
+	}
+	data := record.authorizeData()
+	data.Client = client
+	data.Code = code
+	return data, nil
+}
+
+// RemoveAuthorize revokes or deletes the authorization code.
+func (s *MemoryStorage) RemoveAuthorize(code string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.codes, s.hasher.Hash(code))
+	return nil
+}
+
+// SaveAccess writes AccessData.
+// If RefreshToken is not blank, it must save in a way that can be loaded using LoadRefresh.
+func (s *MemoryStorage) SaveAccess(data *osin.AccessData) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.clients[data.Client.GetId()]; !ok {
+		return fmt.Errorf("保存访问令牌失败: 客户端不存在")
+	}
+
+	grantID, userID, err := s.grantOf(data)
+	if err != nil {
+		return err
+	}
+	record := tokenRecord{
+		GrantId:     grantID,
+		UserId:      userID,
+		ClientId:    data.Client.GetId(),
+		ExpiresIn:   data.ExpiresIn,
+		Scope:       data.Scope,
+		RedirectUri: data.RedirectUri,
+		Extra:       toString(data.UserData),
+		CreatedAt:   data.CreatedAt,
+	}
+	access := record
+	accessHash := s.hasher.Hash(data.AccessToken)
+	s.access[accessHash] = &access
+
+	if data.RefreshToken != "" {
+		// 刷新令牌的有效期独立于访问令牌，0 表示永不过期
+		refresh := record
+		refresh.ExpiresIn = refreshLifetime(data.Client, s.refreshExpiration)
+		refresh.AccessHash = accessHash
+		s.refresh[s.hasher.Hash(data.RefreshToken)] = &refresh
+	}
+	return nil
+}
+
+// LoadAccess retrieves access data by token. Client information MUST be loaded together.
+// AuthorizeData and AccessData DON'T NEED to be loaded if not easily available.
+// Optionally can return error if expired.
+func (s *MemoryStorage) LoadAccess(token string) (*osin.AccessData, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	record, ok := s.access[s.hasher.Hash(token)]
+	if !ok {
+		return nil, osin.ErrNotFound
+	}
+	if record.expired(time.Now()) {
+		return nil, fmt.Errorf("访问令牌已过期")
+	}
+
+	client, err := s.getClient(record.ClientId)
+	if err != nil {
+		return nil, err
+	}
+	data := record.accessData()
+	data.Client = client
+	data.AccessToken = token
+	return data, nil
+}
+
+// RemoveAccess revokes or deletes an AccessData.
+func (s *MemoryStorage) RemoveAccess(token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.access, s.hasher.Hash(token))
+	return nil
+}
+
+// LoadRefresh retrieves refresh AccessData. Client information MUST be loaded together.
+// AuthorizeData and AccessData DON'T NEED to be loaded if not easily available.
+// Optionally can return error if expired.
+func (s *MemoryStorage) LoadRefresh(token string) (*osin.AccessData, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	record, ok := s.refresh[s.hasher.Hash(token)]
+	if !ok {
+		return nil, osin.ErrNotFound
+	}
+	if record.refreshExpired(time.Now()) {
+		return nil, fmt.Errorf("刷新令牌已过期")
+	}
+
+	client, err := s.getClient(record.ClientId)
+	if err != nil {
+		return nil, err
+	}
+	data := record.accessData()
+	data.Client = client
+	data.RefreshToken = token
+	return data, nil
+}
+
+// RemoveRefresh revokes or deletes refresh AccessData, together with the access token issued with it.
+func (s *MemoryStorage) RemoveRefresh(token string) error {
+	_, err := s.RevokeRefresh(context.Background(), token)
+	return err
+}
+
+// RevokeRefresh deletes a refresh token and the access token issued with it.
+func (s *MemoryStorage) RevokeRefresh(ctx context.Context, token string) (RevokeResult, error) {
+	var result RevokeResult
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := s.hasher.Hash(token)
+	record, ok := s.refresh[key]
+	if !ok {
+		return result, nil
+	}
+	delete(s.refresh, key)
+	result.RefreshRevoked = true
+	if _, ok := s.access[record.AccessHash]; ok {
+		delete(s.access, record.AccessHash)
+		result.AccessTokens = append(result.AccessTokens, record.AccessHash)
+	}
+	return result, nil
+}
+
+// PurgeExpired deletes expired codes, access tokens, refresh tokens and pushed requests, and the usage of the grants left
+// without any of them. Grants are not stored separately in memory, so Grants is always 0.
+func (s *MemoryStorage) PurgeExpired(ctx context.Context, batchSize int) (PurgeResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	purge := func(records map[string]*tokenRecord, expired func(*tokenRecord, time.Time) bool) int64 {
+		var deleted int64
+		for key, record := range records {
+			if expired(record, now) {
+				delete(records, key)
+				deleted++
+			}
+		}
+		return deleted
+	}
+	result := PurgeResult{
+		Codes:         purge(s.codes, (*tokenRecord).expired),
+		AccessTokens:  purge(s.access, (*tokenRecord).expired),
+		RefreshTokens: purge(s.refresh, (*tokenRecord).refreshExpired),
+	}
+	for key, request := range s.pushed {
+		if request.Expired(now) {
+			delete(s.pushed, key)
+			result.PushedRequests++
+		}
+	}
+
+	// 删除已没有授权码和令牌的授权的使用记录
+	live := make(map[string]struct{})
+	for _, records := range []map[string]*tokenRecord{s.codes, s.access, s.refresh} {
+		for _, record := range records {
+			live[record.GrantId] = struct{}{}
+		}
+	}
+	for grantID := range s.usage {
+		if _, ok := live[grantID]; !ok {
+			delete(s.usage, grantID)
+		}
+	}
+	return result, nil
+}
+
+// ListUserTokens returns the unexpired access and refresh tokens of a user, grouped by client.
+func (s *MemoryStorage) ListUserTokens(ctx context.Context, userID string) ([]ClientTokens, error) {
+	if userID == "" {
+		return nil, nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+	tokens := make(map[string][]UserToken)
+	for tokenType, records := range map[string]map[string]*tokenRecord{TokenTypeAccess: s.access, TokenTypeRefresh: s.refresh} {
+		for _, record := range records {
+			if record.UserId != userID || tokenExpired(tokenType, record, now) {
+				continue
+			}
+			token := record.userToken(tokenType)
+			if usage, ok := s.usage[record.GrantId]; ok {
+				usage.apply(&token)
+			}
+			tokens[record.ClientId] = append(tokens[record.ClientId], token)
+		}
+	}
+	return groupByClient(tokens), nil
+}
+
+// RevokeUserTokens deletes the codes and tokens of a user, limited to one client unless clientID is empty.
+func (s *MemoryStorage) RevokeUserTokens(ctx context.Context, userID, clientID string) (RevokeResult, error) {
+	return s.revoke(userID, func(record *tokenRecord) bool {
+		return clientID == "" || record.ClientId == clientID
+	}), nil
+}
+
+// RevokeUserGrant deletes the codes and tokens of one grant of a user.
+func (s *MemoryStorage) RevokeUserGrant(ctx context.Context, userID, grantID string) (RevokeResult, error) {
+	return s.revoke(userID, func(record *tokenRecord) bool {
+		return record.GrantId == grantID
+	}), nil
+}
+
+// revoke deletes the codes and tokens of userID that match.
+func (s *MemoryStorage) revoke(userID string, match func(record *tokenRecord) bool) RevokeResult {
+	var result RevokeResult
+	if userID == "" {
+		return result
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	grants := make(map[string]struct{})
+	for _, table := range []struct {
+		records map[string]*tokenRecord
+		access  bool
+	}{
+		{s.codes, false},
+		{s.access, true},
+		{s.refresh, false},
+	} {
+		for key, record := range table.records {
+			if record.UserId != userID || !match(record) {
+				continue
+			}
+			if table.access {
+				result.AccessTokens = append(result.AccessTokens, key)
+			}
+			grants[record.GrantId] = struct{}{}
+			delete(table.records, key)
+		}
+	}
+	for grantID := range grants {
+		delete(s.usage, grantID)
+	}
+	result.Grants = int64(len(grants))
+	return result
+}
+
+// TouchAccess marks the grant of token as used at usedAt from ip. Unknown tokens are ignored.
+func (s *MemoryStorage) TouchAccess(ctx context.Context, token, ip string, usedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if record, ok := s.access[s.hasher.Hash(token)]; ok {
+		s.usage[record.GrantId] = s.usage[record.GrantId].touch(ip, usedAt)
+	}
+	return nil
+}
+
+// grantOf returns the grant and user of the exchanged code or the refreshed token,
+// or a new grant id and the user of data. The caller must hold the lock.
+func (s *MemoryStorage) grantOf(data *osin.AccessData) (string, string, error) {
+	var record *tokenRecord
+	switch {
+	case data.AuthorizeData != nil && data.AuthorizeData.Code != "":
+		record = s.codes[s.hasher.Hash(data.AuthorizeData.Code)]
+	case data.AccessData != nil && data.AccessData.RefreshToken != "":
+		record = s.refresh[s.hasher.Hash(data.AccessData.RefreshToken)]
+	case data.AccessData != nil && data.AccessData.AccessToken != "":
+		record = s.access[s.hasher.Hash(data.AccessData.AccessToken)]
+	}
+	if record != nil {
+		return record.GrantId, record.UserId, nil
+	}
+	grantID, err := newGrantID()
+	return grantID, userIDOf(data.UserData), err
+}
+
+// SavePushedRequest stores request under requestURI.
+func (s *MemoryStorage) SavePushedRequest(ctx context.Context, requestURI string, request *PushedRequest) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	copied := *request
+	copied.Params = cloneValues(request.Params)
+	s.pushed[s.hasher.Hash(requestURI)] = &copied
+	return nil
+}
+
+// TakePushedRequest returns the request stored under requestURI and removes it.
+func (s *MemoryStorage) TakePushedRequest(ctx context.Context, requestURI string) (*PushedRequest, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := s.hasher.Hash(requestURI)
+	request, ok := s.pushed[key]
+	if !ok {
+		return nil, osin.ErrNotFound
+	}
+	delete(s.pushed, key)
+	if request.Expired(time.Now()) {
+		return nil, osin.ErrNotFound
+	}
+	return request, nil
+}
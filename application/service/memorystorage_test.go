@@ -0,0 +1,14 @@
+package service_test
+
+import (
+	"testing"
+
+	"oauth2/application/service"
+	"oauth2/application/service/storagetest"
+)
+
+func TestMemoryStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T, opts service.Options) service.OAuthStorage {
+		return service.NewMemoryStorage(opts)
+	})
+}
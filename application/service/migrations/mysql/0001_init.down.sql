@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS {prefix}token;
+
+DROP TABLE IF EXISTS {prefix}client;
@@ -0,0 +1,28 @@
+CREATE TABLE IF NOT EXISTS {prefix}client (
+	id           varchar(255) NOT NULL PRIMARY KEY,
+	secret       varchar(255) NOT NULL,
+	extra        text,
+	redirect_uri varchar(255) NOT NULL,
+	created_at   timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS {prefix}token (
+	id            varchar(255) NOT NULL PRIMARY KEY,
+	client_id     varchar(255) NOT NULL,
+	type          varchar(20) NOT NULL,    -- 'authorize' 或 'access'
+	access_token  varchar(255),            -- 访问令牌
+	refresh_token varchar(255),            -- 刷新令牌
+	code          varchar(255),            -- 授权码
+	expires_in    int NOT NULL,
+	scope         varchar(255),
+	redirect_uri  varchar(255) NOT NULL,
+	state         varchar(255),
+	extra         text,
+	created_at    timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	expires_at    timestamp NULL,
+	INDEX idx_refresh (refresh_token),
+	INDEX idx_expires (expires_at),
+	INDEX idx_access_token (access_token),
+	INDEX idx_code (code),
+	FOREIGN KEY (client_id) REFERENCES {prefix}client(id) ON DELETE CASCADE
+);
@@ -0,0 +1,39 @@
+CREATE TABLE IF NOT EXISTS {prefix}token (
+	id            varchar(255) NOT NULL PRIMARY KEY,
+	client_id     varchar(255) NOT NULL,
+	type          varchar(20) NOT NULL,    -- 'authorize' 或 'access'
+	access_token  varchar(255),            -- 访问令牌
+	refresh_token varchar(255),            -- 刷新令牌
+	code          varchar(255),            -- 授权码
+	expires_in    int NOT NULL,
+	scope         varchar(255),
+	redirect_uri  varchar(255) NOT NULL,
+	state         varchar(255),
+	extra         text,
+	created_at    timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	expires_at    timestamp NULL,
+	INDEX idx_refresh (refresh_token),
+	INDEX idx_expires (expires_at),
+	INDEX idx_access_token (access_token),
+	INDEX idx_code (code),
+	CONSTRAINT {prefix}token_client_fk FOREIGN KEY (client_id) REFERENCES {prefix}client(id) ON DELETE CASCADE
+);
+
+INSERT INTO {prefix}token (id, client_id, type, code, expires_in, scope, redirect_uri, state, extra, created_at, expires_at)
+SELECT c.code_hash, g.client_id, 'authorize', c.code_hash, c.expires_in, c.scope, g.redirect_uri, c.state, c.extra, c.created_at, c.expires_at
+FROM {prefix}code c JOIN {prefix}access_grant g ON g.id = c.grant_id;
+
+-- 刷新令牌只能随其访问令牌一起保留
+INSERT INTO {prefix}token (id, client_id, type, access_token, refresh_token, expires_in, scope, redirect_uri, extra, created_at, expires_at)
+SELECT a.token_hash, g.client_id, 'access', a.token_hash, r.token_hash, a.expires_in, a.scope, g.redirect_uri, a.extra, a.created_at, a.expires_at
+FROM {prefix}access_token a
+JOIN {prefix}access_grant g ON g.id = a.grant_id
+LEFT JOIN {prefix}refresh_token r ON r.access_token_hash = a.token_hash;
+
+DROP TABLE {prefix}refresh_token;
+
+DROP TABLE {prefix}access_token;
+
+DROP TABLE {prefix}code;
+
+DROP TABLE {prefix}access_grant;
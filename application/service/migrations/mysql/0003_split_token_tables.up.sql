@@ -0,0 +1,65 @@
+CREATE TABLE IF NOT EXISTS {prefix}access_grant (
+	id           varchar(64) NOT NULL PRIMARY KEY,
+	client_id    varchar(255) NOT NULL,
+	redirect_uri varchar(255) NOT NULL,
+	created_at   timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	INDEX idx_client (client_id),
+	CONSTRAINT {prefix}access_grant_client_fk FOREIGN KEY (client_id) REFERENCES {prefix}client(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS {prefix}code (
+	code_hash    varchar(64) NOT NULL PRIMARY KEY,    -- 授权码摘要
+	grant_id     varchar(64) NOT NULL,
+	expires_in   int NOT NULL,
+	scope        varchar(255),
+	state        varchar(255),
+	extra        text,
+	created_at   timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	expires_at   timestamp NULL,
+	INDEX idx_expires (expires_at),
+	CONSTRAINT {prefix}code_grant_fk FOREIGN KEY (grant_id) REFERENCES {prefix}access_grant(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS {prefix}access_token (
+	token_hash   varchar(64) NOT NULL PRIMARY KEY,    -- 访问令牌摘要
+	grant_id     varchar(64) NOT NULL,
+	expires_in   int NOT NULL,
+	scope        varchar(255),
+	extra        text,
+	created_at   timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	expires_at   timestamp NULL,
+	INDEX idx_expires (expires_at),
+	CONSTRAINT {prefix}access_token_grant_fk FOREIGN KEY (grant_id) REFERENCES {prefix}access_grant(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS {prefix}refresh_token (
+	token_hash        varchar(64) NOT NULL PRIMARY KEY,    -- 刷新令牌摘要
+	grant_id          varchar(64) NOT NULL,
+	access_token_hash varchar(64),                          -- 同时签发的访问令牌
+	expires_in        int NOT NULL,                         -- 0 表示永不过期
+	scope             varchar(255),
+	extra             text,
+	created_at        timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	expires_at        timestamp NULL,
+	INDEX idx_expires (expires_at),
+	CONSTRAINT {prefix}refresh_token_grant_fk FOREIGN KEY (grant_id) REFERENCES {prefix}access_grant(id) ON DELETE CASCADE,
+	CONSTRAINT {prefix}refresh_token_access_fk FOREIGN KEY (access_token_hash) REFERENCES {prefix}access_token(token_hash) ON DELETE SET NULL
+);
+
+-- 每条旧记录成为一个独立的授权
+INSERT INTO {prefix}access_grant (id, client_id, redirect_uri, created_at)
+SELECT id, client_id, redirect_uri, created_at FROM {prefix}token;
+
+INSERT INTO {prefix}code (code_hash, grant_id, expires_in, scope, state, extra, created_at, expires_at)
+SELECT code, id, expires_in, scope, state, extra, created_at, expires_at
+FROM {prefix}token WHERE type = 'authorize' AND code IS NOT NULL;
+
+INSERT INTO {prefix}access_token (token_hash, grant_id, expires_in, scope, extra, created_at, expires_at)
+SELECT access_token, id, expires_in, scope, extra, created_at, expires_at
+FROM {prefix}token WHERE type = 'access' AND access_token IS NOT NULL;
+
+INSERT INTO {prefix}refresh_token (token_hash, grant_id, access_token_hash, expires_in, scope, extra, created_at, expires_at)
+SELECT refresh_token, id, access_token, 0, scope, extra, created_at, NULL
+FROM {prefix}token WHERE type = 'access' AND refresh_token IS NOT NULL AND access_token IS NOT NULL;
+
+DROP TABLE {prefix}token;
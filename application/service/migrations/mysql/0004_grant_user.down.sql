@@ -0,0 +1,3 @@
+ALTER TABLE {prefix}access_grant
+	DROP INDEX idx_user_client,
+	DROP COLUMN user_id;
@@ -0,0 +1,4 @@
+-- 授权所属的用户，客户端授权没有用户
+ALTER TABLE {prefix}access_grant
+	ADD COLUMN user_id varchar(255) NULL AFTER client_id,
+	ADD INDEX idx_user_client (user_id, client_id);
@@ -0,0 +1,3 @@
+ALTER TABLE {prefix}access_grant
+	DROP COLUMN last_used_at,
+	DROP COLUMN last_used_ip;
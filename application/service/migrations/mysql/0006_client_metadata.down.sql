@@ -0,0 +1,15 @@
+ALTER TABLE {prefix}client
+	DROP INDEX idx_owner,
+	DROP COLUMN tos_uri,
+	DROP COLUMN policy_uri,
+	DROP COLUMN logo_uri,
+	DROP COLUMN owner_id,
+	DROP COLUMN client_status,
+	DROP COLUMN require_pkce,
+	DROP COLUMN refresh_token_lifetime,
+	DROP COLUMN access_token_lifetime,
+	DROP COLUMN auth_method,
+	DROP COLUMN scopes,
+	DROP COLUMN grant_types,
+	DROP COLUMN client_name,
+	DROP COLUMN client_type;
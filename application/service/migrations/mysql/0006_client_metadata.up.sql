@@ -0,0 +1,16 @@
+-- 客户端的类型化属性，extra 只保存创建方的私有数据(JSON)
+ALTER TABLE {prefix}client
+	ADD COLUMN client_type varchar(16) NOT NULL DEFAULT 'confidential' AFTER redirect_uri,
+	ADD COLUMN client_name varchar(255) NULL AFTER client_type,
+	ADD COLUMN grant_types varchar(255) NULL AFTER client_name,
+	ADD COLUMN scopes varchar(1024) NULL AFTER grant_types,
+	ADD COLUMN auth_method varchar(64) NULL AFTER scopes,
+	ADD COLUMN access_token_lifetime int NOT NULL DEFAULT 0 AFTER auth_method,
+	ADD COLUMN refresh_token_lifetime int NOT NULL DEFAULT 0 AFTER access_token_lifetime,
+	ADD COLUMN require_pkce tinyint(1) NOT NULL DEFAULT 0 AFTER refresh_token_lifetime,
+	ADD COLUMN client_status varchar(16) NOT NULL DEFAULT 'active' AFTER require_pkce,
+	ADD COLUMN owner_id varchar(255) NULL AFTER client_status,
+	ADD COLUMN logo_uri varchar(512) NULL AFTER owner_id,
+	ADD COLUMN policy_uri varchar(512) NULL AFTER logo_uri,
+	ADD COLUMN tos_uri varchar(512) NULL AFTER policy_uri,
+	ADD INDEX idx_owner (owner_id);
@@ -0,0 +1,4 @@
+ALTER TABLE {prefix}client
+	DROP COLUMN jwks,
+	DROP COLUMN jwks_uri,
+	DROP COLUMN require_signed_request;
@@ -0,0 +1,5 @@
+-- RFC 9101 请求对象：客户端的公钥集和是否必须使用签名的请求对象
+ALTER TABLE {prefix}client
+	ADD COLUMN require_signed_request tinyint(1) NOT NULL DEFAULT 0 AFTER require_par,
+	ADD COLUMN jwks_uri varchar(512) NULL AFTER tos_uri,
+	ADD COLUMN jwks text NULL AFTER jwks_uri;
@@ -0,0 +1 @@
+ALTER TABLE {prefix}client DROP COLUMN response_modes;
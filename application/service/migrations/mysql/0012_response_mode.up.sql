@@ -0,0 +1,2 @@
+-- 客户端允许的授权响应模式，以空格分隔，为空时不限制
+ALTER TABLE {prefix}client ADD COLUMN response_modes varchar(255) NULL AFTER scopes;
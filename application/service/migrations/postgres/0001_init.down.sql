@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS {prefix}refresh_token;
+DROP TABLE IF EXISTS {prefix}access_token;
+DROP TABLE IF EXISTS {prefix}code;
+DROP TABLE IF EXISTS {prefix}access_grant;
+DROP TABLE IF EXISTS {prefix}client;
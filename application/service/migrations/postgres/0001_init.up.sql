@@ -0,0 +1,58 @@
+CREATE TABLE IF NOT EXISTS {prefix}client (
+	id           varchar(255) NOT NULL PRIMARY KEY,
+	secret       varchar(255) NOT NULL,
+	extra        text,
+	redirect_uri varchar(255) NOT NULL,
+	created_at   timestamptz NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS {prefix}access_grant (
+	id           varchar(64) NOT NULL PRIMARY KEY,
+	client_id    varchar(255) NOT NULL,
+	redirect_uri varchar(255) NOT NULL,
+	created_at   timestamptz NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	CONSTRAINT {prefix}access_grant_client_fk FOREIGN KEY (client_id) REFERENCES {prefix}client(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS {prefix}access_grant_client_idx ON {prefix}access_grant (client_id);
+
+CREATE TABLE IF NOT EXISTS {prefix}code (
+	code_hash    varchar(64) NOT NULL PRIMARY KEY,    -- 授权码摘要
+	grant_id     varchar(64) NOT NULL,
+	expires_in   integer NOT NULL,
+	scope        varchar(255),
+	state        varchar(255),
+	extra        text,
+	created_at   timestamptz NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	expires_at   timestamptz NULL,
+	CONSTRAINT {prefix}code_grant_fk FOREIGN KEY (grant_id) REFERENCES {prefix}access_grant(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS {prefix}code_grant_idx ON {prefix}code (grant_id);
+CREATE INDEX IF NOT EXISTS {prefix}code_expires_idx ON {prefix}code (expires_at);
+
+CREATE TABLE IF NOT EXISTS {prefix}access_token (
+	token_hash   varchar(64) NOT NULL PRIMARY KEY,    -- 访问令牌摘要
+	grant_id     varchar(64) NOT NULL,
+	expires_in   integer NOT NULL,
+	scope        varchar(255),
+	extra        text,
+	created_at   timestamptz NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	expires_at   timestamptz NULL,
+	CONSTRAINT {prefix}access_token_grant_fk FOREIGN KEY (grant_id) REFERENCES {prefix}access_grant(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS {prefix}access_token_grant_idx ON {prefix}access_token (grant_id);
+CREATE INDEX IF NOT EXISTS {prefix}access_token_expires_idx ON {prefix}access_token (expires_at);
+
+CREATE TABLE IF NOT EXISTS {prefix}refresh_token (
+	token_hash        varchar(64) NOT NULL PRIMARY KEY,    -- 刷新令牌摘要
+	grant_id          varchar(64) NOT NULL,
+	access_token_hash varchar(64),                          -- 同时签发的访问令牌
+	expires_in        integer NOT NULL,                     -- 0 表示永不过期
+	scope             varchar(255),
+	extra             text,
+	created_at        timestamptz NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	expires_at        timestamptz NULL,
+	CONSTRAINT {prefix}refresh_token_grant_fk FOREIGN KEY (grant_id) REFERENCES {prefix}access_grant(id) ON DELETE CASCADE,
+	CONSTRAINT {prefix}refresh_token_access_fk FOREIGN KEY (access_token_hash) REFERENCES {prefix}access_token(token_hash) ON DELETE SET NULL
+);
+CREATE INDEX IF NOT EXISTS {prefix}refresh_token_grant_idx ON {prefix}refresh_token (grant_id);
+CREATE INDEX IF NOT EXISTS {prefix}refresh_token_expires_idx ON {prefix}refresh_token (expires_at);
@@ -0,0 +1,3 @@
+-- 授权所属的用户，客户端授权没有用户
+ALTER TABLE {prefix}access_grant ADD COLUMN user_id varchar(255) NULL;
+CREATE INDEX IF NOT EXISTS {prefix}access_grant_user_idx ON {prefix}access_grant (user_id, client_id);
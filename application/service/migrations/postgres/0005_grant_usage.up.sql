@@ -0,0 +1,3 @@
+-- 授权最近一次使用的时间和IP
+ALTER TABLE {prefix}access_grant ADD COLUMN last_used_at timestamptz NULL;
+ALTER TABLE {prefix}access_grant ADD COLUMN last_used_ip varchar(64) NULL;
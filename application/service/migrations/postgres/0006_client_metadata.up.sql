@@ -0,0 +1,16 @@
+-- 客户端的类型化属性，extra 只保存创建方的私有数据(JSON)
+ALTER TABLE {prefix}client
+	ADD COLUMN client_type varchar(16) NOT NULL DEFAULT 'confidential',
+	ADD COLUMN client_name varchar(255) NULL,
+	ADD COLUMN grant_types varchar(255) NULL,
+	ADD COLUMN scopes varchar(1024) NULL,
+	ADD COLUMN auth_method varchar(64) NULL,
+	ADD COLUMN access_token_lifetime integer NOT NULL DEFAULT 0,
+	ADD COLUMN refresh_token_lifetime integer NOT NULL DEFAULT 0,
+	ADD COLUMN require_pkce boolean NOT NULL DEFAULT false,
+	ADD COLUMN client_status varchar(16) NOT NULL DEFAULT 'active',
+	ADD COLUMN owner_id varchar(255) NULL,
+	ADD COLUMN logo_uri varchar(512) NULL,
+	ADD COLUMN policy_uri varchar(512) NULL,
+	ADD COLUMN tos_uri varchar(512) NULL;
+CREATE INDEX IF NOT EXISTS {prefix}client_owner_idx ON {prefix}client (owner_id);
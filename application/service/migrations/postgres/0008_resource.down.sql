@@ -0,0 +1,2 @@
+ALTER TABLE {prefix}access_grant DROP COLUMN resource;
+DROP TABLE IF EXISTS {prefix}resource;
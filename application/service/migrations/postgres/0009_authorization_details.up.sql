@@ -0,0 +1,9 @@
+-- 授权详情类型注册表，authorization_details 中的每一项必须是已注册的类型并符合其JSON Schema
+CREATE TABLE IF NOT EXISTS {prefix}authorization_detail_type (
+	detail_type varchar(255) NOT NULL PRIMARY KEY,
+	description varchar(512) NOT NULL DEFAULT '',
+	json_schema text NOT NULL,
+	created_at  timestamptz NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+-- 授权的RFC 9396授权详情，JSON数组
+ALTER TABLE {prefix}access_grant ADD COLUMN authorization_details text NULL;
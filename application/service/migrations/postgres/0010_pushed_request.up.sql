@@ -0,0 +1,12 @@
+-- RFC 9126 推送的授权请求，按 request_uri 的摘要保存，使用一次后删除
+CREATE TABLE IF NOT EXISTS {prefix}pushed_request (
+	request_hash varchar(64) NOT NULL PRIMARY KEY,    -- request_uri 摘要
+	client_id    varchar(255) NOT NULL,
+	params       text NOT NULL,                        -- 授权请求参数，URL编码
+	created_at   timestamptz NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	expires_at   timestamptz NULL
+);
+CREATE INDEX IF NOT EXISTS {prefix}pushed_request_client_idx ON {prefix}pushed_request (client_id);
+CREATE INDEX IF NOT EXISTS {prefix}pushed_request_expires_idx ON {prefix}pushed_request (expires_at);
+-- 客户端是否必须先推送授权请求
+ALTER TABLE {prefix}client ADD COLUMN require_par boolean NOT NULL DEFAULT false;
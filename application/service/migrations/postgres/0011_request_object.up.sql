@@ -0,0 +1,4 @@
+-- RFC 9101 请求对象：客户端的公钥集和是否必须使用签名的请求对象
+ALTER TABLE {prefix}client ADD COLUMN require_signed_request boolean NOT NULL DEFAULT false;
+ALTER TABLE {prefix}client ADD COLUMN jwks_uri varchar(512) NULL;
+ALTER TABLE {prefix}client ADD COLUMN jwks text NULL;
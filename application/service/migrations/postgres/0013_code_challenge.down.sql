@@ -0,0 +1,2 @@
+ALTER TABLE {prefix}code DROP COLUMN code_challenge_method;
+ALTER TABLE {prefix}code DROP COLUMN code_challenge;
@@ -0,0 +1,4 @@
+-- 授权码绑定的PKCE挑战，换取令牌时用于校验 code_verifier
+ALTER TABLE {prefix}code ADD COLUMN code_challenge varchar(128) NULL;
+-- PKCE挑战的计算方式：plain 或 S256
+ALTER TABLE {prefix}code ADD COLUMN code_challenge_method varchar(16) NULL;
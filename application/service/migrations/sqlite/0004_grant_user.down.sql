@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS {prefix}access_grant_user_idx;
+ALTER TABLE {prefix}access_grant DROP COLUMN user_id;
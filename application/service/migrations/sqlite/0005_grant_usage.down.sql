@@ -0,0 +1,2 @@
+ALTER TABLE {prefix}access_grant DROP COLUMN last_used_ip;
+ALTER TABLE {prefix}access_grant DROP COLUMN last_used_at;
@@ -0,0 +1,14 @@
+DROP INDEX IF EXISTS {prefix}client_owner_idx;
+ALTER TABLE {prefix}client DROP COLUMN tos_uri;
+ALTER TABLE {prefix}client DROP COLUMN policy_uri;
+ALTER TABLE {prefix}client DROP COLUMN logo_uri;
+ALTER TABLE {prefix}client DROP COLUMN owner_id;
+ALTER TABLE {prefix}client DROP COLUMN client_status;
+ALTER TABLE {prefix}client DROP COLUMN require_pkce;
+ALTER TABLE {prefix}client DROP COLUMN refresh_token_lifetime;
+ALTER TABLE {prefix}client DROP COLUMN access_token_lifetime;
+ALTER TABLE {prefix}client DROP COLUMN auth_method;
+ALTER TABLE {prefix}client DROP COLUMN scopes;
+ALTER TABLE {prefix}client DROP COLUMN grant_types;
+ALTER TABLE {prefix}client DROP COLUMN client_name;
+ALTER TABLE {prefix}client DROP COLUMN client_type;
@@ -0,0 +1,8 @@
+-- 权限范围注册表，授权请求只能申请已注册的权限范围
+CREATE TABLE IF NOT EXISTS {prefix}scope (
+	name             varchar(255) NOT NULL PRIMARY KEY,
+	description      varchar(512) NOT NULL DEFAULT '',
+	consent_required boolean NOT NULL DEFAULT 1,  -- 授权时是否需要用户同意
+	is_default       boolean NOT NULL DEFAULT 0,  -- 授权请求未指定scope时授予
+	created_at       timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,9 @@
+-- 资源注册表，授权请求的 resource 参数只能指定已注册的资源
+CREATE TABLE IF NOT EXISTS {prefix}resource (
+	uri         varchar(255) NOT NULL PRIMARY KEY,
+	description varchar(512) NOT NULL DEFAULT '',
+	scopes      varchar(1024) NOT NULL DEFAULT '',  -- 资源接受的权限范围，空格分隔，为空时不限制
+	created_at  timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+-- 授权的受众资源，空格分隔，为空表示令牌不限受众
+ALTER TABLE {prefix}access_grant ADD COLUMN resource varchar(2048) NULL;
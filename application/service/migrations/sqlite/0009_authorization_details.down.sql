@@ -0,0 +1,2 @@
+ALTER TABLE {prefix}access_grant DROP COLUMN authorization_details;
+DROP TABLE IF EXISTS {prefix}authorization_detail_type;
@@ -0,0 +1,2 @@
+ALTER TABLE {prefix}client DROP COLUMN require_par;
+DROP TABLE IF EXISTS {prefix}pushed_request;
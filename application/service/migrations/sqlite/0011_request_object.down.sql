@@ -0,0 +1,3 @@
+ALTER TABLE {prefix}client DROP COLUMN jwks;
+ALTER TABLE {prefix}client DROP COLUMN jwks_uri;
+ALTER TABLE {prefix}client DROP COLUMN require_signed_request;
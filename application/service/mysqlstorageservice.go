@@ -3,9 +3,13 @@ package service
 // Package mysql is a osin storage implementation for mysql.
 
 import (
+	"context"
 	"database/sql"
+	"embed"
 	"fmt"
-	"oauth2/infrastructure/svc"
+	"net/url"
+	"oauth2/common/util"
+	"oauth2/infrastructure/migration"
 	"strings"
 	"time"
 
@@ -14,61 +18,161 @@ import (
 
 	// driver for mysql db
 	_ "github.com/go-sql-driver/mysql"
+	// driver for postgres db
+	_ "github.com/jackc/pgx/v5/stdlib"
 )
 
-var schemas = []string{`CREATE TABLE IF NOT EXISTS {prefix}client (
-	id           varchar(255) NOT NULL PRIMARY KEY,
-	secret       varchar(255) NOT NULL,
-	extra        text,
-	redirect_uri varchar(255) NOT NULL,
-	created_at   timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
-)`, `CREATE TABLE IF NOT EXISTS {prefix}token (
-	id            varchar(255) NOT NULL PRIMARY KEY,
-	client_id     varchar(255) NOT NULL,
-	type          varchar(20) NOT NULL,    -- 'authorize' 或 'access'
-	access_token  varchar(255),            -- 访问令牌
-	refresh_token varchar(255),            -- 刷新令牌
-	code          varchar(255),            -- 授权码
-	expires_in    int NOT NULL,
-	scope         varchar(255),
-	redirect_uri  varchar(255) NOT NULL,
-	state         varchar(255),
-	extra         text,
-	created_at    timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
-	expires_at    timestamp NULL,
-	INDEX idx_refresh (refresh_token),
-	INDEX idx_expires (expires_at),
-	INDEX idx_access_token (access_token),
-	INDEX idx_code (code),
-	FOREIGN KEY (client_id) REFERENCES {prefix}client(id) ON DELETE CASCADE
-)`}
+// Supported SQL drivers of Storage.
+const (
+	DriverMySQL    = "mysql"
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite"
+)
+
+//go:embed migrations/mysql/*.sql migrations/postgres/*.sql migrations/sqlite/*.sql
+var sqlMigrations embed.FS
 
 // Storage implements interface "github.com/RangelReale/osin".Storage and interface "github.com/felipeweb/osin-mysql/storage".Storage
+// Codes and tokens are only persisted as digests produced by the TokenHasher.
+// Every code and token belongs to a grant; tokens issued by refreshing stay on the grant of the original code.
+// Times are written in UTC so that SQLite, which stores them as text, compares them correctly.
 type Storage struct {
-	db          sqlx.SqlConn
-	tablePrefix string
+	db                sqlx.SqlConn
+	driver            string
+	dialect           migration.Dialect
+	reader            *replicaReader
+	clients           ClientStorage
+	tablePrefix       string
+	hasher            *TokenHasher
+	refreshExpiration int32
 }
 
-// New returns a new mysql storage instance.
-func NewStorage(svcCtx *svc.ServiceContext, tablePrefix string) *Storage {
+// NewStorage returns a new SQL storage instance for opts.Driver, MySQL by default.
+// SQLite connections should be opened with SQLiteDataSource.
+// Clients are read and written through opts.Clients, or directly in the database if it is nil.
+// The tokens listed for a user are read from opts.Replicas when given; codes, tokens and clients are
+// always loaded from the primary, so revocations take effect immediately.
+func NewStorage(db sqlx.SqlConn, opts Options) *Storage {
+	driver, dialect := sqlDialect(opts.Driver)
+	clients := opts.Clients
+	if clients == nil {
+		clients = NewSQLClientStorage(db, opts)
+	}
 	return &Storage{
-		db:          svcCtx.DB,
-		tablePrefix: tablePrefix,
+		db:                db,
+		driver:            driver,
+		dialect:           dialect,
+		reader:            newReplicaReader(db, opts.Replicas),
+		clients:           clients,
+		tablePrefix:       opts.TablePrefix,
+		hasher:            opts.Hasher,
+		refreshExpiration: opts.RefreshExpiration,
 	}
 }
 
-// CreateSchemas creates the schemata, if they do not exist yet in the database. Returns an error if something went wrong.
-func (s *Storage) CreateSchemas() error {
-	for _, schema := range schemas {
-		schema = strings.Replace(schema, "{prefix}", s.tablePrefix, -1)
-		_, err := s.db.Exec(schema)
+// sqlDialect returns the normalized driver name and the migration dialect of driver.
+func sqlDialect(driver string) (string, migration.Dialect) {
+	switch driver {
+	case DriverPostgres:
+		return driver, migration.PostgresDialect{Timeout: time.Minute}
+	case DriverSQLite:
+		return driver, migration.SQLiteDialect{}
+	default:
+		return DriverMySQL, migration.MySQLDialect{Timeout: time.Minute}
+	}
+}
+
+// Migrations returns the versioned schema migrations of the storage.
+func (s *Storage) Migrations() ([]migration.Migration, error) {
+	migrations, err := migration.FromFS(sqlMigrations, "migrations/"+s.driver, s.tablePrefix)
+	if err != nil {
+		return nil, err
+	}
+	// 只有 MySQL 存在哈希之前写入的旧数据
+	if s.driver == DriverMySQL {
+		migrations = append(migrations, migration.Migration{
+			Version: 2,
+			Name:    "hash_tokens",
+			Up:      s.hashLegacyTokens,
+		})
+		migration.Sort(migrations)
+	}
+	return migrations, nil
+}
+
+// hashLegacyTokens replaces the raw codes and tokens written before hashing was introduced by their digests.
+func (s *Storage) hashLegacyTokens(ctx context.Context, tx *sql.Tx) error {
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf("SELECT id, code, access_token, refresh_token FROM %stoken", s.tablePrefix))
+	if err != nil {
+		return err
+	}
+
+	type legacyToken struct {
+		id, code, accessToken, refreshToken sql.NullString
+	}
+	var tokens []legacyToken
+	for rows.Next() {
+		var t legacyToken
+		if err := rows.Scan(&t.id, &t.code, &t.accessToken, &t.refreshToken); err != nil {
+			rows.Close()
+			return err
+		}
+		tokens = append(tokens, t)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf("UPDATE %stoken SET id = ?, code = ?, access_token = ?, refresh_token = ? WHERE id = ?", s.tablePrefix)
+	for _, t := range tokens {
+		_, err := tx.ExecContext(ctx, query,
+			s.hashNullable(util.SqlToString(t.id)),
+			s.hashNullable(util.SqlToString(t.code)),
+			s.hashNullable(util.SqlToString(t.accessToken)),
+			s.hashNullable(util.SqlToString(t.refreshToken)),
+			t.id.String,
+		)
 		if err != nil {
-			return fmt.Errorf("创建表失败: %v", err)
+			return err
 		}
 	}
 	return nil
 }
 
+// hashNullable hashes token, keeping empty values as NULL so they can never be looked up.
+func (s *Storage) hashNullable(token string) sql.NullString {
+	if token == "" {
+		return sql.NullString{}
+	}
+	return util.StringToSql(s.hasher.Hash(token))
+}
+
+// NewMigrator returns a migrator that applies Migrations under a database lock.
+func (s *Storage) NewMigrator() (*migration.Migrator, error) {
+	migrations, err := s.Migrations()
+	if err != nil {
+		return nil, err
+	}
+	db, err := s.db.RawDB()
+	if err != nil {
+		return nil, fmt.Errorf("获取数据库连接失败: %v", err)
+	}
+	return migration.NewMigrator(db, s.tablePrefix, s.dialect, migrations), nil
+}
+
+// CreateSchemas applies all pending migrations. Returns an error if something went wrong.
+func (s *Storage) CreateSchemas() error {
+	migrator, err := s.NewMigrator()
+	if err != nil {
+		return err
+	}
+	if _, err := migrator.Up(context.Background()); err != nil {
+		return fmt.Errorf("创建表失败: %v", err)
+	}
+	return nil
+}
+
 // Clone the storage if needed. For example, using mgo, you can clone the session with session.Clone
 // to avoid concurrent access problems.
 // This is to avoid cloning the connection at each method access.
@@ -83,87 +187,51 @@ func (s *Storage) Close() {
 
 // GetClient loads the client by id
 func (s *Storage) GetClient(id string) (osin.Client, error) {
-	var result struct {
-		Id          string         `db:"id"`
-		Secret      string         `db:"secret"`
-		RedirectUri string         `db:"redirect_uri"`
-		Extra       sql.NullString `db:"extra"`
-	}
-
-	query := fmt.Sprintf("SELECT id, secret, redirect_uri, extra FROM %sclient WHERE id = ?", s.tablePrefix)
-	err := s.db.QueryRowPartial(&result, query, id)
-
-	if err == sql.ErrNoRows {
-		return nil, osin.ErrNotFound
-	} else if err != nil {
-		return nil, fmt.Errorf("获取客户端失败: %v", err)
-	}
-
-	client := &osin.DefaultClient{
-		Id:          result.Id,
-		Secret:      result.Secret,
-		RedirectUri: result.RedirectUri,
-	}
-
-	if result.Extra.Valid {
-		client.UserData = result.Extra.String
-	}
-
-	return client, nil
+	return s.clients.GetClient(id)
 }
 
 // UpdateClient updates the client (identified by it's id) and replaces the values with the values of client.
 func (s *Storage) UpdateClient(c osin.Client) error {
-	query := fmt.Sprintf("UPDATE %sclient SET secret=?, redirect_uri=?, extra=? WHERE id=?", s.tablePrefix)
-	_, err := s.db.Exec(query,
-		c.GetSecret(),
-		c.GetRedirectUri(),
-		toString(c.GetUserData()),
-		c.GetId(),
-	)
-	if err != nil {
-		return fmt.Errorf("更新客户端失败: %v", err)
-	}
-	return nil
+	return s.clients.UpdateClient(c)
 }
 
 // CreateClient stores the client in the database and returns an error, if something went wrong.
 func (s *Storage) CreateClient(c osin.Client) error {
-	data := toString(c.GetUserData())
-
-	if _, err := s.db.Exec(fmt.Sprintf("INSERT INTO %sclient (id, secret, redirect_uri, extra) VALUES (?, ?, ?, ?)", s.tablePrefix), c.GetId(), c.GetSecret(), c.GetRedirectUri(), data); err != nil {
-		return err
-	}
-	return nil
+	return s.clients.CreateClient(c)
 }
 
-// RemoveClient removes a client (identified by id) from the database. Returns an error if something went wrong.
-func (s *Storage) RemoveClient(id string) (err error) {
-	if _, err = s.db.Exec(fmt.Sprintf("DELETE FROM %sclient WHERE id=?", s.tablePrefix), id); err != nil {
-		return err
-	}
-	return nil
+// RemoveClient removes a client (identified by id) together with its grants, codes and tokens.
+// Returns an error if something went wrong.
+func (s *Storage) RemoveClient(id string) error {
+	return s.clients.RemoveClient(id)
 }
 
 // SaveAuthorize saves authorize data.
 func (s *Storage) SaveAuthorize(data *osin.AuthorizeData) error {
-	query := fmt.Sprintf(`INSERT INTO %stoken (
-		id, client_id, type, code, expires_in, scope, 
-		redirect_uri, state, extra, expires_at
-	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, s.tablePrefix)
-
-	_, err := s.db.Exec(query,
-		data.Code,
-		data.Client.GetId(),
-		"authorize",
-		data.Code,
-		data.ExpiresIn,
-		data.Scope,
-		data.RedirectUri,
-		data.State,
-		toString(data.UserData),
-		data.ExpireAt(),
-	)
+	err := s.db.Transact(func(session sqlx.Session) error {
+		grantID, err := s.createGrant(session, data.Client.GetId(), GrantDataOf(data.UserData), data.RedirectUri, data.CreatedAt)
+		if err != nil {
+			return err
+		}
+
+		query := fmt.Sprintf(`INSERT INTO %scode (
+			code_hash, grant_id, expires_in, scope, state, code_challenge, code_challenge_method,
+			extra, created_at, expires_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, s.tablePrefix)
+		_, err = session.Exec(s.dialect.Rebind(query),
+			s.hasher.Hash(data.Code),
+			grantID,
+			data.ExpiresIn,
+			data.Scope,
+			data.State,
+			util.StringToSql(data.CodeChallenge),
+			util.StringToSql(data.CodeChallengeMethod),
+			toString(data.UserData),
+			data.CreatedAt.UTC(),
+			data.ExpireAt().UTC(),
+		)
+		return err
+	})
 
 	if err != nil {
 		return fmt.Errorf("保存授权数据失败: %v", err)
@@ -177,21 +245,25 @@ func (s *Storage) SaveAuthorize(data *osin.AuthorizeData) error {
 func (s *Storage) LoadAuthorize(code string) (*osin.AuthorizeData, error) {
 	var result struct {
 		ClientId    string         `db:"client_id"`
-		Code        string         `db:"code"`
 		ExpiresIn   int32          `db:"expires_in"`
-		Scope       string         `db:"scope"`
+		Scope       sql.NullString `db:"scope"`
 		RedirectUri string         `db:"redirect_uri"`
-		State       string         `db:"state"`
+		State       sql.NullString `db:"state"`
 		Extra       sql.NullString `db:"extra"`
 		CreatedAt   time.Time      `db:"created_at"`
 		ExpiresAt   time.Time      `db:"expires_at"`
+
+		CodeChallenge       sql.NullString `db:"code_challenge"`
+		CodeChallengeMethod sql.NullString `db:"code_challenge_method"`
 	}
 
-	query := fmt.Sprintf(`SELECT client_id, code, expires_in, scope, 
-		redirect_uri, state, extra, created_at, expires_at 
-		FROM %stoken WHERE code = ? AND type = 'authorize'`, s.tablePrefix)
+	query := fmt.Sprintf(`SELECT g.client_id, c.expires_in, c.scope, g.redirect_uri,
+		c.state, c.code_challenge, c.code_challenge_method, c.extra, c.created_at, c.expires_at
+		FROM %scode c JOIN %saccess_grant g ON g.id = c.grant_id
+		WHERE c.code_hash = ?`, s.tablePrefix, s.tablePrefix)
 
-	err := s.db.QueryRowPartial(&result, query, code)
+	// 授权码只从主库读取，保存后立即可用，删除后也不会从副本读到
+	err := s.db.QueryRowPartial(&result, s.dialect.Rebind(query), s.hasher.Hash(code))
 
 	if err == sqlx.ErrNotFound {
 		return nil, osin.ErrNotFound
@@ -212,12 +284,15 @@ func (s *Storage) LoadAuthorize(code string) (*osin.AuthorizeData, error) {
 
 	data := &osin.AuthorizeData{
 		Client:      client,
-		Code:        result.Code,
+		Code:        code,
 		ExpiresIn:   result.ExpiresIn,
-		Scope:       result.Scope,
+		Scope:       result.Scope.String,
 		RedirectUri: result.RedirectUri,
-		State:       result.State,
+		State:       result.State.String,
 		CreatedAt:   result.CreatedAt,
+
+		CodeChallenge:       result.CodeChallenge.String,
+		CodeChallengeMethod: result.CodeChallengeMethod.String,
 	}
 
 	if result.Extra.Valid {
@@ -229,8 +304,8 @@ func (s *Storage) LoadAuthorize(code string) (*osin.AuthorizeData, error) {
 
 // RemoveAuthorize revokes or deletes the authorization code.
 func (s *Storage) RemoveAuthorize(code string) error {
-	query := fmt.Sprintf("DELETE FROM %stoken WHERE code = ? AND type = 'authorize'", s.tablePrefix)
-	_, err := s.db.Exec(query, code)
+	query := fmt.Sprintf("DELETE FROM %scode WHERE code_hash = ?", s.tablePrefix)
+	_, err := s.db.Exec(s.dialect.Rebind(query), s.hasher.Hash(code))
 	if err != nil {
 		return fmt.Errorf("删除授权码失败: %v", err)
 	}
@@ -240,23 +315,50 @@ func (s *Storage) RemoveAuthorize(code string) error {
 // SaveAccess writes AccessData.
 // If RefreshToken is not blank, it must save in a way that can be loaded using LoadRefresh.
 func (s *Storage) SaveAccess(data *osin.AccessData) error {
-	query := fmt.Sprintf(`INSERT INTO %stoken (
-		id, client_id, type, access_token, refresh_token,
-		expires_in, scope, redirect_uri, extra, expires_at
-	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, s.tablePrefix)
+	accessHash := s.hasher.Hash(data.AccessToken)
 
 	err := s.db.Transact(func(session sqlx.Session) error {
-		_, err := session.Exec(query,
-			data.AccessToken,
-			data.Client.GetId(),
-			"access",
-			data.AccessToken,
-			data.RefreshToken,
+		grantID, err := s.grantOf(session, data)
+		if err != nil {
+			return err
+		}
+		if grantID == "" {
+			grantID, err = s.createGrant(session, data.Client.GetId(), GrantDataOf(data.UserData), data.RedirectUri, data.CreatedAt)
+			if err != nil {
+				return err
+			}
+		}
+
+		query := fmt.Sprintf(`INSERT INTO %saccess_token (
+			token_hash, grant_id, expires_in, scope, extra, created_at, expires_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?)`, s.tablePrefix)
+		_, err = session.Exec(s.dialect.Rebind(query),
+			accessHash,
+			grantID,
 			data.ExpiresIn,
 			data.Scope,
-			data.RedirectUri,
 			toString(data.UserData),
-			time.Now().Add(time.Duration(data.ExpiresIn)*time.Second),
+			data.CreatedAt.UTC(),
+			data.ExpireAt().UTC(),
+		)
+		if err != nil || data.RefreshToken == "" {
+			return err
+		}
+
+		// 刷新令牌的有效期独立于访问令牌，客户端可以单独配置
+		refreshExpiration := refreshLifetime(data.Client, s.refreshExpiration)
+		query = fmt.Sprintf(`INSERT INTO %srefresh_token (
+			token_hash, grant_id, access_token_hash, expires_in, scope, extra, created_at, expires_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`, s.tablePrefix)
+		_, err = session.Exec(s.dialect.Rebind(query),
+			s.hasher.Hash(data.RefreshToken),
+			grantID,
+			accessHash,
+			refreshExpiration,
+			data.Scope,
+			toString(data.UserData),
+			data.CreatedAt.UTC(),
+			refreshExpireAt(data.CreatedAt, refreshExpiration),
 		)
 		return err
 	})
@@ -270,24 +372,24 @@ func (s *Storage) SaveAccess(data *osin.AccessData) error {
 // LoadAccess retrieves access data by token. Client information MUST be loaded together.
 // AuthorizeData and AccessData DON'T NEED to be loaded if not easily available.
 // Optionally can return error if expired.
+// Only digests are stored, so the returned RefreshToken is always empty.
 func (s *Storage) LoadAccess(token string) (*osin.AccessData, error) {
 	var result struct {
-		ClientId     string         `db:"client_id"`
-		AccessToken  string         `db:"access_token"`
-		RefreshToken string         `db:"refresh_token"`
-		ExpiresIn    int32          `db:"expires_in"`
-		Scope        string         `db:"scope"`
-		RedirectUri  string         `db:"redirect_uri"`
-		Extra        sql.NullString `db:"extra"`
-		CreatedAt    time.Time      `db:"created_at"`
-		ExpiresAt    time.Time      `db:"expires_at"`
+		ClientId    string         `db:"client_id"`
+		ExpiresIn   int32          `db:"expires_in"`
+		Scope       sql.NullString `db:"scope"`
+		RedirectUri string         `db:"redirect_uri"`
+		Extra       sql.NullString `db:"extra"`
+		CreatedAt   time.Time      `db:"created_at"`
+		ExpiresAt   time.Time      `db:"expires_at"`
 	}
 
-	query := fmt.Sprintf(`SELECT client_id, access_token, refresh_token,
-		expires_in, scope, redirect_uri, extra, created_at, expires_at
-		FROM %stoken WHERE access_token = ? AND type = 'access'`, s.tablePrefix)
+	query := fmt.Sprintf(`SELECT g.client_id, a.expires_in, a.scope, g.redirect_uri,
+		a.extra, a.created_at, a.expires_at
+		FROM %saccess_token a JOIN %saccess_grant g ON g.id = a.grant_id
+		WHERE a.token_hash = ?`, s.tablePrefix, s.tablePrefix)
 
-	err := s.db.QueryRowPartial(&result, query, token)
+	err := s.db.QueryRowPartial(&result, s.dialect.Rebind(query), s.hasher.Hash(token))
 
 	if err == sqlx.ErrNotFound {
 		return nil, osin.ErrNotFound
@@ -306,13 +408,12 @@ func (s *Storage) LoadAccess(token string) (*osin.AccessData, error) {
 	}
 
 	data := &osin.AccessData{
-		Client:       client,
-		AccessToken:  result.AccessToken,
-		RefreshToken: result.RefreshToken,
-		ExpiresIn:    result.ExpiresIn,
-		Scope:        result.Scope,
-		RedirectUri:  result.RedirectUri,
-		CreatedAt:    result.CreatedAt,
+		Client:      client,
+		AccessToken: token,
+		ExpiresIn:   result.ExpiresIn,
+		Scope:       result.Scope.String,
+		RedirectUri: result.RedirectUri,
+		CreatedAt:   result.CreatedAt,
 	}
 
 	if result.Extra.Valid {
@@ -324,12 +425,8 @@ func (s *Storage) LoadAccess(token string) (*osin.AccessData, error) {
 
 // RemoveAccess revokes or deletes an AccessData.
 func (s *Storage) RemoveAccess(token string) error {
-	query := fmt.Sprintf("DELETE FROM %stoken WHERE access_token = ?", s.tablePrefix)
-	err := s.db.Transact(func(session sqlx.Session) error {
-		_, err := session.Exec(query, token)
-		return err
-	})
-
+	query := fmt.Sprintf("DELETE FROM %saccess_token WHERE token_hash = ?", s.tablePrefix)
+	_, err := s.db.Exec(s.dialect.Rebind(query), s.hasher.Hash(token))
 	if err != nil {
 		return fmt.Errorf("删除访问令牌失败: %v", err)
 	}
@@ -339,35 +436,406 @@ func (s *Storage) RemoveAccess(token string) error {
 // LoadRefresh retrieves refresh AccessData. Client information MUST be loaded together.
 // AuthorizeData and AccessData DON'T NEED to be loaded if not easily available.
 // Optionally can return error if expired.
+// The raw access token is unknown at this point, so the returned AccessToken is empty.
 func (s *Storage) LoadRefresh(token string) (*osin.AccessData, error) {
 	var result struct {
-		AccessToken string `db:"access_token"`
+		ClientId    string         `db:"client_id"`
+		ExpiresIn   int32          `db:"expires_in"`
+		Scope       sql.NullString `db:"scope"`
+		RedirectUri string         `db:"redirect_uri"`
+		Extra       sql.NullString `db:"extra"`
+		CreatedAt   time.Time      `db:"created_at"`
+		ExpiresAt   sql.NullTime   `db:"expires_at"`
 	}
 
-	query := fmt.Sprintf(`SELECT access_token FROM %stoken 
-		WHERE refresh_token = ? AND type = 'access'`, s.tablePrefix)
+	query := fmt.Sprintf(`SELECT g.client_id, r.expires_in, r.scope, g.redirect_uri,
+		r.extra, r.created_at, r.expires_at
+		FROM %srefresh_token r JOIN %saccess_grant g ON g.id = r.grant_id
+		WHERE r.token_hash = ?`, s.tablePrefix, s.tablePrefix)
+
+	// 刷新令牌只能使用一次，从主库读取避免副本延迟导致已删除的令牌被重复使用
+	err := s.db.QueryRowPartial(&result, s.dialect.Rebind(query), s.hasher.Hash(token))
 
-	err := s.db.QueryRowPartial(&result, query, token)
 	if err == sqlx.ErrNotFound {
 		return nil, osin.ErrNotFound
 	} else if err != nil {
 		return nil, fmt.Errorf("加载刷新令牌失败: %v", err)
 	}
 
-	// 使用获取到的access_token加载完整的访问数据
-	return s.LoadAccess(result.AccessToken)
+	if result.ExpiresAt.Valid && result.ExpiresAt.Time.Before(time.Now()) {
+		return nil, fmt.Errorf("刷新令牌已过期")
+	}
+
+	client, err := s.GetClient(result.ClientId)
+	if err != nil {
+		return nil, err
+	}
+
+	data := &osin.AccessData{
+		Client:       client,
+		RefreshToken: token,
+		ExpiresIn:    result.ExpiresIn,
+		Scope:        result.Scope.String,
+		RedirectUri:  result.RedirectUri,
+		CreatedAt:    result.CreatedAt,
+	}
+
+	if result.Extra.Valid {
+		data.UserData = result.Extra.String
+	}
+
+	return data, nil
 }
 
-// RemoveRefresh revokes or deletes refresh AccessData.
+// RemoveRefresh revokes or deletes refresh AccessData, together with the access token issued with it.
 func (s *Storage) RemoveRefresh(token string) error {
-	query := fmt.Sprintf("DELETE FROM %stoken WHERE refresh_token = ?", s.tablePrefix)
-	_, err := s.db.Exec(query, token)
+	_, err := s.RevokeRefresh(context.Background(), token)
+	return err
+}
+
+// RevokeRefresh deletes a refresh token and the access token issued with it in one transaction.
+func (s *Storage) RevokeRefresh(ctx context.Context, token string) (RevokeResult, error) {
+	var (
+		result RevokeResult
+		hash   = s.hasher.Hash(token)
+	)
+	err := s.db.TransactCtx(ctx, func(ctx context.Context, session sqlx.Session) error {
+		var row struct {
+			AccessHash sql.NullString `db:"access_token_hash"`
+		}
+		query := fmt.Sprintf("SELECT access_token_hash FROM %srefresh_token WHERE token_hash = ?", s.tablePrefix)
+		err := session.QueryRowCtx(ctx, &row, s.dialect.Rebind(query), hash)
+		if err == sqlx.ErrNotFound {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		query = fmt.Sprintf("DELETE FROM %srefresh_token WHERE token_hash = ?", s.tablePrefix)
+		res, err := session.ExecCtx(ctx, s.dialect.Rebind(query), hash)
+		if err != nil {
+			return err
+		}
+		// 并发撤销时只有实际删除了刷新令牌的事务算作撤销
+		if deleted, err := res.RowsAffected(); err != nil {
+			return err
+		} else if deleted == 0 {
+			return nil
+		}
+		result.RefreshRevoked = true
+		if !row.AccessHash.Valid {
+			return nil
+		}
+		query = fmt.Sprintf("DELETE FROM %saccess_token WHERE token_hash = ?", s.tablePrefix)
+		res, err = session.ExecCtx(ctx, s.dialect.Rebind(query), row.AccessHash.String)
+		if err != nil {
+			return err
+		}
+		if deleted, err := res.RowsAffected(); err != nil {
+			return err
+		} else if deleted > 0 {
+			result.AccessTokens = append(result.AccessTokens, row.AccessHash.String)
+		}
+		return nil
+	})
+	if err != nil {
+		return RevokeResult{}, fmt.Errorf("删除刷新令牌失败: %v", err)
+	}
+	return result, nil
+}
+
+// PurgeExpired deletes expired codes, access tokens, refresh tokens and pushed requests, and then the grants
+// left without any of them.
+// Rows are deleted in batches of at most batchSize so that a single statement never holds locks for long.
+func (s *Storage) PurgeExpired(ctx context.Context, batchSize int) (PurgeResult, error) {
+	var (
+		result PurgeResult
+		err    error
+		now    = time.Now().UTC()
+	)
+
+	for _, table := range []struct {
+		name, key string
+		deleted   *int64
+	}{
+		{"code", "code_hash", &result.Codes},
+		{"access_token", "token_hash", &result.AccessTokens},
+		{"refresh_token", "token_hash", &result.RefreshTokens},
+		{"pushed_request", "request_hash", &result.PushedRequests},
+	} {
+		query := fmt.Sprintf("SELECT %s FROM %s%s WHERE expires_at < ? LIMIT ?", table.key, s.tablePrefix, table.name)
+		if *table.deleted, err = s.deleteInBatches(ctx, table.name, table.key, query, batchSize, now); err != nil {
+			return result, fmt.Errorf("清理过期%s失败: %v", table.name, err)
+		}
+	}
+
+	query := fmt.Sprintf(`SELECT g.id FROM %[1]saccess_grant g
+		WHERE NOT EXISTS (SELECT 1 FROM %[1]scode c WHERE c.grant_id = g.id)
+		AND NOT EXISTS (SELECT 1 FROM %[1]saccess_token a WHERE a.grant_id = g.id)
+		AND NOT EXISTS (SELECT 1 FROM %[1]srefresh_token r WHERE r.grant_id = g.id)
+		LIMIT ?`, s.tablePrefix)
+	if result.Grants, err = s.deleteInBatches(ctx, "access_grant", "id", query, batchSize); err != nil {
+		return result, fmt.Errorf("清理失效授权失败: %v", err)
+	}
+
+	return result, nil
+}
+
+// deleteInBatches repeatedly selects up to batchSize keys with query and deletes them from table,
+// until fewer than batchSize keys are found.
+func (s *Storage) deleteInBatches(ctx context.Context, table, key, query string, batchSize int, args ...interface{}) (int64, error) {
+	var total int64
+	args = append(args, batchSize)
+	for {
+		var keys []string
+		if err := s.db.QueryRowsPartialCtx(ctx, &keys, s.dialect.Rebind(query), args...); err != nil {
+			return total, err
+		}
+		if len(keys) == 0 {
+			return total, nil
+		}
+
+		placeholders := make([]string, len(keys))
+		keyArgs := make([]interface{}, len(keys))
+		for i, k := range keys {
+			placeholders[i] = "?"
+			keyArgs[i] = k
+		}
+		res, err := s.db.ExecCtx(ctx, s.dialect.Rebind(fmt.Sprintf("DELETE FROM %s%s WHERE %s IN (%s)",
+			s.tablePrefix, table, key, strings.Join(placeholders, ", "))), keyArgs...)
+		if err != nil {
+			return total, err
+		}
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return total, err
+		}
+		total += affected
+		if len(keys) < batchSize || affected == 0 {
+			return total, nil
+		}
+	}
+}
+
+// ListUserTokens returns the unexpired access and refresh tokens of a user, grouped by client.
+// The list is read from the replicas and may lag behind revocations for the replication delay.
+func (s *Storage) ListUserTokens(ctx context.Context, userID string) ([]ClientTokens, error) {
+	var (
+		now    = time.Now().UTC()
+		tokens = make(map[string][]UserToken)
+	)
+	for _, table := range []struct{ name, tokenType string }{
+		{"access_token", TokenTypeAccess},
+		{"refresh_token", TokenTypeRefresh},
+	} {
+		var rows []struct {
+			ClientId   string         `db:"client_id"`
+			GrantId    string         `db:"grant_id"`
+			Scope      sql.NullString `db:"scope"`
+			CreatedAt  time.Time      `db:"created_at"`
+			ExpiresAt  sql.NullTime   `db:"expires_at"`
+			LastUsedAt sql.NullTime   `db:"last_used_at"`
+			LastUsedIp sql.NullString `db:"last_used_ip"`
+		}
+		query := fmt.Sprintf(`SELECT g.client_id, t.grant_id, t.scope, t.created_at, t.expires_at,
+			g.last_used_at, g.last_used_ip
+			FROM %[1]s%[2]s t JOIN %[1]saccess_grant g ON g.id = t.grant_id
+			WHERE g.user_id = ? AND (t.expires_at IS NULL OR t.expires_at > ?)`, s.tablePrefix, table.name)
+		if err := s.reader.QueryRowsPartialCtx(ctx, &rows, s.dialect.Rebind(query), userID, now); err != nil {
+			return nil, fmt.Errorf("加载用户令牌失败: %v", err)
+		}
+		for _, row := range rows {
+			token := UserToken{
+				GrantId:   row.GrantId,
+				Type:      table.tokenType,
+				Scope:     row.Scope.String,
+				CreatedAt: row.CreatedAt,
+			}
+			if row.ExpiresAt.Valid {
+				token.ExpiresAt = &row.ExpiresAt.Time
+			}
+			if row.LastUsedAt.Valid {
+				token.LastUsedAt = &row.LastUsedAt.Time
+				token.LastUsedIp = row.LastUsedIp.String
+			}
+			tokens[row.ClientId] = append(tokens[row.ClientId], token)
+		}
+	}
+	return groupByClient(tokens), nil
+}
+
+// RevokeUserTokens deletes the grants of a user together with their codes and tokens,
+// limited to one client unless clientID is empty.
+func (s *Storage) RevokeUserTokens(ctx context.Context, userID, clientID string) (RevokeResult, error) {
+	if clientID == "" {
+		return s.revokeGrants(ctx, "user_id = ?", userID)
+	}
+	return s.revokeGrants(ctx, "user_id = ? AND client_id = ?", userID, clientID)
+}
+
+// RevokeUserGrant deletes one grant of a user together with its codes and tokens.
+func (s *Storage) RevokeUserGrant(ctx context.Context, userID, grantID string) (RevokeResult, error) {
+	return s.revokeGrants(ctx, "user_id = ? AND id = ?", userID, grantID)
+}
+
+// revokeGrants deletes the grants matching where together with their codes and tokens.
+func (s *Storage) revokeGrants(ctx context.Context, where string, args ...interface{}) (RevokeResult, error) {
+	var result RevokeResult
+	grants := fmt.Sprintf("SELECT id FROM %saccess_grant WHERE %s", s.tablePrefix, where)
+
+	// 与删除客户端相同，显式按依赖顺序删除
+	err := s.db.TransactCtx(ctx, func(ctx context.Context, session sqlx.Session) error {
+		query := fmt.Sprintf("SELECT token_hash FROM %saccess_token WHERE grant_id IN (%s)", s.tablePrefix, grants)
+		if err := session.QueryRowsPartialCtx(ctx, &result.AccessTokens, s.dialect.Rebind(query), args...); err != nil {
+			return err
+		}
+		for _, table := range []string{"refresh_token", "access_token", "code"} {
+			query := fmt.Sprintf("DELETE FROM %s%s WHERE grant_id IN (%s)", s.tablePrefix, table, grants)
+			if _, err := session.ExecCtx(ctx, s.dialect.Rebind(query), args...); err != nil {
+				return err
+			}
+		}
+		query = fmt.Sprintf("DELETE FROM %saccess_grant WHERE %s", s.tablePrefix, where)
+		res, err := session.ExecCtx(ctx, s.dialect.Rebind(query), args...)
+		if err != nil {
+			return err
+		}
+		result.Grants, err = res.RowsAffected()
+		return err
+	})
+	if err != nil {
+		return RevokeResult{}, fmt.Errorf("撤销用户令牌失败: %v", err)
+	}
+	return result, nil
+}
+
+// TouchAccess marks the grant of token as used at usedAt from ip. An empty ip keeps the previous one.
+func (s *Storage) TouchAccess(ctx context.Context, token, ip string, usedAt time.Time) error {
+	query := fmt.Sprintf(`UPDATE %[1]saccess_grant SET last_used_at = ?, last_used_ip = COALESCE(?, last_used_ip)
+		WHERE id = (SELECT grant_id FROM %[1]saccess_token WHERE token_hash = ?)`, s.tablePrefix)
+	_, err := s.db.ExecCtx(ctx, s.dialect.Rebind(query), usedAt.UTC(), util.StringToSql(ip), s.hasher.Hash(token))
 	if err != nil {
-		return fmt.Errorf("删除刷新令牌失败: %v", err)
+		return fmt.Errorf("记录令牌使用失败: %v", err)
 	}
 	return nil
 }
 
+// SavePushedRequest stores request under the digest of requestURI.
+func (s *Storage) SavePushedRequest(ctx context.Context, requestURI string, request *PushedRequest) error {
+	query := fmt.Sprintf(`INSERT INTO %spushed_request (request_hash, client_id, params, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?)`, s.tablePrefix)
+	_, err := s.db.ExecCtx(ctx, s.dialect.Rebind(query), s.hasher.Hash(requestURI), request.ClientId,
+		request.Params.Encode(), time.Now().UTC(), request.ExpiresAt.UTC())
+	if err != nil {
+		return fmt.Errorf("保存推送的授权请求失败: %v", err)
+	}
+	return nil
+}
+
+// TakePushedRequest returns the request stored under requestURI and removes it. Only the transaction
+// that deletes the row returns the request, so concurrent requests cannot both use it.
+func (s *Storage) TakePushedRequest(ctx context.Context, requestURI string) (*PushedRequest, error) {
+	var (
+		request PushedRequest
+		hash    = s.hasher.Hash(requestURI)
+	)
+	err := s.db.TransactCtx(ctx, func(ctx context.Context, session sqlx.Session) error {
+		var row struct {
+			ClientId  string    `db:"client_id"`
+			Params    string    `db:"params"`
+			ExpiresAt time.Time `db:"expires_at"`
+		}
+		query := fmt.Sprintf("SELECT client_id, params, expires_at FROM %spushed_request WHERE request_hash = ?", s.tablePrefix)
+		if err := session.QueryRowPartialCtx(ctx, &row, s.dialect.Rebind(query), hash); err != nil {
+			return err
+		}
+		res, err := session.ExecCtx(ctx, s.dialect.Rebind(fmt.Sprintf("DELETE FROM %spushed_request WHERE request_hash = ?",
+			s.tablePrefix)), hash)
+		if err != nil {
+			return err
+		}
+		if affected, err := res.RowsAffected(); err != nil {
+			return err
+		} else if affected == 0 {
+			return sqlx.ErrNotFound
+		}
+
+		params, err := url.ParseQuery(row.Params)
+		if err != nil {
+			return err
+		}
+		request = PushedRequest{ClientId: row.ClientId, Params: params, ExpiresAt: row.ExpiresAt}
+		return nil
+	})
+	if err == sqlx.ErrNotFound {
+		return nil, osin.ErrNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("加载推送的授权请求失败: %v", err)
+	}
+	if request.Expired(time.Now()) {
+		return nil, osin.ErrNotFound
+	}
+	return &request, nil
+}
+
+// createGrant inserts a new grant and returns its id. The user, resources and authorization details
+// of grant are empty for grants without a user, audience restriction or details.
+func (s *Storage) createGrant(session sqlx.Session, clientID string, grant GrantData, redirectURI string, createdAt time.Time) (string, error) {
+	id, err := newGrantID()
+	if err != nil {
+		return "", err
+	}
+	var details sql.NullString
+	if len(grant.AuthorizationDetails) > 0 {
+		details = util.StringToSql(toString(grant.AuthorizationDetails))
+	}
+	query := fmt.Sprintf(`INSERT INTO %saccess_grant (id, client_id, user_id, resource, authorization_details, redirect_uri, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`, s.tablePrefix)
+	_, err = session.Exec(s.dialect.Rebind(query), id, clientID, util.StringToSql(grant.UserId),
+		util.StringToSql(strings.Join(grant.Resources, " ")), details, redirectURI, createdAt.UTC())
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// grantOf finds the grant that a new access token continues: the grant of the exchanged code
+// or of the refreshed token. Returns an empty id if there is none.
+func (s *Storage) grantOf(session sqlx.Session, data *osin.AccessData) (string, error) {
+	var table, token string
+	switch {
+	case data.AuthorizeData != nil && data.AuthorizeData.Code != "":
+		table, token = "code", data.AuthorizeData.Code
+	case data.AccessData != nil && data.AccessData.RefreshToken != "":
+		table, token = "refresh_token", data.AccessData.RefreshToken
+	case data.AccessData != nil && data.AccessData.AccessToken != "":
+		table, token = "access_token", data.AccessData.AccessToken
+	default:
+		return "", nil
+	}
+
+	hashColumn := "token_hash"
+	if table == "code" {
+		hashColumn = "code_hash"
+	}
+
+	var grantID string
+	query := fmt.Sprintf("SELECT grant_id FROM %s%s WHERE %s = ?", s.tablePrefix, table, hashColumn)
+	err := session.QueryRow(&grantID, s.dialect.Rebind(query), s.hasher.Hash(token))
+	if err == sqlx.ErrNotFound {
+		return "", nil
+	}
+	return grantID, err
+}
+
+// refreshExpireAt returns the expiration of a refresh token created at createdAt, or NULL if it never expires.
+func refreshExpireAt(createdAt time.Time, expiration int32) sql.NullTime {
+	if expiration <= 0 {
+		return sql.NullTime{}
+	}
+	return util.TimeToSql(createdAt.Add(time.Duration(expiration) * time.Second).UTC())
+}
+
 // CreateClientWithInformation Makes easy to create a osin.DefaultClient
 func (s *Storage) CreateClientWithInformation(id string, secret string, redirectURI string, userData interface{}) osin.Client {
 	return &osin.DefaultClient{
@@ -377,18 +845,3 @@ func (s *Storage) CreateClientWithInformation(id string, secret string, redirect
 		UserData:    userData,
 	}
 }
-
-// Convert any type to string.
-func toString(value interface{}) string {
-	if value == nil {
-		return ""
-	}
-	switch v := value.(type) {
-	case string:
-		return v
-	case []byte:
-		return string(v)
-	default:
-		return fmt.Sprintf("%v", v)
-	}
-}
@@ -0,0 +1,59 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// PushedRequestURIPrefix is the prefix of the request_uri values returned for pushed authorization requests,
+// as RFC 9126 section 2.2 suggests.
+const PushedRequestURIPrefix = "urn:ietf:params:oauth:request_uri:"
+
+// PushedRequest is an authorization request pushed by a client with RFC 9126, waiting to be referenced
+// by its request_uri at the authorization endpoint.
+type PushedRequest struct {
+	ClientId  string     `json:"client_id"`
+	Params    url.Values `json:"params"` // 授权请求参数，不含客户端认证信息
+	ExpiresAt time.Time  `json:"expires_at"`
+}
+
+// Expired reports whether the request can no longer be used at now.
+func (p *PushedRequest) Expired(now time.Time) bool {
+	return !now.Before(p.ExpiresAt)
+}
+
+// PushedRequestStorage keeps pushed authorization requests until they are used or expire.
+// Request URIs are only persisted as digests, like codes and tokens.
+type PushedRequestStorage interface {
+	// SavePushedRequest stores request under requestURI.
+	SavePushedRequest(ctx context.Context, requestURI string, request *PushedRequest) error
+	// TakePushedRequest returns the request stored under requestURI and removes it, so a request_uri
+	// can only be used once. Unknown and expired requests return osin.ErrNotFound.
+	TakePushedRequest(ctx context.Context, requestURI string) (*PushedRequest, error)
+}
+
+// NewPushedRequestURI returns a new random request_uri.
+func NewPushedRequestURI() (string, error) {
+	token, err := randomToken(32, base64.RawURLEncoding.EncodeToString)
+	if err != nil {
+		return "", err
+	}
+	return PushedRequestURIPrefix + token, nil
+}
+
+// IsPushedRequestURI reports whether requestURI was issued for a pushed authorization request.
+func IsPushedRequestURI(requestURI string) bool {
+	return strings.HasPrefix(requestURI, PushedRequestURIPrefix)
+}
+
+// cloneValues returns a deep copy of values.
+func cloneValues(values url.Values) url.Values {
+	cloned := make(url.Values, len(values))
+	for key, vs := range values {
+		cloned[key] = append([]string(nil), vs...)
+	}
+	return cloned
+}
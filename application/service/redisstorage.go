@@ -0,0 +1,641 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/openshift/osin"
+	"github.com/zeromicro/go-zero/core/logx"
+)
+
+// 键名前加上由表前缀得到的命名空间，如默认的表前缀osin_对应oauth2:oauth:osin:code:%s
+const (
+	redisCodeKey    = "code:%s"
+	redisAccessKey  = "access:%s"
+	redisRefreshKey = "refresh:%s"
+	redisUserKey    = "user:%s"  // 用户的授权码和令牌键集合
+	redisUsageKey   = "usage:%s" // 授权最近一次使用的时间和IP
+	redisPushedKey  = "par:%s"   // 推送的授权请求
+)
+
+// redisTakeScript deletes KEYS[1] and returns its value, so that of concurrent callers only one gets the value.
+var redisTakeScript = redis.NewScript(`
+local value = redis.call('GET', KEYS[1])
+if value then
+	redis.call('DEL', KEYS[1])
+end
+return value
+`)
+
+// RedisStorage implements osin.Storage on top of Redis.
+// Codes and tokens are stored under their digests and expire through native TTLs,
+// while clients are loaded from the wrapped ClientStorage.
+// The keys issued for a user are indexed in a set that lives as long as its longest-lived member;
+// members whose key has expired are dropped when the set is read. The usage of a grant is kept as long as the set.
+// Keys are namespaced by the table prefix, so deployments sharing a Redis keep their tokens apart like they do
+// their tables.
+type RedisStorage struct {
+	rdb               *redis.Client
+	namespace         string
+	clients           ClientStorage
+	hasher            *TokenHasher
+	refreshExpiration int32
+}
+
+// NewRedisStorage returns a new redis storage instance.
+func NewRedisStorage(rdb *redis.Client, clients ClientStorage, opts Options) *RedisStorage {
+	return &RedisStorage{
+		rdb:               rdb,
+		namespace:         redisNamespace(opts.TablePrefix),
+		clients:           clients,
+		hasher:            opts.Hasher,
+		refreshExpiration: opts.RefreshExpiration,
+	}
+}
+
+// Clone the storage if needed. Can return itself if not a problem.
+func (s *RedisStorage) Clone() osin.Storage {
+	return s
+}
+
+// Close the resources the Storage potentially holds (using Clone for example)
+func (s *RedisStorage) Close() {
+}
+
+// GetClient loads the client by id
+func (s *RedisStorage) GetClient(id string) (osin.Client, error) {
+	return s.clients.GetClient(id)
+}
+
+// CreateClient stores the client.
+func (s *RedisStorage) CreateClient(c osin.Client) error {
+	return s.clients.CreateClient(c)
+}
+
+// UpdateClient updates the client (identified by it's id) and replaces the values with the values of client.
+func (s *RedisStorage) UpdateClient(c osin.Client) error {
+	return s.clients.UpdateClient(c)
+}
+
+// RemoveClient removes a client (identified by id).
+func (s *RedisStorage) RemoveClient(id string) error {
+	return s.clients.RemoveClient(id)
+}
+
+// SaveAuthorize saves authorize data.
+func (s *RedisStorage) SaveAuthorize(data *osin.AuthorizeData) error {
+	grantID, err := newGrantID()
+	if err != nil {
+		return err
+	}
+
+	record := tokenRecord{
+		GrantId:     grantID,
+		UserId:      userIDOf(data.UserData),
+		ClientId:    data.Client.GetId(),
+		ExpiresIn:   data.ExpiresIn,
+		Scope:       data.Scope,
+		RedirectUri: data.RedirectUri,
+		State:       data.State,
+		Extra:       toString(data.UserData),
+		CreatedAt:   data.CreatedAt,
+
+		CodeChallenge:       data.CodeChallenge,
+		CodeChallengeMethod: data.CodeChallengeMethod,
+	}
+	if err := s.set(redisCodeKey, data.Code, record, untilExpired(data.ExpireAt())); err != nil {
+		return fmt.Errorf("保存授权数据失败: %v", err)
+	}
+	return nil
+}
+
+// LoadAuthorize looks up AuthorizeData by a code.
+// Client information MUST be loaded together.
+// Optionally can return error if expired.
+func (s *RedisStorage) LoadAuthorize(code string) (*osin.AuthorizeData, error) {
+	record, err := s.get(redisCodeKey, code)
+	if err != nil {
+		if err == osin.ErrNotFound {
+			return nil, err
+		}
+		return nil, fmt.Errorf("加载授权数据失败: %v", err)
+	}
+
+	if record.expired(time.Now()) {
+		return nil, fmt.Errorf("授权码已过期")
+	}
+	data := record.authorizeData()
+	data.Code = code
+
+	if data.Client, err = s.clients.GetClient(record.ClientId); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// RemoveAuthorize revokes or deletes the authorization code.
+func (s *RedisStorage) RemoveAuthorize(code string) error {
+	if err := s.del(redisCodeKey, code); err != nil {
+		return fmt.Errorf("删除授权码失败: %v", err)
+	}
+	return nil
+}
+
+// SaveAccess writes AccessData.
+// If RefreshToken is not blank, it must save in a way that can be loaded using LoadRefresh.
+func (s *RedisStorage) SaveAccess(data *osin.AccessData) error {
+	grantID, userID, err := s.grantOf(data)
+	if err != nil {
+		return fmt.Errorf("保存访问令牌失败: %v", err)
+	}
+
+	record := tokenRecord{
+		GrantId:     grantID,
+		UserId:      userID,
+		ClientId:    data.Client.GetId(),
+		ExpiresIn:   data.ExpiresIn,
+		Scope:       data.Scope,
+		RedirectUri: data.RedirectUri,
+		Extra:       toString(data.UserData),
+		CreatedAt:   data.CreatedAt,
+	}
+	if err := s.set(redisAccessKey, data.AccessToken, record, untilExpired(data.ExpireAt())); err != nil {
+		return fmt.Errorf("保存访问令牌失败: %v", err)
+	}
+	if data.RefreshToken == "" {
+		return nil
+	}
+
+	// 刷新令牌的有效期独立于访问令牌，0 表示永不过期
+	record.ExpiresIn = refreshLifetime(data.Client, s.refreshExpiration)
+	record.AccessHash = s.hasher.Hash(data.AccessToken)
+	var ttl time.Duration
+	if record.ExpiresIn > 0 {
+		ttl = untilExpired(data.CreatedAt.Add(time.Duration(record.ExpiresIn) * time.Second))
+	}
+	if err := s.set(redisRefreshKey, data.RefreshToken, record, ttl); err != nil {
+		return fmt.Errorf("保存刷新令牌失败: %v", err)
+	}
+	return nil
+}
+
+// LoadAccess retrieves access data by token. Client information MUST be loaded together.
+// AuthorizeData and AccessData DON'T NEED to be loaded if not easily available.
+// Optionally can return error if expired.
+func (s *RedisStorage) LoadAccess(token string) (*osin.AccessData, error) {
+	record, err := s.get(redisAccessKey, token)
+	if err != nil {
+		if err == osin.ErrNotFound {
+			return nil, err
+		}
+		return nil, fmt.Errorf("加载访问令牌失败: %v", err)
+	}
+
+	if record.expired(time.Now()) {
+		return nil, fmt.Errorf("访问令牌已过期")
+	}
+	data := record.accessData()
+	data.AccessToken = token
+
+	if data.Client, err = s.clients.GetClient(record.ClientId); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// RemoveAccess revokes or deletes an AccessData.
+func (s *RedisStorage) RemoveAccess(token string) error {
+	if err := s.del(redisAccessKey, token); err != nil {
+		return fmt.Errorf("删除访问令牌失败: %v", err)
+	}
+	return nil
+}
+
+// LoadRefresh retrieves refresh AccessData. Client information MUST be loaded together.
+// AuthorizeData and AccessData DON'T NEED to be loaded if not easily available.
+// Optionally can return error if expired.
+func (s *RedisStorage) LoadRefresh(token string) (*osin.AccessData, error) {
+	record, err := s.get(redisRefreshKey, token)
+	if err != nil {
+		if err == osin.ErrNotFound {
+			return nil, err
+		}
+		return nil, fmt.Errorf("加载刷新令牌失败: %v", err)
+	}
+
+	if record.refreshExpired(time.Now()) {
+		return nil, fmt.Errorf("刷新令牌已过期")
+	}
+	data := record.accessData()
+	data.RefreshToken = token
+
+	if data.Client, err = s.clients.GetClient(record.ClientId); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// RemoveRefresh revokes or deletes refresh AccessData, together with the access token issued with it.
+func (s *RedisStorage) RemoveRefresh(token string) error {
+	_, err := s.RevokeRefresh(context.Background(), token)
+	return err
+}
+
+// RevokeRefresh deletes a refresh token and then the access token issued with it.
+// The refresh token is read and deleted atomically, so only one of concurrent callers reports RefreshRevoked.
+func (s *RedisStorage) RevokeRefresh(ctx context.Context, token string) (RevokeResult, error) {
+	var result RevokeResult
+	// 读取和删除在一个脚本中完成，并发撤销同一令牌时只有一个调用方读到记录
+	value, err := redisTakeScript.Run(ctx, s.rdb, []string{s.key(redisRefreshKey, token)}).Text()
+	if err == redis.Nil {
+		return result, nil
+	} else if err != nil {
+		return result, fmt.Errorf("删除刷新令牌失败: %v", err)
+	}
+	result.RefreshRevoked = true
+
+	var record tokenRecord
+	if err := json.Unmarshal([]byte(value), &record); err != nil {
+		return result, fmt.Errorf("解析刷新令牌失败: %v", err)
+	}
+	if record.AccessHash == "" {
+		return result, nil
+	}
+	deleted, err := s.rdb.Del(ctx, s.format(redisAccessKey, record.AccessHash)).Result()
+	if err != nil {
+		return result, fmt.Errorf("删除访问令牌失败: %v", err)
+	}
+	if deleted > 0 {
+		result.AccessTokens = append(result.AccessTokens, record.AccessHash)
+	}
+	return result, nil
+}
+
+// ListUserTokens returns the unexpired access and refresh tokens of a user, grouped by client.
+func (s *RedisStorage) ListUserTokens(ctx context.Context, userID string) ([]ClientTokens, error) {
+	keys, records, err := s.userRecords(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("加载用户令牌失败: %v", err)
+	}
+
+	usage, err := s.usage(ctx, records)
+	if err != nil {
+		return nil, fmt.Errorf("加载授权使用记录失败: %v", err)
+	}
+
+	now := time.Now()
+	tokens := make(map[string][]UserToken)
+	for i, key := range keys {
+		var tokenType string
+		switch {
+		case strings.HasPrefix(key, s.keyPrefix(redisAccessKey)):
+			tokenType = TokenTypeAccess
+		case strings.HasPrefix(key, s.keyPrefix(redisRefreshKey)):
+			tokenType = TokenTypeRefresh
+		default:
+			continue
+		}
+		if tokenExpired(tokenType, records[i], now) {
+			continue
+		}
+		token := records[i].userToken(tokenType)
+		usage[records[i].GrantId].apply(&token)
+		tokens[records[i].ClientId] = append(tokens[records[i].ClientId], token)
+	}
+	return groupByClient(tokens), nil
+}
+
+// RevokeUserTokens deletes the codes and tokens of a user, limited to one client unless clientID is empty.
+func (s *RedisStorage) RevokeUserTokens(ctx context.Context, userID, clientID string) (RevokeResult, error) {
+	return s.revoke(ctx, userID, func(record *tokenRecord) bool {
+		return clientID == "" || record.ClientId == clientID
+	})
+}
+
+// RevokeUserGrant deletes the codes and tokens of one grant of a user.
+func (s *RedisStorage) RevokeUserGrant(ctx context.Context, userID, grantID string) (RevokeResult, error) {
+	return s.revoke(ctx, userID, func(record *tokenRecord) bool {
+		return record.GrantId == grantID
+	})
+}
+
+// revoke deletes the codes and tokens of userID that match, together with the usage of their grants.
+func (s *RedisStorage) revoke(ctx context.Context, userID string, match func(record *tokenRecord) bool) (RevokeResult, error) {
+	var result RevokeResult
+	keys, records, err := s.userRecords(ctx, userID)
+	if err != nil {
+		return result, fmt.Errorf("撤销用户令牌失败: %v", err)
+	}
+
+	var (
+		revoked []string
+		grants  = make(map[string]struct{})
+		prefix  = s.keyPrefix(redisAccessKey)
+	)
+	for i, key := range keys {
+		if !match(records[i]) {
+			continue
+		}
+		revoked = append(revoked, key)
+		grants[records[i].GrantId] = struct{}{}
+		if strings.HasPrefix(key, prefix) {
+			result.AccessTokens = append(result.AccessTokens, strings.TrimPrefix(key, prefix))
+		}
+	}
+	if len(revoked) == 0 {
+		return result, nil
+	}
+
+	members := make([]interface{}, len(revoked))
+	for i, key := range revoked {
+		members[i] = key
+	}
+	for grantID := range grants {
+		revoked = append(revoked, s.format(redisUsageKey, grantID))
+	}
+	_, err = s.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, revoked...)
+		pipe.SRem(ctx, s.format(redisUserKey, userID), members...)
+		return nil
+	})
+	if err != nil {
+		return RevokeResult{}, fmt.Errorf("撤销用户令牌失败: %v", err)
+	}
+	result.Grants = int64(len(grants))
+	return result, nil
+}
+
+// TouchAccess marks the grant of token as used at usedAt from ip. Only grants of users are tracked,
+// unknown tokens and client credentials grants are ignored.
+func (s *RedisStorage) TouchAccess(ctx context.Context, token, ip string, usedAt time.Time) error {
+	record, err := s.get(redisAccessKey, token)
+	if err == osin.ErrNotFound {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("记录令牌使用失败: %v", err)
+	}
+	if record.UserId == "" {
+		return nil
+	}
+
+	// 使用记录与用户的令牌键集合同时过期
+	ttl, err := s.rdb.TTL(ctx, s.format(redisUserKey, record.UserId)).Result()
+	if err != nil {
+		return fmt.Errorf("记录令牌使用失败: %v", err)
+	}
+	usageKey := s.format(redisUsageKey, record.GrantId)
+	_, err = s.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, usageKey, "used_at", usedAt.UTC().Format(time.RFC3339Nano))
+		if ip != "" {
+			pipe.HSet(ctx, usageKey, "ip", ip)
+		}
+		if ttl > 0 {
+			pipe.Expire(ctx, usageKey, ttl)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("记录令牌使用失败: %v", err)
+	}
+	return nil
+}
+
+// usage loads the usage of the grants of records. Grants that were never used are missing from the result.
+func (s *RedisStorage) usage(ctx context.Context, records []*tokenRecord) (map[string]*grantUsage, error) {
+	cmds := make(map[string]*redis.StringStringMapCmd)
+	pipe := s.rdb.Pipeline()
+	for _, record := range records {
+		if _, ok := cmds[record.GrantId]; !ok {
+			cmds[record.GrantId] = pipe.HGetAll(ctx, s.format(redisUsageKey, record.GrantId))
+		}
+	}
+	if len(cmds) == 0 {
+		return nil, nil
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	usage := make(map[string]*grantUsage)
+	for grantID, cmd := range cmds {
+		fields := cmd.Val()
+		if fields["used_at"] == "" {
+			continue
+		}
+		usedAt, err := time.Parse(time.RFC3339Nano, fields["used_at"])
+		if err != nil {
+			return nil, err
+		}
+		usage[grantID] = &grantUsage{UsedAt: usedAt, Ip: fields["ip"]}
+	}
+	return usage, nil
+}
+
+// userRecords loads the records indexed for a user and drops the members whose key has expired.
+func (s *RedisStorage) userRecords(ctx context.Context, userID string) ([]string, []*tokenRecord, error) {
+	userKey := s.format(redisUserKey, userID)
+	members, err := s.rdb.SMembers(ctx, userKey).Result()
+	if err != nil || len(members) == 0 {
+		return nil, nil, err
+	}
+	values, err := s.rdb.MGet(ctx, members...).Result()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var (
+		keys    []string
+		records []*tokenRecord
+		stale   []interface{}
+	)
+	for i, value := range values {
+		str, ok := value.(string)
+		if !ok {
+			stale = append(stale, members[i])
+			continue
+		}
+		var record tokenRecord
+		if err := json.Unmarshal([]byte(str), &record); err != nil {
+			return nil, nil, err
+		}
+		keys = append(keys, members[i])
+		records = append(records, &record)
+	}
+	if len(stale) > 0 {
+		if err := s.rdb.SRem(ctx, userKey, stale...).Err(); err != nil {
+			logx.Errorf("清理用户令牌索引失败: %v", err)
+		}
+	}
+	return keys, records, nil
+}
+
+// grantOf returns the grant and user of the exchanged code or the refreshed token,
+// or a new grant id and the user of data.
+func (s *RedisStorage) grantOf(data *osin.AccessData) (string, string, error) {
+	var key, token string
+	switch {
+	case data.AuthorizeData != nil && data.AuthorizeData.Code != "":
+		key, token = redisCodeKey, data.AuthorizeData.Code
+	case data.AccessData != nil && data.AccessData.RefreshToken != "":
+		key, token = redisRefreshKey, data.AccessData.RefreshToken
+	case data.AccessData != nil && data.AccessData.AccessToken != "":
+		key, token = redisAccessKey, data.AccessData.AccessToken
+	}
+
+	if key != "" {
+		record, err := s.get(key, token)
+		if err == nil {
+			return record.GrantId, record.UserId, nil
+		} else if err != osin.ErrNotFound {
+			return "", "", err
+		}
+	}
+	grantID, err := newGrantID()
+	return grantID, userIDOf(data.UserData), err
+}
+
+// SavePushedRequest stores request under the digest of requestURI until it expires.
+// Requests that are already expired are not stored at all.
+func (s *RedisStorage) SavePushedRequest(ctx context.Context, requestURI string, request *PushedRequest) error {
+	ttl := time.Until(request.ExpiresAt)
+	if ttl <= 0 {
+		return nil
+	}
+	value, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	if err := s.rdb.Set(ctx, s.key(redisPushedKey, requestURI), value, ttl).Err(); err != nil {
+		return fmt.Errorf("保存推送的授权请求失败: %v", err)
+	}
+	return nil
+}
+
+// TakePushedRequest returns the request stored under requestURI and removes it in the same transaction,
+// so concurrent requests cannot both use it.
+func (s *RedisStorage) TakePushedRequest(ctx context.Context, requestURI string) (*PushedRequest, error) {
+	key := s.key(redisPushedKey, requestURI)
+	var get *redis.StringCmd
+	_, err := s.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		get = pipe.Get(ctx, key)
+		pipe.Del(ctx, key)
+		return nil
+	})
+	if err == redis.Nil {
+		return nil, osin.ErrNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("加载推送的授权请求失败: %v", err)
+	}
+
+	var request PushedRequest
+	if err := json.Unmarshal([]byte(get.Val()), &request); err != nil {
+		return nil, fmt.Errorf("加载推送的授权请求失败: %v", err)
+	}
+	if request.Expired(time.Now()) {
+		return nil, osin.ErrNotFound
+	}
+	return &request, nil
+}
+
+// set stores record under the digest of token and indexes it for its user. A ttl of 0 keeps the key forever,
+// records that are already expired are not stored at all.
+func (s *RedisStorage) set(keyFormat, token string, record tokenRecord, ttl time.Duration) error {
+	if ttl < 0 {
+		return nil
+	}
+	value, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	key := s.key(keyFormat, token)
+	if err := s.rdb.Set(ctx, key, value, ttl).Err(); err != nil {
+		return err
+	}
+	if record.UserId == "" {
+		return nil
+	}
+
+	userKey := s.format(redisUserKey, record.UserId)
+	if err := s.rdb.SAdd(ctx, userKey, key).Err(); err != nil {
+		return err
+	}
+	// 索引的有效期不短于其中任何一个键
+	if ttl == 0 {
+		return s.rdb.Persist(ctx, userKey).Err()
+	}
+	current, err := s.rdb.TTL(ctx, userKey).Result()
+	if err != nil {
+		return err
+	}
+	if current != -1 && current < ttl {
+		return s.rdb.Expire(ctx, userKey, ttl).Err()
+	}
+	return nil
+}
+
+// untilExpired returns the ttl of a key expiring at expireAt for set, negative once it has expired
+// so that set does not mistake it for a key kept forever.
+func untilExpired(expireAt time.Time) time.Duration {
+	if ttl := time.Until(expireAt); ttl > 0 {
+		return ttl
+	}
+	return -1
+}
+
+func (s *RedisStorage) get(keyFormat, token string) (*tokenRecord, error) {
+	value, err := s.rdb.Get(context.Background(), s.key(keyFormat, token)).Bytes()
+	if err == redis.Nil {
+		return nil, osin.ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	var record tokenRecord
+	if err := json.Unmarshal(value, &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+func (s *RedisStorage) del(keyFormat, token string) error {
+	return s.rdb.Del(context.Background(), s.key(keyFormat, token)).Err()
+}
+
+func (s *RedisStorage) key(keyFormat, token string) string {
+	return s.format(keyFormat, s.hasher.Hash(token))
+}
+
+// format returns the key of keyFormat for value in the namespace of the storage.
+func (s *RedisStorage) format(keyFormat, value string) string {
+	return s.namespace + fmt.Sprintf(keyFormat, value)
+}
+
+// keyPrefix returns the part of the key of keyFormat before the digest.
+func (s *RedisStorage) keyPrefix(keyFormat string) string {
+	return s.namespace + strings.TrimSuffix(keyFormat, "%s")
+}
+
+// redisNamespace returns the namespace of the keys for the table prefix, like oauth2:oauth:osin: for osin_.
+// Without a table prefix the keys live directly under oauth2:oauth:.
+func redisNamespace(tablePrefix string) string {
+	return namespace("oauth2:oauth:", tablePrefix)
+}
+
+// cacheNamespace returns the namespace of the cache keys and channels for the table prefix,
+// like oauth2:cache:osin: for osin_, so that deployments sharing a Redis don't see each other's entries.
+func cacheNamespace(tablePrefix string) string {
+	return namespace("oauth2:cache:", tablePrefix)
+}
+
+// namespace appends the table prefix without its trailing underscore to base.
+func namespace(base, tablePrefix string) string {
+	if tablePrefix = strings.TrimSuffix(tablePrefix, "_"); tablePrefix == "" {
+		return base
+	}
+	return base + tablePrefix + ":"
+}
@@ -0,0 +1,118 @@
+package service_test
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"oauth2/application/service"
+	"oauth2/application/service/storagetest"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/openshift/osin"
+)
+
+func TestRedisStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T, opts service.Options) service.OAuthStorage {
+		mr := miniredis.RunT(t)
+		rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+		t.Cleanup(func() { rdb.Close() })
+		// 客户端由内存存储提供，测试只关注Redis中的授权码和令牌
+		return service.NewRedisStorage(rdb, service.NewMemoryStorage(opts), opts)
+	})
+}
+
+func TestRedisStorageTablePrefix(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	newStorage := func(prefix string) *service.RedisStorage {
+		opts := service.Options{TablePrefix: prefix, Hasher: service.NewTokenHasher("")}
+		return service.NewRedisStorage(rdb, service.NewMemoryStorage(opts), opts)
+	}
+	tenant, other := newStorage("tenant_"), newStorage("osin_")
+
+	client := &osin.DefaultClient{Id: "app", Secret: "secret", RedirectUri: "http://localhost/app"}
+	if err := tenant.CreateClient(client); err != nil {
+		t.Fatalf("CreateClient: %v", err)
+	}
+	data := &osin.AccessData{
+		Client:      client,
+		AccessToken: "tenant-access",
+		ExpiresIn:   3600,
+		CreatedAt:   time.Now(),
+		UserData:    "alice",
+	}
+	if err := tenant.SaveAccess(data); err != nil {
+		t.Fatalf("SaveAccess: %v", err)
+	}
+
+	// 键位于表前缀对应的命名空间下，表前缀不同的部署共用Redis时互不可见
+	for _, key := range mr.Keys() {
+		if !strings.HasPrefix(key, "oauth2:oauth:tenant:") {
+			t.Fatalf("key %q outside the namespace of the table prefix", key)
+		}
+	}
+	if _, err := other.LoadAccess("tenant-access"); err != osin.ErrNotFound {
+		t.Fatalf("LoadAccess with another table prefix: %v", err)
+	}
+	if tokens, err := other.ListUserTokens(context.Background(), "alice"); err != nil || len(tokens) != 0 {
+		t.Fatalf("ListUserTokens with another table prefix: %+v, %v", tokens, err)
+	}
+	if _, err := tenant.LoadAccess("tenant-access"); err != nil {
+		t.Fatalf("LoadAccess: %v", err)
+	}
+}
+
+func TestRedisStorageConcurrentRevokeRefresh(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	opts := service.Options{Hasher: service.NewTokenHasher("")}
+	storage := service.NewRedisStorage(rdb, service.NewMemoryStorage(opts), opts)
+
+	client := &osin.DefaultClient{Id: "app", Secret: "secret", RedirectUri: "http://localhost/app"}
+	if err := storage.CreateClient(client); err != nil {
+		t.Fatalf("CreateClient: %v", err)
+	}
+	data := &osin.AccessData{Client: client, AccessToken: "access", RefreshToken: "refresh", ExpiresIn: 3600, CreatedAt: time.Now()}
+	if err := storage.SaveAccess(data); err != nil {
+		t.Fatalf("SaveAccess: %v", err)
+	}
+
+	// 同一刷新令牌被并发兑换时只有一个调用方删除了它
+	var (
+		wg             sync.WaitGroup
+		start          = make(chan struct{})
+		revoked, freed int32
+	)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			result, err := storage.RevokeRefresh(context.Background(), "refresh")
+			if err != nil {
+				t.Errorf("RevokeRefresh: %v", err)
+				return
+			}
+			if result.RefreshRevoked {
+				atomic.AddInt32(&revoked, 1)
+			}
+			atomic.AddInt32(&freed, int32(len(result.AccessTokens)))
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	if revoked != 1 || freed != 1 {
+		t.Fatalf("concurrent RevokeRefresh: %d callers revoked the refresh token, %d the access token", revoked, freed)
+	}
+	if _, err := storage.LoadRefresh("refresh"); err != osin.ErrNotFound {
+		t.Fatalf("LoadRefresh after revoke: %v", err)
+	}
+}
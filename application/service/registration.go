@@ -0,0 +1,496 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"oauth2/common/util"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/openshift/osin"
+)
+
+// RedirectUriSeparator separates the redirect URIs of a client in osin.Client.GetRedirectUri.
+const RedirectUriSeparator = " "
+
+// Registration error codes of RFC 7591 section 3.2.2.
+const (
+	ErrInvalidRedirectUri          = "invalid_redirect_uri"
+	ErrInvalidClientMetadata       = "invalid_client_metadata"
+	ErrInvalidSoftwareStatement    = "invalid_software_statement"
+	ErrUnapprovedSoftwareStatement = "unapproved_software_statement"
+	ErrInvalidInitialAccessToken   = "invalid_token"
+)
+
+// Client metadata values supported by the server.
+const (
+	authMethodClientSecretBasic = "client_secret_basic"
+	authMethodNone              = "none" // 公开客户端，没有密钥，必须使用PKCE
+	grantTypeAuthorizationCode  = "authorization_code"
+	grantTypeRefreshToken       = "refresh_token"
+	responseTypeCode            = "code"
+)
+
+// softwareStatementLeeway is the clock skew tolerated when checking the expiration of a software statement.
+const softwareStatementLeeway = time.Minute
+
+// RegistrationError is a client registration error response of RFC 7591 section 3.2.2.
+type RegistrationError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func (e *RegistrationError) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+func registrationError(code, format string, args ...interface{}) *RegistrationError {
+	return &RegistrationError{Code: code, Description: fmt.Sprintf(format, args...)}
+}
+
+// ClientMetadata is the client metadata of RFC 7591 section 2 supported by the server.
+type ClientMetadata struct {
+	RedirectUris            []string `json:"redirect_uris,omitempty"`
+	GrantTypes              []string `json:"grant_types,omitempty"`
+	ResponseTypes           []string `json:"response_types,omitempty"`
+	TokenEndpointAuthMethod string   `json:"token_endpoint_auth_method,omitempty"`
+	JwksUri                 string   `json:"jwks_uri,omitempty"`
+	ClientName              string   `json:"client_name,omitempty"`
+	LogoUri                 string   `json:"logo_uri,omitempty"`
+	PolicyUri               string   `json:"policy_uri,omitempty"`
+	TosUri                  string   `json:"tos_uri,omitempty"`
+	Scope                   string   `json:"scope,omitempty"`
+	SoftwareId              string   `json:"software_id,omitempty"`
+	SoftwareStatement       string   `json:"software_statement,omitempty"`
+
+	// 客户端允许的授权响应模式，为空时不限制
+	ResponseModes []string `json:"response_modes,omitempty"`
+
+	// 客户端公钥集，与jwks_uri二选一，用于校验请求对象的签名
+	Jwks json.RawMessage `json:"jwks,omitempty"`
+
+	// RFC 9126第6节：客户端的授权请求是否必须先推送
+	RequirePushedAuthorizationRequests bool `json:"require_pushed_authorization_requests,omitempty"`
+	// RFC 9101第10.5节：客户端的授权请求是否必须使用签名的请求对象
+	RequireSignedRequestObject bool `json:"require_signed_request_object,omitempty"`
+}
+
+// RegisteredClient is the client information response of RFC 7591 section 3.2.1,
+// extended with the client configuration endpoint of RFC 7592.
+type RegisteredClient struct {
+	ClientId                string `json:"client_id"`
+	ClientSecret            string `json:"client_secret,omitempty"` // 公开客户端没有密钥
+	ClientIdIssuedAt        int64  `json:"client_id_issued_at"`
+	ClientSecretExpiresAt   int64  `json:"client_secret_expires_at"`            // 0 表示永不过期
+	RegistrationAccessToken string `json:"registration_access_token,omitempty"` // 只在注册和更新时返回
+	RegistrationClientUri   string `json:"registration_client_uri"`
+	ClientMetadata
+}
+
+// clientRegistration is what the registrar keeps as JSON in the user data of a client.
+// Only the digest of the registration access token is stored.
+type clientRegistration struct {
+	ClientMetadata
+	IssuedAt  int64  `json:"client_id_issued_at"`
+	TokenHash string `json:"registration_access_token_hash"`
+}
+
+// RegistrationPolicy controls who may register clients.
+type RegistrationPolicy struct {
+	InitialAccessTokens      []string // 非空时注册必须携带其中之一作为Bearer令牌
+	RequireSoftwareStatement bool     // 是否必须提供软件声明
+	SoftwareStatementKey     string   // 校验软件声明签名的密钥，PEM格式的RSA/EC公钥或HMAC密钥
+	Endpoint                 string   // 注册接口地址，客户端配置地址为 Endpoint/{client_id}
+}
+
+// Registrar registers clients dynamically as described in RFC 7591 and lets them manage their
+// registration through the client configuration endpoint of RFC 7592.
+// The validated metadata is kept as JSON in the client's user data. Clients authenticate to the
+// configuration endpoint with a registration access token that is rotated on every update.
+type Registrar struct {
+	clients ClientStorage
+	hasher  *TokenHasher
+	policy  RegistrationPolicy
+}
+
+// NewRegistrar returns a registrar storing clients in clients. Registration access tokens are hashed with hasher.
+func NewRegistrar(clients ClientStorage, hasher *TokenHasher, policy RegistrationPolicy) *Registrar {
+	return &Registrar{clients: clients, hasher: hasher, policy: policy}
+}
+
+// Register validates metadata, generates credentials and stores the new client.
+// initialAccessToken is the bearer token of the request, empty if there was none.
+// Errors that should be returned to the client are of type *RegistrationError.
+func (r *Registrar) Register(ctx context.Context, metadata ClientMetadata, initialAccessToken string) (*RegisteredClient, error) {
+	if !r.authorized(initialAccessToken) {
+		return nil, registrationError(ErrInvalidInitialAccessToken, "初始访问令牌无效")
+	}
+
+	metadata, err := r.validate(metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	clientID, err := randomToken(16, hex.EncodeToString)
+	if err != nil {
+		return nil, err
+	}
+	registration := clientRegistration{ClientMetadata: metadata, IssuedAt: time.Now().Unix()}
+	client, token, err := r.newClient(&Client{Id: clientID, Status: ClientStatusActive}, registration)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.clients.CreateClient(client); err != nil {
+		return nil, fmt.Errorf("保存客户端失败: %v", err)
+	}
+	return r.response(client, registration, token), nil
+}
+
+// Read returns the current registration of a client. token is the registration access token of the client.
+func (r *Registrar) Read(ctx context.Context, clientID, token string) (*RegisteredClient, error) {
+	client, registration, err := r.load(clientID, token)
+	if err != nil {
+		return nil, err
+	}
+	return r.response(client, registration, ""), nil
+}
+
+// Update replaces the metadata of a client and rotates its registration access token.
+// secret is the client_secret of the request, which must match the current one if present.
+// Properties the metadata does not cover, such as the owner, status and token lifetimes, are kept.
+func (r *Registrar) Update(ctx context.Context, clientID, token string, metadata ClientMetadata, secret string) (*RegisteredClient, error) {
+	client, registration, err := r.load(clientID, token)
+	if err != nil {
+		return nil, err
+	}
+	if secret != "" && !client.ClientSecretMatches(secret) {
+		return nil, registrationError(ErrInvalidClientMetadata, "client_secret与当前值不一致")
+	}
+	if registration.ClientMetadata, err = r.validate(metadata); err != nil {
+		return nil, err
+	}
+
+	updated, token, err := r.newClient(client, registration)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.clients.UpdateClient(updated); err != nil {
+		return nil, fmt.Errorf("更新客户端失败: %v", err)
+	}
+	return r.response(updated, registration, token), nil
+}
+
+// Delete removes a client together with its grants and tokens.
+func (r *Registrar) Delete(ctx context.Context, clientID, token string) error {
+	if _, _, err := r.load(clientID, token); err != nil {
+		return err
+	}
+	if err := r.clients.RemoveClient(clientID); err != nil {
+		return fmt.Errorf("删除客户端失败: %v", err)
+	}
+	return nil
+}
+
+// validate checks the software statement required by the policy and normalizes metadata.
+func (r *Registrar) validate(metadata ClientMetadata) (ClientMetadata, error) {
+	if metadata.SoftwareStatement != "" {
+		// 软件声明中的元数据优先于请求中的同名字段
+		statement, err := r.parseSoftwareStatement(metadata.SoftwareStatement)
+		if err != nil {
+			return metadata, err
+		}
+		metadata = mergeMetadata(metadata, statement)
+	} else if r.policy.RequireSoftwareStatement {
+		return metadata, registrationError(ErrInvalidSoftwareStatement, "缺少软件声明")
+	}
+
+	if err := normalizeMetadata(&metadata); err != nil {
+		return metadata, err
+	}
+	return metadata, nil
+}
+
+// load returns a dynamically registered client after checking its registration access token.
+// Unknown clients and invalid tokens are reported alike, as RFC 7592 section 2 requires.
+func (r *Registrar) load(clientID, token string) (*Client, clientRegistration, error) {
+	var registration clientRegistration
+	invalid := registrationError(ErrInvalidInitialAccessToken, "注册访问令牌无效")
+	if clientID == "" || token == "" {
+		return nil, registration, invalid
+	}
+
+	client, err := r.clients.GetClient(clientID)
+	if errors.Is(err, osin.ErrNotFound) {
+		return nil, registration, invalid
+	} else if err != nil {
+		return nil, registration, err
+	}
+	// 不是动态注册的客户端没有注册访问令牌
+	if err := json.Unmarshal([]byte(toString(client.GetUserData())), &registration); err != nil || registration.TokenHash == "" {
+		return nil, registration, invalid
+	}
+	if subtle.ConstantTimeCompare([]byte(r.hasher.Hash(token)), []byte(registration.TokenHash)) != 1 {
+		return nil, registration, invalid
+	}
+	return AsClient(client), registration, nil
+}
+
+// newClient applies registration to client and returns it with a new registration access token.
+// Confidential clients keep their secret, or get one when they had none.
+func (r *Registrar) newClient(client *Client, registration clientRegistration) (*Client, string, error) {
+	token, err := randomToken(32, base64.RawURLEncoding.EncodeToString)
+	if err != nil {
+		return nil, "", err
+	}
+	registration.TokenHash = r.hasher.Hash(token)
+	extra, err := json.Marshal(registration)
+	if err != nil {
+		return nil, "", err
+	}
+
+	metadata := registration.ClientMetadata
+	if metadata.TokenEndpointAuthMethod == authMethodNone {
+		client.Type, client.Secret, client.RequirePkce = ClientTypePublic, "", true
+	} else {
+		client.Type = ClientTypeConfidential
+		if client.Secret == "" {
+			if client.Secret, err = randomToken(32, base64.RawURLEncoding.EncodeToString); err != nil {
+				return nil, "", err
+			}
+		}
+	}
+	client.RedirectUri = strings.Join(metadata.RedirectUris, RedirectUriSeparator)
+	client.Name = metadata.ClientName
+	client.GrantTypes = metadata.GrantTypes
+	client.Scopes = strings.Fields(metadata.Scope)
+	client.ResponseModes = metadata.ResponseModes
+	client.TokenEndpointAuthMethod = metadata.TokenEndpointAuthMethod
+	client.LogoUri, client.PolicyUri, client.TosUri = metadata.LogoUri, metadata.PolicyUri, metadata.TosUri
+	client.RequirePar = metadata.RequirePushedAuthorizationRequests
+	client.RequireSignedRequest = metadata.RequireSignedRequestObject
+	client.JwksUri, client.Jwks = metadata.JwksUri, string(metadata.Jwks)
+	client.UserData = string(extra)
+	return client, token, nil
+}
+
+// response returns the client information response for registration.
+func (r *Registrar) response(client *Client, registration clientRegistration, token string) *RegisteredClient {
+	return &RegisteredClient{
+		ClientId:                client.Id,
+		ClientSecret:            client.Secret,
+		ClientIdIssuedAt:        registration.IssuedAt,
+		RegistrationAccessToken: token,
+		RegistrationClientUri:   strings.TrimSuffix(r.policy.Endpoint, "/") + "/" + client.Id,
+		ClientMetadata:          registration.ClientMetadata,
+	}
+}
+
+// authorized reports whether token is one of the configured initial access tokens,
+// or whether registration is open.
+func (r *Registrar) authorized(token string) bool {
+	if len(r.policy.InitialAccessTokens) == 0 {
+		return true
+	}
+	for _, allowed := range r.policy.InitialAccessTokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(allowed)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// parseSoftwareStatement verifies the signature of a software statement and returns the metadata it asserts.
+func (r *Registrar) parseSoftwareStatement(statement string) (ClientMetadata, error) {
+	var metadata ClientMetadata
+	if r.policy.SoftwareStatementKey == "" {
+		return metadata, registrationError(ErrUnapprovedSoftwareStatement, "未配置软件声明密钥")
+	}
+
+	claims := jwt.MapClaims{}
+	parser := jwt.NewParser(jwt.WithoutClaimsValidation())
+	_, err := parser.ParseWithClaims(statement, claims, r.softwareStatementKey)
+	if err != nil {
+		return metadata, registrationError(ErrInvalidSoftwareStatement, "软件声明签名无效: %v", err)
+	}
+	// 允许少量时钟偏差
+	now := time.Now().Add(-softwareStatementLeeway).Unix()
+	if !claims.VerifyExpiresAt(now, false) {
+		return metadata, registrationError(ErrInvalidSoftwareStatement, "软件声明已过期")
+	}
+
+	payload, err := json.Marshal(claims)
+	if err == nil {
+		err = json.Unmarshal(payload, &metadata)
+	}
+	if err != nil {
+		return metadata, registrationError(ErrInvalidSoftwareStatement, "无法解析软件声明: %v", err)
+	}
+	metadata.SoftwareStatement = ""
+	return metadata, nil
+}
+
+// softwareStatementKey returns the verification key matching the signing method of token.
+func (r *Registrar) softwareStatementKey(token *jwt.Token) (interface{}, error) {
+	key := []byte(r.policy.SoftwareStatementKey)
+	switch token.Method.(type) {
+	case *jwt.SigningMethodHMAC:
+		if strings.HasPrefix(r.policy.SoftwareStatementKey, "-----BEGIN") {
+			return nil, errors.New("不支持的签名算法")
+		}
+		return key, nil
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+		return jwt.ParseRSAPublicKeyFromPEM(key)
+	case *jwt.SigningMethodECDSA:
+		return jwt.ParseECPublicKeyFromPEM(key)
+	default:
+		return nil, fmt.Errorf("不支持的签名算法: %v", token.Header["alg"])
+	}
+}
+
+// mergeMetadata returns metadata with the fields set in statement replaced by those of statement.
+func mergeMetadata(metadata, statement ClientMetadata) ClientMetadata {
+	if len(statement.RedirectUris) > 0 {
+		metadata.RedirectUris = statement.RedirectUris
+	}
+	if len(statement.GrantTypes) > 0 {
+		metadata.GrantTypes = statement.GrantTypes
+	}
+	if len(statement.ResponseTypes) > 0 {
+		metadata.ResponseTypes = statement.ResponseTypes
+	}
+	if len(statement.ResponseModes) > 0 {
+		metadata.ResponseModes = statement.ResponseModes
+	}
+	for _, field := range []struct {
+		dst *string
+		src string
+	}{
+		{&metadata.TokenEndpointAuthMethod, statement.TokenEndpointAuthMethod},
+		{&metadata.JwksUri, statement.JwksUri},
+		{&metadata.ClientName, statement.ClientName},
+		{&metadata.LogoUri, statement.LogoUri},
+		{&metadata.PolicyUri, statement.PolicyUri},
+		{&metadata.TosUri, statement.TosUri},
+		{&metadata.Scope, statement.Scope},
+		{&metadata.SoftwareId, statement.SoftwareId},
+	} {
+		if field.src != "" {
+			*field.dst = field.src
+		}
+	}
+	if len(statement.Jwks) > 0 {
+		metadata.Jwks = statement.Jwks
+	}
+	if statement.RequirePushedAuthorizationRequests {
+		metadata.RequirePushedAuthorizationRequests = true
+	}
+	if statement.RequireSignedRequestObject {
+		metadata.RequireSignedRequestObject = true
+	}
+	return metadata
+}
+
+// normalizeMetadata applies the defaults of RFC 7591 section 2 and validates metadata against
+// what the server supports.
+func normalizeMetadata(metadata *ClientMetadata) error {
+	if len(metadata.GrantTypes) == 0 {
+		metadata.GrantTypes = []string{grantTypeAuthorizationCode}
+	}
+	if len(metadata.ResponseTypes) == 0 {
+		metadata.ResponseTypes = []string{responseTypeCode}
+	}
+	if metadata.TokenEndpointAuthMethod == "" {
+		metadata.TokenEndpointAuthMethod = authMethodClientSecretBasic
+	}
+
+	for _, grantType := range metadata.GrantTypes {
+		if grantType != grantTypeAuthorizationCode && grantType != grantTypeRefreshToken {
+			return registrationError(ErrInvalidClientMetadata, "不支持的grant_type: %s", grantType)
+		}
+	}
+	for _, responseType := range metadata.ResponseTypes {
+		if responseType != responseTypeCode {
+			return registrationError(ErrInvalidClientMetadata, "不支持的response_type: %s", responseType)
+		}
+	}
+	for _, mode := range metadata.ResponseModes {
+		if !util.InArray(ResponseModes(true), mode) {
+			return registrationError(ErrInvalidClientMetadata, "不支持的response_mode: %s", mode)
+		}
+	}
+	// code 响应类型必须与 authorization_code 授权类型同时使用
+	if !util.InArray(metadata.GrantTypes, grantTypeAuthorizationCode) {
+		return registrationError(ErrInvalidClientMetadata, "response_type code 需要 grant_type authorization_code")
+	}
+	if metadata.TokenEndpointAuthMethod != authMethodClientSecretBasic && metadata.TokenEndpointAuthMethod != authMethodNone {
+		return registrationError(ErrInvalidClientMetadata, "不支持的token_endpoint_auth_method: %s", metadata.TokenEndpointAuthMethod)
+	}
+
+	if len(metadata.RedirectUris) == 0 {
+		return registrationError(ErrInvalidRedirectUri, "缺少redirect_uris")
+	}
+	for _, uri := range metadata.RedirectUris {
+		if err := validateRedirectUri(uri); err != nil {
+			return err
+		}
+	}
+	for name, uri := range map[string]string{
+		"jwks_uri":   metadata.JwksUri,
+		"logo_uri":   metadata.LogoUri,
+		"policy_uri": metadata.PolicyUri,
+		"tos_uri":    metadata.TosUri,
+	} {
+		if uri == "" {
+			continue
+		}
+		if u, err := url.Parse(uri); err != nil || !u.IsAbs() || (u.Scheme != "https" && u.Scheme != "http") {
+			return registrationError(ErrInvalidClientMetadata, "%s 必须是绝对的HTTP(S)地址", name)
+		}
+	}
+
+	// RFC 7591第2节：jwks_uri和jwks不能同时提供
+	if len(metadata.Jwks) > 0 {
+		if metadata.JwksUri != "" {
+			return registrationError(ErrInvalidClientMetadata, "jwks_uri和jwks不能同时提供")
+		}
+		if _, err := ParseKeySet(metadata.Jwks); err != nil {
+			return registrationError(ErrInvalidClientMetadata, "jwks不合法: %v", err)
+		}
+	}
+	if metadata.RequireSignedRequestObject && metadata.JwksUri == "" && len(metadata.Jwks) == 0 {
+		return registrationError(ErrInvalidClientMetadata, "require_signed_request_object 需要 jwks_uri 或 jwks")
+	}
+	return nil
+}
+
+// validateRedirectUri checks that uri is an absolute URI without fragment, as required by RFC 6749 section 3.1.2.
+func validateRedirectUri(uri string) error {
+	u, err := url.Parse(uri)
+	if err != nil || !u.IsAbs() || u.Host == "" {
+		return registrationError(ErrInvalidRedirectUri, "重定向URI必须是绝对地址: %s", uri)
+	}
+	if u.Fragment != "" {
+		return registrationError(ErrInvalidRedirectUri, "重定向URI不能包含片段: %s", uri)
+	}
+	if strings.ContainsAny(uri, RedirectUriSeparator) {
+		return registrationError(ErrInvalidRedirectUri, "重定向URI不能包含空格: %s", uri)
+	}
+	return nil
+}
+
+// randomToken returns n random bytes encoded with encode.
+func randomToken(n int, encode func([]byte) string) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("生成随机值失败: %v", err)
+	}
+	return encode(b), nil
+}
@@ -0,0 +1,324 @@
+package service_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"oauth2/application/service"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/openshift/osin"
+)
+
+func TestRegistrar(t *testing.T) {
+	ctx := context.Background()
+	storage := service.NewMemoryStorage(service.Options{Hasher: service.NewTokenHasher("")})
+	registrar := service.NewRegistrar(storage, service.NewTokenHasher(""), service.RegistrationPolicy{})
+
+	registered, err := registrar.Register(ctx, service.ClientMetadata{
+		RedirectUris: []string{"https://app.example.com/cb", "http://localhost:8080/cb"},
+		ClientName:   "Example",
+	}, "")
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	if registered.ClientId == "" || registered.ClientSecret == "" || registered.TokenEndpointAuthMethod != "client_secret_basic" ||
+		len(registered.GrantTypes) != 1 || registered.GrantTypes[0] != "authorization_code" {
+		t.Fatalf("unexpected registration response: %+v", registered)
+	}
+
+	client, err := storage.GetClient(registered.ClientId)
+	if err != nil {
+		t.Fatalf("GetClient: %v", err)
+	}
+	if client.GetSecret() != registered.ClientSecret || client.GetRedirectUri() != "https://app.example.com/cb http://localhost:8080/cb" {
+		t.Fatalf("stored client mismatch: %+v", client)
+	}
+	var stored service.ClientMetadata
+	if err := json.Unmarshal([]byte(client.GetUserData().(string)), &stored); err != nil || stored.ClientName != "Example" {
+		t.Fatalf("stored metadata: %v, %+v", err, stored)
+	}
+
+	// 公开客户端没有密钥，授权码必须使用PKCE
+	public, err := registrar.Register(ctx, service.ClientMetadata{
+		RedirectUris:                       []string{"http://127.0.0.1:8080/cb"},
+		TokenEndpointAuthMethod:            "none",
+		Scope:                              "read profile",
+		RequirePushedAuthorizationRequests: true,
+	}, "")
+	if err != nil {
+		t.Fatalf("Register a public client: %v", err)
+	}
+	if public.ClientSecret != "" {
+		t.Fatalf("public client got a secret: %+v", public)
+	}
+	if client, err := storage.GetClient(public.ClientId); err != nil {
+		t.Fatalf("GetClient: %v", err)
+	} else if c := service.AsClient(client); !c.IsPublic() || !c.RequirePkce || !c.RequirePar ||
+		!c.AllowsScope("read") || c.AllowsScope("write") {
+		t.Fatalf("stored public client: %+v", c)
+	}
+
+	for name, metadata := range map[string]service.ClientMetadata{
+		service.ErrInvalidRedirectUri:    {RedirectUris: []string{"https://app.example.com/cb#fragment"}},
+		service.ErrInvalidClientMetadata: {RedirectUris: []string{"https://app.example.com/cb"}, GrantTypes: []string{"password"}},
+	} {
+		_, err := registrar.Register(ctx, metadata, "")
+		requireRegistrationError(t, err, name)
+	}
+
+	// 请求对象的公钥：jwks和jwks_uri二选一，只能是公钥，要求签名的请求对象时必须提供；响应模式必须受支持
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	publicJwks, _ := json.Marshal(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: &key.PublicKey, KeyID: "k1"}}})
+	privateJwks, _ := json.Marshal(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: key, KeyID: "k1"}}})
+	for _, metadata := range []service.ClientMetadata{
+		{JwksUri: "https://app.example.com/jwks", Jwks: publicJwks},
+		{Jwks: privateJwks},
+		{RequireSignedRequestObject: true},
+		{ResponseModes: []string{"web_message"}},
+	} {
+		metadata.RedirectUris = []string{"https://app.example.com/cb"}
+		_, err := registrar.Register(ctx, metadata, "")
+		requireRegistrationError(t, err, service.ErrInvalidClientMetadata)
+	}
+	signed, err := registrar.Register(ctx, service.ClientMetadata{
+		RedirectUris:               []string{"https://app.example.com/cb"},
+		Jwks:                       publicJwks,
+		RequireSignedRequestObject: true,
+		ResponseModes:              []string{"form_post.jwt"},
+	}, "")
+	if err != nil {
+		t.Fatalf("Register with jwks: %v", err)
+	}
+	if client, err := storage.GetClient(signed.ClientId); err != nil {
+		t.Fatalf("GetClient: %v", err)
+	} else if c := service.AsClient(client); !c.RequireSignedRequest || c.Jwks != string(publicJwks) ||
+		!c.AllowsResponseMode("form_post.jwt") || c.AllowsResponseMode("query") {
+		t.Fatalf("stored client keys: %+v", c)
+	}
+}
+
+func TestRegistrarConfiguration(t *testing.T) {
+	ctx := context.Background()
+	storage := service.NewMemoryStorage(service.Options{Hasher: service.NewTokenHasher("")})
+	registrar := service.NewRegistrar(storage, service.NewTokenHasher(""), service.RegistrationPolicy{
+		Endpoint: "https://auth.example.com/v1/oauth/register",
+	})
+
+	registered, err := registrar.Register(ctx, service.ClientMetadata{RedirectUris: []string{"https://app.example.com/cb"}}, "")
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	if registered.RegistrationAccessToken == "" ||
+		registered.RegistrationClientUri != "https://auth.example.com/v1/oauth/register/"+registered.ClientId {
+		t.Fatalf("missing client configuration endpoint: %+v", registered)
+	}
+	id, token := registered.ClientId, registered.RegistrationAccessToken
+
+	read, err := registrar.Read(ctx, id, token)
+	if err != nil || read.ClientSecret != registered.ClientSecret || read.RegistrationAccessToken != "" {
+		t.Fatalf("Read: %+v, %v", read, err)
+	}
+	_, err = registrar.Read(ctx, id, "wrong")
+	requireRegistrationError(t, err, service.ErrInvalidInitialAccessToken)
+	_, err = registrar.Read(ctx, "unknown", token)
+	requireRegistrationError(t, err, service.ErrInvalidInitialAccessToken)
+
+	// 更新后轮换注册访问令牌，旧令牌失效
+	metadata := service.ClientMetadata{RedirectUris: []string{"https://app.example.com/new"}, ClientName: "Renamed"}
+	_, err = registrar.Update(ctx, id, token, metadata, "wrong-secret")
+	requireRegistrationError(t, err, service.ErrInvalidClientMetadata)
+	updated, err := registrar.Update(ctx, id, token, metadata, registered.ClientSecret)
+	if err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if updated.ClientName != "Renamed" || updated.ClientIdIssuedAt != registered.ClientIdIssuedAt ||
+		updated.RegistrationAccessToken == "" || updated.RegistrationAccessToken == token {
+		t.Fatalf("unexpected update response: %+v", updated)
+	}
+	if client, err := storage.GetClient(id); err != nil || client.GetRedirectUri() != "https://app.example.com/new" {
+		t.Fatalf("GetClient after update: %+v, %v", client, err)
+	}
+	_, err = registrar.Read(ctx, id, token)
+	requireRegistrationError(t, err, service.ErrInvalidInitialAccessToken)
+
+	if err := registrar.Delete(ctx, id, updated.RegistrationAccessToken); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := storage.GetClient(id); err == nil {
+		t.Fatal("client still exists after Delete")
+	}
+}
+
+func TestRegistrarPolicy(t *testing.T) {
+	ctx := context.Background()
+	storage := service.NewMemoryStorage(service.Options{Hasher: service.NewTokenHasher("")})
+	metadata := service.ClientMetadata{RedirectUris: []string{"https://app.example.com/cb"}}
+
+	registrar := service.NewRegistrar(storage, service.NewTokenHasher(""), service.RegistrationPolicy{InitialAccessTokens: []string{"initial"}})
+	_, err := registrar.Register(ctx, metadata, "wrong")
+	requireRegistrationError(t, err, service.ErrInvalidInitialAccessToken)
+	if _, err := registrar.Register(ctx, metadata, "initial"); err != nil {
+		t.Fatalf("Register with the initial access token: %v", err)
+	}
+
+	// HMAC签名的软件声明，声明中的元数据优先
+	registrar = service.NewRegistrar(storage, service.NewTokenHasher(""), service.RegistrationPolicy{RequireSoftwareStatement: true, SoftwareStatementKey: "publisher"})
+	_, err = registrar.Register(ctx, metadata, "")
+	requireRegistrationError(t, err, service.ErrInvalidSoftwareStatement)
+
+	statement := signStatement(t, jwt.SigningMethodHS256, []byte("publisher"), jwt.MapClaims{
+		"client_name": "Signed",
+		"software_id": "app-1",
+		"exp":         time.Now().Add(time.Hour).Unix(),
+	})
+	withStatement := metadata
+	withStatement.ClientName, withStatement.SoftwareStatement = "Unsigned", statement
+	registered, err := registrar.Register(ctx, withStatement, "")
+	if err != nil {
+		t.Fatalf("Register with a software statement: %v", err)
+	}
+	if registered.ClientName != "Signed" || registered.SoftwareId != "app-1" {
+		t.Fatalf("software statement must take precedence: %+v", registered)
+	}
+
+	withStatement.SoftwareStatement = signStatement(t, jwt.SigningMethodHS256, []byte("someone-else"), jwt.MapClaims{})
+	_, err = registrar.Register(ctx, withStatement, "")
+	requireRegistrationError(t, err, service.ErrInvalidSoftwareStatement)
+	withStatement.SoftwareStatement = signStatement(t, jwt.SigningMethodHS256, []byte("publisher"), jwt.MapClaims{
+		"exp": time.Now().Add(-time.Hour).Unix(),
+	})
+	_, err = registrar.Register(ctx, withStatement, "")
+	requireRegistrationError(t, err, service.ErrInvalidSoftwareStatement)
+
+	// 公钥验签，且不接受以公钥作为HMAC密钥的声明
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("MarshalPKIXPublicKey: %v", err)
+	}
+	publicKey := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	registrar = service.NewRegistrar(storage, service.NewTokenHasher(""), service.RegistrationPolicy{SoftwareStatementKey: publicKey})
+
+	withStatement.SoftwareStatement = signStatement(t, jwt.SigningMethodES256, key, jwt.MapClaims{"client_name": "EC"})
+	if registered, err = registrar.Register(ctx, withStatement, ""); err != nil || registered.ClientName != "EC" {
+		t.Fatalf("Register with an EC signed statement: %+v, %v", registered, err)
+	}
+	withStatement.SoftwareStatement = signStatement(t, jwt.SigningMethodHS256, []byte(publicKey), jwt.MapClaims{})
+	_, err = registrar.Register(ctx, withStatement, "")
+	requireRegistrationError(t, err, service.ErrInvalidSoftwareStatement)
+}
+
+// 通过none注册的公开客户端没有密钥，授权码只能由发起授权的一方凭 code_verifier 换取
+func TestRegistrarPublicClientPKCE(t *testing.T) {
+	storage := service.NewMemoryStorage(service.Options{Hasher: service.NewTokenHasher("")})
+	registrar := service.NewRegistrar(storage, service.NewTokenHasher(""), service.RegistrationPolicy{})
+	registered, err := registrar.Register(context.Background(), service.ClientMetadata{
+		RedirectUris:            []string{"http://127.0.0.1:8080/cb"},
+		TokenEndpointAuthMethod: "none",
+	}, "")
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+
+	config := osin.NewServerConfig()
+	config.AllowedAccessTypes = osin.AllowedAccessType{osin.AUTHORIZATION_CODE}
+	config.RequirePKCEForPublicClients = true
+	server := osin.NewServer(config, storage)
+
+	authorize := func(challenge string) *osin.Response {
+		query := url.Values{
+			"response_type": {"code"},
+			"client_id":     {registered.ClientId},
+			"redirect_uri":  {"http://127.0.0.1:8080/cb"},
+		}
+		if challenge != "" {
+			query.Set("code_challenge", challenge)
+			query.Set("code_challenge_method", osin.PKCE_S256)
+		}
+		resp := server.NewResponse()
+		if ar := server.HandleAuthorizeRequest(resp, httptest.NewRequest(http.MethodGet, "/authorize?"+query.Encode(), nil)); ar != nil {
+			ar.Authorized = true
+			server.FinishAuthorizeRequest(resp, httptest.NewRequest(http.MethodGet, "/authorize", nil), ar)
+		}
+		return resp
+	}
+	if resp := authorize(""); !resp.IsError {
+		t.Fatal("a public client must not get a code without a code challenge")
+	}
+
+	verifier := "public-client-verifier-0123456789-abcdefghijklmnop"
+	sum := sha256.Sum256([]byte(verifier))
+	resp := authorize(base64.RawURLEncoding.EncodeToString(sum[:]))
+	if resp.IsError {
+		t.Fatalf("authorize with a code challenge: %s", resp.ErrorId)
+	}
+	code, _ := resp.Output["code"].(string)
+
+	for _, tt := range []struct {
+		verifier string
+		ok       bool
+	}{
+		{"", false},
+		{verifier, true},
+	} {
+		form := url.Values{
+			"grant_type":   {"authorization_code"},
+			"code":         {code},
+			"redirect_uri": {"http://127.0.0.1:8080/cb"},
+		}
+		if tt.verifier != "" {
+			form.Set("code_verifier", tt.verifier)
+		}
+		req := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth(registered.ClientId, "")
+		resp := server.NewResponse()
+		if ar := server.HandleAccessRequest(resp, req); ar != nil {
+			ar.Authorized = true
+			server.FinishAccessRequest(resp, req, ar)
+		}
+		if resp.IsError == tt.ok {
+			t.Fatalf("token exchange with verifier %q: error %v (%s), want success %v",
+				tt.verifier, resp.IsError, resp.ErrorId, tt.ok)
+		}
+	}
+}
+
+func signStatement(t *testing.T, method jwt.SigningMethod, key interface{}, claims jwt.MapClaims) string {
+	t.Helper()
+	statement, err := jwt.NewWithClaims(method, claims).SignedString(key)
+	if err != nil {
+		t.Fatalf("SignedString: %v", err)
+	}
+	return statement
+}
+
+func requireRegistrationError(t *testing.T, err error, code string) {
+	t.Helper()
+	var regErr *service.RegistrationError
+	if !errors.As(err, &regErr) || regErr.Code != code {
+		t.Fatalf("expected registration error %s, got %v", code, err)
+	}
+}
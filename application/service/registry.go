@@ -0,0 +1,142 @@
+package service
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/zeromicro/go-zero/core/collection"
+)
+
+// RegistryStorage persists the entries of a registry, each identified by a unique key:
+// the name of a scope, the URI of a resource or the type of authorization details.
+type RegistryStorage[T any] interface {
+	// List returns all entries ordered by key.
+	List(ctx context.Context) ([]T, error)
+	// Save stores entry, replacing the entry of the same key.
+	Save(ctx context.Context, entry T) error
+	// Remove removes the entry of key. What was already granted with it is kept.
+	Remove(ctx context.Context, key string) error
+}
+
+// registryCacheKey is the only key of a registry cache.
+const registryCacheKey = "entries"
+
+// cachedRegistry is the part ScopeRegistry, ResourceRegistry and AuthorizationDetailRegistry share.
+// It stores entries of type T in a RegistryStorage and caches them in process by key, converted to E,
+// for the configured expiry, so changes reach other replicas within it. Each registry adds its own
+// validation through check and its own use of the cached entries.
+type cachedRegistry[T, E any] struct {
+	storage RegistryStorage[T]
+	cache   *collection.Cache
+	key     func(T) string
+	entry   func(T) (E, error)
+	check   func(T) (T, error)
+}
+
+// newCachedRegistry returns a registry of storage cached for expiry under name. check validates and
+// normalizes entries before they are saved; entry converts the stored entries for lookup.
+func newCachedRegistry[T, E any](name string, storage RegistryStorage[T], expiry time.Duration,
+	key func(T) string, entry func(T) (E, error), check func(T) (T, error)) (*cachedRegistry[T, E], error) {
+	cache, err := collection.NewCache(expiry, collection.WithName(name))
+	if err != nil {
+		return nil, err
+	}
+	return &cachedRegistry[T, E]{storage: storage, cache: cache, key: key, entry: entry, check: check}, nil
+}
+
+// List returns all registered entries ordered by key.
+func (r *cachedRegistry[T, E]) List(ctx context.Context) ([]T, error) {
+	return r.storage.List(ctx)
+}
+
+// Save registers entry after checking it, and drops the local cache.
+func (r *cachedRegistry[T, E]) Save(ctx context.Context, entry T) error {
+	entry, err := r.check(entry)
+	if err != nil {
+		return err
+	}
+	if err := r.storage.Save(ctx, entry); err != nil {
+		return err
+	}
+	r.cache.Del(registryCacheKey)
+	return nil
+}
+
+// Remove removes the entry of key and drops the local cache.
+func (r *cachedRegistry[T, E]) Remove(ctx context.Context, key string) error {
+	if err := r.storage.Remove(ctx, key); err != nil {
+		return err
+	}
+	r.cache.Del(registryCacheKey)
+	return nil
+}
+
+// lookup returns the registered entries by key, from the cache when possible.
+func (r *cachedRegistry[T, E]) lookup(ctx context.Context) (map[string]E, error) {
+	v, err := r.cache.Take(registryCacheKey, func() (any, error) {
+		entries, err := r.storage.List(ctx)
+		if err != nil {
+			return nil, err
+		}
+		byKey := make(map[string]E, len(entries))
+		for _, entry := range entries {
+			if byKey[r.key(entry)], err = r.entry(entry); err != nil {
+				return nil, err
+			}
+		}
+		return byKey, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(map[string]E), nil
+}
+
+// identity is the entry conversion of registries that look up the stored entries themselves.
+func identity[T any](entry T) (T, error) {
+	return entry, nil
+}
+
+// MemoryRegistryStorage keeps the entries of a registry in process, for the memory storage backend.
+type MemoryRegistryStorage[T any] struct {
+	mu      sync.RWMutex
+	entries map[string]T
+	key     func(T) string
+	clone   func(T) T
+}
+
+// newMemoryRegistryStorage returns an empty in-memory registry of entries identified by key.
+// clone copies the slices an entry shares with its caller.
+func newMemoryRegistryStorage[T any](key func(T) string, clone func(T) T) *MemoryRegistryStorage[T] {
+	return &MemoryRegistryStorage[T]{entries: make(map[string]T), key: key, clone: clone}
+}
+
+// List returns all entries ordered by key.
+func (s *MemoryRegistryStorage[T]) List(ctx context.Context) ([]T, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	entries := make([]T, 0, len(s.entries))
+	for _, entry := range s.entries {
+		entries = append(entries, s.clone(entry))
+	}
+	sort.Slice(entries, func(i, j int) bool { return s.key(entries[i]) < s.key(entries[j]) })
+	return entries, nil
+}
+
+// Save stores entry, replacing the entry of the same key.
+func (s *MemoryRegistryStorage[T]) Save(ctx context.Context, entry T) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[s.key(entry)] = s.clone(entry)
+	return nil
+}
+
+// Remove removes the entry of key.
+func (s *MemoryRegistryStorage[T]) Remove(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, key)
+	return nil
+}
@@ -0,0 +1,112 @@
+package service_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"oauth2/application/service"
+
+	"github.com/zeromicro/go-zero/core/stores/sqlx"
+)
+
+// registryStorages are the storages of the scope, resource and authorization detail type registries.
+type registryStorages struct {
+	scopes    service.ScopeStorage
+	resources service.ResourceStorage
+	types     service.AuthorizationDetailTypeStorage
+}
+
+func TestRegistryStorage(t *testing.T) {
+	for name, newStorages := range map[string]func(t *testing.T) registryStorages{
+		"memory": func(t *testing.T) registryStorages {
+			return registryStorages{
+				scopes:    service.NewMemoryScopeStorage(),
+				resources: service.NewMemoryResourceStorage(),
+				types:     service.NewMemoryAuthorizationDetailTypeStorage(),
+			}
+		},
+		service.DriverMySQL: func(t *testing.T) registryStorages {
+			return sqlRegistryStorages(t, sqlx.NewMysql(startMySQL(t)), service.DriverMySQL)
+		},
+		service.DriverSQLite: func(t *testing.T) registryStorages {
+			dataSource := service.SQLiteDataSource("file:"+filepath.Join(t.TempDir(), "oauth2.db"), true)
+			return sqlRegistryStorages(t, sqlx.NewSqlConn(service.DriverSQLite, dataSource), service.DriverSQLite)
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			storages := newStorages(t)
+			t.Run("scope", func(t *testing.T) {
+				testRegistryStorage(t, storages.scopes, func(scope service.Scope) string { return scope.Name },
+					[]service.Scope{
+						{Name: "openid", Description: "登录", Default: true},
+						{Name: "write", Description: "写入", ConsentRequired: true},
+					},
+					service.Scope{Name: "openid"})
+			})
+			t.Run("resource", func(t *testing.T) {
+				testRegistryStorage(t, storages.resources, func(resource service.Resource) string { return resource.Uri },
+					[]service.Resource{
+						{Uri: "https://admin.example.com", Description: "管理接口", Scopes: []string{"admin"}},
+						{Uri: "https://billing.example.com", Description: "账单"},
+					},
+					service.Resource{Uri: "https://admin.example.com"})
+			})
+			t.Run("authorization detail type", func(t *testing.T) {
+				testRegistryStorage(t, storages.types, func(detailType service.AuthorizationDetailType) string { return detailType.Type },
+					[]service.AuthorizationDetailType{
+						{Type: "account_information", Description: "账户信息", Schema: json.RawMessage(`{}`)},
+						{Type: "payment_initiation", Description: "发起支付", Schema: json.RawMessage(paymentSchema)},
+					},
+					service.AuthorizationDetailType{Type: "account_information", Schema: json.RawMessage(`{}`)})
+			})
+		})
+	}
+}
+
+// sqlRegistryStorages creates the schemas in conn and returns the SQL registry storages.
+func sqlRegistryStorages(t *testing.T, conn sqlx.SqlConn, driver string) registryStorages {
+	t.Helper()
+	opts := service.Options{Driver: driver, TablePrefix: "osin_", Hasher: service.NewTokenHasher("")}
+	if err := service.NewStorage(conn, opts).CreateSchemas(); err != nil {
+		t.Fatalf("CreateSchemas: %v", err)
+	}
+	return registryStorages{
+		scopes:    service.NewSQLScopeStorage(conn, opts),
+		resources: service.NewSQLResourceStorage(conn, opts),
+		types:     service.NewSQLAuthorizationDetailTypeStorage(conn, opts),
+	}
+}
+
+// testRegistryStorage checks storage with want, two entries ordered by key, and replaced, an entry
+// with the key of want[0] that saving want[0] replaces.
+func testRegistryStorage[T any](t *testing.T, storage service.RegistryStorage[T], key func(T) string, want []T, replaced T) {
+	ctx := context.Background()
+	for _, entry := range []T{want[1], replaced, want[0]} {
+		if err := storage.Save(ctx, entry); err != nil {
+			t.Fatalf("Save(%s): %v", key(entry), err)
+		}
+	}
+	if got, err := storage.List(ctx); err != nil || !reflect.DeepEqual(got, want) {
+		t.Fatalf("List: %+v, %v", got, err)
+	}
+
+	if err := storage.Remove(ctx, key(want[0])); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if got, err := storage.List(ctx); err != nil || !reflect.DeepEqual(got, want[1:]) {
+		t.Fatalf("List after remove: %+v, %v", got, err)
+	}
+}
+
+// requireError fails the test unless err is an E, the error a registry reports for an invalid entry.
+func requireError[E error](t *testing.T, err error) {
+	t.Helper()
+	var target E
+	if !errors.As(err, &target) {
+		t.Fatalf("expected %T, got %v", target, err)
+	}
+}
@@ -0,0 +1,64 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// redisReplayKey is the Redis key recording a used identifier, by digest.
+const redisReplayKey = "oauth2:oauth:replay:%s"
+
+// replaySweepInterval is how often the in-process guard drops expired identifiers.
+const replaySweepInterval = time.Minute
+
+// ReplayGuard remembers identifiers, such as the jti of request objects, until they expire so each
+// one is only accepted once. With Redis the identifiers are shared by all replicas; without it every
+// process only knows the identifiers it has seen itself.
+type ReplayGuard struct {
+	rdb *redis.Client
+
+	mu    sync.Mutex
+	seen  map[string]time.Time
+	swept time.Time
+}
+
+// NewReplayGuard returns a guard keeping identifiers in rdb, or in process when rdb is nil.
+func NewReplayGuard(rdb *redis.Client) *ReplayGuard {
+	return &ReplayGuard{rdb: rdb, seen: make(map[string]time.Time)}
+}
+
+// Use records key until expiresAt and reports whether it had not been used before.
+func (g *ReplayGuard) Use(ctx context.Context, key string, expiresAt time.Time) (bool, error) {
+	ttl := time.Until(expiresAt)
+	if ttl < time.Second {
+		ttl = time.Second
+	}
+	sum := sha256.Sum256([]byte(key))
+	digest := hex.EncodeToString(sum[:])
+	if g.rdb != nil {
+		return g.rdb.SetNX(ctx, fmt.Sprintf(redisReplayKey, digest), 1, ttl).Result()
+	}
+
+	now := time.Now()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if now.Sub(g.swept) >= replaySweepInterval {
+		for k, until := range g.seen {
+			if !now.Before(until) {
+				delete(g.seen, k)
+			}
+		}
+		g.swept = now
+	}
+	if until, ok := g.seen[digest]; ok && now.Before(until) {
+		return false, nil
+	}
+	g.seen[digest] = now.Add(ttl)
+	return true, nil
+}
@@ -0,0 +1,38 @@
+package service
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/zeromicro/go-zero/core/logx"
+	"github.com/zeromicro/go-zero/core/stores/sqlx"
+)
+
+// replicaReader sends listing reads to the replicas in turn, and to the primary when a replica fails.
+// Replicas may lag behind the primary, so reads that validate a token or a client, or refill a cache,
+// must not go through it: a revoked token or an old secret still present on a replica would be accepted.
+type replicaReader struct {
+	primary  sqlx.SqlConn
+	replicas []sqlx.SqlConn
+	next     uint64
+}
+
+func newReplicaReader(primary sqlx.SqlConn, replicas []sqlx.SqlConn) *replicaReader {
+	return &replicaReader{primary: primary, replicas: replicas}
+}
+
+// QueryRowsPartialCtx behaves like sqlx.SqlConn.QueryRowsPartialCtx on the next replica, falling back to
+// the primary.
+func (r *replicaReader) QueryRowsPartialCtx(ctx context.Context, v interface{}, query string, args ...interface{}) error {
+	if len(r.replicas) == 0 {
+		return r.primary.QueryRowsPartialCtx(ctx, v, query, args...)
+	}
+
+	replica := r.replicas[atomic.AddUint64(&r.next, 1)%uint64(len(r.replicas))]
+	err := replica.QueryRowsPartialCtx(ctx, v, query, args...)
+	if err == nil {
+		return nil
+	}
+	logx.WithContext(ctx).Errorf("从只读副本读取失败，改为读取主库: %v", err)
+	return r.primary.QueryRowsPartialCtx(ctx, v, query, args...)
+}
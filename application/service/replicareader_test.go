@@ -0,0 +1,88 @@
+package service_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"oauth2/application/service"
+
+	"github.com/openshift/osin"
+	"github.com/zeromicro/go-zero/core/stores/sqlx"
+)
+
+func TestStorageReplicas(t *testing.T) {
+	opts := service.Options{Driver: service.DriverSQLite, TablePrefix: "osin_", Hasher: service.NewTokenHasher("")}
+	open := func(name string) (sqlx.SqlConn, *service.Storage) {
+		conn := sqlx.NewSqlConn(service.DriverSQLite, service.SQLiteDataSource("file:"+filepath.Join(t.TempDir(), name), true))
+		storage := service.NewStorage(conn, opts)
+		if err := storage.CreateSchemas(); err != nil {
+			t.Fatalf("CreateSchemas(%s): %v", name, err)
+		}
+		return conn, storage
+	}
+	ctx := context.Background()
+
+	// 两个独立的数据库模拟尚未同步的主库和副本
+	primaryConn, primary := open("primary.db")
+	replicaConn, replica := open("replica.db")
+	routedOpts := opts
+	routedOpts.Replicas = []sqlx.SqlConn{replicaConn}
+	routed := service.NewStorage(primaryConn, routedOpts)
+
+	client := &osin.DefaultClient{Id: "app", Secret: "secret", RedirectUri: "http://localhost"}
+	for _, s := range []*service.Storage{primary, replica} {
+		if err := s.CreateClient(client); err != nil {
+			t.Fatalf("CreateClient: %v", err)
+		}
+		err := s.SaveAccess(&osin.AccessData{Client: client, AccessToken: "revoked", ExpiresIn: 3600, CreatedAt: time.Now(), UserData: "alice"})
+		if err != nil {
+			t.Fatalf("SaveAccess: %v", err)
+		}
+	}
+
+	// 已在主库撤销、副本中仍存在的令牌和客户端不能通过校验
+	if err := primary.RemoveAccess("revoked"); err != nil {
+		t.Fatalf("RemoveAccess: %v", err)
+	}
+	if err := primary.RemoveClient("app"); err != nil {
+		t.Fatalf("RemoveClient: %v", err)
+	}
+	if _, err := routed.LoadAccess("revoked"); err != osin.ErrNotFound {
+		t.Fatalf("LoadAccess of a token removed on the primary: %v", err)
+	}
+	if _, err := routed.GetClient("app"); err != osin.ErrNotFound {
+		t.Fatalf("GetClient of a client removed on the primary: %v", err)
+	}
+
+	// 用户的令牌列表读取副本
+	list, err := routed.ListUserTokens(ctx, "alice")
+	if err != nil || len(list) != 1 || list[0].ClientId != "app" {
+		t.Fatalf("ListUserTokens from replica: %+v, %v", list, err)
+	}
+
+	// 授权码只从主库读取
+	if err := primary.CreateClient(client); err != nil {
+		t.Fatalf("CreateClient: %v", err)
+	}
+	if err := replica.SaveAuthorize(&osin.AuthorizeData{Client: client, Code: "stale", ExpiresIn: 600, CreatedAt: time.Now()}); err != nil {
+		t.Fatalf("SaveAuthorize on replica: %v", err)
+	}
+	if _, err := routed.LoadAuthorize("stale"); err != osin.ErrNotFound {
+		t.Fatalf("LoadAuthorize must ignore replicas, got %v", err)
+	}
+
+	// 副本不可用时回退到主库
+	brokenConn := sqlx.NewSqlConn(service.DriverSQLite, service.SQLiteDataSource("file:"+filepath.Join(t.TempDir(), "missing", "replica.db"), true))
+	brokenOpts := opts
+	brokenOpts.Replicas = []sqlx.SqlConn{brokenConn}
+	broken := service.NewStorage(primaryConn, brokenOpts)
+	err = broken.SaveAccess(&osin.AccessData{Client: client, AccessToken: "fresh", ExpiresIn: 3600, CreatedAt: time.Now(), UserData: "bob"})
+	if err != nil {
+		t.Fatalf("SaveAccess: %v", err)
+	}
+	if list, err := broken.ListUserTokens(ctx, "bob"); err != nil || len(list) != 1 {
+		t.Fatalf("ListUserTokens with a failing replica: %+v, %v", list, err)
+	}
+}
@@ -0,0 +1,357 @@
+package service
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"oauth2/common/util"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/openshift/osin"
+)
+
+// Error codes of RFC 9101 section 6.3.
+const (
+	ErrInvalidRequestObject = "invalid_request_object"
+	ErrInvalidRequestURI    = "invalid_request_uri"
+)
+
+const (
+	// requestObjectLeeway is the clock skew tolerated when checking exp and nbf of a request object.
+	requestObjectLeeway = time.Minute
+	// maxRequestObjectLifetime bounds how far in the future a request object may expire, which also
+	// bounds how long its jti has to be remembered.
+	maxRequestObjectLifetime = time.Hour
+	// defaultFetchTimeout bounds fetching request_uri and jwks_uri when the policy has no HTTP client.
+	defaultFetchTimeout = 5 * time.Second
+)
+
+// requestObjectSigningAlgs are the accepted signing algorithms. A request object must be signed with
+// a key the client registered: none and the symmetric algorithms are not accepted.
+var requestObjectSigningAlgs = []string{
+	"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA",
+}
+
+// Accepted algorithms of encrypted request objects.
+var (
+	requestObjectKeyAlgs     = []jose.KeyAlgorithm{jose.RSA_OAEP, jose.RSA_OAEP_256}
+	requestObjectContentEncs = []jose.ContentEncryption{
+		jose.A128GCM, jose.A192GCM, jose.A256GCM, jose.A128CBC_HS256, jose.A192CBC_HS384, jose.A256CBC_HS512,
+	}
+)
+
+// requestObjectClaims are the claims of a request object that are not authorization parameters.
+var requestObjectClaims = []string{"iss", "aud", "exp", "nbf", "iat", "jti", "sub"}
+
+// jsonRequestParams are the authorization parameters whose values are JSON, which request objects
+// carry as JSON rather than as encoded strings.
+var jsonRequestParams = []string{"authorization_details", "claims"}
+
+// RequestObjectError reports a request object that cannot be used. Code is invalid_request_object,
+// invalid_request_uri for a request_uri that cannot be fetched, or invalid_request.
+type RequestObjectError struct {
+	Code        string
+	Description string
+}
+
+func (e *RequestObjectError) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+// requestObjectError returns an invalid_request_object error with a formatted description.
+func requestObjectError(format string, args ...interface{}) *RequestObjectError {
+	return &RequestObjectError{Code: ErrInvalidRequestObject, Description: fmt.Sprintf(format, args...)}
+}
+
+// RequestObjectPolicy configures a RequestObjectVerifier.
+type RequestObjectPolicy struct {
+	Issuer          string        // 授权服务器的标识，请求对象的aud必须包含它
+	DecryptionKey   string        // 解密请求对象的RSA私钥，PEM格式，为空时不接受加密的请求对象
+	KeysCacheExpiry time.Duration // 从jwks_uri获取的客户端公钥集的缓存有效期
+	HTTPClient      *http.Client  // 获取request_uri和jwks_uri的HTTP客户端，为空时使用只连接公网地址的客户端
+}
+
+// RequestObjectVerifier accepts the JWT-secured authorization requests of RFC 9101. A request object
+// is signed with a key the client registered and optionally encrypted to the server; its claims are
+// the authorization parameters, which take precedence over the plain parameters of the request.
+// Every request object must name the server as audience, expire within maxRequestObjectLifetime and
+// carry a jti that is only accepted once.
+type RequestObjectVerifier struct {
+	issuer        string
+	decryptionKey *rsa.PrivateKey
+	client        *http.Client
+	keys          *ClientKeys
+	replay        *ReplayGuard
+}
+
+// NewRequestObjectVerifier returns a verifier for policy, rejecting replayed request objects with replay.
+func NewRequestObjectVerifier(policy RequestObjectPolicy, replay *ReplayGuard) (*RequestObjectVerifier, error) {
+	client := policy.HTTPClient
+	if client == nil {
+		client = util.NewPublicHttpClient(defaultFetchTimeout)
+	}
+	keys, err := NewClientKeys(client, policy.KeysCacheExpiry)
+	if err != nil {
+		return nil, err
+	}
+	v := &RequestObjectVerifier{issuer: policy.Issuer, client: client, keys: keys, replay: replay}
+	if policy.DecryptionKey != "" {
+		if v.decryptionKey, err = jwt.ParseRSAPrivateKeyFromPEM([]byte(policy.DecryptionKey)); err != nil {
+			return nil, fmt.Errorf("无法解析请求对象解密密钥: %v", err)
+		}
+	}
+	return v, nil
+}
+
+// SigningAlgorithms returns the accepted signing algorithms of request objects.
+func (v *RequestObjectVerifier) SigningAlgorithms() []string {
+	return append([]string(nil), requestObjectSigningAlgs...)
+}
+
+// EncryptionAlgorithms returns the accepted key management and content encryption algorithms of
+// encrypted request objects, none when no decryption key is configured.
+func (v *RequestObjectVerifier) EncryptionAlgorithms() ([]string, []string) {
+	if v.decryptionKey == nil {
+		return nil, nil
+	}
+	algs := make([]string, 0, len(requestObjectKeyAlgs))
+	for _, alg := range requestObjectKeyAlgs {
+		algs = append(algs, string(alg))
+	}
+	encs := make([]string, 0, len(requestObjectContentEncs))
+	for _, enc := range requestObjectContentEncs {
+		encs = append(encs, string(enc))
+	}
+	return algs, encs
+}
+
+// EncryptionKey returns the public key clients encrypt request objects to. The second result is false
+// when no decryption key is configured.
+func (v *RequestObjectVerifier) EncryptionKey() (jose.JSONWebKey, bool) {
+	if v.decryptionKey == nil {
+		return jose.JSONWebKey{}, false
+	}
+	key := jose.JSONWebKey{Key: &v.decryptionKey.PublicKey, Use: "enc"}
+	if thumbprint, err := key.Thumbprint(crypto.SHA256); err == nil {
+		key.KeyID = base64.RawURLEncoding.EncodeToString(thumbprint)
+	}
+	return key, true
+}
+
+// Resolve returns the authorization parameters of a request of client with params. When params carry
+// a request object by value (request) or by reference (request_uri), its parameters replace those of
+// the same name and request and request_uri are removed; otherwise params are returned unchanged.
+// The second result reports whether a request object was used.
+func (v *RequestObjectVerifier) Resolve(ctx context.Context, client *Client, params url.Values) (url.Values, bool, error) {
+	object, requestURI := params.Get("request"), params.Get("request_uri")
+	switch {
+	case object == "" && requestURI == "":
+		return params, false, nil
+	case object != "" && requestURI != "":
+		return nil, false, &RequestObjectError{Code: osin.E_INVALID_REQUEST, Description: "request和request_uri不能同时使用"}
+	case requestURI != "":
+		var err error
+		if object, err = v.Fetch(ctx, requestURI); err != nil {
+			return nil, false, err
+		}
+	}
+
+	objectParams, err := v.Verify(ctx, client, object)
+	if err != nil {
+		return nil, false, err
+	}
+	merged := cloneValues(params)
+	merged.Del("request")
+	merged.Del("request_uri")
+	for name, values := range objectParams {
+		merged[name] = values
+	}
+	return merged, true, nil
+}
+
+// Fetch retrieves the request object referenced by requestURI. Only https references are fetched, and
+// unless the policy supplies its own HTTP client only from public addresses, so that a client cannot make
+// the server request its internal network.
+func (v *RequestObjectVerifier) Fetch(ctx context.Context, requestURI string) (string, error) {
+	if u, err := url.Parse(requestURI); err != nil || u.Scheme != "https" || u.Host == "" {
+		return "", &RequestObjectError{Code: ErrInvalidRequestURI, Description: "request_uri必须是HTTPS地址"}
+	}
+	data, err := fetch(ctx, v.client, requestURI, "application/oauth-authz-req+jwt")
+	if err != nil {
+		return "", &RequestObjectError{Code: ErrInvalidRequestURI, Description: fmt.Sprintf("无法获取request_uri: %v", err)}
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// Verify decrypts and verifies a request object of client and returns the authorization parameters it carries.
+// Errors other than *RequestObjectError are server errors.
+func (v *RequestObjectVerifier) Verify(ctx context.Context, client *Client, object string) (url.Values, error) {
+	// JWE紧凑序列化有五段，其中嵌套签名的请求对象
+	if strings.Count(object, ".") == 4 {
+		if v.decryptionKey == nil {
+			return nil, requestObjectError("不支持加密的请求对象")
+		}
+		encrypted, err := jose.ParseEncrypted(object, requestObjectKeyAlgs, requestObjectContentEncs)
+		if err != nil {
+			return nil, requestObjectError("无法解析加密的请求对象: %v", err)
+		}
+		payload, err := encrypted.Decrypt(v.decryptionKey)
+		if err != nil {
+			return nil, requestObjectError("无法解密请求对象")
+		}
+		object = string(payload)
+	}
+
+	claims := jwt.MapClaims{}
+	parser := jwt.NewParser(jwt.WithValidMethods(requestObjectSigningAlgs), jwt.WithoutClaimsValidation())
+	_, err := parser.ParseWithClaims(object, claims, func(token *jwt.Token) (interface{}, error) {
+		return v.verificationKey(ctx, client, token)
+	})
+	if err != nil {
+		return nil, requestObjectError("请求对象签名无效: %v", err)
+	}
+
+	now := time.Now()
+	exp, _ := claims["exp"].(float64)
+	nbf, hasNbf := claims["nbf"].(float64)
+	jti, _ := claims["jti"].(string)
+	expiresAt := time.Unix(int64(exp), 0)
+	switch {
+	case !claims.VerifyIssuer(client.Id, true):
+		return nil, requestObjectError("iss必须是客户端的client_id")
+	case claims["client_id"] != client.Id:
+		return nil, requestObjectError("client_id与请求不一致")
+	case !claims.VerifyAudience(v.issuer, true):
+		return nil, requestObjectError("aud必须包含授权服务器 %s", v.issuer)
+	case exp == 0:
+		return nil, requestObjectError("缺少exp")
+	case !expiresAt.After(now.Add(-requestObjectLeeway)):
+		return nil, requestObjectError("请求对象已过期")
+	case expiresAt.After(now.Add(maxRequestObjectLifetime + requestObjectLeeway)):
+		return nil, requestObjectError("请求对象的有效期不能超过 %s", maxRequestObjectLifetime)
+	case hasNbf && time.Unix(int64(nbf), 0).After(now.Add(requestObjectLeeway)):
+		return nil, requestObjectError("请求对象尚未生效")
+	case jti == "":
+		return nil, requestObjectError("缺少jti")
+	}
+
+	params, err := requestObjectParams(claims)
+	if err != nil {
+		return nil, err
+	}
+	// 校验通过后才记录jti，无效的请求对象不会占用它
+	fresh, err := v.replay.Use(ctx, client.Id+":"+jti, expiresAt.Add(requestObjectLeeway))
+	if err != nil {
+		return nil, fmt.Errorf("记录请求对象jti失败: %v", err)
+	}
+	if !fresh {
+		return nil, requestObjectError("请求对象已使用过")
+	}
+	return params, nil
+}
+
+// verificationKey returns the key of client that verifies token: the key with the kid of the token,
+// or the first key fitting its algorithm when there is no kid. An unknown key refreshes a key set
+// fetched from jwks_uri once, in case the client rotated its keys.
+func (v *RequestObjectVerifier) verificationKey(ctx context.Context, client *Client, token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	for _, refresh := range []bool{false, true} {
+		set, err := v.keys.KeySet(ctx, client, refresh)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range set.Keys {
+			if (kid == "" || key.KeyID == kid) && keyFits(key, token.Method) {
+				return key.Key, nil
+			}
+		}
+		if client.JwksUri == "" {
+			break
+		}
+	}
+	return nil, errors.New("没有匹配的客户端公钥")
+}
+
+// keyFits reports whether key may verify signatures of method.
+func keyFits(key jose.JSONWebKey, method jwt.SigningMethod) bool {
+	if key.Use != "" && key.Use != "sig" || key.Algorithm != "" && key.Algorithm != method.Alg() {
+		return false
+	}
+	var ok bool
+	switch method.(type) {
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+		_, ok = key.Key.(*rsa.PublicKey)
+	case *jwt.SigningMethodECDSA:
+		_, ok = key.Key.(*ecdsa.PublicKey)
+	case *jwt.SigningMethodEd25519:
+		_, ok = key.Key.(ed25519.PublicKey)
+	}
+	return ok
+}
+
+// requestObjectParams converts the claims of a request object to authorization parameters.
+// Arrays of strings, such as resource, become repeated parameters; the values of jsonRequestParams
+// and other structured values are encoded as JSON.
+func requestObjectParams(claims jwt.MapClaims) (url.Values, error) {
+	if _, ok := claims["request"]; ok {
+		return nil, requestObjectError("请求对象不能包含request")
+	}
+	if _, ok := claims["request_uri"]; ok {
+		return nil, requestObjectError("请求对象不能包含request_uri")
+	}
+
+	params := make(url.Values, len(claims))
+	for name, value := range claims {
+		if util.InArray(requestObjectClaims, name) || value == nil {
+			continue
+		}
+		switch v := value.(type) {
+		case string:
+			params.Set(name, v)
+		case float64:
+			params.Set(name, strconv.FormatFloat(v, 'f', -1, 64))
+		case bool:
+			params.Set(name, strconv.FormatBool(v))
+		default:
+			if values, ok := stringArray(value); ok && !util.InArray(jsonRequestParams, name) {
+				params[name] = values
+				continue
+			}
+			encoded, err := json.Marshal(value)
+			if err != nil {
+				return nil, requestObjectError("无法解析参数 %s", name)
+			}
+			params.Set(name, string(encoded))
+		}
+	}
+	return params, nil
+}
+
+// stringArray returns value as strings if it is a JSON array of strings.
+func stringArray(value interface{}) ([]string, bool) {
+	items, ok := value.([]interface{})
+	if !ok {
+		return nil, false
+	}
+	values := make([]string, 0, len(items))
+	for _, item := range items {
+		s, ok := item.(string)
+		if !ok {
+			return nil, false
+		}
+		values = append(values, s)
+	}
+	return values, true
+}
@@ -0,0 +1,257 @@
+package service_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"oauth2/application/service"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const requestObjectIssuer = "https://auth.example.com"
+
+// requestObjectFixture is a client with an RSA signing key and a verifier that accepts its request objects.
+type requestObjectFixture struct {
+	key      *rsa.PrivateKey
+	client   *service.Client
+	verifier *service.RequestObjectVerifier
+}
+
+func newRequestObjectFixture(t *testing.T, policy service.RequestObjectPolicy) *requestObjectFixture {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	jwks, err := json.Marshal(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+		{Key: &key.PublicKey, KeyID: "k1", Algorithm: "RS256", Use: "sig"},
+	}})
+	if err != nil {
+		t.Fatalf("marshal jwks: %v", err)
+	}
+	policy.Issuer = requestObjectIssuer
+	verifier, err := service.NewRequestObjectVerifier(policy, service.NewReplayGuard(nil))
+	if err != nil {
+		t.Fatalf("NewRequestObjectVerifier: %v", err)
+	}
+	return &requestObjectFixture{
+		key:      key,
+		client:   &service.Client{Id: "jar-client", Jwks: string(jwks)},
+		verifier: verifier,
+	}
+}
+
+// sign returns a request object of the client with claims added to valid defaults.
+func (f *requestObjectFixture) sign(t *testing.T, claims jwt.MapClaims) string {
+	t.Helper()
+	full := jwt.MapClaims{
+		"iss":       f.client.Id,
+		"aud":       requestObjectIssuer,
+		"client_id": f.client.Id,
+		"exp":       time.Now().Add(5 * time.Minute).Unix(),
+		"jti":       fmt.Sprintf("jti-%d", time.Now().UnixNano()),
+	}
+	for name, value := range claims {
+		if value == nil {
+			delete(full, name)
+		} else {
+			full[name] = value
+		}
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, full)
+	token.Header["kid"] = "k1"
+	signed, err := token.SignedString(f.key)
+	if err != nil {
+		t.Fatalf("sign request object: %v", err)
+	}
+	return signed
+}
+
+func requireRequestObjectError(t *testing.T, err error, code string) {
+	t.Helper()
+	var objectErr *service.RequestObjectError
+	if !errors.As(err, &objectErr) || objectErr.Code != code {
+		t.Fatalf("expected %s, got %v", code, err)
+	}
+}
+
+func TestRequestObjectResolve(t *testing.T) {
+	ctx := context.Background()
+	f := newRequestObjectFixture(t, service.RequestObjectPolicy{})
+
+	// 没有请求对象时参数不变
+	plain := url.Values{"client_id": {f.client.Id}, "scope": {"read"}}
+	if params, used, err := f.verifier.Resolve(ctx, f.client, plain); err != nil || used || params.Get("scope") != "read" {
+		t.Fatalf("Resolve without request object: %v, %v, %v", params, used, err)
+	}
+
+	// 请求对象中的参数优先于查询参数，字符串数组成为重复参数，授权详情编码为JSON
+	object := f.sign(t, jwt.MapClaims{
+		"response_type":         "code",
+		"scope":                 "read write",
+		"state":                 "signed",
+		"resource":              []string{"https://api.example.com", "https://files.example.com"},
+		"authorization_details": []map[string]interface{}{{"type": "payment", "amount": 10}},
+		"max_age":               300,
+	})
+	params, used, err := f.verifier.Resolve(ctx, f.client, url.Values{
+		"client_id": {f.client.Id},
+		"request":   {object},
+		"state":     {"query"},
+		"nonce":     {"n-1"},
+	})
+	if err != nil || !used {
+		t.Fatalf("Resolve: %v, %v", used, err)
+	}
+	if params.Get("state") != "signed" || params.Get("scope") != "read write" || params.Get("nonce") != "n-1" ||
+		params.Get("max_age") != "300" || len(params["resource"]) != 2 || params.Has("request") ||
+		params.Get("authorization_details") != `[{"amount":10,"type":"payment"}]` || params.Has("jti") {
+		t.Fatalf("unexpected merged parameters: %v", params)
+	}
+
+	// 每个请求对象只能使用一次
+	_, _, err = f.verifier.Resolve(ctx, f.client, url.Values{"client_id": {f.client.Id}, "request": {object}})
+	requireRequestObjectError(t, err, service.ErrInvalidRequestObject)
+
+	_, _, err = f.verifier.Resolve(ctx, f.client, url.Values{"request": {object}, "request_uri": {"https://app.example.com/r"}})
+	requireRequestObjectError(t, err, "invalid_request")
+	_, _, err = f.verifier.Resolve(ctx, f.client, url.Values{"request_uri": {"http://app.example.com/r"}})
+	requireRequestObjectError(t, err, service.ErrInvalidRequestURI)
+}
+
+func TestRequestObjectVerify(t *testing.T) {
+	ctx := context.Background()
+	f := newRequestObjectFixture(t, service.RequestObjectPolicy{})
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	unsigned := jwt.NewWithClaims(jwt.SigningMethodNone, jwt.MapClaims{
+		"iss": f.client.Id, "aud": requestObjectIssuer, "client_id": f.client.Id,
+		"exp": time.Now().Add(time.Minute).Unix(), "jti": "none",
+	})
+	none, _ := unsigned.SignedString(jwt.UnsafeAllowNoneSignatureType)
+	hmac, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"iss": f.client.Id, "aud": requestObjectIssuer, "client_id": f.client.Id,
+		"exp": time.Now().Add(time.Minute).Unix(), "jti": "hmac",
+	}).SignedString([]byte("secret"))
+	forged := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss": f.client.Id, "aud": requestObjectIssuer, "client_id": f.client.Id,
+		"exp": time.Now().Add(time.Minute).Unix(), "jti": "forged",
+	})
+	forged.Header["kid"] = "k1"
+	forgedObject, _ := forged.SignedString(otherKey)
+
+	for name, object := range map[string]string{
+		"none":        none,
+		"hmac":        hmac,
+		"other key":   forgedObject,
+		"wrong iss":   f.sign(t, jwt.MapClaims{"iss": "someone"}),
+		"wrong aud":   f.sign(t, jwt.MapClaims{"aud": "https://other.example.com"}),
+		"wrong id":    f.sign(t, jwt.MapClaims{"client_id": "other"}),
+		"no exp":      f.sign(t, jwt.MapClaims{"exp": nil}),
+		"expired":     f.sign(t, jwt.MapClaims{"exp": time.Now().Add(-time.Hour).Unix()}),
+		"too long":    f.sign(t, jwt.MapClaims{"exp": time.Now().Add(24 * time.Hour).Unix()}),
+		"not yet":     f.sign(t, jwt.MapClaims{"nbf": time.Now().Add(time.Hour).Unix()}),
+		"no jti":      f.sign(t, jwt.MapClaims{"jti": nil}),
+		"nested":      f.sign(t, jwt.MapClaims{"request_uri": "https://app.example.com/r"}),
+		"encrypted":   "a.b.c.d.e",
+		"not a token": "garbage",
+	} {
+		_, err := f.verifier.Verify(ctx, f.client, object)
+		var objectErr *service.RequestObjectError
+		if !errors.As(err, &objectErr) || objectErr.Code != service.ErrInvalidRequestObject {
+			t.Errorf("%s: expected invalid_request_object, got %v", name, err)
+		}
+	}
+
+	// 没有注册公钥的客户端不能使用请求对象
+	_, err = f.verifier.Verify(ctx, &service.Client{Id: f.client.Id}, f.sign(t, nil))
+	requireRequestObjectError(t, err, service.ErrInvalidRequestObject)
+}
+
+func TestRequestObjectEncrypted(t *testing.T) {
+	ctx := context.Background()
+	serverKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	pemKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(serverKey)})
+	f := newRequestObjectFixture(t, service.RequestObjectPolicy{DecryptionKey: string(pemKey)})
+
+	encrypter, err := jose.NewEncrypter(jose.A256GCM,
+		jose.Recipient{Algorithm: jose.RSA_OAEP_256, Key: &serverKey.PublicKey},
+		(&jose.EncrypterOptions{}).WithContentType("JWT"))
+	if err != nil {
+		t.Fatalf("NewEncrypter: %v", err)
+	}
+	encrypted, err := encrypter.Encrypt([]byte(f.sign(t, jwt.MapClaims{"scope": "read"})))
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	object, err := encrypted.CompactSerialize()
+	if err != nil {
+		t.Fatalf("CompactSerialize: %v", err)
+	}
+	params, err := f.verifier.Verify(ctx, f.client, object)
+	if err != nil || params.Get("scope") != "read" {
+		t.Fatalf("Verify encrypted: %v, %v", params, err)
+	}
+}
+
+func TestRequestObjectByReference(t *testing.T) {
+	ctx := context.Background()
+	var (
+		object string
+		jwks   []byte
+	)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/request.jwt":
+			w.Header().Set("Content-Type", "application/oauth-authz-req+jwt")
+			w.Write([]byte(object))
+		case "/jwks.json":
+			w.Write(jwks)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	f := newRequestObjectFixture(t, service.RequestObjectPolicy{HTTPClient: server.Client(), KeysCacheExpiry: time.Minute})
+	jwks = []byte(f.client.Jwks)
+	f.client.Jwks, f.client.JwksUri = "", server.URL+"/jwks.json"
+	object = f.sign(t, jwt.MapClaims{"scope": "read", "state": "by-reference"})
+
+	params, used, err := f.verifier.Resolve(ctx, f.client, url.Values{
+		"client_id":   {f.client.Id},
+		"request_uri": {server.URL + "/request.jwt"},
+	})
+	if err != nil || !used || params.Get("state") != "by-reference" || params.Has("request_uri") {
+		t.Fatalf("Resolve by reference: %v, %v, %v", params, used, err)
+	}
+
+	_, _, err = f.verifier.Resolve(ctx, f.client, url.Values{"request_uri": {server.URL + "/missing.jwt"}})
+	requireRequestObjectError(t, err, service.ErrInvalidRequestURI)
+
+	// 默认的HTTP客户端不连接回环、私有和链路本地地址
+	_, err = newRequestObjectFixture(t, service.RequestObjectPolicy{}).verifier.Fetch(ctx, server.URL+"/request.jwt")
+	requireRequestObjectError(t, err, service.ErrInvalidRequestURI)
+	if !strings.Contains(err.Error(), "非公网地址") {
+		t.Fatalf("Fetch from a loopback address: %v", err)
+	}
+}
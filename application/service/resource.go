@@ -0,0 +1,156 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"oauth2/common/util"
+)
+
+// TargetError reports a resource indicator that is malformed, unknown or does not accept the requested
+// scope. It maps to the invalid_target error of RFC 8707.
+type TargetError struct {
+	Description string
+}
+
+func (e *TargetError) Error() string {
+	return "invalid_target: " + e.Description
+}
+
+// targetError returns a TargetError with a formatted description.
+func targetError(format string, args ...interface{}) *TargetError {
+	return &TargetError{Description: fmt.Sprintf(format, args...)}
+}
+
+// Resource is an entry of the resource registry: a protected resource that tokens can be
+// audience-restricted to with the resource parameter of RFC 8707.
+type Resource struct {
+	Uri         string   `json:"uri"`
+	Description string   `json:"description"`
+	Scopes      []string `json:"scopes,omitempty"` // 资源接受的权限范围，为空时不限制
+}
+
+// AllowsScope reports whether every scope in the space separated scope is accepted by the resource.
+func (r Resource) AllowsScope(scope string) bool {
+	if len(r.Scopes) == 0 {
+		return true
+	}
+	for _, s := range strings.Fields(scope) {
+		if !util.InArray(r.Scopes, s) {
+			return false
+		}
+	}
+	return true
+}
+
+// ResourceStorage persists the resource registry, keyed by resource URI.
+type ResourceStorage = RegistryStorage[Resource]
+
+// ResourceRegistry validates resource indicators against the registered resources.
+// Like ScopeRegistry it is cached in process for the configured expiry.
+type ResourceRegistry struct {
+	*cachedRegistry[Resource, Resource]
+}
+
+// NewResourceRegistry returns a registry reading resources from resources and caching them for expiry.
+func NewResourceRegistry(resources ResourceStorage, expiry time.Duration) (*ResourceRegistry, error) {
+	registry, err := newCachedRegistry("resource", resources, expiry, resourceURI, identity[Resource], checkResource)
+	if err != nil {
+		return nil, err
+	}
+	return &ResourceRegistry{registry}, nil
+}
+
+// resourceURI returns the key of resource.
+func resourceURI(resource Resource) string {
+	return resource.Uri
+}
+
+// checkResource checks the URI and the scopes of a resource to register.
+func checkResource(resource Resource) (Resource, error) {
+	if err := checkResourceURI(resource.Uri); err != nil {
+		return resource, err
+	}
+	for _, scope := range resource.Scopes {
+		if strings.ContainsAny(scope, " \"\\") {
+			return resource, targetError("权限范围 %q 不能包含空格、引号或反斜杠", scope)
+		}
+	}
+	return resource, nil
+}
+
+// Lookup returns the registered resources by URI.
+func (r *ResourceRegistry) Lookup(ctx context.Context) (map[string]Resource, error) {
+	return r.lookup(ctx)
+}
+
+// Validate checks that every resource is a registered resource indicator that accepts the space
+// separated scope. A request without resources is not audience-restricted and always passes.
+func (r *ResourceRegistry) Validate(ctx context.Context, resources []string, scope string) error {
+	if len(resources) == 0 {
+		return nil
+	}
+	registered, err := r.Lookup(ctx)
+	if err != nil {
+		return err
+	}
+	for _, uri := range resources {
+		if err := checkResourceURI(uri); err != nil {
+			return err
+		}
+		resource, ok := registered[uri]
+		if !ok {
+			return targetError("未注册的资源 %s", uri)
+		}
+		if !resource.AllowsScope(scope) {
+			return targetError("资源 %s 不接受权限范围 %s", uri, scope)
+		}
+	}
+	return nil
+}
+
+// Narrow returns the grant data of a token issued from g for the requested resources.
+// requested may only pick from the resources of the grant, and an empty request restores them all,
+// so a refresh token stays usable for every authorized resource. A grant without resources is not
+// audience-restricted, so requested restricts it.
+func (g GrantData) Narrow(requested []string) (GrantData, error) {
+	requested = util.Unique(requested)
+	switch {
+	case len(g.Resources) == 0:
+		g.Resources = requested
+	case len(requested) == 0:
+		g.Audience = nil
+	default:
+		for _, uri := range requested {
+			if !util.InArray(g.Resources, uri) {
+				return g, targetError("资源 %s 不在授权范围内", uri)
+			}
+		}
+		g.Audience = requested
+	}
+	return g, nil
+}
+
+// checkResourceURI checks a resource indicator as RFC 8707 section 2 requires: an absolute URI
+// without a fragment. Spaces are rejected since resources are stored space separated.
+func checkResourceURI(uri string) error {
+	u, err := url.Parse(uri)
+	if err != nil || !u.IsAbs() || u.Host == "" || strings.ContainsAny(uri, " \t\n") {
+		return targetError("资源 %q 必须是绝对URI", uri)
+	}
+	if strings.Contains(uri, "#") {
+		return targetError("资源 %q 不能包含片段", uri)
+	}
+	return nil
+}
+
+// NewMemoryResourceStorage returns an empty in-memory resource registry.
+func NewMemoryResourceStorage() *MemoryRegistryStorage[Resource] {
+	return newMemoryRegistryStorage(resourceURI, func(resource Resource) Resource {
+		resource.Scopes = append([]string(nil), resource.Scopes...)
+		return resource
+	})
+}
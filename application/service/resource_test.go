@@ -0,0 +1,78 @@
+package service_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"oauth2/application/service"
+)
+
+func TestResourceRegistry(t *testing.T) {
+	ctx := context.Background()
+	registry, err := service.NewResourceRegistry(service.NewMemoryResourceStorage(), time.Minute)
+	if err != nil {
+		t.Fatalf("NewResourceRegistry: %v", err)
+	}
+	const billing, admin = "https://billing.example.com", "https://admin.example.com/api"
+	for _, resource := range []service.Resource{
+		{Uri: billing, Scopes: []string{"read", "write"}},
+		{Uri: admin},
+	} {
+		if err := registry.Save(ctx, resource); err != nil {
+			t.Fatalf("Save(%s): %v", resource.Uri, err)
+		}
+	}
+	for _, uri := range []string{"billing", "/relative", "https://billing.example.com#frag"} {
+		requireError[*service.TargetError](t, registry.Save(ctx, service.Resource{Uri: uri}))
+	}
+
+	if err := registry.Validate(ctx, nil, "anything"); err != nil {
+		t.Fatalf("Validate without resources: %v", err)
+	}
+	if err := registry.Validate(ctx, []string{billing, admin}, "read"); err != nil {
+		t.Fatalf("Validate(read): %v", err)
+	}
+	// 资源限制了权限范围时，只接受这些权限范围
+	requireError[*service.TargetError](t, registry.Validate(ctx, []string{billing}, "read admin"))
+	requireError[*service.TargetError](t, registry.Validate(ctx, []string{"https://unknown.example.com"}, ""))
+
+	// 删除后立即失效
+	if err := registry.Remove(ctx, admin); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	requireError[*service.TargetError](t, registry.Validate(ctx, []string{admin}, ""))
+}
+
+func TestGrantDataNarrow(t *testing.T) {
+	const billing, admin = "https://billing.example.com", "https://admin.example.com"
+
+	// 未限制受众的授权由请求确定受众
+	grant, err := service.GrantData{UserId: "alice"}.Narrow([]string{billing, billing})
+	if err != nil || !reflect.DeepEqual(grant.Resources, []string{billing}) || len(grant.Audience) != 0 {
+		t.Fatalf("Narrow of an unrestricted grant: %+v, %v", grant, err)
+	}
+
+	granted := service.GrantData{UserId: "alice", Resources: []string{billing, admin}}
+	grant, err = granted.Narrow([]string{admin})
+	if err != nil || !reflect.DeepEqual(grant.TokenAudience(), []string{admin}) {
+		t.Fatalf("Narrow(admin): %+v, %v", grant, err)
+	}
+	// 未指定resource时恢复为授权的全部资源
+	grant, err = grant.Narrow(nil)
+	if err != nil || !reflect.DeepEqual(grant.TokenAudience(), []string{billing, admin}) {
+		t.Fatalf("Narrow without a request: %+v, %v", grant, err)
+	}
+	_, err = granted.Narrow([]string{"https://other.example.com"})
+	requireError[*service.TargetError](t, err)
+
+	// UserData 为用户ID字符串或JSON时都能解析
+	if got := service.GrantDataOf("alice"); got.UserId != "alice" || len(got.Resources) != 0 {
+		t.Fatalf("GrantDataOf(alice): %+v", got)
+	}
+	if got := service.GrantDataOf(`{"user_id":"alice","resource":["` + billing + `"]}`); got.UserId != "alice" ||
+		!reflect.DeepEqual(got.Resources, []string{billing}) {
+		t.Fatalf("GrantDataOf(json): %+v", got)
+	}
+}
@@ -0,0 +1,128 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"oauth2/common/util"
+)
+
+// ScopeError reports a requested scope that is unknown, not allowed for the client or wider than
+// what was granted. It maps to the invalid_scope error of RFC 6749.
+type ScopeError struct {
+	Description string
+}
+
+func (e *ScopeError) Error() string {
+	return "invalid_scope: " + e.Description
+}
+
+// scopeError returns a ScopeError with a formatted description.
+func scopeError(format string, args ...interface{}) *ScopeError {
+	return &ScopeError{Description: fmt.Sprintf(format, args...)}
+}
+
+// Scope is an entry of the scope registry.
+type Scope struct {
+	Name            string `json:"name"`
+	Description     string `json:"description"`
+	ConsentRequired bool   `json:"consent_required"` // 授权时是否需要用户同意
+	Default         bool   `json:"default"`          // 授权请求未指定scope时授予
+}
+
+// ScopeStorage persists the scope registry, keyed by scope name.
+type ScopeStorage = RegistryStorage[Scope]
+
+// ScopeRegistry validates requested scopes against the registered scopes and the client's allowed set.
+// The registry is cached in process for the configured expiry, so changes reach other replicas within it.
+type ScopeRegistry struct {
+	*cachedRegistry[Scope, Scope]
+}
+
+// NewScopeRegistry returns a registry reading scopes from scopes and caching them for expiry.
+func NewScopeRegistry(scopes ScopeStorage, expiry time.Duration) (*ScopeRegistry, error) {
+	registry, err := newCachedRegistry("scope", scopes, expiry, scopeName, identity[Scope], checkScope)
+	if err != nil {
+		return nil, err
+	}
+	return &ScopeRegistry{registry}, nil
+}
+
+// scopeName returns the key of scope.
+func scopeName(scope Scope) string {
+	return scope.Name
+}
+
+// checkScope checks the name of a scope to register.
+func checkScope(scope Scope) (Scope, error) {
+	if scope.Name == "" || strings.ContainsAny(scope.Name, " \"\\") {
+		return scope, scopeError("权限范围名称不能为空，也不能包含空格、引号或反斜杠")
+	}
+	return scope, nil
+}
+
+// Lookup returns the registered scopes by name.
+func (r *ScopeRegistry) Lookup(ctx context.Context) (map[string]Scope, error) {
+	return r.lookup(ctx)
+}
+
+// Resolve returns the scope to authorize for a request of client. An empty request gets the default
+// scopes the client is allowed; otherwise the request is validated as by Validate.
+func (r *ScopeRegistry) Resolve(ctx context.Context, client *Client, requested string) (string, error) {
+	if strings.TrimSpace(requested) != "" {
+		return requested, r.Validate(ctx, client, requested)
+	}
+
+	registered, err := r.Lookup(ctx)
+	if err != nil {
+		return "", err
+	}
+	var defaults []string
+	for name, scope := range registered {
+		if scope.Default && client.AllowsScope(name) {
+			defaults = append(defaults, name)
+		}
+	}
+	sort.Strings(defaults)
+	return strings.Join(defaults, " "), nil
+}
+
+// Validate checks that every scope in the space separated scope is registered and allowed for client.
+func (r *ScopeRegistry) Validate(ctx context.Context, client *Client, scope string) error {
+	registered, err := r.Lookup(ctx)
+	if err != nil {
+		return err
+	}
+	for _, name := range strings.Fields(scope) {
+		if _, ok := registered[name]; !ok {
+			return scopeError("未注册的权限范围 %s", name)
+		}
+		if !client.AllowsScope(name) {
+			return scopeError("客户端不允许申请权限范围 %s", name)
+		}
+	}
+	return nil
+}
+
+// NarrowScope returns the scope of a refreshed token. requested may only narrow granted;
+// an empty request keeps the granted scope, as RFC 6749 section 6 requires.
+func NarrowScope(granted, requested string) (string, error) {
+	if strings.TrimSpace(requested) == "" {
+		return granted, nil
+	}
+	grantedScopes := strings.Fields(granted)
+	for _, name := range strings.Fields(requested) {
+		if !util.InArray(grantedScopes, name) {
+			return "", scopeError("刷新令牌不能扩大权限范围 %s", name)
+		}
+	}
+	return requested, nil
+}
+
+// NewMemoryScopeStorage returns an empty in-memory scope registry.
+func NewMemoryScopeStorage() *MemoryRegistryStorage[Scope] {
+	return newMemoryRegistryStorage(scopeName, func(scope Scope) Scope { return scope })
+}
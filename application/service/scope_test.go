@@ -0,0 +1,59 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"oauth2/application/service"
+)
+
+func TestScopeRegistry(t *testing.T) {
+	ctx := context.Background()
+	registry, err := service.NewScopeRegistry(service.NewMemoryScopeStorage(), time.Minute)
+	if err != nil {
+		t.Fatalf("NewScopeRegistry: %v", err)
+	}
+	for _, scope := range []service.Scope{
+		{Name: "openid", Default: true},
+		{Name: "profile", Default: true, ConsentRequired: true},
+		{Name: "write", ConsentRequired: true},
+	} {
+		if err := registry.Save(ctx, scope); err != nil {
+			t.Fatalf("Save(%s): %v", scope.Name, err)
+		}
+	}
+	requireError[*service.ScopeError](t, registry.Save(ctx, service.Scope{Name: "two words"}))
+
+	client := &service.Client{Id: "app"}
+	if scope, err := registry.Resolve(ctx, client, ""); err != nil || scope != "openid profile" {
+		t.Fatalf("default scopes: %q, %v", scope, err)
+	}
+	if scope, err := registry.Resolve(ctx, client, "write"); err != nil || scope != "write" {
+		t.Fatalf("Resolve(write): %q, %v", scope, err)
+	}
+	_, err = registry.Resolve(ctx, client, "openid admin")
+	requireError[*service.ScopeError](t, err)
+
+	// 客户端限制了权限范围时，默认权限范围同样受限
+	restricted := &service.Client{Id: "restricted", Scopes: []string{"openid", "write"}}
+	if scope, err := registry.Resolve(ctx, restricted, ""); err != nil || scope != "openid" {
+		t.Fatalf("default scopes of a restricted client: %q, %v", scope, err)
+	}
+	requireError[*service.ScopeError](t, registry.Validate(ctx, restricted, "profile"))
+
+	// 删除后立即失效
+	if err := registry.Remove(ctx, "write"); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	requireError[*service.ScopeError](t, registry.Validate(ctx, client, "write"))
+
+	if scope, err := service.NarrowScope("openid profile", ""); err != nil || scope != "openid profile" {
+		t.Fatalf("NarrowScope without a request: %q, %v", scope, err)
+	}
+	if scope, err := service.NarrowScope("openid profile", "profile"); err != nil || scope != "profile" {
+		t.Fatalf("NarrowScope(profile): %q, %v", scope, err)
+	}
+	_, err = service.NarrowScope("openid", "openid profile")
+	requireError[*service.ScopeError](t, err)
+}
@@ -0,0 +1,76 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"oauth2/infrastructure/migration"
+
+	"github.com/zeromicro/go-zero/core/stores/sqlx"
+)
+
+// SQLAuthorizationDetailTypeStorage keeps the registered authorization detail types in the
+// authorization_detail_type table of Storage.
+type SQLAuthorizationDetailTypeStorage struct {
+	db          sqlx.SqlConn
+	dialect     migration.Dialect
+	tablePrefix string
+}
+
+// NewSQLAuthorizationDetailTypeStorage returns a type storage for the database of opts.Driver.
+func NewSQLAuthorizationDetailTypeStorage(db sqlx.SqlConn, opts Options) *SQLAuthorizationDetailTypeStorage {
+	_, dialect := sqlDialect(opts.Driver)
+	return &SQLAuthorizationDetailTypeStorage{db: db, dialect: dialect, tablePrefix: opts.TablePrefix}
+}
+
+// List returns all registered types ordered by type.
+func (s *SQLAuthorizationDetailTypeStorage) List(ctx context.Context) ([]AuthorizationDetailType, error) {
+	var rows []struct {
+		Type        string `db:"detail_type"`
+		Description string `db:"description"`
+		Schema      string `db:"json_schema"`
+	}
+	query := fmt.Sprintf("SELECT detail_type, description, json_schema FROM %sauthorization_detail_type ORDER BY detail_type", s.tablePrefix)
+	if err := s.db.QueryRowsPartialCtx(ctx, &rows, query); err != nil {
+		return nil, fmt.Errorf("查询授权详情类型失败: %v", err)
+	}
+
+	types := make([]AuthorizationDetailType, 0, len(rows))
+	for _, row := range rows {
+		types = append(types, AuthorizationDetailType{
+			Type:        row.Type,
+			Description: row.Description,
+			Schema:      json.RawMessage(row.Schema),
+		})
+	}
+	return types, nil
+}
+
+// Save registers detailType, replacing the type of the same name.
+func (s *SQLAuthorizationDetailTypeStorage) Save(ctx context.Context, detailType AuthorizationDetailType) error {
+	// 先删后插，避免各数据库不同的 upsert 语法
+	err := s.db.TransactCtx(ctx, func(ctx context.Context, session sqlx.Session) error {
+		query := s.dialect.Rebind(fmt.Sprintf("DELETE FROM %sauthorization_detail_type WHERE detail_type = ?", s.tablePrefix))
+		if _, err := session.ExecCtx(ctx, query, detailType.Type); err != nil {
+			return err
+		}
+		query = s.dialect.Rebind(fmt.Sprintf(`INSERT INTO %sauthorization_detail_type (detail_type, description, json_schema)
+			VALUES (?, ?, ?)`, s.tablePrefix))
+		_, err := session.ExecCtx(ctx, query, detailType.Type, detailType.Description, string(detailType.Schema))
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("保存授权详情类型失败: %v", err)
+	}
+	return nil
+}
+
+// Remove removes the type.
+func (s *SQLAuthorizationDetailTypeStorage) Remove(ctx context.Context, typ string) error {
+	query := s.dialect.Rebind(fmt.Sprintf("DELETE FROM %sauthorization_detail_type WHERE detail_type = ?", s.tablePrefix))
+	if _, err := s.db.ExecCtx(ctx, query, typ); err != nil {
+		return fmt.Errorf("删除授权详情类型失败: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+package service
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"oauth2/common/util"
+	"oauth2/infrastructure/migration"
+
+	"github.com/openshift/osin"
+	"github.com/zeromicro/go-zero/core/stores/sqlx"
+)
+
+// SQLClientStorage reads and writes clients directly in the client table of Storage.
+type SQLClientStorage struct {
+	db          sqlx.SqlConn
+	dialect     migration.Dialect
+	tablePrefix string
+}
+
+// NewSQLClientStorage returns a client storage for the database of opts.Driver.
+// Clients are always read from the primary, so suspensions and rotated secrets take effect immediately.
+func NewSQLClientStorage(db sqlx.SqlConn, opts Options) *SQLClientStorage {
+	_, dialect := sqlDialect(opts.Driver)
+	return &SQLClientStorage{
+		db:          db,
+		dialect:     dialect,
+		tablePrefix: opts.TablePrefix,
+	}
+}
+
+// clientColumns are the columns of the client table, in the order of clientRow.args.
+const clientColumns = `id, secret, redirect_uri, client_type, client_name, grant_types, scopes, response_modes,
+	auth_method, access_token_lifetime, refresh_token_lifetime, require_pkce, require_par, require_signed_request,
+	client_status, owner_id, logo_uri, policy_uri, tos_uri, jwks_uri, jwks, extra`
+
+// clientRow is a row of the client table. Grant types, scopes and response modes are stored space separated.
+type clientRow struct {
+	Id                   string         `db:"id"`
+	Secret               string         `db:"secret"`
+	RedirectUri          string         `db:"redirect_uri"`
+	Type                 string         `db:"client_type"`
+	Name                 sql.NullString `db:"client_name"`
+	GrantTypes           sql.NullString `db:"grant_types"`
+	Scopes               sql.NullString `db:"scopes"`
+	ResponseModes        sql.NullString `db:"response_modes"`
+	AuthMethod           sql.NullString `db:"auth_method"`
+	AccessTokenLifetime  int32          `db:"access_token_lifetime"`
+	RefreshTokenLifetime int32          `db:"refresh_token_lifetime"`
+	RequirePkce          bool           `db:"require_pkce"`
+	RequirePar           bool           `db:"require_par"`
+	RequireSignedRequest bool           `db:"require_signed_request"`
+	Status               string         `db:"client_status"`
+	OwnerId              sql.NullString `db:"owner_id"`
+	LogoUri              sql.NullString `db:"logo_uri"`
+	PolicyUri            sql.NullString `db:"policy_uri"`
+	TosUri               sql.NullString `db:"tos_uri"`
+	JwksUri              sql.NullString `db:"jwks_uri"`
+	Jwks                 sql.NullString `db:"jwks"`
+	Extra                sql.NullString `db:"extra"`
+}
+
+// client converts the row to a Client.
+func (r *clientRow) client() *Client {
+	client := &Client{
+		Id:                      r.Id,
+		Secret:                  r.Secret,
+		RedirectUri:             r.RedirectUri,
+		Type:                    r.Type,
+		Name:                    r.Name.String,
+		GrantTypes:              strings.Fields(r.GrantTypes.String),
+		Scopes:                  strings.Fields(r.Scopes.String),
+		ResponseModes:           strings.Fields(r.ResponseModes.String),
+		TokenEndpointAuthMethod: r.AuthMethod.String,
+		AccessTokenLifetime:     r.AccessTokenLifetime,
+		RefreshTokenLifetime:    r.RefreshTokenLifetime,
+		RequirePkce:             r.RequirePkce,
+		RequirePar:              r.RequirePar,
+		RequireSignedRequest:    r.RequireSignedRequest,
+		Status:                  r.Status,
+		OwnerId:                 r.OwnerId.String,
+		LogoUri:                 r.LogoUri.String,
+		PolicyUri:               r.PolicyUri.String,
+		TosUri:                  r.TosUri.String,
+		JwksUri:                 r.JwksUri.String,
+		Jwks:                    r.Jwks.String,
+	}
+	if r.Extra.Valid {
+		client.UserData = r.Extra.String
+	}
+	return client
+}
+
+// clientArgs returns the values of clientColumns for c.
+func clientArgs(c osin.Client) []interface{} {
+	client := AsClient(c)
+	if client.Type == "" {
+		client.Type = ClientTypeConfidential
+	}
+	if client.Status == "" {
+		client.Status = ClientStatusActive
+	}
+	return []interface{}{
+		client.Id,
+		client.Secret,
+		client.RedirectUri,
+		client.Type,
+		util.StringToSql(client.Name),
+		util.StringToSql(strings.Join(client.GrantTypes, " ")),
+		util.StringToSql(strings.Join(client.Scopes, " ")),
+		util.StringToSql(strings.Join(client.ResponseModes, " ")),
+		util.StringToSql(client.TokenEndpointAuthMethod),
+		client.AccessTokenLifetime,
+		client.RefreshTokenLifetime,
+		client.RequirePkce,
+		client.RequirePar,
+		client.RequireSignedRequest,
+		client.Status,
+		util.StringToSql(client.OwnerId),
+		util.StringToSql(client.LogoUri),
+		util.StringToSql(client.PolicyUri),
+		util.StringToSql(client.TosUri),
+		util.StringToSql(client.JwksUri),
+		util.StringToSql(client.Jwks),
+		toString(client.UserData),
+	}
+}
+
+// GetClient loads the client by id
+func (s *SQLClientStorage) GetClient(id string) (osin.Client, error) {
+	var row clientRow
+	query := fmt.Sprintf("SELECT %s FROM %sclient WHERE id = ?", clientColumns, s.tablePrefix)
+	err := s.db.QueryRowPartial(&row, s.dialect.Rebind(query), id)
+
+	if err == sql.ErrNoRows {
+		return nil, osin.ErrNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("获取客户端失败: %v", err)
+	}
+	return row.client(), nil
+}
+
+// UpdateClient updates the client (identified by it's id) and replaces the values with the values of client.
+// Returns osin.ErrNotFound if there is no client with the id.
+func (s *SQLClientStorage) UpdateClient(c osin.Client) error {
+	query := fmt.Sprintf(`UPDATE %sclient SET secret=?, redirect_uri=?, client_type=?, client_name=?, grant_types=?, scopes=?,
+		response_modes=?, auth_method=?, access_token_lifetime=?, refresh_token_lifetime=?, require_pkce=?, require_par=?, require_signed_request=?,
+		client_status=?, owner_id=?, logo_uri=?, policy_uri=?, tos_uri=?, jwks_uri=?, jwks=?, extra=? WHERE id=?`, s.tablePrefix)
+	args := clientArgs(c)
+	result, err := s.db.Exec(s.dialect.Rebind(query), append(args[1:], args[0])...)
+	if err != nil {
+		return fmt.Errorf("更新客户端失败: %v", err)
+	}
+	if affected, err := result.RowsAffected(); err != nil || affected > 0 {
+		return nil
+	}
+
+	// MySQL对取值未变的行也返回0，需要在主库确认客户端是否存在
+	var exists int
+	err = s.db.QueryRow(&exists, s.dialect.Rebind(fmt.Sprintf("SELECT 1 FROM %sclient WHERE id = ?", s.tablePrefix)), args[0])
+	if err == sql.ErrNoRows {
+		return osin.ErrNotFound
+	} else if err != nil {
+		return fmt.Errorf("更新客户端失败: %v", err)
+	}
+	return nil
+}
+
+// CreateClient stores the client in the database and returns an error, if something went wrong.
+func (s *SQLClientStorage) CreateClient(c osin.Client) error {
+	query := fmt.Sprintf("INSERT INTO %sclient (%s) VALUES (?%s)",
+		s.tablePrefix, clientColumns, strings.Repeat(", ?", strings.Count(clientColumns, ",")))
+	if _, err := s.db.Exec(s.dialect.Rebind(query), clientArgs(c)...); err != nil {
+		return err
+	}
+	return nil
+}
+
+// RemoveClient removes a client (identified by id) together with its grants, codes and tokens.
+// Returns an error if something went wrong.
+func (s *SQLClientStorage) RemoveClient(id string) error {
+	// 显式按依赖顺序删除，不依赖数据库的级联删除
+	return s.db.Transact(func(session sqlx.Session) error {
+		for _, table := range []string{"refresh_token", "access_token", "code"} {
+			query := fmt.Sprintf(`DELETE FROM %[1]s%[2]s WHERE grant_id IN (
+				SELECT id FROM %[1]saccess_grant WHERE client_id = ?
+			)`, s.tablePrefix, table)
+			if _, err := session.Exec(s.dialect.Rebind(query), id); err != nil {
+				return err
+			}
+		}
+		for _, table := range []string{"access_grant", "pushed_request"} {
+			query := s.dialect.Rebind(fmt.Sprintf("DELETE FROM %s%s WHERE client_id = ?", s.tablePrefix, table))
+			if _, err := session.Exec(query, id); err != nil {
+				return err
+			}
+		}
+		_, err := session.Exec(s.dialect.Rebind(fmt.Sprintf("DELETE FROM %sclient WHERE id = ?", s.tablePrefix)), id)
+		return err
+	})
+}
@@ -0,0 +1,31 @@
+package service
+
+import (
+	"strings"
+
+	// driver for sqlite db
+	_ "modernc.org/sqlite"
+)
+
+// SQLiteDataSource adds the connection parameters Storage relies on to a SQLite data source:
+// foreign keys, a busy timeout, immediate write transactions and a sortable time format.
+// With wal set the database is switched to write-ahead logging, so readers never block the writer.
+func SQLiteDataSource(dataSource string, wal bool) string {
+	params := []string{
+		"_pragma=foreign_keys(1)",
+		"_pragma=busy_timeout(5000)",
+		// 写事务在开始时即获取写锁，避免读事务升级为写事务时出现 SQLITE_BUSY
+		"_txlock=immediate",
+		// 时间按统一格式写入，文本比较即可判断先后
+		"_time_format=sqlite",
+	}
+	if wal {
+		params = append(params, "_pragma=journal_mode(WAL)")
+	}
+
+	separator := "?"
+	if strings.Contains(dataSource, "?") {
+		separator = "&"
+	}
+	return dataSource + separator + strings.Join(params, "&")
+}
@@ -0,0 +1,35 @@
+package service_test
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"oauth2/application/service"
+	"oauth2/application/service/storagetest"
+
+	"github.com/zeromicro/go-zero/core/stores/sqlx"
+)
+
+func TestSQLiteStorage(t *testing.T) {
+	dataSource := service.SQLiteDataSource("file:"+filepath.Join(t.TempDir(), "oauth2.db"), true)
+	conn := sqlx.NewSqlConn(service.DriverSQLite, dataSource)
+
+	// TestMySQLStorage关闭了外键检查，外键约束和删除顺序只在这里覆盖
+	var foreignKeys int
+	if err := conn.QueryRow(&foreignKeys, "PRAGMA foreign_keys"); err != nil || foreignKeys != 1 {
+		t.Fatalf("foreign_keys = %d, %v, want enabled", foreignKeys, err)
+	}
+
+	var n int32
+	storagetest.Run(t, func(t *testing.T, opts service.Options) service.OAuthStorage {
+		opts.Driver = service.DriverSQLite
+		opts.TablePrefix = fmt.Sprintf("t%d_", atomic.AddInt32(&n, 1))
+		storage := service.NewStorage(conn, opts)
+		if err := storage.CreateSchemas(); err != nil {
+			t.Fatalf("CreateSchemas: %v", err)
+		}
+		return storage
+	})
+}
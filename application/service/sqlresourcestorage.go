@@ -0,0 +1,74 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"oauth2/infrastructure/migration"
+
+	"github.com/zeromicro/go-zero/core/stores/sqlx"
+)
+
+// SQLResourceStorage keeps the resource registry in the resource table of Storage.
+type SQLResourceStorage struct {
+	db          sqlx.SqlConn
+	dialect     migration.Dialect
+	tablePrefix string
+}
+
+// NewSQLResourceStorage returns a resource storage for the database of opts.Driver.
+func NewSQLResourceStorage(db sqlx.SqlConn, opts Options) *SQLResourceStorage {
+	_, dialect := sqlDialect(opts.Driver)
+	return &SQLResourceStorage{db: db, dialect: dialect, tablePrefix: opts.TablePrefix}
+}
+
+// List returns all registered resources ordered by URI.
+func (s *SQLResourceStorage) List(ctx context.Context) ([]Resource, error) {
+	var rows []struct {
+		Uri         string `db:"uri"`
+		Description string `db:"description"`
+		Scopes      string `db:"scopes"`
+	}
+	query := fmt.Sprintf("SELECT uri, description, scopes FROM %sresource ORDER BY uri", s.tablePrefix)
+	if err := s.db.QueryRowsPartialCtx(ctx, &rows, query); err != nil {
+		return nil, fmt.Errorf("查询资源失败: %v", err)
+	}
+
+	resources := make([]Resource, 0, len(rows))
+	for _, row := range rows {
+		resource := Resource{Uri: row.Uri, Description: row.Description}
+		if row.Scopes != "" {
+			resource.Scopes = strings.Fields(row.Scopes)
+		}
+		resources = append(resources, resource)
+	}
+	return resources, nil
+}
+
+// Save registers resource, replacing the resource of the same URI.
+func (s *SQLResourceStorage) Save(ctx context.Context, resource Resource) error {
+	// 先删后插，避免各数据库不同的 upsert 语法
+	err := s.db.TransactCtx(ctx, func(ctx context.Context, session sqlx.Session) error {
+		query := s.dialect.Rebind(fmt.Sprintf("DELETE FROM %sresource WHERE uri = ?", s.tablePrefix))
+		if _, err := session.ExecCtx(ctx, query, resource.Uri); err != nil {
+			return err
+		}
+		query = s.dialect.Rebind(fmt.Sprintf("INSERT INTO %sresource (uri, description, scopes) VALUES (?, ?, ?)", s.tablePrefix))
+		_, err := session.ExecCtx(ctx, query, resource.Uri, resource.Description, strings.Join(resource.Scopes, " "))
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("保存资源失败: %v", err)
+	}
+	return nil
+}
+
+// Remove removes the resource.
+func (s *SQLResourceStorage) Remove(ctx context.Context, uri string) error {
+	query := s.dialect.Rebind(fmt.Sprintf("DELETE FROM %sresource WHERE uri = ?", s.tablePrefix))
+	if _, err := s.db.ExecCtx(ctx, query, uri); err != nil {
+		return fmt.Errorf("删除资源失败: %v", err)
+	}
+	return nil
+}
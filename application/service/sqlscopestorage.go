@@ -0,0 +1,76 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"oauth2/infrastructure/migration"
+
+	"github.com/zeromicro/go-zero/core/stores/sqlx"
+)
+
+// SQLScopeStorage keeps the scope registry in the scope table of Storage.
+type SQLScopeStorage struct {
+	db          sqlx.SqlConn
+	dialect     migration.Dialect
+	tablePrefix string
+}
+
+// NewSQLScopeStorage returns a scope storage for the database of opts.Driver.
+func NewSQLScopeStorage(db sqlx.SqlConn, opts Options) *SQLScopeStorage {
+	_, dialect := sqlDialect(opts.Driver)
+	return &SQLScopeStorage{db: db, dialect: dialect, tablePrefix: opts.TablePrefix}
+}
+
+// List returns all registered scopes ordered by name.
+func (s *SQLScopeStorage) List(ctx context.Context) ([]Scope, error) {
+	var rows []struct {
+		Name            string `db:"name"`
+		Description     string `db:"description"`
+		ConsentRequired bool   `db:"consent_required"`
+		Default         bool   `db:"is_default"`
+	}
+	query := fmt.Sprintf("SELECT name, description, consent_required, is_default FROM %sscope ORDER BY name", s.tablePrefix)
+	if err := s.db.QueryRowsPartialCtx(ctx, &rows, query); err != nil {
+		return nil, fmt.Errorf("查询权限范围失败: %v", err)
+	}
+
+	scopes := make([]Scope, 0, len(rows))
+	for _, row := range rows {
+		scopes = append(scopes, Scope{
+			Name:            row.Name,
+			Description:     row.Description,
+			ConsentRequired: row.ConsentRequired,
+			Default:         row.Default,
+		})
+	}
+	return scopes, nil
+}
+
+// Save registers scope, replacing the scope of the same name.
+func (s *SQLScopeStorage) Save(ctx context.Context, scope Scope) error {
+	// 先删后插，避免各数据库不同的 upsert 语法
+	err := s.db.TransactCtx(ctx, func(ctx context.Context, session sqlx.Session) error {
+		query := s.dialect.Rebind(fmt.Sprintf("DELETE FROM %sscope WHERE name = ?", s.tablePrefix))
+		if _, err := session.ExecCtx(ctx, query, scope.Name); err != nil {
+			return err
+		}
+		query = s.dialect.Rebind(fmt.Sprintf(`INSERT INTO %sscope (name, description, consent_required, is_default)
+			VALUES (?, ?, ?, ?)`, s.tablePrefix))
+		_, err := session.ExecCtx(ctx, query, scope.Name, scope.Description, scope.ConsentRequired, scope.Default)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("保存权限范围失败: %v", err)
+	}
+	return nil
+}
+
+// Remove removes the scope.
+func (s *SQLScopeStorage) Remove(ctx context.Context, name string) error {
+	query := s.dialect.Rebind(fmt.Sprintf("DELETE FROM %sscope WHERE name = ?", s.tablePrefix))
+	if _, err := s.db.ExecCtx(ctx, query, name); err != nil {
+		return fmt.Errorf("删除权限范围失败: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,340 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/openshift/osin"
+	"github.com/zeromicro/go-zero/core/stores/sqlx"
+)
+
+// ClientStorage manages OAuth clients.
+type ClientStorage interface {
+	GetClient(id string) (osin.Client, error)
+	CreateClient(c osin.Client) error
+	UpdateClient(c osin.Client) error
+	RemoveClient(id string) error
+}
+
+// UserTokenStorage indexes grants by the user they were issued for, identified by userIDOf(UserData).
+type UserTokenStorage interface {
+	// ListUserTokens returns the unexpired access and refresh tokens of a user, grouped by client.
+	ListUserTokens(ctx context.Context, userID string) ([]ClientTokens, error)
+	// RevokeUserTokens deletes the grants of a user together with their codes and tokens,
+	// limited to one client unless clientID is empty.
+	RevokeUserTokens(ctx context.Context, userID, clientID string) (RevokeResult, error)
+	// RevokeUserGrant deletes one grant of a user together with its codes and tokens.
+	// Grants of other users are left alone, so a user can only revoke their own.
+	RevokeUserGrant(ctx context.Context, userID, grantID string) (RevokeResult, error)
+}
+
+// AccessUsageStorage records when and from where the grant of an access token was last used.
+type AccessUsageStorage interface {
+	// TouchAccess marks the grant of token as used at usedAt from ip. An empty ip keeps the previous one.
+	TouchAccess(ctx context.Context, token, ip string, usedAt time.Time) error
+}
+
+// RefreshStorage retires refresh tokens together with the access token issued alongside.
+type RefreshStorage interface {
+	// RevokeRefresh deletes a refresh token and the access token issued with it, so that refreshing
+	// leaves no usable token of the previous pair. RemoveRefresh does the same.
+	// Of concurrent calls for the same token only one reports RefreshRevoked.
+	RevokeRefresh(ctx context.Context, token string) (RevokeResult, error)
+}
+
+// OAuthStorage is implemented by every storage backend: the osin.Storage contract plus client management,
+// refresh token pairs, the per-user token index, grant usage tracking and pushed authorization requests.
+type OAuthStorage interface {
+	osin.Storage
+	ClientStorage
+	RefreshStorage
+	UserTokenStorage
+	AccessUsageStorage
+	PushedRequestStorage
+}
+
+// Options configures how a storage backend persists codes and tokens.
+type Options struct {
+	Driver            string // SQL存储的数据库驱动，mysql(默认)、postgres 或 sqlite
+	TablePrefix       string
+	Hasher            *TokenHasher
+	RefreshExpiration int32          // 刷新令牌有效期(秒)，0 表示永不过期
+	Clients           ClientStorage  // SQL存储加载客户端使用的存储，为空时直接查询数据库
+	Replicas          []sqlx.SqlConn // SQL存储读取访问令牌和客户端使用的只读副本，为空时只使用主库
+}
+
+// Unwrap returns the innermost storage below decorators such as CachedAccessStorage.
+func Unwrap(storage OAuthStorage) OAuthStorage {
+	for {
+		wrapper, ok := storage.(interface{ Unwrap() OAuthStorage })
+		if !ok {
+			return storage
+		}
+		storage = wrapper.Unwrap()
+	}
+}
+
+// PurgeResult reports how many rows a purge run deleted.
+type PurgeResult struct {
+	Codes          int64
+	AccessTokens   int64
+	RefreshTokens  int64
+	Grants         int64
+	PushedRequests int64
+}
+
+// Total returns the number of deleted rows.
+func (r PurgeResult) Total() int64 {
+	return r.Codes + r.AccessTokens + r.RefreshTokens + r.Grants + r.PushedRequests
+}
+
+// Token types reported by UserToken.
+const (
+	TokenTypeAccess  = "access"
+	TokenTypeRefresh = "refresh"
+)
+
+// UserToken describes an issued token without the token itself.
+type UserToken struct {
+	GrantId   string     `json:"grant_id"`
+	Type      string     `json:"type"` // access 或 refresh
+	Scope     string     `json:"scope"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // 为空表示永不过期
+
+	// 所属授权最近一次使用的时间和IP，尚未使用时为空
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	LastUsedIp string     `json:"last_used_ip,omitempty"`
+}
+
+// ClientTokens groups the tokens a user granted to one client.
+type ClientTokens struct {
+	ClientId string      `json:"client_id"`
+	Tokens   []UserToken `json:"tokens"`
+}
+
+// RevokeResult reports what a revocation deleted.
+type RevokeResult struct {
+	Grants         int64
+	AccessTokens   []string // 被删除的访问令牌摘要，用于清除访问令牌缓存
+	RefreshRevoked bool     // RevokeRefresh删除了刷新令牌；同一令牌被并发撤销时只有一个调用方得到true
+}
+
+// grantUsage is when and from where a grant was last used.
+type grantUsage struct {
+	UsedAt time.Time `json:"used_at"`
+	Ip     string    `json:"ip,omitempty"`
+}
+
+// touch records a use at usedAt from ip, keeping the previous ip if ip is empty.
+func (u grantUsage) touch(ip string, usedAt time.Time) grantUsage {
+	if ip == "" {
+		ip = u.Ip
+	}
+	return grantUsage{UsedAt: usedAt, Ip: ip}
+}
+
+// apply copies the usage to token.
+func (u *grantUsage) apply(token *UserToken) {
+	if u == nil {
+		return
+	}
+	usedAt := u.UsedAt
+	token.LastUsedAt = &usedAt
+	token.LastUsedIp = u.Ip
+}
+
+// groupByClient groups tokens by client, ordering clients by id and tokens by creation time.
+func groupByClient(tokens map[string][]UserToken) []ClientTokens {
+	result := make([]ClientTokens, 0, len(tokens))
+	for clientID, list := range tokens {
+		sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+		result = append(result, ClientTokens{ClientId: clientID, Tokens: list})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ClientId < result[j].ClientId })
+	return result
+}
+
+// GrantData is the UserData of a code or token issued for a user, audience-restricted to resources or
+// carrying authorization details. Grants with neither resources nor details keep the bare user id as
+// UserData, so existing codes and tokens still parse.
+type GrantData struct {
+	UserId    string   `json:"user_id,omitempty"`
+	Resources []string `json:"resource,omitempty"` // 授权的资源，RFC 8707 resource 参数
+	Audience  []string `json:"aud,omitempty"`      // 令牌请求从授权的资源中选择的受众，为空时为全部资源
+
+	AuthorizationDetails []AuthorizationDetail `json:"authorization_details,omitempty"` // 授权的RFC 9396授权详情
+	TokenDetails         []AuthorizationDetail `json:"token_details,omitempty"`         // 令牌请求选择的授权详情，为空时为全部
+}
+
+// UserOnly reports whether the grant carries nothing but the user, so that the bare user id suffices as UserData.
+func (g GrantData) UserOnly() bool {
+	return len(g.Resources) == 0 && len(g.AuthorizationDetails) == 0
+}
+
+// TokenAuthorizationDetails returns the authorization details a token of the grant carries.
+func (g GrantData) TokenAuthorizationDetails() []AuthorizationDetail {
+	if len(g.TokenDetails) > 0 {
+		return g.TokenDetails
+	}
+	return g.AuthorizationDetails
+}
+
+// TokenAudience returns the resources a token of the grant is audience-restricted to.
+// An empty audience means the token is not restricted.
+func (g GrantData) TokenAudience() []string {
+	if len(g.Audience) > 0 {
+		return g.Audience
+	}
+	return g.Resources
+}
+
+// GrantDataOf parses the UserData of a code or token. Loaded codes and tokens carry UserData as the
+// stored string: GrantData encoded as JSON, or the bare user id. Maps are read by their "user_id" entry.
+// Client credentials grants have no user and yield an empty user id.
+func GrantDataOf(userData interface{}) GrantData {
+	switch v := userData.(type) {
+	case GrantData:
+		return v
+	case *GrantData:
+		if v != nil {
+			return *v
+		}
+	case string:
+		var data GrantData
+		if strings.HasPrefix(v, "{") && json.Unmarshal([]byte(v), &data) == nil {
+			return data
+		}
+		return GrantData{UserId: v}
+	case map[string]string:
+		return GrantData{UserId: v["user_id"]}
+	case map[string]interface{}:
+		return GrantData{UserId: toString(v["user_id"])}
+	}
+	return GrantData{}
+}
+
+// userIDOf returns the id of the user a code or token is issued for, as parsed by GrantDataOf.
+func userIDOf(userData interface{}) string {
+	return GrantDataOf(userData).UserId
+}
+
+// tokenRecord is what the key-value backends keep for a code, an access token or a refresh token.
+type tokenRecord struct {
+	GrantId     string    `json:"grant_id"`
+	UserId      string    `json:"user_id,omitempty"`
+	ClientId    string    `json:"client_id"`
+	ExpiresIn   int32     `json:"expires_in"`
+	Scope       string    `json:"scope"`
+	RedirectUri string    `json:"redirect_uri"`
+	State       string    `json:"state,omitempty"`
+	Extra       string    `json:"extra,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+
+	// AccessHash is the digest of the access token issued with a refresh token.
+	AccessHash string `json:"access_hash,omitempty"`
+	// CodeChallenge and CodeChallengeMethod are only set on authorization codes.
+	CodeChallenge       string `json:"code_challenge,omitempty"`
+	CodeChallengeMethod string `json:"code_challenge_method,omitempty"`
+}
+
+// authorizeData converts the record to AuthorizeData without client information.
+func (r *tokenRecord) authorizeData() *osin.AuthorizeData {
+	data := &osin.AuthorizeData{
+		ExpiresIn:   r.ExpiresIn,
+		Scope:       r.Scope,
+		RedirectUri: r.RedirectUri,
+		State:       r.State,
+		CreatedAt:   r.CreatedAt,
+
+		CodeChallenge:       r.CodeChallenge,
+		CodeChallengeMethod: r.CodeChallengeMethod,
+	}
+	if r.Extra != "" {
+		data.UserData = r.Extra
+	}
+	return data
+}
+
+// accessData converts the record to AccessData without client information.
+func (r *tokenRecord) accessData() *osin.AccessData {
+	data := &osin.AccessData{
+		ExpiresIn:   r.ExpiresIn,
+		Scope:       r.Scope,
+		RedirectUri: r.RedirectUri,
+		CreatedAt:   r.CreatedAt,
+	}
+	if r.Extra != "" {
+		data.UserData = r.Extra
+	}
+	return data
+}
+
+// userToken describes the record as a token of the given type.
+func (r *tokenRecord) userToken(tokenType string) UserToken {
+	token := UserToken{
+		GrantId:   r.GrantId,
+		Type:      tokenType,
+		Scope:     r.Scope,
+		CreatedAt: r.CreatedAt,
+	}
+	if r.ExpiresIn > 0 {
+		expiresAt := r.CreatedAt.Add(time.Duration(r.ExpiresIn) * time.Second)
+		token.ExpiresAt = &expiresAt
+	}
+	return token
+}
+
+// expired reports whether the code or access token of the record is expired at t. Like osin's IsExpiredAt
+// and the expires_at column of the SQL storage, a lifetime of 0 expires the token as soon as it is issued.
+func (r *tokenRecord) expired(t time.Time) bool {
+	return r.CreatedAt.Add(time.Duration(r.ExpiresIn) * time.Second).Before(t)
+}
+
+// refreshExpired reports whether the refresh token of the record is expired at t.
+// A refresh token with ExpiresIn 0 never expires.
+func (r *tokenRecord) refreshExpired(t time.Time) bool {
+	return r.ExpiresIn > 0 && r.expired(t)
+}
+
+// tokenExpired reports whether the access or refresh token of record, by tokenType, is expired at t.
+func tokenExpired(tokenType string, record *tokenRecord, t time.Time) bool {
+	if tokenType == TokenTypeRefresh {
+		return record.refreshExpired(t)
+	}
+	return record.expired(t)
+}
+
+// newGrantID returns a random identifier for a grant.
+func newGrantID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("生成授权ID失败: %v", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Convert any type to string.
+func toString(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+	switch v := value.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	default:
+		// 结构化的数据以JSON保存，读取后可以解析
+		if data, err := json.Marshal(v); err == nil {
+			return string(data)
+		}
+		return fmt.Sprintf("%v", v)
+	}
+}
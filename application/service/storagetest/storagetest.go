@@ -0,0 +1,1073 @@
+// Package storagetest provides a conformance suite that every service.OAuthStorage backend must pass.
+package storagetest
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"oauth2/application/service"
+
+	"github.com/openshift/osin"
+)
+
+// Factory returns a new, empty storage configured with opts.
+// It is called once per subtest, so backends can isolate subtests from each other.
+type Factory func(t *testing.T, opts service.Options) service.OAuthStorage
+
+// Run checks the full storage contract against the backend returned by newStorage.
+func Run(t *testing.T, newStorage Factory) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, newStorage Factory)
+	}{
+		{"ClientCRUD", testClientCRUD},
+		{"ClientMetadata", testClientMetadata},
+		{"Authorize", testAuthorize},
+		{"AuthorizeExpired", testAuthorizeExpired},
+		{"PKCE", testPKCE},
+		{"AccessAndRefresh", testAccessAndRefresh},
+		{"AccessExpired", testAccessExpired},
+		{"RefreshAfterAccessExpired", testRefreshAfterAccessExpired},
+		{"RefreshExpired", testRefreshExpired},
+		{"ZeroLifetime", testZeroLifetime},
+		{"RevokeRefresh", testRevokeRefresh},
+		{"RemoveClient", testRemoveClient},
+		{"UserTokens", testUserTokens},
+		{"UserGrantUsage", testUserGrantUsage},
+		{"GrantData", testGrantData},
+		{"PushedRequest", testPushedRequest},
+		{"PurgeExpired", testPurgeExpired},
+		{"PurgeBatches", testPurgeBatches},
+		{"Concurrent", testConcurrent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, newStorage)
+		})
+	}
+}
+
+func defaultOptions() service.Options {
+	return service.Options{
+		TablePrefix: "osin_",
+		Hasher:      service.NewTokenHasher("storagetest"),
+	}
+}
+
+// newClient creates and stores a client with the given id.
+func newClient(t *testing.T, s service.OAuthStorage, id string) osin.Client {
+	t.Helper()
+	client := &osin.DefaultClient{
+		Id:          id,
+		Secret:      "secret-" + id,
+		RedirectUri: "http://localhost/" + id,
+	}
+	if err := s.CreateClient(client); err != nil {
+		t.Fatalf("CreateClient(%s): %v", id, err)
+	}
+	return client
+}
+
+func newAuthorize(client osin.Client, code string, createdAt time.Time) *osin.AuthorizeData {
+	return &osin.AuthorizeData{
+		Client:      client,
+		Code:        code,
+		ExpiresIn:   600,
+		Scope:       "read write",
+		RedirectUri: client.GetRedirectUri(),
+		State:       "state-" + code,
+		CreatedAt:   createdAt,
+		UserData:    "user-" + code,
+	}
+}
+
+func newAccess(client osin.Client, access, refresh string, createdAt time.Time) *osin.AccessData {
+	return &osin.AccessData{
+		Client:       client,
+		AccessToken:  access,
+		RefreshToken: refresh,
+		ExpiresIn:    3600,
+		Scope:        "read",
+		RedirectUri:  client.GetRedirectUri(),
+		CreatedAt:    createdAt,
+		UserData:     "user-" + access,
+	}
+}
+
+func requireNotFound(t *testing.T, what string, err error) {
+	t.Helper()
+	if !errors.Is(err, osin.ErrNotFound) {
+		t.Fatalf("%s: expected osin.ErrNotFound, got %v", what, err)
+	}
+}
+
+func checkClient(t *testing.T, got, want osin.Client) {
+	t.Helper()
+	if got == nil {
+		t.Fatalf("client %s was not loaded", want.GetId())
+	}
+	if got.GetId() != want.GetId() || got.GetSecret() != want.GetSecret() || got.GetRedirectUri() != want.GetRedirectUri() {
+		t.Fatalf("client mismatch: got %s/%s/%s, want %s/%s/%s",
+			got.GetId(), got.GetSecret(), got.GetRedirectUri(),
+			want.GetId(), want.GetSecret(), want.GetRedirectUri())
+	}
+}
+
+func checkAccess(t *testing.T, got, want *osin.AccessData) {
+	t.Helper()
+	checkClient(t, got.Client, want.Client)
+	if got.Scope != want.Scope || got.RedirectUri != want.RedirectUri {
+		t.Fatalf("access mismatch: got scope %q redirect %q, want scope %q redirect %q",
+			got.Scope, got.RedirectUri, want.Scope, want.RedirectUri)
+	}
+	if fmt.Sprint(got.UserData) != fmt.Sprint(want.UserData) {
+		t.Fatalf("access user data: got %v, want %v", got.UserData, want.UserData)
+	}
+	if got.CreatedAt.Sub(want.CreatedAt).Abs() > time.Second {
+		t.Fatalf("access created at: got %v, want %v", got.CreatedAt, want.CreatedAt)
+	}
+}
+
+func testClientCRUD(t *testing.T, newStorage Factory) {
+	s := newStorage(t, defaultOptions())
+
+	_, err := s.GetClient("missing")
+	requireNotFound(t, "GetClient(missing)", err)
+
+	client := newClient(t, s, "crud")
+	got, err := s.GetClient("crud")
+	if err != nil {
+		t.Fatalf("GetClient: %v", err)
+	}
+	checkClient(t, got, client)
+
+	if err := s.CreateClient(client); err == nil {
+		t.Fatal("CreateClient with a duplicate id should fail")
+	}
+
+	updated := &osin.DefaultClient{Id: "crud", Secret: "rotated", RedirectUri: "http://localhost/updated"}
+	if err := s.UpdateClient(updated); err != nil {
+		t.Fatalf("UpdateClient: %v", err)
+	}
+	// 取值未变的更新同样成功，更新不存在的客户端返回ErrNotFound且不会创建它
+	if err := s.UpdateClient(updated); err != nil {
+		t.Fatalf("UpdateClient with unchanged values: %v", err)
+	}
+	err = s.UpdateClient(&osin.DefaultClient{Id: "missing", Secret: "secret", RedirectUri: "http://localhost/missing"})
+	requireNotFound(t, "UpdateClient(missing)", err)
+	_, err = s.GetClient("missing")
+	requireNotFound(t, "GetClient(missing) after update", err)
+
+	got, err = s.GetClient("crud")
+	if err != nil {
+		t.Fatalf("GetClient after update: %v", err)
+	}
+	checkClient(t, got, updated)
+
+	if err := s.RemoveClient("crud"); err != nil {
+		t.Fatalf("RemoveClient: %v", err)
+	}
+	_, err = s.GetClient("crud")
+	requireNotFound(t, "GetClient after remove", err)
+}
+
+func testClientMetadata(t *testing.T, newStorage Factory) {
+	s := newStorage(t, defaultOptions())
+
+	// 其他实现的客户端按机密、启用状态保存
+	if err := s.CreateClient(&osin.DefaultClient{Id: "plain", Secret: "secret", RedirectUri: "http://localhost"}); err != nil {
+		t.Fatalf("CreateClient: %v", err)
+	}
+	got, err := s.GetClient("plain")
+	if err != nil {
+		t.Fatalf("GetClient: %v", err)
+	}
+	if c := service.AsClient(got); c.Type != service.ClientTypeConfidential || !c.IsActive() {
+		t.Fatalf("default client metadata: %+v", c)
+	}
+
+	client := &service.Client{
+		Id:                      "typed",
+		Secret:                  "secret",
+		RedirectUri:             "https://app.example.com/cb https://app.example.com/other",
+		Type:                    service.ClientTypePublic,
+		Name:                    "Typed",
+		GrantTypes:              []string{"authorization_code", "refresh_token"},
+		Scopes:                  []string{"read", "write"},
+		ResponseModes:           []string{"query", "form_post.jwt"},
+		TokenEndpointAuthMethod: "none",
+		AccessTokenLifetime:     600,
+		RefreshTokenLifetime:    86400,
+		RequirePkce:             true,
+		RequirePar:              true,
+		RequireSignedRequest:    true,
+		Status:                  service.ClientStatusActive,
+		OwnerId:                 "alice",
+		LogoUri:                 "https://app.example.com/logo.png",
+		PolicyUri:               "https://app.example.com/policy",
+		TosUri:                  "https://app.example.com/tos",
+		Jwks:                    `{"keys":[]}`,
+		UserData:                `{"software_id":"app"}`,
+	}
+	if err := s.CreateClient(client); err != nil {
+		t.Fatalf("CreateClient: %v", err)
+	}
+	got, err = s.GetClient("typed")
+	if err != nil {
+		t.Fatalf("GetClient: %v", err)
+	}
+	if !reflect.DeepEqual(service.AsClient(got), client) {
+		t.Fatalf("client metadata mismatch:\n got %+v\nwant %+v", got, client)
+	}
+
+	client.Status, client.Scopes, client.RequirePkce, client.RequirePar = service.ClientStatusSuspended, nil, false, false
+	client.RequireSignedRequest, client.Jwks, client.JwksUri = false, "", "https://app.example.com/jwks"
+	if err := s.UpdateClient(client); err != nil {
+		t.Fatalf("UpdateClient: %v", err)
+	}
+	got, err = s.GetClient("typed")
+	if err != nil {
+		t.Fatalf("GetClient after update: %v", err)
+	}
+	if c := service.AsClient(got); c.IsActive() || len(c.Scopes) != 0 || c.RequirePkce || c.RequirePar ||
+		c.RequireSignedRequest || c.Jwks != "" || c.JwksUri != client.JwksUri {
+		t.Fatalf("client metadata was not updated: %+v", c)
+	}
+}
+
+func testAuthorize(t *testing.T, newStorage Factory) {
+	s := newStorage(t, defaultOptions())
+	client := newClient(t, s, "authorize")
+
+	_, err := s.LoadAuthorize("missing")
+	requireNotFound(t, "LoadAuthorize(missing)", err)
+
+	data := newAuthorize(client, "code-1", time.Now())
+	if err := s.SaveAuthorize(data); err != nil {
+		t.Fatalf("SaveAuthorize: %v", err)
+	}
+
+	got, err := s.LoadAuthorize("code-1")
+	if err != nil {
+		t.Fatalf("LoadAuthorize: %v", err)
+	}
+	checkClient(t, got.Client, client)
+	if got.Code != data.Code || got.Scope != data.Scope || got.RedirectUri != data.RedirectUri ||
+		got.State != data.State || got.ExpiresIn != data.ExpiresIn {
+		t.Fatalf("authorize mismatch: got %+v, want %+v", got, data)
+	}
+	if fmt.Sprint(got.UserData) != fmt.Sprint(data.UserData) {
+		t.Fatalf("authorize user data: got %v, want %v", got.UserData, data.UserData)
+	}
+
+	if err := s.RemoveAuthorize("code-1"); err != nil {
+		t.Fatalf("RemoveAuthorize: %v", err)
+	}
+	_, err = s.LoadAuthorize("code-1")
+	requireNotFound(t, "LoadAuthorize after remove", err)
+
+	if err := s.RemoveAuthorize("code-1"); err != nil {
+		t.Fatalf("RemoveAuthorize of a removed code should succeed: %v", err)
+	}
+}
+
+func testAuthorizeExpired(t *testing.T, newStorage Factory) {
+	s := newStorage(t, defaultOptions())
+	client := newClient(t, s, "authorize-expired")
+
+	data := newAuthorize(client, "expired-code", time.Now().Add(-time.Hour))
+	if err := s.SaveAuthorize(data); err != nil {
+		t.Fatalf("SaveAuthorize: %v", err)
+	}
+	if got, err := s.LoadAuthorize("expired-code"); err == nil {
+		t.Fatalf("LoadAuthorize of an expired code should fail, got %+v", got)
+	}
+}
+
+func testPKCE(t *testing.T, newStorage Factory) {
+	s := newStorage(t, defaultOptions())
+	client := newClient(t, s, "pkce")
+
+	verifier := "pkce-verifier-0123456789-abcdefghijklmnopqrstuvwxyz"
+	sum := sha256.Sum256([]byte(verifier))
+	data := newAuthorize(client, "pkce-code", time.Now())
+	data.CodeChallenge = base64.RawURLEncoding.EncodeToString(sum[:])
+	data.CodeChallengeMethod = osin.PKCE_S256
+	if err := s.SaveAuthorize(data); err != nil {
+		t.Fatalf("SaveAuthorize: %v", err)
+	}
+	got, err := s.LoadAuthorize("pkce-code")
+	if err != nil {
+		t.Fatalf("LoadAuthorize: %v", err)
+	}
+	if got.CodeChallenge != data.CodeChallenge || got.CodeChallengeMethod != data.CodeChallengeMethod {
+		t.Fatalf("code challenge: got %q/%q, want %q/%q",
+			got.CodeChallenge, got.CodeChallengeMethod, data.CodeChallenge, data.CodeChallengeMethod)
+	}
+
+	// 通过osin换取令牌：缺少或错误的 code_verifier 都必须失败，授权码仍可用正确的值换取
+	config := osin.NewServerConfig()
+	config.AllowedAccessTypes = osin.AllowedAccessType{osin.AUTHORIZATION_CODE}
+	server := osin.NewServer(config, s)
+	for _, tt := range []struct {
+		verifier string
+		ok       bool
+	}{
+		{"", false},
+		{"wrong-verifier-0123456789-abcdefghijklmnopqrstuvwxyz", false},
+		{verifier, true},
+	} {
+		form := url.Values{
+			"grant_type":   {"authorization_code"},
+			"code":         {"pkce-code"},
+			"redirect_uri": {client.GetRedirectUri()},
+		}
+		if tt.verifier != "" {
+			form.Set("code_verifier", tt.verifier)
+		}
+		req := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth(client.GetId(), client.GetSecret())
+
+		resp := server.NewResponse()
+		if ar := server.HandleAccessRequest(resp, req); ar != nil {
+			ar.Authorized = true
+			server.FinishAccessRequest(resp, req, ar)
+		}
+		resp.Close()
+		if resp.IsError == tt.ok {
+			t.Fatalf("token exchange with verifier %q: error %v (%s), want success %v",
+				tt.verifier, resp.IsError, resp.ErrorId, tt.ok)
+		}
+	}
+}
+
+func testAccessAndRefresh(t *testing.T, newStorage Factory) {
+	s := newStorage(t, defaultOptions())
+	client := newClient(t, s, "access")
+
+	_, err := s.LoadAccess("missing")
+	requireNotFound(t, "LoadAccess(missing)", err)
+	_, err = s.LoadRefresh("missing")
+	requireNotFound(t, "LoadRefresh(missing)", err)
+
+	authorize := newAuthorize(client, "code-access", time.Now())
+	authorize.UserData = "alice"
+	if err := s.SaveAuthorize(authorize); err != nil {
+		t.Fatalf("SaveAuthorize: %v", err)
+	}
+	data := newAccess(client, "access-1", "refresh-1", time.Now())
+	data.AuthorizeData, data.UserData = authorize, "alice"
+	if err := s.SaveAccess(data); err != nil {
+		t.Fatalf("SaveAccess: %v", err)
+	}
+
+	got, err := s.LoadAccess("access-1")
+	if err != nil {
+		t.Fatalf("LoadAccess: %v", err)
+	}
+	checkAccess(t, got, data)
+	if got.AccessToken != "access-1" {
+		t.Fatalf("LoadAccess returned access token %q", got.AccessToken)
+	}
+
+	got, err = s.LoadRefresh("refresh-1")
+	if err != nil {
+		t.Fatalf("LoadRefresh: %v", err)
+	}
+	checkAccess(t, got, data)
+	if got.RefreshToken != "refresh-1" {
+		t.Fatalf("LoadRefresh returned refresh token %q", got.RefreshToken)
+	}
+	if _, err := s.LoadRefresh("access-1"); err == nil {
+		t.Fatal("an access token must not be usable as a refresh token")
+	}
+	grants := userGrants(t, s, "alice")
+
+	// 按osin的刷新流程，只使用LoadRefresh返回的数据：保存新令牌，再删除旧的刷新令牌和访问令牌
+	next := newAccess(client, "access-2", "refresh-2", time.Now())
+	next.AccessData, next.UserData = got, got.UserData
+	if err := s.SaveAccess(next); err != nil {
+		t.Fatalf("SaveAccess after refresh: %v", err)
+	}
+	if err := s.RemoveRefresh(got.RefreshToken); err != nil {
+		t.Fatalf("RemoveRefresh: %v", err)
+	}
+	if err := s.RemoveAccess(got.AccessToken); err != nil {
+		t.Fatalf("RemoveAccess: %v", err)
+	}
+
+	_, err = s.LoadRefresh("refresh-1")
+	requireNotFound(t, "LoadRefresh after refresh", err)
+	_, err = s.LoadAccess("access-1")
+	requireNotFound(t, "LoadAccess of the previous token after refresh", err)
+	if _, err := s.LoadAccess("access-2"); err != nil {
+		t.Fatalf("LoadAccess of the refreshed token: %v", err)
+	}
+	if _, err := s.LoadRefresh("refresh-2"); err != nil {
+		t.Fatalf("LoadRefresh of the refreshed token: %v", err)
+	}
+	if after := userGrants(t, s, "alice"); !reflect.DeepEqual(after, grants) {
+		t.Fatalf("refresh changed the grant: got %v, want %v", after, grants)
+	}
+}
+
+// userGrants returns the grants of the listed tokens of a user.
+func userGrants(t *testing.T, s service.OAuthStorage, userID string) map[string]struct{} {
+	t.Helper()
+	list, err := s.ListUserTokens(context.Background(), userID)
+	if err != nil {
+		t.Fatalf("ListUserTokens(%s): %v", userID, err)
+	}
+	grants := make(map[string]struct{})
+	for _, client := range list {
+		for _, token := range client.Tokens {
+			grants[token.GrantId] = struct{}{}
+		}
+	}
+	return grants
+}
+
+func testAccessExpired(t *testing.T, newStorage Factory) {
+	s := newStorage(t, defaultOptions())
+	client := newClient(t, s, "access-expired")
+
+	data := newAccess(client, "expired-access", "", time.Now().Add(-2*time.Hour))
+	if err := s.SaveAccess(data); err != nil {
+		t.Fatalf("SaveAccess: %v", err)
+	}
+	if got, err := s.LoadAccess("expired-access"); err == nil {
+		t.Fatalf("LoadAccess of an expired token should fail, got %+v", got)
+	}
+}
+
+func testRefreshAfterAccessExpired(t *testing.T, newStorage Factory) {
+	opts := defaultOptions()
+	opts.RefreshExpiration = int32((24 * time.Hour).Seconds())
+	s := newStorage(t, opts)
+	client := newClient(t, s, "refresh-outlives")
+
+	data := newAccess(client, "short-access", "long-refresh", time.Now().Add(-2*time.Hour))
+	if err := s.SaveAccess(data); err != nil {
+		t.Fatalf("SaveAccess: %v", err)
+	}
+	if _, err := s.LoadAccess("short-access"); err == nil {
+		t.Fatal("LoadAccess of an expired token should fail")
+	}
+	got, err := s.LoadRefresh("long-refresh")
+	if err != nil {
+		t.Fatalf("LoadRefresh after the access token expired: %v", err)
+	}
+	checkClient(t, got.Client, client)
+
+	// 删除访问令牌不影响刷新令牌
+	if err := s.RemoveAccess("short-access"); err != nil {
+		t.Fatalf("RemoveAccess: %v", err)
+	}
+	if _, err := s.LoadRefresh("long-refresh"); err != nil {
+		t.Fatalf("LoadRefresh after RemoveAccess: %v", err)
+	}
+}
+
+func testRefreshExpired(t *testing.T, newStorage Factory) {
+	opts := defaultOptions()
+	opts.RefreshExpiration = int32(time.Hour.Seconds())
+	s := newStorage(t, opts)
+	client := newClient(t, s, "refresh-expired")
+
+	data := newAccess(client, "old-access", "old-refresh", time.Now().Add(-2*time.Hour))
+	if err := s.SaveAccess(data); err != nil {
+		t.Fatalf("SaveAccess: %v", err)
+	}
+	if got, err := s.LoadRefresh("old-refresh"); err == nil {
+		t.Fatalf("LoadRefresh of an expired refresh token should fail, got %+v", got)
+	}
+}
+
+// testZeroLifetime checks the meaning of ExpiresIn 0: codes and access tokens expire as soon as they are
+// issued, as osin's IsExpiredAt treats them, while a refresh token with lifetime 0 never expires.
+func testZeroLifetime(t *testing.T, newStorage Factory) {
+	s := newStorage(t, defaultOptions())
+	client := newClient(t, s, "zero-lifetime")
+	ctx := context.Background()
+	createdAt := time.Now().Add(-time.Second)
+
+	code := newAuthorize(client, "zero-code", createdAt)
+	code.ExpiresIn = 0
+	if err := s.SaveAuthorize(code); err != nil {
+		t.Fatalf("SaveAuthorize: %v", err)
+	}
+	if got, err := s.LoadAuthorize("zero-code"); err == nil {
+		t.Fatalf("LoadAuthorize of a code with ExpiresIn 0 should fail, got %+v", got)
+	}
+
+	data := newAccess(client, "zero-access", "zero-refresh", createdAt.Add(-48*time.Hour))
+	data.ExpiresIn = 0
+	if err := s.SaveAccess(data); err != nil {
+		t.Fatalf("SaveAccess: %v", err)
+	}
+	if got, err := s.LoadAccess("zero-access"); err == nil {
+		t.Fatalf("LoadAccess of a token with ExpiresIn 0 should fail, got %+v", got)
+	}
+	got, err := s.LoadRefresh("zero-refresh")
+	if err != nil {
+		t.Fatalf("LoadRefresh of a refresh token with lifetime 0: %v", err)
+	}
+	if got.ExpiresIn != 0 {
+		t.Fatalf("refresh ExpiresIn: got %d, want 0", got.ExpiresIn)
+	}
+
+	list, err := s.ListUserTokens(ctx, "user-zero-access")
+	if err != nil {
+		t.Fatalf("ListUserTokens: %v", err)
+	}
+	if len(list) != 1 || len(list[0].Tokens) != 1 || list[0].Tokens[0].Type != service.TokenTypeRefresh ||
+		list[0].Tokens[0].ExpiresAt != nil {
+		t.Fatalf("ListUserTokens: got %+v, want only the refresh token without expiry", list)
+	}
+
+	p, ok := service.Unwrap(s).(purger)
+	if !ok {
+		return
+	}
+	result, err := p.PurgeExpired(ctx, 10)
+	if err != nil {
+		t.Fatalf("PurgeExpired: %v", err)
+	}
+	if result.Codes != 1 || result.AccessTokens != 1 || result.RefreshTokens != 0 {
+		t.Fatalf("PurgeExpired: %+v", result)
+	}
+	if _, err := s.LoadRefresh("zero-refresh"); err != nil {
+		t.Fatalf("LoadRefresh after purge: %v", err)
+	}
+}
+
+func testRevokeRefresh(t *testing.T, newStorage Factory) {
+	s := newStorage(t, defaultOptions())
+	client := newClient(t, s, "revoke-refresh")
+	ctx := context.Background()
+
+	for _, data := range []*osin.AccessData{
+		newAccess(client, "pair-access", "pair-refresh", time.Now()),
+		newAccess(client, "other-access", "other-refresh", time.Now()),
+	} {
+		if err := s.SaveAccess(data); err != nil {
+			t.Fatalf("SaveAccess(%s): %v", data.AccessToken, err)
+		}
+	}
+
+	// 刷新令牌与同时签发的访问令牌一起删除，其他令牌不受影响
+	result, err := s.RevokeRefresh(ctx, "pair-refresh")
+	if err != nil {
+		t.Fatalf("RevokeRefresh: %v", err)
+	}
+	if result.Grants != 0 || len(result.AccessTokens) != 1 || !result.RefreshRevoked {
+		t.Fatalf("RevokeRefresh: got %+v", result)
+	}
+	_, err = s.LoadRefresh("pair-refresh")
+	requireNotFound(t, "LoadRefresh after revoke", err)
+	_, err = s.LoadAccess("pair-access")
+	requireNotFound(t, "LoadAccess of the revoked pair", err)
+	if _, err := s.LoadAccess("other-access"); err != nil {
+		t.Fatalf("LoadAccess of another pair: %v", err)
+	}
+
+	if result, err := s.RevokeRefresh(ctx, "pair-refresh"); err != nil || len(result.AccessTokens) != 0 || result.RefreshRevoked {
+		t.Fatalf("RevokeRefresh of a revoked token: %+v, %v", result, err)
+	}
+
+	// osin刷新时调用RemoveRefresh，效果相同
+	if err := s.RemoveRefresh("other-refresh"); err != nil {
+		t.Fatalf("RemoveRefresh: %v", err)
+	}
+	_, err = s.LoadAccess("other-access")
+	requireNotFound(t, "LoadAccess after RemoveRefresh", err)
+}
+
+func testRemoveClient(t *testing.T, newStorage Factory) {
+	s := newStorage(t, defaultOptions())
+	client := newClient(t, s, "removed")
+
+	if err := s.SaveAuthorize(newAuthorize(client, "removed-code", time.Now())); err != nil {
+		t.Fatalf("SaveAuthorize: %v", err)
+	}
+	if err := s.SaveAccess(newAccess(client, "removed-access", "removed-refresh", time.Now())); err != nil {
+		t.Fatalf("SaveAccess: %v", err)
+	}
+	if err := s.RemoveClient("removed"); err != nil {
+		t.Fatalf("RemoveClient: %v", err)
+	}
+
+	if _, err := s.LoadAuthorize("removed-code"); err == nil {
+		t.Fatal("codes of a removed client must not load")
+	}
+	if _, err := s.LoadAccess("removed-access"); err == nil {
+		t.Fatal("access tokens of a removed client must not load")
+	}
+	if _, err := s.LoadRefresh("removed-refresh"); err == nil {
+		t.Fatal("refresh tokens of a removed client must not load")
+	}
+}
+
+func testUserTokens(t *testing.T, newStorage Factory) {
+	s := newStorage(t, defaultOptions())
+	web := newClient(t, s, "user-web")
+	app := newClient(t, s, "user-app")
+	ctx := context.Background()
+
+	// alice 通过授权码授权 web，刷新一次；直接授权 app；bob 授权 web；另有一个不属于用户的客户端授权
+	authorize := newAuthorize(web, "alice-code", time.Now())
+	authorize.UserData = "alice"
+	if err := s.SaveAuthorize(authorize); err != nil {
+		t.Fatalf("SaveAuthorize: %v", err)
+	}
+	first := newAccess(web, "alice-web-1", "alice-web-refresh-1", time.Now())
+	first.AuthorizeData, first.UserData = authorize, "alice"
+	if err := s.SaveAccess(first); err != nil {
+		t.Fatalf("SaveAccess: %v", err)
+	}
+	refreshed, err := s.LoadRefresh("alice-web-refresh-1")
+	if err != nil {
+		t.Fatalf("LoadRefresh: %v", err)
+	}
+	second := newAccess(web, "alice-web-2", "alice-web-refresh-2", time.Now())
+	second.AccessData, second.UserData = refreshed, refreshed.UserData
+	if err := s.SaveAccess(second); err != nil {
+		t.Fatalf("SaveAccess after refresh: %v", err)
+	}
+	if err := s.RemoveRefresh("alice-web-refresh-1"); err != nil {
+		t.Fatalf("RemoveRefresh: %v", err)
+	}
+	for _, tt := range []struct {
+		data     *osin.AccessData
+		userData interface{}
+	}{
+		{newAccess(app, "alice-app", "", time.Now()), map[string]interface{}{"user_id": "alice"}},
+		{newAccess(web, "bob-web", "bob-web-refresh", time.Now()), "bob"},
+		{newAccess(web, "client-only", "", time.Now()), nil},
+	} {
+		tt.data.UserData = tt.userData
+		if err := s.SaveAccess(tt.data); err != nil {
+			t.Fatalf("SaveAccess(%s): %v", tt.data.AccessToken, err)
+		}
+	}
+
+	list, err := s.ListUserTokens(ctx, "alice")
+	if err != nil {
+		t.Fatalf("ListUserTokens: %v", err)
+	}
+	if got := countTokens(list); len(list) != 2 || got["user-app"] != 1 || got["user-web"] != 2 {
+		t.Fatalf("ListUserTokens(alice): got %+v", list)
+	}
+	if list[0].ClientId != "user-app" || list[0].Tokens[0].Type != service.TokenTypeAccess {
+		t.Fatalf("ListUserTokens(alice) should be ordered by client: got %+v", list)
+	}
+
+	result, err := s.RevokeUserTokens(ctx, "alice", "user-web")
+	if err != nil {
+		t.Fatalf("RevokeUserTokens(alice, user-web): %v", err)
+	}
+	if result.Grants != 1 || len(result.AccessTokens) != 1 {
+		t.Fatalf("RevokeUserTokens(alice, user-web): got %+v", result)
+	}
+	for _, token := range []string{"alice-web-1", "alice-web-2"} {
+		_, err = s.LoadAccess(token)
+		requireNotFound(t, "LoadAccess("+token+") after revoke", err)
+	}
+	_, err = s.LoadRefresh("alice-web-refresh-2")
+	requireNotFound(t, "LoadRefresh after revoke", err)
+	for _, token := range []string{"alice-app", "bob-web", "client-only"} {
+		if _, err := s.LoadAccess(token); err != nil {
+			t.Fatalf("LoadAccess(%s) must survive revoking another user or client: %v", token, err)
+		}
+	}
+
+	if _, err := s.RevokeUserTokens(ctx, "alice", ""); err != nil {
+		t.Fatalf("RevokeUserTokens(alice): %v", err)
+	}
+	_, err = s.LoadAccess("alice-app")
+	requireNotFound(t, "LoadAccess(alice-app) after revoke", err)
+	if list, err = s.ListUserTokens(ctx, "alice"); err != nil || len(list) != 0 {
+		t.Fatalf("ListUserTokens(alice) after revoke: got %+v, %v", list, err)
+	}
+	if list, err = s.ListUserTokens(ctx, "bob"); err != nil || len(list) != 1 || len(list[0].Tokens) != 2 {
+		t.Fatalf("ListUserTokens(bob): got %+v, %v", list, err)
+	}
+}
+
+func testUserGrantUsage(t *testing.T, newStorage Factory) {
+	s := newStorage(t, defaultOptions())
+	web := newClient(t, s, "usage-web")
+	app := newClient(t, s, "usage-app")
+	ctx := context.Background()
+
+	for _, data := range []*osin.AccessData{
+		newAccess(web, "usage-web", "usage-web-refresh", time.Now()),
+		newAccess(app, "usage-app", "", time.Now()),
+	} {
+		data.UserData = "carol"
+		if err := s.SaveAccess(data); err != nil {
+			t.Fatalf("SaveAccess(%s): %v", data.AccessToken, err)
+		}
+	}
+
+	if err := s.TouchAccess(ctx, "missing", "10.0.0.9", time.Now()); err != nil {
+		t.Fatalf("TouchAccess of an unknown token: %v", err)
+	}
+	usedAt := time.Now().Add(-time.Minute)
+	if err := s.TouchAccess(ctx, "usage-web", "10.0.0.1", usedAt.Add(-time.Hour)); err != nil {
+		t.Fatalf("TouchAccess: %v", err)
+	}
+	// 没有IP时保留上一次的IP
+	if err := s.TouchAccess(ctx, "usage-web", "", usedAt); err != nil {
+		t.Fatalf("TouchAccess without ip: %v", err)
+	}
+
+	list, err := s.ListUserTokens(ctx, "carol")
+	if err != nil {
+		t.Fatalf("ListUserTokens: %v", err)
+	}
+	var webGrant string
+	for _, client := range list {
+		for _, token := range client.Tokens {
+			switch client.ClientId {
+			case "usage-web":
+				webGrant = token.GrantId
+				if token.LastUsedAt == nil || token.LastUsedAt.Sub(usedAt).Abs() > time.Second || token.LastUsedIp != "10.0.0.1" {
+					t.Fatalf("usage of %s token: got %v from %q, want %v from 10.0.0.1", token.Type, token.LastUsedAt, token.LastUsedIp, usedAt)
+				}
+			case "usage-app":
+				if token.LastUsedAt != nil || token.LastUsedIp != "" {
+					t.Fatalf("unused grant reports usage %v from %q", token.LastUsedAt, token.LastUsedIp)
+				}
+			}
+		}
+	}
+	if webGrant == "" {
+		t.Fatalf("ListUserTokens(carol) is missing usage-web: %+v", list)
+	}
+
+	// 只能撤销自己的授权
+	if result, err := s.RevokeUserGrant(ctx, "mallory", webGrant); err != nil || result.Grants != 0 {
+		t.Fatalf("RevokeUserGrant of another user's grant: got %+v, %v", result, err)
+	}
+	if _, err := s.LoadAccess("usage-web"); err != nil {
+		t.Fatalf("LoadAccess after another user's revoke: %v", err)
+	}
+
+	result, err := s.RevokeUserGrant(ctx, "carol", webGrant)
+	if err != nil || result.Grants != 1 || len(result.AccessTokens) != 1 {
+		t.Fatalf("RevokeUserGrant: got %+v, %v", result, err)
+	}
+	_, err = s.LoadAccess("usage-web")
+	requireNotFound(t, "LoadAccess after RevokeUserGrant", err)
+	_, err = s.LoadRefresh("usage-web-refresh")
+	requireNotFound(t, "LoadRefresh after RevokeUserGrant", err)
+	if _, err := s.LoadAccess("usage-app"); err != nil {
+		t.Fatalf("other grants must survive RevokeUserGrant: %v", err)
+	}
+}
+
+// testGrantData checks that the resources and authorization details of a grant survive the round trip
+// of a code, the narrowed audience of its access token and a refresh, and that the user is still found.
+func testGrantData(t *testing.T, newStorage Factory) {
+	s := newStorage(t, defaultOptions())
+	client := newClient(t, s, "resources")
+	resources := []string{"https://billing.example.com", "https://admin.example.com"}
+	details := []service.AuthorizationDetail{{"type": "payment_initiation", "amount": 12.5, "actions": []interface{}{"initiate"}}}
+
+	authorize := newAuthorize(client, "resource-code", time.Now())
+	authorize.UserData = service.GrantData{UserId: "alice", Resources: resources, AuthorizationDetails: details}
+	if err := s.SaveAuthorize(authorize); err != nil {
+		t.Fatalf("SaveAuthorize: %v", err)
+	}
+	code, err := s.LoadAuthorize("resource-code")
+	if err != nil {
+		t.Fatalf("LoadAuthorize: %v", err)
+	}
+	grant := service.GrantDataOf(code.UserData)
+	if grant.UserId != "alice" || !reflect.DeepEqual(grant.Resources, resources) ||
+		!reflect.DeepEqual(grant.AuthorizationDetails, details) {
+		t.Fatalf("authorize grant data: got %+v", grant)
+	}
+
+	grant, err = grant.Narrow(resources[:1])
+	if err != nil {
+		t.Fatalf("Narrow: %v", err)
+	}
+	access := newAccess(client, "resource-access", "resource-refresh", time.Now())
+	access.AuthorizeData, access.UserData = code, grant
+	if err := s.SaveAccess(access); err != nil {
+		t.Fatalf("SaveAccess: %v", err)
+	}
+	loaded, err := s.LoadAccess("resource-access")
+	if err != nil {
+		t.Fatalf("LoadAccess: %v", err)
+	}
+	if got := service.GrantDataOf(loaded.UserData).TokenAudience(); !reflect.DeepEqual(got, resources[:1]) {
+		t.Fatalf("access token audience: got %v", got)
+	}
+
+	// 刷新令牌仍可用于授权的全部资源
+	refreshed, err := s.LoadRefresh("resource-refresh")
+	if err != nil {
+		t.Fatalf("LoadRefresh: %v", err)
+	}
+	grant, err = service.GrantDataOf(refreshed.UserData).Narrow(nil)
+	if err != nil || !reflect.DeepEqual(grant.TokenAudience(), resources) ||
+		!reflect.DeepEqual(grant.TokenAuthorizationDetails(), details) {
+		t.Fatalf("refreshed grant data: got %+v, %v", grant, err)
+	}
+	second := newAccess(client, "resource-access-2", "", time.Now())
+	second.AccessData, second.UserData = refreshed, grant
+	if err := s.SaveAccess(second); err != nil {
+		t.Fatalf("SaveAccess after refresh: %v", err)
+	}
+
+	list, err := s.ListUserTokens(context.Background(), "alice")
+	if err != nil {
+		t.Fatalf("ListUserTokens: %v", err)
+	}
+	if got := countTokens(list); got["resources"] != 3 {
+		t.Fatalf("ListUserTokens(alice): got %+v", list)
+	}
+}
+
+// testPushedRequest checks that a pushed request is returned once with its parameters, and that expired
+// and unknown request URIs are not found.
+func testPushedRequest(t *testing.T, newStorage Factory) {
+	s := newStorage(t, defaultOptions())
+	client := newClient(t, s, "pushed")
+	ctx := context.Background()
+
+	params := url.Values{
+		"response_type": {"code"},
+		"client_id":     {client.GetId()},
+		"resource":      {"https://a.example.com", "https://b.example.com"},
+		"state":         {"a b&c"},
+	}
+	if err := s.SavePushedRequest(ctx, "urn:pushed", &service.PushedRequest{
+		ClientId:  client.GetId(),
+		Params:    params,
+		ExpiresAt: time.Now().Add(time.Minute),
+	}); err != nil {
+		t.Fatalf("SavePushedRequest: %v", err)
+	}
+	got, err := s.TakePushedRequest(ctx, "urn:pushed")
+	if err != nil {
+		t.Fatalf("TakePushedRequest: %v", err)
+	}
+	if got.ClientId != client.GetId() || !reflect.DeepEqual(got.Params, params) {
+		t.Fatalf("pushed request mismatch: got %+v", got)
+	}
+	_, err = s.TakePushedRequest(ctx, "urn:pushed")
+	requireNotFound(t, "TakePushedRequest twice", err)
+
+	if err := s.SavePushedRequest(ctx, "urn:expired", &service.PushedRequest{
+		ClientId:  client.GetId(),
+		Params:    params,
+		ExpiresAt: time.Now().Add(-time.Second),
+	}); err != nil {
+		t.Fatalf("SavePushedRequest(expired): %v", err)
+	}
+	_, err = s.TakePushedRequest(ctx, "urn:expired")
+	requireNotFound(t, "TakePushedRequest(expired)", err)
+	_, err = s.TakePushedRequest(ctx, "urn:unknown")
+	requireNotFound(t, "TakePushedRequest(unknown)", err)
+}
+
+// purger is implemented by the backends that delete expired data in bulk; Redis expires keys by itself.
+type purger interface {
+	PurgeExpired(ctx context.Context, batchSize int) (service.PurgeResult, error)
+}
+
+func testPurgeExpired(t *testing.T, newStorage Factory) {
+	opts := defaultOptions()
+	opts.RefreshExpiration = int32(time.Hour.Seconds())
+	s := newStorage(t, opts)
+	p, ok := service.Unwrap(s).(purger)
+	if !ok {
+		t.Skip("storage expires data by itself")
+	}
+	client := newClient(t, s, "purge")
+	ctx := context.Background()
+
+	for _, data := range []*osin.AuthorizeData{
+		newAuthorize(client, "expired-code", time.Now().Add(-time.Hour)),
+		newAuthorize(client, "live-code", time.Now()),
+	} {
+		if err := s.SaveAuthorize(data); err != nil {
+			t.Fatalf("SaveAuthorize(%s): %v", data.Code, err)
+		}
+	}
+	for _, data := range []*osin.AccessData{
+		newAccess(client, "expired-access", "", time.Now().Add(-2*time.Hour)),
+		newAccess(client, "expired-pair", "expired-pair-refresh", time.Now().Add(-2*time.Hour)),
+		newAccess(client, "live-access", "live-refresh", time.Now()),
+	} {
+		if err := s.SaveAccess(data); err != nil {
+			t.Fatalf("SaveAccess(%s): %v", data.AccessToken, err)
+		}
+	}
+	for uri, expiresAt := range map[string]time.Time{
+		"urn:expired": time.Now().Add(-time.Second),
+		"urn:live":    time.Now().Add(time.Minute),
+	} {
+		if err := s.SavePushedRequest(ctx, uri, &service.PushedRequest{ClientId: client.GetId(), ExpiresAt: expiresAt}); err != nil {
+			t.Fatalf("SavePushedRequest(%s): %v", uri, err)
+		}
+	}
+
+	result, err := p.PurgeExpired(ctx, 1000)
+	if err != nil {
+		t.Fatalf("PurgeExpired: %v", err)
+	}
+	if result.Codes != 1 || result.AccessTokens != 2 || result.RefreshTokens != 1 || result.PushedRequests != 1 {
+		t.Fatalf("PurgeExpired: got %+v", result)
+	}
+	if _, err := s.LoadAuthorize("live-code"); err != nil {
+		t.Fatalf("LoadAuthorize of a live code after purge: %v", err)
+	}
+	if _, err := s.LoadAccess("live-access"); err != nil {
+		t.Fatalf("LoadAccess of a live token after purge: %v", err)
+	}
+	if _, err := s.LoadRefresh("live-refresh"); err != nil {
+		t.Fatalf("LoadRefresh of a live token after purge: %v", err)
+	}
+	if _, err := s.TakePushedRequest(ctx, "urn:live"); err != nil {
+		t.Fatalf("TakePushedRequest of a live request after purge: %v", err)
+	}
+
+	// 没有可删除的数据时什么也不做
+	if result, err := p.PurgeExpired(ctx, 1000); err != nil || result.Total() != 0 {
+		t.Fatalf("PurgeExpired again: %+v, %v", result, err)
+	}
+}
+
+func testPurgeBatches(t *testing.T, newStorage Factory) {
+	s := newStorage(t, defaultOptions())
+	p, ok := service.Unwrap(s).(purger)
+	if !ok {
+		t.Skip("storage expires data by itself")
+	}
+	client := newClient(t, s, "purge-batches")
+	ctx := context.Background()
+
+	// 每批删除2行，过期数据多于一批且恰好是批大小的整数倍
+	for i := 0; i < 3; i++ {
+		if err := s.SaveAuthorize(newAuthorize(client, fmt.Sprintf("batch-code-%d", i), time.Now().Add(-601*time.Second))); err != nil {
+			t.Fatalf("SaveAuthorize: %v", err)
+		}
+	}
+	for i := 0; i < 4; i++ {
+		if err := s.SaveAccess(newAccess(client, fmt.Sprintf("batch-access-%d", i), "", time.Now().Add(-3601*time.Second))); err != nil {
+			t.Fatalf("SaveAccess: %v", err)
+		}
+	}
+	// 访问令牌过期后，永不过期的刷新令牌保留
+	if err := s.SaveAccess(newAccess(client, "old-access", "old-refresh", time.Now().Add(-48*time.Hour))); err != nil {
+		t.Fatalf("SaveAccess: %v", err)
+	}
+	// 还有几秒才过期的数据保留
+	if err := s.SaveAuthorize(newAuthorize(client, "boundary-code", time.Now().Add(-595*time.Second))); err != nil {
+		t.Fatalf("SaveAuthorize: %v", err)
+	}
+	if err := s.SaveAccess(newAccess(client, "boundary-access", "", time.Now().Add(-3595*time.Second))); err != nil {
+		t.Fatalf("SaveAccess: %v", err)
+	}
+
+	result, err := p.PurgeExpired(ctx, 2)
+	if err != nil {
+		t.Fatalf("PurgeExpired: %v", err)
+	}
+	if result.Codes != 3 || result.AccessTokens != 5 || result.RefreshTokens != 0 {
+		t.Fatalf("PurgeExpired: got %+v", result)
+	}
+	for i := 0; i < 4; i++ {
+		_, err := s.LoadAccess(fmt.Sprintf("batch-access-%d", i))
+		requireNotFound(t, "LoadAccess of a purged token", err)
+	}
+	if _, err := s.LoadRefresh("old-refresh"); err != nil {
+		t.Fatalf("LoadRefresh of a refresh token that never expires: %v", err)
+	}
+	if _, err := s.LoadAuthorize("boundary-code"); err != nil {
+		t.Fatalf("LoadAuthorize of a code about to expire: %v", err)
+	}
+	if _, err := s.LoadAccess("boundary-access"); err != nil {
+		t.Fatalf("LoadAccess of a token about to expire: %v", err)
+	}
+}
+
+// countTokens returns the number of listed tokens by client.
+func countTokens(list []service.ClientTokens) map[string]int {
+	counts := make(map[string]int)
+	for _, client := range list {
+		counts[client.ClientId] += len(client.Tokens)
+	}
+	return counts
+}
+
+func testConcurrent(t *testing.T, newStorage Factory) {
+	s := newStorage(t, defaultOptions())
+	client := newClient(t, s, "concurrent")
+
+	const workers = 16
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- exchange(s, client, i)
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	for i := 0; i < workers; i++ {
+		if _, err := s.LoadAccess(fmt.Sprintf("concurrent-access-%d", i)); err != nil {
+			t.Errorf("LoadAccess(%d) after concurrent exchange: %v", i, err)
+		}
+	}
+}
+
+// exchange runs the storage calls of an authorization code exchange.
+func exchange(s service.OAuthStorage, client osin.Client, i int) error {
+	code := fmt.Sprintf("concurrent-code-%d", i)
+	if err := s.SaveAuthorize(newAuthorize(client, code, time.Now())); err != nil {
+		return fmt.Errorf("SaveAuthorize(%d): %v", i, err)
+	}
+	authorize, err := s.LoadAuthorize(code)
+	if err != nil {
+		return fmt.Errorf("LoadAuthorize(%d): %v", i, err)
+	}
+
+	access := newAccess(client, fmt.Sprintf("concurrent-access-%d", i), fmt.Sprintf("concurrent-refresh-%d", i), time.Now())
+	access.AuthorizeData = authorize
+	if err := s.SaveAccess(access); err != nil {
+		return fmt.Errorf("SaveAccess(%d): %v", i, err)
+	}
+	if err := s.RemoveAuthorize(code); err != nil {
+		return fmt.Errorf("RemoveAuthorize(%d): %v", i, err)
+	}
+	if _, err := s.LoadRefresh(access.RefreshToken); err != nil {
+		return fmt.Errorf("LoadRefresh(%d): %v", i, err)
+	}
+	return nil
+}
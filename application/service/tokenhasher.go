@@ -0,0 +1,29 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// TokenHasher turns codes and tokens into the digests that are persisted instead of the raw values.
+// With a pepper configured it uses HMAC-SHA256, otherwise plain SHA-256.
+type TokenHasher struct {
+	pepper []byte
+}
+
+// NewTokenHasher returns a hasher keyed with the given server pepper. An empty pepper means SHA-256.
+func NewTokenHasher(pepper string) *TokenHasher {
+	return &TokenHasher{pepper: []byte(pepper)}
+}
+
+// Hash returns the hex encoded digest of token.
+func (h *TokenHasher) Hash(token string) string {
+	if len(h.pepper) == 0 {
+		sum := sha256.Sum256([]byte(token))
+		return hex.EncodeToString(sum[:])
+	}
+	mac := hmac.New(sha256.New, h.pepper)
+	mac.Write([]byte(token))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,67 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/openshift/osin"
+	"github.com/zeromicro/go-zero/core/collection"
+	"github.com/zeromicro/go-zero/core/logx"
+)
+
+// UsageTracker records when and from where the access tokens validated through it were last used.
+// A token is recorded at most once per interval on each replica, whatever IP it is used from, so only a
+// small share of validations waits for the write. Failed writes are logged and never fail the validation.
+type UsageTracker struct {
+	OAuthStorage
+	hasher *TokenHasher
+	recent *collection.Cache
+}
+
+// NewUsageTracker returns a tracker in front of storage. interval is the minimum time between two writes
+// for the same token, limit the number of tokens remembered in process.
+func NewUsageTracker(storage OAuthStorage, opts Options, interval time.Duration, limit int) (*UsageTracker, error) {
+	recent, err := collection.NewCache(interval, collection.WithLimit(limit), collection.WithName("usage"))
+	if err != nil {
+		return nil, err
+	}
+	return &UsageTracker{
+		OAuthStorage: storage,
+		hasher:       opts.Hasher,
+		recent:       recent,
+	}, nil
+}
+
+// Unwrap returns the wrapped storage.
+func (t *UsageTracker) Unwrap() OAuthStorage {
+	return t.OAuthStorage
+}
+
+// LoadAccess retrieves access data by token and records the use, keeping the previously recorded IP.
+func (t *UsageTracker) LoadAccess(token string) (*osin.AccessData, error) {
+	return t.LoadAccessFrom(token, "")
+}
+
+// LoadAccessFrom retrieves access data by token and records the use from ip.
+func (t *UsageTracker) LoadAccessFrom(token, ip string) (*osin.AccessData, error) {
+	data, err := t.OAuthStorage.LoadAccess(token)
+	if err != nil {
+		return nil, err
+	}
+	t.track(token, ip)
+	return data, nil
+}
+
+// track records the use of token from ip unless the token was recorded within the interval.
+func (t *UsageTracker) track(token, ip string) {
+	// 只按令牌节流，不断变换IP也不会增加写入
+	key := t.hasher.Hash(token)
+	if _, ok := t.recent.Get(key); ok {
+		return
+	}
+	t.recent.Set(key, struct{}{})
+
+	if err := t.OAuthStorage.TouchAccess(context.Background(), token, ip, time.Now()); err != nil {
+		logx.Errorf("记录令牌使用失败: %v", err)
+	}
+}
@@ -0,0 +1,64 @@
+package service_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"oauth2/application/service"
+
+	"github.com/openshift/osin"
+)
+
+// countingStorage counts TouchAccess calls reaching the storage.
+type countingStorage struct {
+	service.OAuthStorage
+	touches atomic.Int32
+}
+
+func (s *countingStorage) TouchAccess(ctx context.Context, token, ip string, usedAt time.Time) error {
+	s.touches.Add(1)
+	return s.OAuthStorage.TouchAccess(ctx, token, ip, usedAt)
+}
+
+func TestUsageTracker(t *testing.T) {
+	opts := service.Options{Hasher: service.NewTokenHasher("")}
+	storage := &countingStorage{OAuthStorage: service.NewMemoryStorage(opts)}
+	tracker, err := service.NewUsageTracker(storage, opts, time.Hour, 100)
+	if err != nil {
+		t.Fatalf("NewUsageTracker: %v", err)
+	}
+
+	client := &osin.DefaultClient{Id: "app", Secret: "secret", RedirectUri: "http://localhost"}
+	if err := storage.CreateClient(client); err != nil {
+		t.Fatalf("CreateClient: %v", err)
+	}
+	for _, token := range []string{"phone", "laptop"} {
+		err := storage.SaveAccess(&osin.AccessData{Client: client, AccessToken: token, ExpiresIn: 3600, CreatedAt: time.Now(), UserData: "dave"})
+		if err != nil {
+			t.Fatalf("SaveAccess(%s): %v", token, err)
+		}
+	}
+
+	// 同一令牌在间隔内只记录一次，不断变换IP也不会增加写入
+	for _, ip := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4"} {
+		if _, err := tracker.LoadAccessFrom("phone", ip); err != nil {
+			t.Fatalf("LoadAccessFrom: %v", err)
+		}
+	}
+	if n := storage.touches.Load(); n != 1 {
+		t.Fatalf("expected 1 throttled write, got %d", n)
+	}
+	if _, err := tracker.LoadAccess("missing"); err == nil {
+		t.Fatal("LoadAccess of an unknown token should fail")
+	}
+
+	connections, err := service.UserConnections(context.Background(), tracker, "dave")
+	if err != nil {
+		t.Fatalf("UserConnections: %v", err)
+	}
+	if len(connections) != 2 || connections[0].LastUsedIp != "10.0.0.1" || connections[1].LastUsedAt != nil {
+		t.Fatalf("the used connection should be listed first with its last IP: %+v", connections)
+	}
+}
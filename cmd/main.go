@@ -4,9 +4,12 @@ import (
 	"flag"
 	"fmt"
 	"oauth2/infrastructure/svc"
+	"os"
 
+	"oauth2/application/service"
 	"oauth2/common/redis"
 	"oauth2/infrastructure/config"
+	"oauth2/infrastructure/job"
 	"oauth2/interfaces/api"
 
 	"github.com/zeromicro/go-zero/core/conf"
@@ -22,10 +25,41 @@ func main() {
 	var c config.Config
 	conf.MustLoad(*configFile, &c)
 
-	redis.Init(c.Redis.Host, c.Redis.Pass)
-	defer redis.Close()
+	if flag.Arg(0) == "migrate" {
+		if err := runMigrate(c, flag.Args()[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if c.Redis.Host != "" {
+		redis.Init(c.Redis.Host, c.Redis.Pass)
+		defer redis.Close()
+	}
 
 	ctx := svc.NewServiceContext(c)
+	if ctx.ClientCache != nil {
+		if err := ctx.ClientCache.Start(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		defer ctx.ClientCache.Stop()
+	}
+	if ctx.AccessCache != nil {
+		if err := ctx.AccessCache.Start(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		defer ctx.AccessCache.Stop()
+	}
+	// Redis等自带过期机制的存储无需清理
+	if purger, ok := service.Unwrap(ctx.Storage).(job.Purger); ok && c.Purge.Enabled {
+		purgeJob := job.NewPurgeJob(ctx, purger)
+		purgeJob.Start()
+		defer purgeJob.Stop()
+	}
+
 	server := rest.MustNewServer(c.RestConf)
 	defer server.Stop()
 	api.RegisterHandlers(server, ctx)
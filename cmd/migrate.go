@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"oauth2/application/service"
+	"oauth2/infrastructure/config"
+	"oauth2/infrastructure/svc"
+)
+
+const migrateUsage = "用法: oauth2 [-f 配置文件] migrate up|down [步数]|status"
+
+// runMigrate 执行 migrate 子命令
+func runMigrate(c config.Config, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf(migrateUsage)
+	}
+
+	storage := service.NewStorage(svc.NewSqlConn(c), svc.StorageOptions(c))
+	migrator, err := storage.NewMigrator()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	switch args[0] {
+	case "up":
+		executed, err := migrator.Up(ctx)
+		for _, m := range executed {
+			fmt.Printf("已升级 %04d_%s\n", m.Version, m.Name)
+		}
+		if err == nil && len(executed) == 0 {
+			fmt.Println("数据库已是最新版本")
+		}
+		return err
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps <= 0 {
+				return fmt.Errorf("回滚步数无效: %s", args[1])
+			}
+		}
+		reverted, err := migrator.Down(ctx, steps)
+		for _, m := range reverted {
+			fmt.Printf("已回滚 %04d_%s\n", m.Version, m.Name)
+		}
+		return err
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.Applied {
+				appliedAt = status.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(w, "%04d\t%s\t%s\n", status.Version, status.Name, appliedAt)
+		}
+		return w.Flush()
+	default:
+		return fmt.Errorf(migrateUsage)
+	}
+}
@@ -15,6 +15,7 @@ import (
 
 	"github.com/tidwall/gjson"
 	"github.com/zeromicro/go-zero/core/logx"
+	"github.com/zeromicro/go-zero/rest/httpx"
 )
 
 const TIMEOUT = 220
@@ -291,3 +292,11 @@ func PostV3(ctx context.Context, url string, method string, requestBody interfac
 
 	return nil
 }
+
+// WriteError 以OAuth2错误格式输出JSON错误
+func WriteError(w http.ResponseWriter, r *http.Request, status int, code, description string) {
+	httpx.WriteJsonCtx(r.Context(), w, status, map[string]string{
+		"error":             code,
+		"error_description": description,
+	})
+}
@@ -1,8 +1,11 @@
 package util
 
 import (
+	"fmt"
 	"log"
 	"net"
+	"net/http"
+	"strings"
 )
 
 func GetLocalIp() string {
@@ -20,3 +23,116 @@ func GetLocalIp() string {
 	}
 	return ""
 }
+
+// TrustedProxies 可信代理的地址段，只有直接来自这些地址的请求才采用转发头中的IP
+type TrustedProxies []*net.IPNet
+
+// ParseTrustedProxies 解析可信代理列表，每项为CIDR或单个IP
+func ParseTrustedProxies(proxies []string) (TrustedProxies, error) {
+	nets := make(TrustedProxies, 0, len(proxies))
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, fmt.Errorf("无效的可信代理地址: %s", proxy)
+			}
+			bits := 8 * len(ip.To16())
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("无效的可信代理地址: %s", proxy)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// ClientIp 获取请求方IP。请求直接来自可信代理时，从右向左跳过 X-Forwarded-For 中的可信代理，
+// 取第一个不可信的地址，没有 X-Forwarded-For 时取 X-Real-IP；否则转发头可以被任意伪造，只使用连接的对端地址
+func (p TrustedProxies) ClientIp(r *http.Request) string {
+	remote := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(remote); err == nil {
+		remote = host
+	}
+	if !p.contains(remote) {
+		return remote
+	}
+
+	if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+		hops := strings.Split(strings.Join(forwarded, ","), ",")
+		client := remote
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if net.ParseIP(hop) == nil {
+				// 无法解析的地址之前的内容都不可信
+				break
+			}
+			client = hop
+			if !p.contains(hop) {
+				break
+			}
+		}
+		return client
+	}
+	if realIp := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(realIp) != nil {
+		return realIp
+	}
+	return remote
+}
+
+// contains 判断ip是否属于可信代理
+func (p TrustedProxies) contains(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, ipNet := range p {
+		if ipNet.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// nonPublicNets 除回环、私有和链路本地地址外，其他不能从公网访问的地址段
+var nonPublicNets = mustParseCIDRs(
+	"0.0.0.0/8",     // 本网络
+	"100.64.0.0/10", // 运营商级NAT
+	"192.0.0.0/24",  // IETF协议分配
+	"198.18.0.0/15", // 网络设备基准测试
+	"240.0.0.0/4",   // 保留
+	"64:ff9b::/96",  // NAT64，可映射到任意IPv4地址
+	"2001:db8::/32", // 文档示例
+)
+
+// IsPublicIp 判断ip是否为公网地址，回环、私有、链路本地、组播和保留地址都不是
+func IsPublicIp(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return false
+	}
+	for _, ipNet := range nonPublicNets {
+		if ipNet.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// mustParseCIDRs 解析固定的地址段，格式错误时panic
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets
+}
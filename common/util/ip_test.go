@@ -0,0 +1,86 @@
+package util_test
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"oauth2/common/util"
+)
+
+func TestTrustedProxiesClientIp(t *testing.T) {
+	proxies, err := util.ParseTrustedProxies([]string{"10.0.0.0/8", "192.168.1.1", "fd00::/8"})
+	if err != nil {
+		t.Fatalf("ParseTrustedProxies: %v", err)
+	}
+	if _, err := util.ParseTrustedProxies([]string{"proxy.internal"}); err == nil {
+		t.Fatal("ParseTrustedProxies should reject host names")
+	}
+
+	for _, tt := range []struct {
+		name      string
+		remote    string
+		forwarded []string
+		realIp    string
+		want      string
+	}{
+		{"direct", "203.0.113.7:4321", nil, "", "203.0.113.7"},
+		{"forged by an untrusted caller", "203.0.113.7:4321", []string{"198.51.100.1"}, "198.51.100.2", "203.0.113.7"},
+		{"single proxy", "10.1.2.3:80", []string{"198.51.100.1"}, "", "198.51.100.1"},
+		{"forged entries before the proxy chain", "10.1.2.3:80", []string{"1.1.1.1, 198.51.100.1, 192.168.1.1"}, "", "198.51.100.1"},
+		{"repeated headers", "10.1.2.3:80", []string{"1.1.1.1", "198.51.100.1"}, "", "198.51.100.1"},
+		{"only proxies", "10.1.2.3:80", []string{"10.0.0.9, 10.0.0.8"}, "", "10.0.0.9"},
+		{"garbage stops the walk", "10.1.2.3:80", []string{"evil, 10.0.0.8"}, "", "10.0.0.8"},
+		{"real ip from a proxy", "[fd00::1]:80", nil, "198.51.100.3", "198.51.100.3"},
+		{"invalid real ip", "10.1.2.3:80", nil, "unknown", "10.1.2.3"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remote
+			for _, value := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+			if tt.realIp != "" {
+				r.Header.Set("X-Real-IP", tt.realIp)
+			}
+			if got := proxies.ClientIp(r); got != tt.want {
+				t.Fatalf("ClientIp: got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// 未配置可信代理时忽略转发头
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "10.1.2.3:80"
+	r.Header.Set("X-Forwarded-For", "198.51.100.1")
+	if got := util.TrustedProxies(nil).ClientIp(r); got != "10.1.2.3" {
+		t.Fatalf("ClientIp without trusted proxies: %q", got)
+	}
+}
+
+func TestIsPublicIp(t *testing.T) {
+	for ip, want := range map[string]bool{
+		"203.0.114.1":      true,
+		"8.8.8.8":          true,
+		"2606:4700::1111":  true,
+		"127.0.0.1":        false,
+		"::1":              false,
+		"10.1.2.3":         false,
+		"172.16.0.1":       false,
+		"192.168.1.1":      false,
+		"169.254.169.254":  false,
+		"fe80::1":          false,
+		"fd00::1":          false,
+		"0.0.0.0":          false,
+		"100.64.0.1":       false,
+		"224.0.0.1":        false,
+		"::ffff:127.0.0.1": false,
+		"::ffff:10.0.0.1":  false,
+		"64:ff9b::a00:1":   false,
+	} {
+		if got := util.IsPublicIp(net.ParseIP(ip)); got != want {
+			t.Errorf("IsPublicIp(%s) = %v, want %v", ip, got, want)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package util
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// NewPublicHttpClient 返回只连接公网地址的HTTP客户端，用于获取客户端提供的地址(如request_uri和jwks_uri)，
+// 避免被用来访问部署所在网络的内部服务。地址在域名解析之后、建立连接之前校验，重定向同样受限；
+// 不使用环境变量中的代理，否则校验的只是代理的地址
+func NewPublicHttpClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout, Control: dialPublicOnly}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
+
+// dialPublicOnly 拒绝连接非公网地址
+func dialPublicOnly(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !IsPublicIp(ip) {
+		return fmt.Errorf("禁止访问非公网地址 %s", host)
+	}
+	return nil
+}
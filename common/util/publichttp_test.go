@@ -0,0 +1,30 @@
+package util_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"oauth2/common/util"
+)
+
+func TestPublicHttpClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("internal"))
+	}))
+	defer server.Close()
+
+	// httptest监听回环地址，域名解析到回环地址时同样拒绝
+	for _, target := range []string{server.URL, strings.Replace(server.URL, "127.0.0.1", "localhost", 1)} {
+		resp, err := util.NewPublicHttpClient(time.Second).Get(target)
+		if err == nil {
+			resp.Body.Close()
+			t.Fatalf("GET %s succeeded", target)
+		}
+		if !strings.Contains(err.Error(), "非公网地址") {
+			t.Fatalf("GET %s: %v", target, err)
+		}
+	}
+}
@@ -1,6 +1,8 @@
 package config
 
 import (
+	"time"
+
 	"github.com/zeromicro/go-zero/rest"
 )
 
@@ -9,15 +11,96 @@ type Config struct {
 	rest.RestConf // REST服务配置
 
 	DB struct {
-		DataSource string // 数据库连接字符串
+		Driver          string        `json:",default=mysql,options=mysql|postgres|sqlite"` // 数据库驱动
+		DataSource      string        `json:",optional"`                                    // 主库连接字符串，sqlite为数据库文件，内存存储时可为空
+		Replicas        []string      `json:",optional"`                                    // 只读副本连接字符串，用于读取用户的令牌列表
+		WAL             bool          `json:",default=true"`                                // sqlite是否启用WAL模式
+		MaxOpenConns    int           `json:",default=64"`                                  // 每个连接池的最大连接数
+		MaxIdleConns    int           `json:",default=64"`                                  // 每个连接池的最大空闲连接数
+		ConnMaxLifetime time.Duration `json:",default=1m"`                                  // 连接的最长存活时间
 	}
 
 	Domain string // 回调基础URL
 
+	TrustedProxies []string `json:",optional"` // 可信反向代理的CIDR或IP，只有来自这些地址的请求才采用X-Forwarded-For中的用户IP
+
 	Redis struct {
-		Host string // Redis主机
-		Pass string // Redis密码
-		Type string // Redis类型
-		Tls  bool   // Redis是否启用TLS
+		Host string `json:",optional"`     // Redis主机，内存存储时可为空
+		Pass string `json:",optional"`     // Redis密码
+		Type string `json:",default=node"` // Redis类型
+		Tls  bool   `json:",optional"`     // Redis是否启用TLS
+	}
+
+	OAuth struct {
+		TablePrefix             string        `json:",default=osin_"`   // 存储表前缀
+		AuthorizationExpiration int32         `json:",default=600"`     // 授权码有效期(秒)
+		AccessExpiration        int32         `json:",default=3600"`    // 访问令牌有效期(秒)
+		RefreshExpiration       int32         `json:",default=2592000"` // 刷新令牌有效期(秒)，0为永不过期
+		TokenPepper             string        `json:",optional"`        // 令牌哈希密钥，为空时使用SHA-256
+		ScopeCacheExpiry        time.Duration `json:",default=1m"`      // 权限范围注册表的进程内缓存有效期
+		ResourceCacheExpiry     time.Duration `json:",default=1m"`      // 资源注册表的进程内缓存有效期
+		DetailTypeCacheExpiry   time.Duration `json:",default=1m"`      // 授权详情类型注册表的进程内缓存有效期
+		PushedRequestExpiration int32         `json:",default=60"`      // 推送的授权请求(RFC 9126)有效期(秒)
+	}
+
+	Consent struct {
+		Enabled bool          `json:",optional"`    // 是否在签发授权码前向用户展示同意页面
+		Secret  string        `json:",optional"`    // 同意页面票据的签名密钥，为空时启动时随机生成，多副本部署时必须配置
+		Expiry  time.Duration `json:",default=10m"` // 同意页面的有效期
+	}
+
+	RequestObject struct {
+		DecryptionKey   string        `json:",optional"`    // 解密请求对象(RFC 9101)的RSA私钥，PEM格式，为空时不接受加密的请求对象
+		KeysCacheExpiry time.Duration `json:",default=10m"` // 从客户端jwks_uri获取的公钥集的进程内缓存有效期
+		FetchTimeout    time.Duration `json:",default=5s"`  // 获取request_uri和jwks_uri的超时时间
+	}
+
+	Jarm struct {
+		SigningKey string        `json:",optional"`    // 签名授权响应(JARM)的私钥，PEM格式的RSA或EC私钥，为空时不支持*.jwt响应模式
+		Expiry     time.Duration `json:",default=10m"` // 签名的授权响应的有效期
+	}
+
+	Auth struct {
+		AccessSecret string `json:",optional"` // 用户JWT签名密钥，JWT的user_id为用户ID，为空时不开放用户接口
+	}
+
+	Admin struct {
+		AccessSecret string `json:",optional"` // 管理接口JWT签名密钥，为空时不开放管理接口
+	}
+
+	Registration struct {
+		Enabled                  bool     `json:",optional"` // 是否开放动态客户端注册
+		InitialAccessTokens      []string `json:",optional"` // 初始访问令牌，非空时注册需要携带其中之一
+		RequireSoftwareStatement bool     `json:",optional"` // 是否必须提供签名的软件声明
+		SoftwareStatementKey     string   `json:",optional"` // 软件声明的验签密钥，PEM格式的RSA/EC公钥或HMAC密钥
+	}
+
+	Storage struct {
+		Backend string `json:",default=sql,options=sql|redis|memory"` // 令牌存储后端，redis后端需要配置Redis.Host，客户端仍保存在数据库中
+	}
+
+	ClientCache struct {
+		Expiry      time.Duration `json:",default=10m"`  // Redis中客户端缓存有效期
+		LocalExpiry time.Duration `json:",default=1m"`   // 进程内客户端缓存有效期，广播丢失时以此为准
+		LocalLimit  int           `json:",default=1000"` // 进程内最多缓存的客户端数
+	}
+
+	AccessCache struct {
+		Enabled     bool          `json:",default=true"`  // 是否缓存校验通过的访问令牌，配置Redis后生效
+		Expiry      time.Duration `json:",default=5m"`    // Redis中访问令牌缓存的最长有效期，不超过令牌剩余有效期
+		LocalExpiry time.Duration `json:",default=1m"`    // 进程内访问令牌缓存的最长有效期
+		LocalLimit  int           `json:",default=10000"` // 进程内最多缓存的访问令牌数
+	}
+
+	Usage struct {
+		Enabled  bool          `json:",default=true"`  // 是否记录访问令牌所属授权的最近使用时间和IP
+		Interval time.Duration `json:",default=1m"`    // 同一令牌在此间隔内只记录一次
+		Limit    int           `json:",default=10000"` // 进程内最多记住的令牌数
+	}
+
+	Purge struct {
+		Enabled   bool          `json:",default=true"` // 是否启用过期数据清理
+		Interval  time.Duration `json:",default=10m"`  // 清理间隔
+		BatchSize int           `json:",default=1000"` // 每批删除的最大行数
 	}
 }
@@ -0,0 +1,104 @@
+package job
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"oauth2/application/service"
+	"oauth2/infrastructure/svc"
+
+	"github.com/zeromicro/go-zero/core/logx"
+	"github.com/zeromicro/go-zero/core/stores/redis"
+	"github.com/zeromicro/go-zero/core/threading"
+)
+
+// PurgeLeaderKey 清理任务选主使用的Redis键
+const PurgeLeaderKey = "oauth2:job:purge:leader"
+
+// Purger 可以清理过期数据的存储
+type Purger interface {
+	PurgeExpired(ctx context.Context, batchSize int) (service.PurgeResult, error)
+}
+
+// PurgeJob 定时分批删除过期的授权码、访问令牌、刷新令牌和推送的授权请求。
+// 多副本部署时通过Redis租约选主，只有持有租约的副本会执行清理。
+type PurgeJob struct {
+	purger    Purger
+	leader    *redis.RedisLock
+	interval  time.Duration
+	batchSize int
+	done      chan struct{}
+	stopOnce  sync.Once
+}
+
+// NewPurgeJob 根据配置创建清理任务
+func NewPurgeJob(svcCtx *svc.ServiceContext, purger Purger) *PurgeJob {
+	c := svcCtx.Config.Purge
+	j := &PurgeJob{
+		purger:    purger,
+		interval:  c.Interval,
+		batchSize: c.BatchSize,
+		done:      make(chan struct{}),
+	}
+	if svcCtx.Redis != nil {
+		j.leader = redis.NewRedisLock(svcCtx.Redis, PurgeLeaderKey)
+		// 租约覆盖两个周期，领导者宕机后其他副本最多等待两个周期接管
+		j.leader.SetExpire(int(2 * c.Interval / time.Second))
+	}
+	return j
+}
+
+// Start 在后台启动定时清理
+func (j *PurgeJob) Start() {
+	threading.GoSafe(func() {
+		ticker := time.NewTicker(j.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				j.RunOnce(context.Background())
+			case <-j.done:
+				return
+			}
+		}
+	})
+}
+
+// Stop 停止定时清理并释放租约
+func (j *PurgeJob) Stop() {
+	j.stopOnce.Do(func() {
+		close(j.done)
+		if j.leader != nil {
+			if _, err := j.leader.Release(); err != nil {
+				logx.Errorf("Purge job failed to release leadership: %v", err)
+			}
+		}
+	})
+}
+
+// RunOnce 执行一次清理，未获得租约时跳过并返回false
+func (j *PurgeJob) RunOnce(ctx context.Context) (service.PurgeResult, bool, error) {
+	logger := logx.WithContext(ctx)
+	if j.leader != nil {
+		acquired, err := j.leader.AcquireCtx(ctx)
+		if err != nil {
+			logger.Errorf("Purge job failed to acquire leadership: %v", err)
+			return service.PurgeResult{}, false, err
+		}
+		if !acquired {
+			return service.PurgeResult{}, false, nil
+		}
+	}
+
+	start := time.Now()
+	result, err := j.purger.PurgeExpired(ctx, j.batchSize)
+	if err != nil {
+		logger.Errorf("Purge job failed after deleting %d rows: %v", result.Total(), err)
+		return result, true, err
+	}
+	logger.Infof("Purge job deleted %d rows in %s: codes=%d access_tokens=%d refresh_tokens=%d grants=%d pushed_requests=%d",
+		result.Total(), time.Since(start), result.Codes, result.AccessTokens, result.RefreshTokens, result.Grants,
+		result.PushedRequests)
+	return result, true, nil
+}
@@ -0,0 +1,89 @@
+package job_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"oauth2/application/service"
+	"oauth2/infrastructure/config"
+	"oauth2/infrastructure/job"
+	"oauth2/infrastructure/svc"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/zeromicro/go-zero/core/stores/redis"
+)
+
+// countingPurger 记录被调用的次数
+type countingPurger struct {
+	runs int32
+}
+
+func (p *countingPurger) PurgeExpired(ctx context.Context, batchSize int) (service.PurgeResult, error) {
+	atomic.AddInt32(&p.runs, 1)
+	return service.PurgeResult{Codes: int64(batchSize)}, nil
+}
+
+func newServiceContext(rds *redis.Redis) *svc.ServiceContext {
+	var c config.Config
+	c.Purge.Interval = time.Minute
+	c.Purge.BatchSize = 10
+	return &svc.ServiceContext{Config: c, Redis: rds}
+}
+
+func TestPurgeJobLeader(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rds, err := redis.NewRedis(redis.RedisConf{Host: mr.Addr(), Type: redis.NodeType})
+	if err != nil {
+		t.Fatalf("NewRedis: %v", err)
+	}
+	ctx := context.Background()
+
+	var first, second countingPurger
+	leader := job.NewPurgeJob(newServiceContext(rds), &first)
+	follower := job.NewPurgeJob(newServiceContext(rds), &second)
+
+	// 只有持有租约的副本执行清理，领导者可以连续执行，租约覆盖两个周期
+	for i := 0; i < 2; i++ {
+		result, ran, err := leader.RunOnce(ctx)
+		if err != nil || !ran || result.Codes != 10 {
+			t.Fatalf("leader RunOnce: %+v, %v, %v", result, ran, err)
+		}
+		if _, ran, err := follower.RunOnce(ctx); err != nil || ran {
+			t.Fatalf("follower RunOnce: %v, %v", ran, err)
+		}
+	}
+	if first.runs != 2 || second.runs != 0 {
+		t.Fatalf("purges: leader %d, follower %d", first.runs, second.runs)
+	}
+	if ttl := mr.TTL(job.PurgeLeaderKey); ttl < 2*time.Minute || ttl > 2*time.Minute+time.Second {
+		t.Fatalf("lease ttl: %v", ttl)
+	}
+
+	// 领导者停止后释放租约，其他副本立即接管
+	leader.Stop()
+	if _, ran, err := follower.RunOnce(ctx); err != nil || !ran {
+		t.Fatalf("follower RunOnce after the leader stopped: %v, %v", ran, err)
+	}
+	if _, ran, err := leader.RunOnce(ctx); err != nil || ran {
+		t.Fatalf("stopped leader RunOnce: %v, %v", ran, err)
+	}
+	follower.Stop()
+}
+
+func TestPurgeJobWithoutRedis(t *testing.T) {
+	var purger countingPurger
+	j := job.NewPurgeJob(newServiceContext(nil), &purger)
+	defer j.Stop()
+
+	// 未配置Redis时单副本部署，每次都执行
+	for i := 0; i < 2; i++ {
+		if _, ran, err := j.RunOnce(context.Background()); err != nil || !ran {
+			t.Fatalf("RunOnce: %v, %v", ran, err)
+		}
+	}
+	if purger.runs != 2 {
+		t.Fatalf("purges: %d", purger.runs)
+	}
+}
@@ -0,0 +1,114 @@
+package migration
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MySQLDialect 基于 GET_LOCK 的会话级命名锁，使用 ? 占位符
+type MySQLDialect struct {
+	Timeout time.Duration
+}
+
+// Lock 获取命名锁，超时返回错误
+func (d MySQLDialect) Lock(ctx context.Context, conn *sql.Conn, name string) error {
+	var acquired sql.NullInt64
+	if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", name, int(d.Timeout.Seconds())).Scan(&acquired); err != nil {
+		return err
+	}
+	if !acquired.Valid || acquired.Int64 != 1 {
+		return fmt.Errorf("等待迁移锁 %s 超时", name)
+	}
+	return nil
+}
+
+// Unlock 释放命名锁
+func (d MySQLDialect) Unlock(ctx context.Context, conn *sql.Conn, name string) error {
+	_, err := conn.ExecContext(ctx, "SELECT RELEASE_LOCK(?)", name)
+	return err
+}
+
+// Rebind MySQL 直接使用 ? 占位符
+func (d MySQLDialect) Rebind(query string) string {
+	return query
+}
+
+// PostgresDialect 基于 pg_try_advisory_lock 的会话级咨询锁，使用 $n 占位符
+type PostgresDialect struct {
+	Timeout time.Duration
+}
+
+// Lock 轮询获取咨询锁，超时返回错误
+func (d PostgresDialect) Lock(ctx context.Context, conn *sql.Conn, name string) error {
+	deadline := time.Now().Add(d.Timeout)
+	for {
+		var acquired bool
+		if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock(hashtext($1))", name).Scan(&acquired); err != nil {
+			return err
+		}
+		if acquired {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("等待迁移锁 %s 超时", name)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// Unlock 释放咨询锁
+func (d PostgresDialect) Unlock(ctx context.Context, conn *sql.Conn, name string) error {
+	_, err := conn.ExecContext(ctx, "SELECT pg_advisory_unlock(hashtext($1))", name)
+	return err
+}
+
+// Rebind 将 ? 占位符依次替换为 $1, $2, ...
+func (d PostgresDialect) Rebind(query string) string {
+	return RebindDollar(query)
+}
+
+// SQLiteDialect 用于嵌入式单进程部署，SQLite 没有会话级的命名锁，
+// 并发执行同一迁移时版本表的主键冲突会使后执行的事务回滚
+type SQLiteDialect struct{}
+
+// Lock SQLite 不需要额外加锁
+func (d SQLiteDialect) Lock(ctx context.Context, conn *sql.Conn, name string) error {
+	return nil
+}
+
+// Unlock SQLite 不需要额外加锁
+func (d SQLiteDialect) Unlock(ctx context.Context, conn *sql.Conn, name string) error {
+	return nil
+}
+
+// Rebind SQLite 直接使用 ? 占位符
+func (d SQLiteDialect) Rebind(query string) string {
+	return query
+}
+
+// RebindDollar 将 ? 占位符依次替换为 $1, $2, ...，查询中不能包含字面量 ?
+func RebindDollar(query string) string {
+	var (
+		b strings.Builder
+		n int
+	)
+	b.Grow(len(query) + 8)
+	for _, r := range query {
+		if r != '?' {
+			b.WriteRune(r)
+			continue
+		}
+		n++
+		b.WriteByte('$')
+		b.WriteString(strconv.Itoa(n))
+	}
+	return b.String()
+}
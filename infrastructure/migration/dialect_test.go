@@ -0,0 +1,259 @@
+package migration_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"oauth2/infrastructure/migration"
+
+	sqle "github.com/dolthub/go-mysql-server"
+	"github.com/dolthub/go-mysql-server/memory"
+	"github.com/dolthub/go-mysql-server/server"
+	_ "github.com/go-sql-driver/mysql"
+)
+
+func TestMySQLDialectLock(t *testing.T) {
+	ctx := context.Background()
+	db, err := sql.Open("mysql", startMySQL(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	first, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	second, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+
+	d := migration.MySQLDialect{}
+	if err := d.Lock(ctx, first, "migrations"); err != nil {
+		t.Fatalf("Lock: %v", err)
+	}
+	// 其他会话持有锁时超时返回错误，释放后即可获取
+	if err := d.Lock(ctx, second, "migrations"); err == nil || !strings.Contains(err.Error(), "超时") {
+		t.Fatalf("Lock held by another session = %v, want timeout", err)
+	}
+	if err := d.Unlock(ctx, first, "migrations"); err != nil {
+		t.Fatalf("Unlock: %v", err)
+	}
+	if err := d.Lock(ctx, second, "migrations"); err != nil {
+		t.Fatalf("Lock after Unlock: %v", err)
+	}
+	if err := d.Unlock(ctx, second, "migrations"); err != nil {
+		t.Fatalf("Unlock: %v", err)
+	}
+}
+
+func TestMySQLMigratorConcurrentUp(t *testing.T) {
+	ctx := context.Background()
+	db, err := sql.Open("mysql", startMySQL(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	migrations := []migration.Migration{
+		{Version: 1, Name: "init", Up: migration.SQL("CREATE TABLE t_a (id int NOT NULL PRIMARY KEY);")},
+		{Version: 2, Name: "data", Up: migration.SQL("INSERT INTO t_a (id) VALUES (1);")},
+	}
+
+	// 多个实例同时启动时每个迁移只执行一次
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		executed int
+		errs     []error
+	)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m := migration.NewMigrator(db, "t_", migration.MySQLDialect{Timeout: 10 * time.Second}, migrations)
+			result, err := m.Up(ctx)
+			mu.Lock()
+			defer mu.Unlock()
+			executed += len(result)
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if len(errs) > 0 || executed != len(migrations) {
+		t.Fatalf("executed %d migrations with errors %v, want %d", executed, errs, len(migrations))
+	}
+
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM t_a").Scan(&n); err != nil || n != 1 {
+		t.Fatalf("rows = %d, %v, want 1", n, err)
+	}
+}
+
+func TestPostgresDialectLock(t *testing.T) {
+	ctx := context.Background()
+	lock := &advisoryLock{}
+	sql.Register("migration-test-postgres", lock)
+	db, err := sql.Open("migration-test-postgres", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	d := migration.PostgresDialect{}
+	if err := d.Lock(ctx, conn, "t_schema_migrations"); err != nil {
+		t.Fatalf("Lock: %v", err)
+	}
+	// 锁被其他会话持有时轮询到超时为止
+	if err := d.Lock(ctx, conn, "t_schema_migrations"); err == nil || !strings.Contains(err.Error(), "超时") {
+		t.Fatalf("Lock held = %v, want timeout", err)
+	}
+	if err := d.Unlock(ctx, conn, "t_schema_migrations"); err != nil {
+		t.Fatalf("Unlock: %v", err)
+	}
+	if err := d.Lock(ctx, conn, "t_schema_migrations"); err != nil {
+		t.Fatalf("Lock after Unlock: %v", err)
+	}
+
+	want := []string{
+		"SELECT pg_try_advisory_lock(hashtext($1)) [t_schema_migrations]",
+		"SELECT pg_try_advisory_lock(hashtext($1)) [t_schema_migrations]",
+		"SELECT pg_advisory_unlock(hashtext($1)) [t_schema_migrations]",
+		"SELECT pg_try_advisory_lock(hashtext($1)) [t_schema_migrations]",
+	}
+	if got := lock.queries; strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("queries = %q, want %q", got, want)
+	}
+}
+
+func TestRebindDollar(t *testing.T) {
+	tests := map[string]string{
+		"SELECT 1":                         "SELECT 1",
+		"DELETE FROM t WHERE version = ?":  "DELETE FROM t WHERE version = $1",
+		"INSERT INTO t VALUES (?, ?, ?)":   "INSERT INTO t VALUES ($1, $2, $3)",
+		"UPDATE t SET 名称 = ? WHERE id = ?": "UPDATE t SET 名称 = $1 WHERE id = $2",
+	}
+	for query, want := range tests {
+		if got := migration.RebindDollar(query); got != want {
+			t.Errorf("RebindDollar(%q) = %q, want %q", query, got, want)
+		}
+	}
+	if got := (migration.PostgresDialect{}).Rebind("a = ?"); got != "a = $1" {
+		t.Errorf("PostgresDialect.Rebind = %q", got)
+	}
+	if got := (migration.MySQLDialect{}).Rebind("a = ?"); got != "a = ?" {
+		t.Errorf("MySQLDialect.Rebind = %q", got)
+	}
+}
+
+// startMySQL starts an in-process MySQL compatible server and returns its DSN.
+func startMySQL(t *testing.T) string {
+	t.Helper()
+	db := memory.NewDatabase("oauth2")
+	db.EnablePrimaryKeyIndexes()
+	engine := sqle.NewDefault(memory.NewDBProvider(db))
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	s, err := server.NewDefaultServer(server.Config{Protocol: "tcp", Address: addr}, engine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	t.Cleanup(func() { s.Close() })
+	return fmt.Sprintf("root:@tcp(%s)/oauth2?parseTime=true", addr)
+}
+
+// advisoryLock is a database/sql driver that answers the advisory lock queries of
+// PostgresDialect like a single PostgreSQL lock held by whichever session took it first.
+type advisoryLock struct {
+	mu      sync.Mutex
+	held    bool
+	queries []string
+}
+
+func (l *advisoryLock) Open(string) (driver.Conn, error) { return advisoryConn{l}, nil }
+
+type advisoryConn struct{ lock *advisoryLock }
+
+func (c advisoryConn) Prepare(query string) (driver.Stmt, error) {
+	return advisoryStmt{lock: c.lock, query: query}, nil
+}
+func (c advisoryConn) Close() error              { return nil }
+func (c advisoryConn) Begin() (driver.Tx, error) { return nil, fmt.Errorf("不支持事务") }
+
+type advisoryStmt struct {
+	lock  *advisoryLock
+	query string
+}
+
+func (s advisoryStmt) Close() error  { return nil }
+func (s advisoryStmt) NumInput() int { return -1 }
+
+func (s advisoryStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.run(args)
+	return driver.RowsAffected(0), nil
+}
+
+func (s advisoryStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return &advisoryRows{acquired: s.run(args)}, nil
+}
+
+// run records the query and returns whether it took the lock.
+func (s advisoryStmt) run(args []driver.Value) bool {
+	l := s.lock
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.queries = append(l.queries, fmt.Sprintf("%s %v", s.query, args))
+	switch {
+	case strings.Contains(s.query, "pg_try_advisory_lock"):
+		if l.held {
+			return false
+		}
+		l.held = true
+		return true
+	case strings.Contains(s.query, "pg_advisory_unlock"):
+		l.held = false
+	}
+	return false
+}
+
+type advisoryRows struct {
+	acquired bool
+	done     bool
+}
+
+func (r *advisoryRows) Columns() []string { return []string{"pg_try_advisory_lock"} }
+func (r *advisoryRows) Close() error      { return nil }
+
+func (r *advisoryRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = r.acquired
+	return nil
+}
@@ -0,0 +1,143 @@
+// Package migration 按版本号执行数据库迁移，已执行的版本记录在版本表中，多个实例同时启动时由方言提供的锁保证只执行一次。
+//
+// 迁移脚本按行切分为语句：去掉首尾空白后以分号结尾的行结束一条语句，因此每条语句必须在自己最后一行的行尾
+// 以分号结束，一行中也不能写多条语句。行中间的分号(如字符串字面量 'a;b')不会切分语句，但以分号结尾的行
+// 无论是否在字符串或注释中都会结束语句，脚本中需要避免这样的多行字符串和注释。只包含 -- 注释的片段会被忽略，
+// 最后一条语句可以省略分号。脚本中的 {prefix} 在加载时替换为表前缀。
+package migration
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Step 在事务中执行的一次迁移操作
+type Step func(ctx context.Context, tx *sql.Tx) error
+
+// Migration 一个带版本号的迁移，包含升级和回滚两个方向
+type Migration struct {
+	Version int64
+	Name    string
+	Up      Step
+	Down    Step
+}
+
+var fileNamePattern = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// FromFS 从目录中加载形如 0001_init.up.sql / 0001_init.down.sql 的迁移脚本，
+// 脚本中的 {prefix} 会被替换为表前缀
+func FromFS(fsys fs.FS, dir, tablePrefix string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("读取迁移目录失败: %v", err)
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		matches := fileNamePattern.FindStringSubmatch(entry.Name())
+		if matches == nil {
+			continue
+		}
+		version, err := strconv.ParseInt(matches[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("迁移版本号无效 %s: %v", entry.Name(), err)
+		}
+		content, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("读取迁移脚本失败 %s: %v", entry.Name(), err)
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: matches[2]}
+			byVersion[version] = m
+		} else if m.Name != matches[2] {
+			return nil, fmt.Errorf("迁移版本号重复: %d", version)
+		}
+
+		step := SQL(strings.ReplaceAll(string(content), "{prefix}", tablePrefix))
+		if matches[3] == "up" {
+			m.Up = step
+		} else {
+			m.Down = step
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == nil {
+			return nil, fmt.Errorf("迁移 %d_%s 缺少 up 脚本", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	Sort(migrations)
+	return migrations, nil
+}
+
+// SQL 将一段脚本拆分为多条语句依次执行
+func SQL(script string) Step {
+	statements := splitStatements(script)
+	return func(ctx context.Context, tx *sql.Tx) error {
+		for _, statement := range statements {
+			if _, err := tx.ExecContext(ctx, statement); err != nil {
+				return fmt.Errorf("%v: %s", err, statement)
+			}
+		}
+		return nil
+	}
+}
+
+// Sort 按版本号升序排列迁移
+func Sort(migrations []Migration) {
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+}
+
+// splitStatements 以行尾的分号切分语句，并忽略只包含注释的片段
+func splitStatements(script string) []string {
+	var (
+		statements []string
+		current    strings.Builder
+	)
+	flush := func() {
+		statement := strings.TrimSpace(current.String())
+		current.Reset()
+		if statement != "" && !onlyComments(statement) {
+			statements = append(statements, statement)
+		}
+	}
+
+	for _, line := range strings.Split(script, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasSuffix(trimmed, ";") {
+			current.WriteString(strings.TrimSuffix(trimmed, ";"))
+			flush()
+			continue
+		}
+		current.WriteString(line)
+		current.WriteString("\n")
+	}
+	flush()
+	return statements
+}
+
+func onlyComments(statement string) bool {
+	for _, line := range strings.Split(statement, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "--") {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,264 @@
+package migration_test
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"oauth2/infrastructure/migration"
+
+	_ "modernc.org/sqlite"
+)
+
+var testMigrations = fstest.MapFS{
+	// 文件顺序与版本号无关，加载后按版本号排序
+	"migrations/0002_users.up.sql": {Data: []byte(`-- 用户表
+CREATE TABLE {prefix}users (
+	id   integer NOT NULL PRIMARY KEY,
+	name varchar(64) NOT NULL
+);
+
+-- 只包含注释的片段被忽略;
+INSERT INTO {prefix}users (id, name) VALUES (1, 'a;b');
+INSERT INTO {prefix}users (id, name)
+VALUES (2, 'c')
+`)},
+	"migrations/0002_users.down.sql":    {Data: []byte("DROP TABLE {prefix}users;\n")},
+	"migrations/0010_index.up.sql":      {Data: []byte("CREATE INDEX {prefix}users_name ON {prefix}users (name);\n")},
+	"migrations/0010_index.down.sql":    {Data: []byte("DROP INDEX {prefix}users_name;\n")},
+	"migrations/0001_settings.up.sql":   {Data: []byte("CREATE TABLE {prefix}settings (name varchar(64) NOT NULL PRIMARY KEY);\n")},
+	"migrations/0001_settings.down.sql": {Data: []byte("DROP TABLE {prefix}settings;\n")},
+	"migrations/README.md":              {Data: []byte("不是迁移脚本")},
+	"migrations/old/0003_old.up.sql":    {Data: []byte("不会被加载;")},
+}
+
+func openSQLite(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "migration.db")+"?_time_format=sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func versions(migrations []migration.Migration) []int64 {
+	var result []int64
+	for _, m := range migrations {
+		result = append(result, m.Version)
+	}
+	return result
+}
+
+func tableExists(t *testing.T, db *sql.DB, name string) bool {
+	t.Helper()
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name = ?", name).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n > 0
+}
+
+func TestFromFS(t *testing.T) {
+	migrations, err := migration.FromFS(testMigrations, "migrations", "t_")
+	if err != nil {
+		t.Fatalf("FromFS: %v", err)
+	}
+	if got, want := versions(migrations), []int64{1, 2, 10}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("versions = %v, want %v", got, want)
+	}
+	for i, name := range []string{"settings", "users", "index"} {
+		if migrations[i].Name != name || migrations[i].Up == nil || migrations[i].Down == nil {
+			t.Fatalf("migration %d = %+v, want %s with up and down", i, migrations[i], name)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		files fstest.MapFS
+		err   string
+	}{
+		{
+			name: "missing up",
+			files: fstest.MapFS{
+				"migrations/0001_init.down.sql": {Data: []byte("DROP TABLE t;")},
+			},
+			err: "缺少 up 脚本",
+		},
+		{
+			name: "duplicate version",
+			files: fstest.MapFS{
+				"migrations/0001_init.up.sql":  {Data: []byte("CREATE TABLE a (id integer);")},
+				"migrations/0001_other.up.sql": {Data: []byte("CREATE TABLE b (id integer);")},
+			},
+			err: "迁移版本号重复",
+		},
+		{
+			name:  "missing dir",
+			files: fstest.MapFS{},
+			err:   "读取迁移目录失败",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := migration.FromFS(test.files, "migrations", "")
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("FromFS error = %v, want %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestMigrator(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+	migrations, err := migration.FromFS(testMigrations, "migrations", "t_")
+	if err != nil {
+		t.Fatalf("FromFS: %v", err)
+	}
+	// 迁移器自己按版本号排序
+	reversed := make([]migration.Migration, len(migrations))
+	for i, m := range migrations {
+		reversed[len(migrations)-1-i] = m
+	}
+	m := migration.NewMigrator(db, "t_", migration.SQLiteDialect{}, reversed)
+
+	executed, err := m.Up(ctx)
+	if err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+	if got, want := versions(executed), []int64{1, 2, 10}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("executed = %v, want %v", got, want)
+	}
+	for _, table := range []string{"t_schema_migrations", "t_settings", "t_users", "t_users_name"} {
+		if !tableExists(t, db, table) {
+			t.Fatalf("%s not created", table)
+		}
+	}
+
+	// 行中间的分号不切分语句，跨行的语句和省略分号的最后一条语句完整执行
+	var names []string
+	rows, err := db.Query("SELECT name FROM t_users ORDER BY id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+	rows.Close()
+	if want := []string{"a;b", "c"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("users = %v, want %v", names, want)
+	}
+
+	if executed, err := m.Up(ctx); err != nil || len(executed) != 0 {
+		t.Fatalf("second Up = %v, %v, want nothing executed", versions(executed), err)
+	}
+
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	for _, s := range statuses {
+		if !s.Applied || s.AppliedAt.IsZero() {
+			t.Fatalf("status %+v, want applied", s)
+		}
+	}
+
+	reverted, err := m.Down(ctx, 2)
+	if err != nil {
+		t.Fatalf("Down: %v", err)
+	}
+	if got, want := versions(reverted), []int64{10, 2}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("reverted = %v, want %v", got, want)
+	}
+	if tableExists(t, db, "t_users") || !tableExists(t, db, "t_settings") {
+		t.Fatal("Down(2) should drop users and keep settings")
+	}
+	statuses, err = m.Status(ctx)
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	for _, s := range statuses {
+		if s.Applied != (s.Version == 1) {
+			t.Fatalf("status %+v after Down(2)", s)
+		}
+	}
+
+	// 重新升级只执行被回滚的迁移
+	if executed, err := m.Up(ctx); err != nil || !reflect.DeepEqual(versions(executed), []int64{2, 10}) {
+		t.Fatalf("Up after Down = %v, %v", versions(executed), err)
+	}
+}
+
+func TestMigratorPrefix(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+
+	// 不同表前缀的迁移互不影响
+	for _, prefix := range []string{"a_", "b_"} {
+		migrations, err := migration.FromFS(testMigrations, "migrations", prefix)
+		if err != nil {
+			t.Fatalf("FromFS: %v", err)
+		}
+		executed, err := migration.NewMigrator(db, prefix, migration.SQLiteDialect{}, migrations).Up(ctx)
+		if err != nil || len(executed) != 3 {
+			t.Fatalf("Up %s = %v, %v", prefix, versions(executed), err)
+		}
+		for _, table := range []string{"schema_migrations", "settings", "users"} {
+			if !tableExists(t, db, prefix+table) {
+				t.Fatalf("%s%s not created", prefix, table)
+			}
+		}
+	}
+	if tableExists(t, db, "{prefix}users") || tableExists(t, db, "users") {
+		t.Fatal("{prefix} not substituted")
+	}
+}
+
+func TestMigratorDownUnsupported(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+	m := migration.NewMigrator(db, "", migration.SQLiteDialect{}, []migration.Migration{
+		{Version: 1, Name: "init", Up: migration.SQL("CREATE TABLE a (id integer);"), Down: migration.SQL("DROP TABLE a;")},
+		{Version: 2, Name: "data", Up: migration.SQL("INSERT INTO a (id) VALUES (1);")},
+	})
+	if _, err := m.Up(ctx); err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+
+	reverted, err := m.Down(ctx, 2)
+	if err == nil || !strings.Contains(err.Error(), "不支持回滚") {
+		t.Fatalf("Down error = %v, want 不支持回滚", err)
+	}
+	if len(reverted) != 0 || !tableExists(t, db, "a") {
+		t.Fatalf("Down reverted %v, want nothing", versions(reverted))
+	}
+}
+
+func TestMigratorFailure(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+	m := migration.NewMigrator(db, "", migration.SQLiteDialect{}, []migration.Migration{
+		{Version: 1, Name: "broken", Up: migration.SQL("CREATE TABLE a (id integer);\nINSERT INTO missing (id) VALUES (1);")},
+	})
+
+	// 失败的迁移整体回滚，也不记录版本
+	if _, err := m.Up(ctx); err == nil || !strings.Contains(err.Error(), "执行迁移 1_broken 失败") {
+		t.Fatalf("Up error = %v", err)
+	}
+	if tableExists(t, db, "a") {
+		t.Fatal("failed migration not rolled back")
+	}
+	statuses, err := m.Status(ctx)
+	if err != nil || len(statuses) != 1 || statuses[0].Applied {
+		t.Fatalf("Status = %+v, %v, want not applied", statuses, err)
+	}
+}
@@ -0,0 +1,208 @@
+package migration
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Locker 保证同一时间只有一个实例执行迁移
+type Locker interface {
+	Lock(ctx context.Context, conn *sql.Conn, name string) error
+	Unlock(ctx context.Context, conn *sql.Conn, name string) error
+}
+
+// Dialect 屏蔽不同数据库在迁移锁和占位符上的差异
+type Dialect interface {
+	Locker
+	// Rebind 将 ? 占位符转换为数据库使用的占位符
+	Rebind(query string) string
+}
+
+// Status 单个迁移的执行状态
+type Status struct {
+	Version   int64
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Migrator 负责执行迁移并在版本表中记录已执行的版本
+type Migrator struct {
+	db         *sql.DB
+	table      string
+	dialect    Dialect
+	migrations []Migration
+}
+
+// NewMigrator 创建迁移器，已执行的版本记录在 {tablePrefix}schema_migrations 表中
+func NewMigrator(db *sql.DB, tablePrefix string, dialect Dialect, migrations []Migration) *Migrator {
+	sorted := append([]Migration(nil), migrations...)
+	Sort(sorted)
+	return &Migrator{
+		db:         db,
+		table:      tablePrefix + "schema_migrations",
+		dialect:    dialect,
+		migrations: sorted,
+	}
+}
+
+// Up 执行所有未执行的迁移，返回本次执行的迁移
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	var executed []Migration
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, migration := range m.migrations {
+			if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+			if err := m.run(ctx, conn, migration, true); err != nil {
+				return err
+			}
+			executed = append(executed, migration)
+		}
+		return nil
+	})
+	return executed, err
+}
+
+// Down 按版本号倒序回滚最近执行的 steps 个迁移，返回本次回滚的迁移
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	var reverted []Migration
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for i := len(m.migrations) - 1; i >= 0 && len(reverted) < steps; i-- {
+			migration := m.migrations[i]
+			if _, ok := applied[migration.Version]; !ok {
+				continue
+			}
+			if migration.Down == nil {
+				return fmt.Errorf("迁移 %d_%s 不支持回滚", migration.Version, migration.Name)
+			}
+			if err := m.run(ctx, conn, migration, false); err != nil {
+				return err
+			}
+			reverted = append(reverted, migration)
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// Status 返回所有已知迁移的执行状态
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	var statuses []Status
+	err := m.withConn(ctx, func(conn *sql.Conn) error {
+		if err := m.ensureTable(ctx, conn); err != nil {
+			return err
+		}
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, migration := range m.migrations {
+			appliedAt, ok := applied[migration.Version]
+			statuses = append(statuses, Status{
+				Version:   migration.Version,
+				Name:      migration.Name,
+				Applied:   ok,
+				AppliedAt: appliedAt,
+			})
+		}
+		return nil
+	})
+	return statuses, err
+}
+
+func (m *Migrator) withConn(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("获取数据库连接失败: %v", err)
+	}
+	defer conn.Close()
+	return fn(conn)
+}
+
+// withLock 在持有迁移锁的同一连接上执行 fn
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	return m.withConn(ctx, func(conn *sql.Conn) error {
+		if err := m.dialect.Lock(ctx, conn, m.table); err != nil {
+			return fmt.Errorf("获取迁移锁失败: %v", err)
+		}
+		defer m.dialect.Unlock(context.Background(), conn, m.table)
+
+		if err := m.ensureTable(ctx, conn); err != nil {
+			return err
+		}
+		return fn(conn)
+	})
+}
+
+func (m *Migrator) ensureTable(ctx context.Context, conn *sql.Conn) error {
+	_, err := conn.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	version    bigint NOT NULL PRIMARY KEY,
+	name       varchar(255) NOT NULL,
+	applied_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
+)`, m.table))
+	if err != nil {
+		return fmt.Errorf("创建迁移版本表失败: %v", err)
+	}
+	return nil
+}
+
+func (m *Migrator) applied(ctx context.Context, conn *sql.Conn) (map[int64]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, fmt.Sprintf("SELECT version, applied_at FROM %s", m.table))
+	if err != nil {
+		return nil, fmt.Errorf("查询迁移版本失败: %v", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]time.Time)
+	for rows.Next() {
+		var (
+			version   int64
+			appliedAt time.Time
+		)
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, fmt.Errorf("查询迁移版本失败: %v", err)
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+// run 在事务中执行迁移并更新版本表
+func (m *Migrator) run(ctx context.Context, conn *sql.Conn, migration Migration, up bool) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	step, record := migration.Down, fmt.Sprintf("DELETE FROM %s WHERE version = ?", m.table)
+	args := []interface{}{migration.Version}
+	if up {
+		step, record = migration.Up, fmt.Sprintf("INSERT INTO %s (version, name, applied_at) VALUES (?, ?, ?)", m.table)
+		args = append(args, migration.Name, time.Now())
+	}
+
+	if err := step(ctx, tx); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("执行迁移 %d_%s 失败: %v", migration.Version, migration.Name, err)
+	}
+	if _, err := tx.ExecContext(ctx, m.dialect.Rebind(record), args...); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("记录迁移 %d_%s 失败: %v", migration.Version, migration.Name, err)
+	}
+	if err := tx.Commit(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+		return fmt.Errorf("提交迁移 %d_%s 失败: %v", migration.Version, migration.Name, err)
+	}
+	return nil
+}
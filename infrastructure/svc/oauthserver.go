@@ -0,0 +1,62 @@
+package svc
+
+import (
+	"net/http"
+	"oauth2/application/service"
+	"oauth2/infrastructure/config"
+
+	"github.com/openshift/osin"
+)
+
+// Option 自定义ServiceContext的构建过程
+type Option func(*options)
+
+type options struct {
+	authorizeTokenGen osin.AuthorizeTokenGen
+	accessTokenGen    osin.AccessTokenGen
+}
+
+// WithAuthorizeTokenGen 使用自定义的授权码生成器
+func WithAuthorizeTokenGen(gen osin.AuthorizeTokenGen) Option {
+	return func(o *options) {
+		o.authorizeTokenGen = gen
+	}
+}
+
+// WithAccessTokenGen 使用自定义的访问令牌生成器
+func WithAccessTokenGen(gen osin.AccessTokenGen) Option {
+	return func(o *options) {
+		o.accessTokenGen = gen
+	}
+}
+
+// newOAuthServer 创建全局共享的OAuth服务器实例
+func newOAuthServer(c config.Config, storage osin.Storage, o *options) *osin.Server {
+	serverConfig := osin.NewServerConfig()
+	serverConfig.AllowedAuthorizeTypes = osin.AllowedAuthorizeType{osin.CODE}
+	serverConfig.AllowedAccessTypes = osin.AllowedAccessType{
+		osin.AUTHORIZATION_CODE,
+		osin.REFRESH_TOKEN,
+	}
+	serverConfig.AuthorizationExpiration = c.OAuth.AuthorizationExpiration
+	serverConfig.AccessExpiration = c.OAuth.AccessExpiration
+	serverConfig.AllowGetAccessRequest = true
+	// RFC 6749第5.2节：错误默认返回400，令牌接口再按错误码返回401、500等状态码
+	serverConfig.ErrorStatusCode = http.StatusBadRequest
+	// 动态注册的客户端可以有多个重定向URI
+	serverConfig.RedirectUriSeparator = service.RedirectUriSeparator
+	// 公开客户端没有密钥，授权码必须绑定PKCE
+	serverConfig.RequirePKCEForPublicClients = true
+	// 旧的刷新令牌由令牌接口在签发后撤销，osin忽略删除结果，无法拒绝并发使用同一刷新令牌的请求
+	serverConfig.RetainTokenAfterRefresh = true
+
+	server := osin.NewServer(serverConfig, storage)
+	if o.authorizeTokenGen != nil {
+		server.AuthorizeTokenGen = o.authorizeTokenGen
+	}
+	if o.accessTokenGen != nil {
+		server.AccessTokenGen = o.accessTokenGen
+	}
+
+	return server
+}
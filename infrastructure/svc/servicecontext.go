@@ -2,23 +2,49 @@ package svc
 
 import (
 	"context"
+	"database/sql"
+	"errors"
+	"oauth2/application/service"
+	commonredis "oauth2/common/redis"
+	"oauth2/common/util"
 	"oauth2/infrastructure/config"
+	"strings"
 
+	"github.com/go-sql-driver/mysql"
+	"github.com/openshift/osin"
 	"github.com/zeromicro/go-zero/core/logx"
 	"github.com/zeromicro/go-zero/core/stores/cache"
 	"github.com/zeromicro/go-zero/core/stores/redis"
 	"github.com/zeromicro/go-zero/core/stores/sqlx"
+	"github.com/zeromicro/go-zero/core/syncx"
 )
 
 type ServiceContext struct {
-	Config config.Config
-	DB     sqlx.SqlConn
-	Cache  cache.ClusterConf
-	Redis  *redis.Redis
+	Config      config.Config
+	DB          sqlx.SqlConn
+	Cache       cache.ClusterConf
+	Redis       *redis.Redis
+	ClientCache *service.CachedClientStorage // 未配置Redis或使用内存存储时为nil
+	AccessCache *service.CachedAccessStorage // 未配置Redis或未启用时为nil
+	Usage       *service.UsageTracker        // 未启用时为nil
+	Proxies     util.TrustedProxies          // 可信反向代理，为空时忽略转发头
+	Registrar   *service.Registrar           // 未开放动态注册时为nil
+	Scopes      *service.ScopeRegistry
+	Resources   *service.ResourceRegistry
+	Details     *service.AuthorizationDetailRegistry
+	Consent     *service.ConsentSigner // 未启用同意页面时为nil
+	Requests    *service.RequestObjectVerifier
+	Responses   *service.ResponseSigner // 未配置JARM签名密钥时为nil
+	Storage     service.OAuthStorage
+	OAuthServer *osin.Server
 }
 
-func NewServiceContext(c config.Config) *ServiceContext {
+func NewServiceContext(c config.Config, opts ...Option) *ServiceContext {
 	logger := logx.WithContext(context.Background())
+	// redis后端的授权码和令牌只保存在Redis中，未配置Redis时启动即失败，而不是在处理请求时崩溃
+	if c.Storage.Backend == "redis" && c.Redis.Host == "" {
+		logx.Must(errors.New("Storage.Backend为redis时必须配置Redis.Host"))
+	}
 	redisConf := redis.RedisConf{
 		Host: c.Redis.Host,
 		Type: c.Redis.Type,
@@ -31,16 +57,210 @@ func NewServiceContext(c config.Config) *ServiceContext {
 			Weight:    100,
 		},
 	}
-	conn := sqlx.NewMysql(c.DB.DataSource)
+	// 内存存储用于测试和单机开发，不依赖数据库和Redis
+	var (
+		conn        sqlx.SqlConn
+		redisClient *redis.Redis
+		err         error
+	)
+	if c.Storage.Backend != "memory" {
+		conn = NewSqlConn(c)
+	}
+	if c.Redis.Host != "" {
+		redisClient, err = redis.NewRedis(redisConf)
+		if err != nil {
+			logger.Errorf("Failed to create Redis client: %v", err)
+		}
+	}
+
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	var clientCache *service.CachedClientStorage
+	storageOpts := StorageOptions(c)
+	if c.Storage.Backend != "memory" {
+		for _, replica := range c.DB.Replicas {
+			storageOpts.Replicas = append(storageOpts.Replicas, newSqlConn(c, replica))
+		}
+		var clients service.ClientStorage = service.NewSQLClientStorage(conn, storageOpts)
+		if c.Redis.Host != "" {
+			clientCache, err = newClientCache(c, cacheConf, clients, storageOpts)
+			if err != nil {
+				logger.Errorf("Failed to create client cache: %v", err)
+			} else {
+				clients = clientCache
+			}
+		}
+		storageOpts.Clients = clients
+	}
+	var (
+		accessCache *service.CachedAccessStorage
+		storage     = newStorage(c, conn, storageOpts)
+	)
+	if c.Redis.Host != "" && c.AccessCache.Enabled {
+		invalidator := service.NewInvalidator(commonredis.Rdb, service.AccessInvalidationChannel(storageOpts.TablePrefix))
+		accessCache, err = service.NewCachedAccessStorage(storage, commonredis.Rdb, invalidator, storageOpts,
+			c.AccessCache.Expiry, c.AccessCache.LocalExpiry, c.AccessCache.LocalLimit)
+		if err != nil {
+			logger.Errorf("Failed to create access token cache: %v", err)
+		} else {
+			storage = accessCache
+		}
+	}
+
+	proxies, err := util.ParseTrustedProxies(c.TrustedProxies)
+	logx.Must(err)
+
+	var usage *service.UsageTracker
+	if c.Usage.Enabled {
+		// 在访问令牌缓存之外记录，缓存命中时同样生效
+		usage, err = service.NewUsageTracker(storage, storageOpts, c.Usage.Interval, c.Usage.Limit)
+		if err != nil {
+			logger.Errorf("Failed to create usage tracker: %v", err)
+		} else {
+			storage = usage
+		}
+	}
+
+	var (
+		scopeStorage      service.ScopeStorage                   = service.NewMemoryScopeStorage()
+		resourceStorage   service.ResourceStorage                = service.NewMemoryResourceStorage()
+		detailTypeStorage service.AuthorizationDetailTypeStorage = service.NewMemoryAuthorizationDetailTypeStorage()
+	)
+	if c.Storage.Backend != "memory" {
+		scopeStorage = service.NewSQLScopeStorage(conn, storageOpts)
+		resourceStorage = service.NewSQLResourceStorage(conn, storageOpts)
+		detailTypeStorage = service.NewSQLAuthorizationDetailTypeStorage(conn, storageOpts)
+	}
+	scopes, err := service.NewScopeRegistry(scopeStorage, c.OAuth.ScopeCacheExpiry)
+	logx.Must(err)
+	resources, err := service.NewResourceRegistry(resourceStorage, c.OAuth.ResourceCacheExpiry)
+	logx.Must(err)
+	details, err := service.NewAuthorizationDetailRegistry(detailTypeStorage, c.OAuth.DetailTypeCacheExpiry)
+	logx.Must(err)
+
+	// 配置Redis时请求对象的jti和已使用的同意票据在副本间共享
+	replay := service.NewReplayGuard(commonredis.Rdb)
+
+	var consent *service.ConsentSigner
+	if c.Consent.Enabled {
+		consent, err = service.NewConsentSigner(c.Consent.Secret, c.Consent.Expiry, replay)
+		logx.Must(err)
+	}
+
+	requests, err := service.NewRequestObjectVerifier(service.RequestObjectPolicy{
+		Issuer:          strings.TrimSuffix(c.Domain, "/"),
+		DecryptionKey:   c.RequestObject.DecryptionKey,
+		KeysCacheExpiry: c.RequestObject.KeysCacheExpiry,
+		HTTPClient:      util.NewPublicHttpClient(c.RequestObject.FetchTimeout),
+	}, replay)
+	logx.Must(err)
+
+	var responses *service.ResponseSigner
+	if c.Jarm.SigningKey != "" {
+		responses, err = service.NewResponseSigner(strings.TrimSuffix(c.Domain, "/"), c.Jarm.SigningKey, c.Jarm.Expiry)
+		logx.Must(err)
+	}
 
-	redisClient, err := redis.NewRedis(redisConf)
-	if err != nil {
-		logger.Errorf("Failed to create Redis client: %v", err)
+	var registrar *service.Registrar
+	if c.Registration.Enabled {
+		// Redis缓存的客户端没有明文密钥，注册管理接口从数据库读取客户端
+		var clients service.ClientStorage = storage
+		if clientCache != nil {
+			clients = clientCache.Uncached()
+		}
+		registrar = service.NewRegistrar(clients, storageOpts.Hasher, service.RegistrationPolicy{
+			InitialAccessTokens:      c.Registration.InitialAccessTokens,
+			RequireSoftwareStatement: c.Registration.RequireSoftwareStatement,
+			SoftwareStatementKey:     c.Registration.SoftwareStatementKey,
+			Endpoint:                 strings.TrimSuffix(c.Domain, "/") + "/v1/oauth/register",
+		})
 	}
+
 	return &ServiceContext{
-		Config: c,
-		DB:     conn,
-		Cache:  cacheConf,
-		Redis:  redisClient,
+		Config:      c,
+		DB:          conn,
+		Cache:       cacheConf,
+		Redis:       redisClient,
+		ClientCache: clientCache,
+		AccessCache: accessCache,
+		Usage:       usage,
+		Proxies:     proxies,
+		Registrar:   registrar,
+		Scopes:      scopes,
+		Resources:   resources,
+		Details:     details,
+		Consent:     consent,
+		Requests:    requests,
+		Responses:   responses,
+		Storage:     storage,
+		OAuthServer: newOAuthServer(c, storage, &o),
+	}
+}
+
+// newClientCache 创建进程内和Redis两级的客户端缓存
+func newClientCache(c config.Config, cacheConf cache.CacheConf, clients service.ClientStorage, opts service.Options) (*service.CachedClientStorage, error) {
+	redisCache := cache.New(cacheConf, syncx.NewSingleFlight(), cache.NewStat("client"), osin.ErrNotFound,
+		cache.WithExpiry(c.ClientCache.Expiry))
+	invalidator := service.NewInvalidator(commonredis.Rdb, service.ClientInvalidationChannel(opts.TablePrefix))
+	return service.NewCachedClientStorage(clients, redisCache, invalidator, opts, c.ClientCache.LocalExpiry, c.ClientCache.LocalLimit)
+}
+
+// NewSqlConn 根据配置的数据库驱动创建主库连接
+func NewSqlConn(c config.Config) sqlx.SqlConn {
+	return newSqlConn(c, c.DB.DataSource)
+}
+
+// newSqlConn 创建连接并应用连接池配置
+func newSqlConn(c config.Config, dataSource string) sqlx.SqlConn {
+	var (
+		driverName = c.DB.Driver
+		opts       []sqlx.SqlOption
+	)
+	switch c.DB.Driver {
+	case service.DriverPostgres:
+		driverName = "pgx"
+	case service.DriverSQLite:
+		dataSource = service.SQLiteDataSource(dataSource, c.DB.WAL)
+	default:
+		driverName = service.DriverMySQL
+		opts = append(opts, sqlx.WithAcceptable(isDuplicateEntry))
+	}
+
+	db, err := sql.Open(driverName, dataSource)
+	logx.Must(err)
+	db.SetMaxOpenConns(c.DB.MaxOpenConns)
+	db.SetMaxIdleConns(c.DB.MaxIdleConns)
+	db.SetConnMaxLifetime(c.DB.ConnMaxLifetime)
+	return sqlx.NewSqlConnFromDB(db, opts...)
+}
+
+// isDuplicateEntry 主键冲突属于业务错误，不计入熔断
+func isDuplicateEntry(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == 1062
+}
+
+// StorageOptions 根据配置生成存储选项
+func StorageOptions(c config.Config) service.Options {
+	return service.Options{
+		Driver:            c.DB.Driver,
+		TablePrefix:       c.OAuth.TablePrefix,
+		Hasher:            service.NewTokenHasher(c.OAuth.TokenPepper),
+		RefreshExpiration: c.OAuth.RefreshExpiration,
+	}
+}
+
+// newStorage 根据配置的存储后端创建存储
+func newStorage(c config.Config, conn sqlx.SqlConn, opts service.Options) service.OAuthStorage {
+	switch c.Storage.Backend {
+	case "memory":
+		return service.NewMemoryStorage(opts)
+	case "redis":
+		return service.NewRedisStorage(commonredis.Rdb, opts.Clients, opts)
+	default:
+		return service.NewStorage(conn, opts)
 	}
 }
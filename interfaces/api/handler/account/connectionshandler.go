@@ -0,0 +1,59 @@
+package account
+
+import (
+	"net/http"
+	"oauth2/application/service"
+	"oauth2/common/util"
+	"oauth2/infrastructure/svc"
+
+	"github.com/zeromicro/go-zero/rest/httpx"
+	"github.com/zeromicro/go-zero/rest/pathvar"
+)
+
+// ConnectionsHandler 列出当前用户已授权、仍持有令牌的应用和设备
+func ConnectionsHandler(svc *svc.ServiceContext) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := util.GetUserStrFromContext(r.Context())
+		if err != nil {
+			util.WriteError(w, r, http.StatusUnauthorized, "invalid_token", "缺少用户身份")
+			return
+		}
+
+		connections, err := service.UserConnections(r.Context(), svc.Storage, userID)
+		if err != nil {
+			util.WriteError(w, r, http.StatusInternalServerError, "server_error", err.Error())
+			return
+		}
+		if connections == nil {
+			connections = []service.Connection{}
+		}
+
+		httpx.OkJsonCtx(r.Context(), w, map[string]interface{}{
+			"connections": connections,
+		})
+	}
+}
+
+// RevokeConnectionHandler 撤销当前用户的一个授权，该授权的授权码和令牌立即失效
+func RevokeConnectionHandler(svc *svc.ServiceContext) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := util.GetUserStrFromContext(r.Context())
+		if err != nil {
+			util.WriteError(w, r, http.StatusUnauthorized, "invalid_token", "缺少用户身份")
+			return
+		}
+
+		result, err := svc.Storage.RevokeUserGrant(r.Context(), userID, pathvar.Vars(r)["grant_id"])
+		if err != nil {
+			util.WriteError(w, r, http.StatusInternalServerError, "server_error", err.Error())
+			return
+		}
+		// 不存在和属于其他用户的授权同样返回404
+		if result.Grants == 0 {
+			util.WriteError(w, r, http.StatusNotFound, "not_found", "授权不存在")
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
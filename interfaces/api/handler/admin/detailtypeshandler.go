@@ -0,0 +1,74 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"oauth2/application/service"
+	"oauth2/common/util"
+	"oauth2/infrastructure/svc"
+
+	"github.com/zeromicro/go-zero/rest/httpx"
+	"github.com/zeromicro/go-zero/rest/pathvar"
+)
+
+// ListDetailTypesHandler 列出已注册的授权详情类型
+func ListDetailTypesHandler(svc *svc.ServiceContext) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		types, err := svc.Details.List(r.Context())
+		if err != nil {
+			util.WriteError(w, r, http.StatusInternalServerError, "server_error", err.Error())
+			return
+		}
+
+		httpx.OkJsonCtx(r.Context(), w, map[string]interface{}{
+			"types": types,
+		})
+	}
+}
+
+// SaveDetailTypeHandler 注册或更新授权详情类型，schema 为该类型的JSON Schema，为空时不限制字段
+func SaveDetailTypeHandler(svc *svc.ServiceContext) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			util.WriteError(w, r, http.StatusBadRequest, "invalid_request", "无法解析请求参数")
+			return
+		}
+		schema := r.Form.Get("schema")
+		if schema == "" {
+			schema = "{}"
+		}
+		if !json.Valid([]byte(schema)) {
+			util.WriteError(w, r, http.StatusBadRequest, "invalid_request", "schema必须是JSON")
+			return
+		}
+		detailType := service.AuthorizationDetailType{
+			Type:        r.Form.Get("type"),
+			Description: r.Form.Get("description"),
+			Schema:      json.RawMessage(schema),
+		}
+
+		if err := svc.Details.Save(r.Context(), detailType); err != nil {
+			var detailErr *service.AuthorizationDetailsError
+			if errors.As(err, &detailErr) {
+				util.WriteError(w, r, http.StatusBadRequest, "invalid_request", detailErr.Description)
+			} else {
+				util.WriteError(w, r, http.StatusInternalServerError, "server_error", err.Error())
+			}
+			return
+		}
+
+		httpx.OkJsonCtx(r.Context(), w, detailType)
+	}
+}
+
+// RemoveDetailTypeHandler 删除授权详情类型，已签发授权中的该类型在换取或刷新令牌时失效
+func RemoveDetailTypeHandler(svc *svc.ServiceContext) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := svc.Details.Remove(r.Context(), pathvar.Vars(r)["type"]); err != nil {
+			util.WriteError(w, r, http.StatusInternalServerError, "server_error", err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,70 @@
+package admin
+
+import (
+	"errors"
+	"net/http"
+	"oauth2/application/service"
+	"oauth2/common/util"
+	"oauth2/infrastructure/svc"
+	"strings"
+
+	"github.com/zeromicro/go-zero/rest/httpx"
+)
+
+// ListResourcesHandler 列出已注册的资源
+func ListResourcesHandler(svc *svc.ServiceContext) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		resources, err := svc.Resources.List(r.Context())
+		if err != nil {
+			util.WriteError(w, r, http.StatusInternalServerError, "server_error", err.Error())
+			return
+		}
+
+		httpx.OkJsonCtx(r.Context(), w, map[string]interface{}{
+			"resources": resources,
+		})
+	}
+}
+
+// SaveResourceHandler 注册或更新资源，scopes 为资源接受的权限范围，空格分隔，为空时不限制
+func SaveResourceHandler(svc *svc.ServiceContext) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			util.WriteError(w, r, http.StatusBadRequest, "invalid_request", "无法解析请求参数")
+			return
+		}
+		resource := service.Resource{
+			Uri:         r.Form.Get("uri"),
+			Description: r.Form.Get("description"),
+			Scopes:      strings.Fields(r.Form.Get("scopes")),
+		}
+
+		if err := svc.Resources.Save(r.Context(), resource); err != nil {
+			var targetErr *service.TargetError
+			if errors.As(err, &targetErr) {
+				util.WriteError(w, r, http.StatusBadRequest, "invalid_request", targetErr.Description)
+			} else {
+				util.WriteError(w, r, http.StatusInternalServerError, "server_error", err.Error())
+			}
+			return
+		}
+
+		httpx.OkJsonCtx(r.Context(), w, resource)
+	}
+}
+
+// RemoveResourceHandler 删除资源，资源URI包含斜杠，由查询参数 uri 指定。已签发令牌的受众不变
+func RemoveResourceHandler(svc *svc.ServiceContext) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		uri := r.URL.Query().Get("uri")
+		if uri == "" {
+			util.WriteError(w, r, http.StatusBadRequest, "invalid_request", "缺少uri参数")
+			return
+		}
+		if err := svc.Resources.Remove(r.Context(), uri); err != nil {
+			util.WriteError(w, r, http.StatusInternalServerError, "server_error", err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
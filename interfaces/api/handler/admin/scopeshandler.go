@@ -0,0 +1,82 @@
+package admin
+
+import (
+	"errors"
+	"net/http"
+	"oauth2/application/service"
+	"oauth2/common/util"
+	"oauth2/infrastructure/svc"
+	"strconv"
+
+	"github.com/zeromicro/go-zero/rest/httpx"
+	"github.com/zeromicro/go-zero/rest/pathvar"
+)
+
+// ListScopesHandler 列出已注册的权限范围
+func ListScopesHandler(svc *svc.ServiceContext) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		scopes, err := svc.Scopes.List(r.Context())
+		if err != nil {
+			util.WriteError(w, r, http.StatusInternalServerError, "server_error", err.Error())
+			return
+		}
+
+		httpx.OkJsonCtx(r.Context(), w, map[string]interface{}{
+			"scopes": scopes,
+		})
+	}
+}
+
+// SaveScopeHandler 注册或更新权限范围，consent_required 默认为true，default 默认为false
+func SaveScopeHandler(svc *svc.ServiceContext) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			util.WriteError(w, r, http.StatusBadRequest, "invalid_request", "无法解析请求参数")
+			return
+		}
+		scope := service.Scope{
+			Name:            r.Form.Get("name"),
+			Description:     r.Form.Get("description"),
+			ConsentRequired: true,
+		}
+		for _, field := range []struct {
+			name string
+			dst  *bool
+		}{
+			{"consent_required", &scope.ConsentRequired},
+			{"default", &scope.Default},
+		} {
+			if value := r.Form.Get(field.name); value != "" {
+				parsed, err := strconv.ParseBool(value)
+				if err != nil {
+					util.WriteError(w, r, http.StatusBadRequest, "invalid_request", field.name+"必须是布尔值")
+					return
+				}
+				*field.dst = parsed
+			}
+		}
+
+		if err := svc.Scopes.Save(r.Context(), scope); err != nil {
+			var scopeErr *service.ScopeError
+			if errors.As(err, &scopeErr) {
+				util.WriteError(w, r, http.StatusBadRequest, "invalid_request", scopeErr.Description)
+			} else {
+				util.WriteError(w, r, http.StatusInternalServerError, "server_error", err.Error())
+			}
+			return
+		}
+
+		httpx.OkJsonCtx(r.Context(), w, scope)
+	}
+}
+
+// RemoveScopeHandler 删除权限范围，已签发令牌中的该权限范围在刷新时失效
+func RemoveScopeHandler(svc *svc.ServiceContext) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := svc.Scopes.Remove(r.Context(), pathvar.Vars(r)["name"]); err != nil {
+			util.WriteError(w, r, http.StatusInternalServerError, "server_error", err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
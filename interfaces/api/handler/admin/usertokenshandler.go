@@ -0,0 +1,60 @@
+package admin
+
+import (
+	"net/http"
+	"oauth2/common/util"
+	"oauth2/infrastructure/svc"
+
+	"github.com/zeromicro/go-zero/rest/httpx"
+)
+
+// ListUserTokensHandler 按客户端分组列出用户未过期的访问令牌和刷新令牌
+func ListUserTokensHandler(svc *svc.ServiceContext) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.URL.Query().Get("user_id")
+		if userID == "" {
+			util.WriteError(w, r, http.StatusBadRequest, "invalid_request", "缺少user_id参数")
+			return
+		}
+
+		clients, err := svc.Storage.ListUserTokens(r.Context(), userID)
+		if err != nil {
+			util.WriteError(w, r, http.StatusInternalServerError, "server_error", err.Error())
+			return
+		}
+
+		httpx.OkJsonCtx(r.Context(), w, map[string]interface{}{
+			"user_id": userID,
+			"clients": clients,
+		})
+	}
+}
+
+// RevokeUserTokensHandler 撤销用户的全部授权，指定client_id时只撤销该客户端的授权
+func RevokeUserTokensHandler(svc *svc.ServiceContext) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			util.WriteError(w, r, http.StatusBadRequest, "invalid_request", "无法解析请求参数")
+			return
+		}
+		userID := r.Form.Get("user_id")
+		if userID == "" {
+			util.WriteError(w, r, http.StatusBadRequest, "invalid_request", "缺少user_id参数")
+			return
+		}
+		clientID := r.Form.Get("client_id")
+
+		result, err := svc.Storage.RevokeUserTokens(r.Context(), userID, clientID)
+		if err != nil {
+			util.WriteError(w, r, http.StatusInternalServerError, "server_error", err.Error())
+			return
+		}
+
+		httpx.OkJsonCtx(r.Context(), w, map[string]interface{}{
+			"user_id":       userID,
+			"client_id":     clientID,
+			"revoked":       result.Grants,
+			"access_tokens": len(result.AccessTokens),
+		})
+	}
+}
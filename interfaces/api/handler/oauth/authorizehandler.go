@@ -2,33 +2,129 @@ package oauth
 
 import (
 	"net/http"
+	"oauth2/application/service"
 	"oauth2/infrastructure/svc"
 
 	"github.com/openshift/osin"
 )
 
-// AuthorizeHandler 处理获取用户课程的请求
+// AuthorizeHandler 处理授权请求，启用同意页面时同时处理同意页面提交的决定
 func AuthorizeHandler(svc *svc.ServiceContext) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		server := newOAuthServer(svc)
+		server := svc.OAuthServer
 		resp := server.NewResponse()
 		defer resp.Close()
+		// 响应模式校验通过前，重定向的错误以默认的query模式返回；重定向的错误都带上请求的state
+		var clientID, mode, state string
+		output := func() {
+			writeAuthorizeResponse(w, r, svc, resp, clientID, mode, state)
+		}
+
+		// RFC 9126: request_uri引用推送的授权请求时，授权参数取自推送的请求；
+		// RFC 9101: 否则请求对象中的参数优先于请求中的同名参数
+		pushed, ok := usePushedRequest(svc, resp, r)
+		signed := false
+		if ok && pushed == nil {
+			signed, ok = useRequestObject(svc, resp, r)
+		}
+		if !ok {
+			output()
+			return
+		}
 
 		if ar := server.HandleAuthorizeRequest(resp, r); ar != nil {
+			state = ar.State
 			// 验证客户端
 			if ar.Client == nil {
 				resp.SetError("unauthorized_client", "客户端未授权")
-				osin.OutputJSON(resp, w, r)
+				output()
+				return
+			}
+
+			// 验证响应模式，之后的响应都按该模式返回
+			client := service.AsClient(ar.Client)
+			requestedMode, description := responseModeOf(svc, client, r.Form.Get("response_mode"))
+			if description != "" {
+				resp.SetError("invalid_request", description)
+				output()
+				return
+			}
+			clientID, mode = client.Id, requestedMode
+
+			// 验证客户端状态、授权类型和PKCE要求，公开客户端的PKCE由osin校验
+			if !checkClient(resp, client, osin.AUTHORIZATION_CODE) {
+				output()
+				return
+			}
+			// 未指定scope时授予默认权限范围，否则只能申请已注册且客户端允许的权限范围
+			scope, err := svc.Scopes.Resolve(r.Context(), client, ar.Scope)
+			if err != nil {
+				setRequestError(resp, err)
+				output()
+				return
+			}
+			ar.Scope = scope
+			if client.RequirePkce && ar.CodeChallenge == "" {
+				resp.SetError("invalid_request", "客户端要求使用PKCE")
+				output()
 				return
 			}
 
 			// 验证重定向URI
 			if ar.RedirectUri == "" {
 				resp.SetError("invalid_request", "缺少重定向URI")
-				osin.OutputJSON(resp, w, r)
+				output()
 				return
 			}
 
+			// 记录授权的用户ID，用于按用户查询和撤销令牌
+			userID, consented, err := authorizingUser(svc, r)
+			if err != nil {
+				setRequestError(resp, err)
+				output()
+				return
+			}
+			if userID != "" {
+				ar.UserData = userID
+			}
+			// 同意页面只为通过了全部校验的请求签发票据，因此提交的决定不再要求推送或签名；
+			// 推送的请求在推送时已按客户端的要求校验过签名
+			if client.RequirePar && pushed == nil && !consented {
+				resp.SetError("invalid_request", "客户端要求先推送授权请求")
+				output()
+				return
+			}
+			if client.RequireSignedRequest && pushed == nil && !signed && !consented {
+				resp.SetError("invalid_request", "客户端要求使用签名的请求对象")
+				output()
+				return
+			}
+			// RFC 8707: resource 参数可重复，令牌的受众限制为这些已注册且接受该权限范围的资源；
+			// RFC 9396: authorization_details 的每一项必须符合已注册类型的schema，随授权保存
+			if ar.UserData, err = narrowGrant(r.Context(), svc, ar.UserData, r.Form, ar.Scope); err != nil {
+				setRequestError(resp, err)
+				output()
+				return
+			}
+
+			// 启用同意页面时，需要用户同意的权限范围和授权详情先展示给用户，用户提交决定后再签发授权码
+			if consented && r.PostForm.Get("decision") != "approve" {
+				resp.SetError("access_denied", "用户拒绝授权")
+				output()
+				return
+			}
+			if !consented {
+				page, err := consentPageOf(r.Context(), svc, client, ar, userID, r.Form)
+				if err != nil {
+					setRequestError(resp, err)
+					output()
+					return
+				}
+				if page != nil {
+					renderConsent(w, r, page)
+					return
+				}
+			}
 			ar.Authorized = true
 
 			// 完成授权请求,这里只会返回授权码
@@ -38,9 +134,12 @@ func AuthorizeHandler(svc *svc.ServiceContext) http.HandlerFunc {
 			if !resp.IsError {
 				resp.Type = osin.REDIRECT
 			}
+		} else if resp.Type == osin.REDIRECT {
+			// osin校验重定向URI之后发现的错误已带上state，按请求的响应模式重定向回客户端
+			clientID, mode = redirectErrorMode(svc, r)
 		}
 
-		// 输出响应(可能是重定向或错误信息)
-		osin.OutputJSON(resp, w, r)
+		// 按响应模式输出响应(重定向到客户端，或无法重定向时的错误页面)
+		output()
 	}
 }
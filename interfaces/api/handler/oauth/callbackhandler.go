@@ -25,7 +25,7 @@ func CallbackHandler(svc *svc.ServiceContext) http.HandlerFunc {
 		}
 
 		// 初始化 OAuth 服务器
-		server := newOAuthServer(svc)
+		server := svc.OAuthServer
 
 		// 先加载授权数据
 		authData, err := server.Storage.LoadAuthorize(code)
@@ -0,0 +1,122 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"oauth2/application/service"
+	"oauth2/infrastructure/svc"
+
+	"github.com/openshift/osin"
+)
+
+// checkClient 校验客户端的状态和允许的授权类型，不通过时在resp中设置错误
+func checkClient(resp *osin.Response, client *service.Client, grantType osin.AccessRequestType) bool {
+	switch {
+	case !client.IsActive():
+		resp.SetError("unauthorized_client", "客户端已停用")
+	case !client.AllowsGrantType(string(grantType)):
+		resp.SetError("unauthorized_client", "客户端不允许使用该授权类型")
+	default:
+		return true
+	}
+	return false
+}
+
+// authenticateClient 按令牌接口的方式认证客户端：机密客户端使用Basic认证，配置允许时也可以在参数中提供密钥；
+// 公开客户端没有密钥，只需提供client_id
+func authenticateClient(svc *svc.ServiceContext, r *http.Request) (*service.Client, bool) {
+	clientID, secret, ok := r.BasicAuth()
+	if !ok {
+		clientID = r.PostForm.Get("client_id")
+		if svc.OAuthServer.Config.AllowClientSecretInParams {
+			secret = r.PostForm.Get("client_secret")
+		}
+	}
+	if clientID == "" {
+		return nil, false
+	}
+	client, err := svc.OAuthServer.Storage.GetClient(clientID)
+	if err != nil || !osin.CheckClientSecret(client, secret) {
+		return nil, false
+	}
+	return service.AsClient(client), true
+}
+
+// requestError 返回校验授权请求失败的错误码和描述：权限范围不合法时为invalid_scope，资源不合法时为invalid_target，
+// 授权详情不合法时为invalid_authorization_details，请求对象不合法时为invalid_request_object等，
+// 同意票据无效时为invalid_request，其余错误为服务端错误
+func requestError(err error) (string, string) {
+	var (
+		scopeErr  *service.ScopeError
+		targetErr *service.TargetError
+		detailErr *service.AuthorizationDetailsError
+		objectErr *service.RequestObjectError
+	)
+	switch {
+	case errors.As(err, &objectErr):
+		return objectErr.Code, objectErr.Description
+	case errors.As(err, &scopeErr):
+		return "invalid_scope", scopeErr.Description
+	case errors.As(err, &targetErr):
+		return "invalid_target", targetErr.Description
+	case errors.As(err, &detailErr):
+		return "invalid_authorization_details", detailErr.Description
+	case errors.Is(err, service.ErrConsentTicket), errors.Is(err, errConsentDisabled):
+		return osin.E_INVALID_REQUEST, err.Error()
+	default:
+		return osin.E_SERVER_ERROR, "校验授权请求失败"
+	}
+}
+
+// setRequestError 按requestError在resp中设置错误，服务端错误同时记录原始错误
+func setRequestError(resp *osin.Response, err error) {
+	code, description := requestError(err)
+	resp.SetError(code, description)
+	if code == osin.E_SERVER_ERROR {
+		resp.InternalError = err
+	}
+}
+
+// narrowGrant 按请求的resource和authorization_details参数确定令牌的受众和授权详情，
+// 并校验受众资源已注册且接受scope、授权详情符合已注册类型的schema
+func narrowGrant(ctx context.Context, svc *svc.ServiceContext, userData interface{}, form url.Values, scope string) (interface{}, error) {
+	grant, err := service.GrantDataOf(userData).Narrow(form["resource"])
+	if err != nil {
+		return nil, err
+	}
+	details, err := service.ParseAuthorizationDetails(form.Get("authorization_details"))
+	if err != nil {
+		return nil, err
+	}
+	if grant, err = grant.NarrowAuthorizationDetails(details); err != nil {
+		return nil, err
+	}
+	if err := svc.Resources.Validate(ctx, grant.TokenAudience(), scope); err != nil {
+		return nil, err
+	}
+	if err := svc.Details.Validate(ctx, grant.TokenAuthorizationDetails()); err != nil {
+		return nil, err
+	}
+	// 既不限受众也没有授权详情的授权保留原有的UserData
+	if grant.UserOnly() {
+		return userData, nil
+	}
+	return grant, nil
+}
+
+// outputAuthorizationDetails RFC 9396第7节要求令牌响应返回令牌携带的授权详情
+func outputAuthorizationDetails(resp *osin.Response, userData interface{}) {
+	if details := service.GrantDataOf(userData).TokenAuthorizationDetails(); !resp.IsError && len(details) > 0 {
+		resp.Output["authorization_details"] = details
+	}
+}
+
+// accessExpiration 返回客户端的访问令牌有效期，未配置时使用服务端配置
+func accessExpiration(client *service.Client, def int32) int32 {
+	if client.AccessTokenLifetime > 0 {
+		return client.AccessTokenLifetime
+	}
+	return def
+}
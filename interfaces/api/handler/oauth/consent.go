@@ -0,0 +1,157 @@
+package oauth
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"oauth2/application/service"
+	"oauth2/common/util"
+	"oauth2/infrastructure/svc"
+	"sort"
+	"strings"
+
+	"github.com/openshift/osin"
+	"github.com/zeromicro/go-zero/core/logx"
+)
+
+// consentFields 是同意页面提交时附加的字段，不属于授权请求参数
+var consentFields = []string{"consent_ticket", "decision"}
+
+// errConsentDisabled 未启用同意页面时提交了决定
+var errConsentDisabled = errors.New("未启用同意页面")
+
+//go:embed consent.html
+var consentHTML string
+
+var consentTemplate = template.Must(template.New("consent").Parse(consentHTML))
+
+// consentPage 同意页面展示的内容
+type consentPage struct {
+	Action    string
+	Client    *service.Client
+	Scopes    []service.Scope
+	Resources []string
+	Details   []consentDetail
+	Params    []consentParam
+	Ticket    string
+}
+
+// consentDetail 同意页面展示的一项授权详情
+type consentDetail struct {
+	Type        string
+	Description string
+	Fields      []consentParam
+}
+
+// consentParam 名称和取值
+type consentParam struct {
+	Name  string
+	Value string
+}
+
+// authorizingUser 返回授权的用户以及用户是否已在同意页面做出决定。同意页面以POST提交，
+// 用户由页面中的票据确定；其余请求的用户取自登录的JWT
+func authorizingUser(svc *svc.ServiceContext, r *http.Request) (string, bool, error) {
+	if r.Method != http.MethodPost {
+		userID, _ := util.GetUserStrFromContext(r.Context())
+		return userID, false, nil
+	}
+	if svc.Consent == nil {
+		return "", false, errConsentDisabled
+	}
+	userID, err := svc.Consent.Verify(r.Context(), r.PostForm.Get("consent_ticket"), consentParams(r.Form))
+	if err != nil {
+		return "", false, err
+	}
+	return userID, true, nil
+}
+
+// consentParams 返回授权请求参数，去掉同意页面附加的字段
+func consentParams(form url.Values) url.Values {
+	params := make(url.Values, len(form))
+	for name, values := range form {
+		if !util.InArray(consentFields, name) {
+			params[name] = values
+		}
+	}
+	return params
+}
+
+// consentPageOf 返回需要展示的同意页面。未启用同意页面，或请求既没有授权详情也没有需要用户同意的权限范围时返回nil
+func consentPageOf(ctx context.Context, svc *svc.ServiceContext, client *service.Client, ar *osin.AuthorizeRequest,
+	userID string, form url.Values) (*consentPage, error) {
+	if svc.Consent == nil {
+		return nil, nil
+	}
+	grant := service.GrantDataOf(ar.UserData)
+	registered, err := svc.Scopes.Lookup(ctx)
+	if err != nil {
+		return nil, err
+	}
+	page := &consentPage{Client: client, Resources: grant.Resources}
+	needed := len(grant.AuthorizationDetails) > 0
+	for _, name := range strings.Fields(ar.Scope) {
+		scope := registered[name]
+		needed = needed || scope.ConsentRequired
+		page.Scopes = append(page.Scopes, scope)
+	}
+	if !needed {
+		return nil, nil
+	}
+
+	types, err := svc.Details.Lookup(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, detail := range grant.AuthorizationDetails {
+		page.Details = append(page.Details, consentDetail{
+			Type:        detail.Type(),
+			Description: types[detail.Type()].Description,
+			Fields:      detailFields(detail),
+		})
+	}
+
+	params := consentParams(form)
+	for name, values := range params {
+		for _, value := range values {
+			page.Params = append(page.Params, consentParam{Name: name, Value: value})
+		}
+	}
+	sort.SliceStable(page.Params, func(i, j int) bool { return page.Params[i].Name < page.Params[j].Name })
+	page.Ticket = svc.Consent.Sign(userID, params)
+	return page, nil
+}
+
+// detailFields 按名称排序返回授权详情中除type以外的字段，非字符串的取值以JSON展示
+func detailFields(detail service.AuthorizationDetail) []consentParam {
+	var fields []consentParam
+	for name, value := range detail {
+		if name == "type" {
+			continue
+		}
+		text, ok := value.(string)
+		if !ok {
+			data, _ := json.Marshal(value)
+			text = string(data)
+		}
+		fields = append(fields, consentParam{Name: name, Value: text})
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].Name < fields[j].Name })
+	return fields
+}
+
+// renderConsent 输出同意页面，页面包含只能提交一次的决定票据，不允许缓存，也不允许被嵌入其他页面
+func renderConsent(w http.ResponseWriter, r *http.Request, page *consentPage) {
+	page.Action = r.URL.Path
+	noStore(w)
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; img-src https:; form-action 'self'; frame-ancestors 'none'")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := consentTemplate.Execute(w, page); err != nil {
+		logx.WithContext(r.Context()).Errorf("渲染同意页面失败: %v", err)
+	}
+}
@@ -2,24 +2,21 @@ package oauth
 
 import (
 	"net/http"
-	"oauth2/application/service"
 	"oauth2/infrastructure/svc"
+
+	"github.com/openshift/osin"
 )
 
 // CreateClientHandler 处理创建客户端的请求
 func CreateClientHandler(svc *svc.ServiceContext) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		server := newOAuthServer(svc)
-		storage := server.Storage.(*service.Storage)
-
-		client := storage.CreateClientWithInformation(
-			"1234",      // client_id
-			"secret123", // client_secret
-			"http://127.0.0.1:8884/v1/oauth/callback", // redirect_uri
-			nil, // user_data
-		)
+		client := &osin.DefaultClient{
+			Id:          "1234",                                    // client_id
+			Secret:      "secret123",                               // client_secret
+			RedirectUri: "http://127.0.0.1:8884/v1/oauth/callback", // redirect_uri
+		}
 
-		if err := storage.CreateClient(client); err != nil {
+		if err := svc.Storage.CreateClient(client); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
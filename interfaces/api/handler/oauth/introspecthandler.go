@@ -0,0 +1,95 @@
+package oauth
+
+import (
+	"net/http"
+	"oauth2/application/service"
+	"oauth2/common/util"
+	"oauth2/infrastructure/svc"
+	"time"
+
+	"github.com/openshift/osin"
+	"github.com/zeromicro/go-zero/rest/httpx"
+)
+
+// IntrospectHandler 处理RFC 7662令牌自省请求，调用方需以机密客户端身份认证
+func IntrospectHandler(svc *svc.ServiceContext) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		noStore(w)
+		if err := r.ParseForm(); err != nil {
+			util.WriteError(w, r, http.StatusBadRequest, "invalid_request", "无法解析请求参数")
+			return
+		}
+
+		// 认证调用方，公开客户端没有密钥，不能自省令牌
+		if client, ok := authenticateClient(svc, r); !ok || client.IsPublic() {
+			w.Header().Set("WWW-Authenticate", `Basic realm="oauth2"`)
+			util.WriteError(w, r, http.StatusUnauthorized, "invalid_client", "客户端认证失败")
+			return
+		}
+
+		token := r.PostForm.Get("token")
+		if token == "" {
+			util.WriteError(w, r, http.StatusBadRequest, "invalid_request", "缺少token参数")
+			return
+		}
+
+		// token_type_hint只决定查找顺序，RFC 7662第2.1节要求找不到时继续查找其他类型
+		lookups := []func() map[string]interface{}{
+			func() map[string]interface{} { return introspectAccess(svc, token, r) },
+			func() map[string]interface{} { return introspectRefresh(svc, token) },
+		}
+		if r.PostForm.Get("token_type_hint") == "refresh_token" {
+			lookups[0], lookups[1] = lookups[1], lookups[0]
+		}
+		for _, lookup := range lookups {
+			if output := lookup(); output != nil {
+				httpx.OkJsonCtx(r.Context(), w, output)
+				return
+			}
+		}
+		httpx.OkJsonCtx(r.Context(), w, map[string]interface{}{"active": false})
+	}
+}
+
+// introspectAccess 返回访问令牌的自省结果，令牌无效或已过期时返回nil
+func introspectAccess(svc *svc.ServiceContext, token string, r *http.Request) map[string]interface{} {
+	accessData, err := loadAccess(svc, token, r)
+	if err != nil || accessData.IsExpiredAt(time.Now()) {
+		return nil
+	}
+	grant := service.GrantDataOf(accessData.UserData)
+	output := introspection(accessData, grant.TokenAudience(), grant.TokenAuthorizationDetails())
+	output["token_type"] = svc.OAuthServer.Config.TokenType
+	output["exp"] = accessData.ExpireAt().Unix()
+	return output
+}
+
+// introspectRefresh 返回刷新令牌的自省结果，受众和授权详情为授权的全部，令牌无效或已过期时返回nil
+func introspectRefresh(svc *svc.ServiceContext, token string) map[string]interface{} {
+	accessData, err := svc.OAuthServer.Storage.LoadRefresh(token)
+	if err != nil {
+		return nil
+	}
+	grant := service.GrantDataOf(accessData.UserData)
+	return introspection(accessData, grant.Resources, grant.AuthorizationDetails)
+}
+
+// introspection 返回令牌共有的自省字段
+func introspection(accessData *osin.AccessData, aud []string, details []service.AuthorizationDetail) map[string]interface{} {
+	output := map[string]interface{}{
+		"active":    true,
+		"client_id": accessData.Client.GetId(),
+		"scope":     accessData.Scope,
+		"iat":       accessData.CreatedAt.Unix(),
+	}
+	if sub := service.GrantDataOf(accessData.UserData).UserId; sub != "" {
+		output["sub"] = sub
+	}
+	if len(aud) > 0 {
+		output["aud"] = audience(aud)
+	}
+	if len(details) > 0 {
+		output["authorization_details"] = details
+	}
+	return output
+}
@@ -0,0 +1,125 @@
+package oauth
+
+import (
+	"net/http"
+	"oauth2/application/service"
+	"oauth2/common/util"
+	"oauth2/infrastructure/svc"
+	"sort"
+	"strings"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/openshift/osin"
+	"github.com/zeromicro/go-zero/core/logx"
+	"github.com/zeromicro/go-zero/rest/httpx"
+)
+
+// authorizationServerMetadata RFC 8414授权服务器元数据，包含RFC 9126、RFC 9101、RFC 9396和JARM定义的字段
+type authorizationServerMetadata struct {
+	Issuer                                    string   `json:"issuer"`
+	AuthorizationEndpoint                     string   `json:"authorization_endpoint"`
+	TokenEndpoint                             string   `json:"token_endpoint"`
+	IntrospectionEndpoint                     string   `json:"introspection_endpoint"`
+	PushedAuthorizationRequestEndpoint        string   `json:"pushed_authorization_request_endpoint"`
+	RegistrationEndpoint                      string   `json:"registration_endpoint,omitempty"`
+	JwksUri                                   string   `json:"jwks_uri,omitempty"`
+	ScopesSupported                           []string `json:"scopes_supported"`
+	ResponseTypesSupported                    []string `json:"response_types_supported"`
+	ResponseModesSupported                    []string `json:"response_modes_supported"`
+	GrantTypesSupported                       []string `json:"grant_types_supported"`
+	TokenEndpointAuthMethodsSupported         []string `json:"token_endpoint_auth_methods_supported"`
+	IntrospectionEndpointAuthMethodsSupported []string `json:"introspection_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported             []string `json:"code_challenge_methods_supported"`
+	AuthorizationDetailsTypesSupported        []string `json:"authorization_details_types_supported"`
+	RequestParameterSupported                 bool     `json:"request_parameter_supported"`
+	RequestUriParameterSupported              bool     `json:"request_uri_parameter_supported"`
+	RequireRequestUriRegistration             bool     `json:"require_request_uri_registration"`
+	RequestObjectSigningAlgValuesSupported    []string `json:"request_object_signing_alg_values_supported"`
+	RequestObjectEncryptionAlgValuesSupported []string `json:"request_object_encryption_alg_values_supported,omitempty"`
+	RequestObjectEncryptionEncValuesSupported []string `json:"request_object_encryption_enc_values_supported,omitempty"`
+	AuthorizationSigningAlgValuesSupported    []string `json:"authorization_signing_alg_values_supported,omitempty"`
+	RequirePushedAuthorizationRequests        bool     `json:"require_pushed_authorization_requests"`
+}
+
+// MetadataHandler 返回RFC 8414授权服务器元数据，客户端据此发现各接口地址和支持的能力
+func MetadataHandler(svc *svc.ServiceContext) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		scopes, err := svc.Scopes.Lookup(r.Context())
+		if err != nil {
+			logx.WithContext(r.Context()).Errorf("Load scopes failed: %v", err)
+			util.WriteError(w, r, http.StatusInternalServerError, osin.E_SERVER_ERROR, "加载权限范围失败")
+			return
+		}
+		detailTypes, err := svc.Details.Lookup(r.Context())
+		if err != nil {
+			logx.WithContext(r.Context()).Errorf("Load authorization detail types failed: %v", err)
+			util.WriteError(w, r, http.StatusInternalServerError, osin.E_SERVER_ERROR, "加载授权详情类型失败")
+			return
+		}
+
+		issuer := strings.TrimSuffix(svc.Config.Domain, "/")
+		authMethods := []string{"client_secret_basic"}
+		if svc.OAuthServer.Config.AllowClientSecretInParams {
+			authMethods = append(authMethods, "client_secret_post")
+		}
+		metadata := authorizationServerMetadata{
+			Issuer:                                    issuer,
+			AuthorizationEndpoint:                     issuer + "/v1/oauth/authorize",
+			TokenEndpoint:                             issuer + "/v1/oauth/token",
+			IntrospectionEndpoint:                     issuer + "/v1/oauth/introspect",
+			PushedAuthorizationRequestEndpoint:        issuer + "/v1/oauth/par",
+			ScopesSupported:                           sortedKeys(scopes),
+			ResponseTypesSupported:                    []string{string(osin.CODE)},
+			ResponseModesSupported:                    service.ResponseModes(svc.Responses != nil),
+			GrantTypesSupported:                       []string{string(osin.AUTHORIZATION_CODE), string(osin.REFRESH_TOKEN)},
+			TokenEndpointAuthMethodsSupported:         append(append([]string(nil), authMethods...), "none"),
+			IntrospectionEndpointAuthMethodsSupported: authMethods,
+			CodeChallengeMethodsSupported:             codeChallengeMethods,
+			AuthorizationDetailsTypesSupported:        sortedKeys(detailTypes),
+			RequestParameterSupported:                 true,
+			RequestUriParameterSupported:              true,
+			RequestObjectSigningAlgValuesSupported:    svc.Requests.SigningAlgorithms(),
+		}
+		metadata.RequestObjectEncryptionAlgValuesSupported, metadata.RequestObjectEncryptionEncValuesSupported =
+			svc.Requests.EncryptionAlgorithms()
+		if svc.Registrar != nil {
+			metadata.RegistrationEndpoint = issuer + "/v1/oauth/register"
+		}
+		if len(serverKeys(svc).Keys) > 0 {
+			metadata.JwksUri = issuer + "/.well-known/jwks.json"
+		}
+		if svc.Responses != nil {
+			metadata.AuthorizationSigningAlgValuesSupported = []string{svc.Responses.Algorithm()}
+		}
+		httpx.OkJsonCtx(r.Context(), w, metadata)
+	}
+}
+
+// JwksHandler 返回服务端的公钥集：校验签名的授权响应(JARM)的公钥和加密请求对象使用的公钥
+func JwksHandler(svc *svc.ServiceContext) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httpx.OkJsonCtx(r.Context(), w, serverKeys(svc))
+	}
+}
+
+// serverKeys 返回服务端配置的公钥
+func serverKeys(svc *svc.ServiceContext) jose.JSONWebKeySet {
+	set := jose.JSONWebKeySet{Keys: []jose.JSONWebKey{}}
+	if svc.Responses != nil {
+		set.Keys = append(set.Keys, svc.Responses.PublicKey())
+	}
+	if key, ok := svc.Requests.EncryptionKey(); ok {
+		set.Keys = append(set.Keys, key)
+	}
+	return set
+}
+
+// sortedKeys 返回按名称排序的键
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,77 @@
+package oauth_test
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"oauth2/application/service"
+	"oauth2/interfaces/api/handler/oauth"
+
+	"github.com/openshift/osin"
+)
+
+// 元数据声明的每种PKCE方式都必须在换取令牌时真正校验 code_verifier
+func TestMetadataCodeChallengeMethods(t *testing.T) {
+	ctx := newServiceContext(t)
+	client := newClient(t, ctx, &service.Client{Id: "metadata"})
+
+	w := httptest.NewRecorder()
+	oauth.MetadataHandler(ctx)(w, httptest.NewRequest(http.MethodGet, "/.well-known/oauth-authorization-server", nil))
+	var metadata struct {
+		CodeChallengeMethodsSupported []string `json:"code_challenge_methods_supported"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &metadata); err != nil {
+		t.Fatalf("decode metadata: %v, %s", err, w.Body)
+	}
+	if len(metadata.CodeChallengeMethodsSupported) == 0 {
+		t.Fatal("metadata does not advertise any code challenge method")
+	}
+
+	token := oauth.TokenHandler(ctx)
+	verifier := "metadata-verifier-0123456789-abcdefghijklmnopqrstuvwxyz"
+	for _, method := range metadata.CodeChallengeMethodsSupported {
+		t.Run(method, func(t *testing.T) {
+			challenge := verifier
+			switch method {
+			case osin.PKCE_PLAIN:
+			case osin.PKCE_S256:
+				sum := sha256.Sum256([]byte(verifier))
+				challenge = base64.RawURLEncoding.EncodeToString(sum[:])
+			default:
+				t.Fatalf("metadata advertises unknown method %q", method)
+			}
+			code := "code-" + method
+			if err := ctx.Storage.SaveAuthorize(&osin.AuthorizeData{
+				Client:              client,
+				Code:                code,
+				ExpiresIn:           600,
+				RedirectUri:         client.RedirectUri,
+				CreatedAt:           time.Now(),
+				UserData:            "alice",
+				CodeChallenge:       challenge,
+				CodeChallengeMethod: method,
+			}); err != nil {
+				t.Fatalf("SaveAuthorize: %v", err)
+			}
+
+			form := url.Values{
+				"grant_type":   {"authorization_code"},
+				"code":         {code},
+				"redirect_uri": {client.RedirectUri},
+			}
+			if w := postForm(token, client, form); w.Code != http.StatusBadRequest {
+				t.Fatalf("token without code_verifier: status %d, %s", w.Code, w.Body)
+			}
+			form.Set("code_verifier", verifier)
+			if w := postForm(token, client, form); w.Code != http.StatusOK {
+				t.Fatalf("token with code_verifier: status %d, %s", w.Code, w.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package oauth
+
+import (
+	_ "embed"
+	"fmt"
+	"html/template"
+	"net/http"
+
+	"github.com/openshift/osin"
+	"github.com/zeromicro/go-zero/core/logx"
+)
+
+//go:embed authorizeerror.html
+var authorizeErrorHTML string
+
+var authorizeErrorTemplate = template.Must(template.New("authorize_error").Parse(authorizeErrorHTML))
+
+// authorizeErrorPage 授权错误页面展示的内容
+type authorizeErrorPage struct {
+	Error       string
+	Description string
+}
+
+// errorStatus 返回OAuth错误码对应的HTTP状态码：RFC 6749第5.2节要求客户端认证失败返回401，
+// 服务端错误和暂不可用返回5xx，其余错误返回400
+func errorStatus(errorID string) int {
+	switch errorID {
+	case osin.E_INVALID_CLIENT:
+		return http.StatusUnauthorized
+	case osin.E_SERVER_ERROR:
+		return http.StatusInternalServerError
+	case osin.E_TEMPORARILY_UNAVAILABLE:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+// writeTokenResponse 输出令牌接口的响应，错误按errorStatus设置状态码；客户端认证失败时
+// 同时返回WWW-Authenticate，提示客户端使用Basic认证
+func writeTokenResponse(w http.ResponseWriter, r *http.Request, resp *osin.Response) {
+	if resp.IsError {
+		resp.StatusCode = errorStatus(resp.ErrorId)
+		if resp.ErrorId == osin.E_INVALID_CLIENT {
+			w.Header().Set("WWW-Authenticate", `Basic realm="oauth2"`)
+		}
+	}
+	osin.OutputJSON(resp, w, r)
+}
+
+// renderAuthorizeError 输出授权错误页面。客户端或重定向URI未通过校验时不能把错误重定向回去，
+// RFC 6749第4.1.2.1节要求直接告知用户，以免把用户带到不可信的地址
+func renderAuthorizeError(w http.ResponseWriter, r *http.Request, resp *osin.Response) {
+	page := authorizeErrorPage{Error: resp.ErrorId}
+	if description, ok := resp.Output["error_description"]; ok {
+		page.Description = fmt.Sprint(description)
+	}
+	if resp.InternalError != nil {
+		logx.WithContext(r.Context()).Errorf("Authorize error: %s, %v", resp.ErrorId, resp.InternalError)
+	}
+	noStore(w)
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; frame-ancestors 'none'")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(errorStatus(resp.ErrorId))
+	if err := authorizeErrorTemplate.Execute(w, page); err != nil {
+		logx.WithContext(r.Context()).Errorf("渲染授权错误页面失败: %v", err)
+	}
+}
@@ -0,0 +1,208 @@
+package oauth
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"oauth2/application/service"
+	"oauth2/common/util"
+	"oauth2/infrastructure/svc"
+	"regexp"
+	"time"
+
+	"github.com/openshift/osin"
+	"github.com/zeromicro/go-zero/core/logx"
+	"github.com/zeromicro/go-zero/rest/httpx"
+)
+
+// codeChallengeMethods 是授权码绑定PKCE挑战时支持的计算方式，换取令牌时由osin校验 code_verifier，
+// 授权服务器元数据按此声明
+var codeChallengeMethods = []string{osin.PKCE_PLAIN, osin.PKCE_S256}
+
+var codeChallengePattern = regexp.MustCompile(`^[a-zA-Z0-9~._-]{43,128}$`)
+
+// PushedAuthorizationHandler 处理RFC 9126推送的授权请求：客户端认证后提交授权请求参数，换取短期有效、
+// 只能使用一次的request_uri，再以client_id和request_uri访问授权接口，授权参数不再经过浏览器
+func PushedAuthorizationHandler(svc *svc.ServiceContext) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		noStore(w)
+		if err := r.ParseForm(); err != nil {
+			util.WriteError(w, r, http.StatusBadRequest, "invalid_request", "无法解析请求参数")
+			return
+		}
+
+		client, ok := authenticateClient(svc, r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Basic realm="oauth2"`)
+			util.WriteError(w, r, http.StatusUnauthorized, "invalid_client", "客户端认证失败")
+			return
+		}
+
+		// 只接受请求体中的参数，客户端密钥不随请求保存
+		params := make(url.Values, len(r.PostForm))
+		for key, values := range r.PostForm {
+			if key != "client_secret" {
+				params[key] = values
+			}
+		}
+		if params.Get("request_uri") != "" {
+			util.WriteError(w, r, http.StatusBadRequest, osin.E_INVALID_REQUEST, "推送的授权请求不能包含request_uri")
+			return
+		}
+		// RFC 9126第3节：推送的请求可以是RFC 9101签名的请求对象，保存的是校验后的参数
+		params, signed, err := svc.Requests.Resolve(r.Context(), client, params)
+		if err != nil {
+			writeRequestError(w, r, err)
+			return
+		}
+		if client.RequireSignedRequest && !signed {
+			util.WriteError(w, r, http.StatusBadRequest, osin.E_INVALID_REQUEST, "客户端要求使用签名的请求对象")
+			return
+		}
+		if !checkPushedRequest(w, r, svc, client, params) {
+			return
+		}
+
+		requestURI, err := service.NewPushedRequestURI()
+		if err == nil {
+			expiresAt := time.Now().Add(time.Duration(svc.Config.OAuth.PushedRequestExpiration) * time.Second)
+			err = svc.Storage.SavePushedRequest(r.Context(), requestURI, &service.PushedRequest{
+				ClientId:  client.Id,
+				Params:    params,
+				ExpiresAt: expiresAt,
+			})
+		}
+		if err != nil {
+			logx.WithContext(r.Context()).Errorf("Push authorization request failed: %v", err)
+			util.WriteError(w, r, http.StatusInternalServerError, osin.E_SERVER_ERROR, "保存授权请求失败")
+			return
+		}
+
+		httpx.WriteJsonCtx(r.Context(), w, http.StatusCreated, map[string]interface{}{
+			"request_uri": requestURI,
+			"expires_in":  svc.Config.OAuth.PushedRequestExpiration,
+		})
+	}
+}
+
+// checkPushedRequest 按授权接口的规则预先校验推送的授权请求，不通过时输出错误。
+// 使用request_uri时授权接口会再完整校验一次，这里只让客户端尽早发现错误
+func checkPushedRequest(w http.ResponseWriter, r *http.Request, svc *svc.ServiceContext, client *service.Client, params url.Values) bool {
+	fail := func(status int, code, description string) bool {
+		util.WriteError(w, r, status, code, description)
+		return false
+	}
+
+	config := svc.OAuthServer.Config
+	switch {
+	case params.Get("client_id") != "" && params.Get("client_id") != client.Id:
+		return fail(http.StatusBadRequest, osin.E_INVALID_REQUEST, "client_id与认证的客户端不一致")
+	case !client.IsActive():
+		return fail(http.StatusBadRequest, osin.E_UNAUTHORIZED_CLIENT, "客户端已停用")
+	case !client.AllowsGrantType(string(osin.AUTHORIZATION_CODE)):
+		return fail(http.StatusBadRequest, osin.E_UNAUTHORIZED_CLIENT, "客户端不允许使用该授权类型")
+	case !config.AllowedAuthorizeTypes.Exists(osin.AuthorizeRequestType(params.Get("response_type"))):
+		return fail(http.StatusBadRequest, osin.E_UNSUPPORTED_RESPONSE_TYPE, "不支持的响应类型")
+	case (client.IsPublic() || client.RequirePkce) && params.Get("code_challenge") == "":
+		return fail(http.StatusBadRequest, osin.E_INVALID_REQUEST, "客户端要求使用PKCE")
+	case params.Get("code_challenge") != "" && !validCodeChallenge(params):
+		return fail(http.StatusBadRequest, osin.E_INVALID_REQUEST, "code_challenge或code_challenge_method无效")
+	}
+	params.Set("client_id", client.Id)
+
+	// 客户端只注册了一个重定向URI时可以省略
+	redirectURI := params.Get("redirect_uri")
+	if redirectURI == "" {
+		if osin.FirstUri(client.RedirectUri, config.RedirectUriSeparator) != client.RedirectUri {
+			return fail(http.StatusBadRequest, osin.E_INVALID_REQUEST, "缺少重定向URI")
+		}
+	} else if _, err := osin.ValidateUriList(client.RedirectUri, redirectURI, config.RedirectUriSeparator); err != nil {
+		return fail(http.StatusBadRequest, osin.E_INVALID_REQUEST, "重定向URI未在客户端注册")
+	}
+
+	scope, err := svc.Scopes.Resolve(r.Context(), client, params.Get("scope"))
+	if err == nil {
+		_, err = narrowGrant(r.Context(), svc, nil, params, scope)
+	}
+	if err != nil {
+		writeRequestError(w, r, err)
+		return false
+	}
+	return true
+}
+
+// validCodeChallenge 按osin校验授权请求的规则检查PKCE参数：未指定方式时为plain，
+// 挑战为RFC 7636第4.2节规定的43到128个字符
+func validCodeChallenge(params url.Values) bool {
+	method := params.Get("code_challenge_method")
+	if method == "" {
+		method = osin.PKCE_PLAIN
+	}
+	return util.InArray(codeChallengeMethods, method) && codeChallengePattern.MatchString(params.Get("code_challenge"))
+}
+
+// writeRequestError 按requestError输出校验推送的授权请求失败的错误，服务端错误同时记录原始错误
+func writeRequestError(w http.ResponseWriter, r *http.Request, err error) {
+	code, description := requestError(err)
+	if code == osin.E_SERVER_ERROR {
+		logx.WithContext(r.Context()).Errorf("Check pushed authorization request failed: %v", err)
+		util.WriteError(w, r, http.StatusInternalServerError, code, description)
+		return
+	}
+	util.WriteError(w, r, http.StatusBadRequest, code, description)
+}
+
+// usePushedRequest 请求的request_uri引用推送的授权请求时，以推送的参数代替请求中的参数，
+// RFC 9126第4节要求授权接口只使用推送的参数。返回使用的推送请求，没有引用推送请求时为nil，
+// 其他request_uri留给useRequestObject处理；request_uri无效、已使用或已过期时在resp中设置错误并返回false
+func usePushedRequest(svc *svc.ServiceContext, resp *osin.Response, r *http.Request) (*service.PushedRequest, bool) {
+	if err := r.ParseForm(); err != nil {
+		resp.SetError(osin.E_INVALID_REQUEST, "无法解析请求参数")
+		return nil, false
+	}
+	requestURI := r.Form.Get("request_uri")
+	if !service.IsPushedRequestURI(requestURI) {
+		return nil, true
+	}
+
+	request, err := svc.Storage.TakePushedRequest(r.Context(), requestURI)
+	switch {
+	case errors.Is(err, osin.ErrNotFound):
+		resp.SetError(service.ErrInvalidRequestURI, "request_uri无效、已使用或已过期")
+		return nil, false
+	case err != nil:
+		resp.SetError(osin.E_SERVER_ERROR, "加载推送的授权请求失败")
+		resp.InternalError = err
+		return nil, false
+	case request.ClientId != r.Form.Get("client_id"):
+		resp.SetError(osin.E_INVALID_REQUEST, "request_uri不属于该客户端")
+		return nil, false
+	}
+	r.Form = request.Params
+	return request, true
+}
+
+// useRequestObject 请求携带RFC 9101请求对象(request或request_uri)时，校验请求对象并以其中的参数覆盖请求中的同名参数。
+// 返回是否使用了请求对象；请求对象无效时在resp中设置错误并返回false
+func useRequestObject(svc *svc.ServiceContext, resp *osin.Response, r *http.Request) (bool, bool) {
+	if r.Form.Get("request") == "" && r.Form.Get("request_uri") == "" {
+		return false, true
+	}
+	client, err := svc.OAuthServer.Storage.GetClient(r.Form.Get("client_id"))
+	if errors.Is(err, osin.ErrNotFound) {
+		resp.SetError(osin.E_UNAUTHORIZED_CLIENT, "客户端未授权")
+		return false, false
+	} else if err != nil {
+		resp.SetError(osin.E_SERVER_ERROR, "加载客户端失败")
+		resp.InternalError = err
+		return false, false
+	}
+
+	params, signed, err := svc.Requests.Resolve(r.Context(), service.AsClient(client), r.Form)
+	if err != nil {
+		setRequestError(resp, err)
+		return false, false
+	}
+	r.Form = params
+	return signed, true
+}
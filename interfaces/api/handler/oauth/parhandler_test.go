@@ -0,0 +1,78 @@
+package oauth_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"oauth2/application/service"
+	"oauth2/infrastructure/config"
+	"oauth2/infrastructure/svc"
+	"oauth2/interfaces/api/handler/oauth"
+
+	"github.com/zeromicro/go-zero/core/conf"
+)
+
+// newServiceContext 创建使用内存存储、不依赖数据库和Redis的服务上下文
+func newServiceContext(t *testing.T) *svc.ServiceContext {
+	t.Helper()
+	var c config.Config
+	// httptest的请求来自192.0.2.1，作为可信代理转发用户IP
+	yaml := "Name: oauth2-test\nPort: 8888\nDomain: http://localhost:8888\nTrustedProxies: [192.0.2.1]\nStorage:\n  Backend: memory\n"
+	if err := conf.LoadFromYamlBytes([]byte(yaml), &c); err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	return svc.NewServiceContext(c)
+}
+
+// newClient 保存一个机密客户端
+func newClient(t *testing.T, ctx *svc.ServiceContext, client *service.Client) *service.Client {
+	t.Helper()
+	client.Type, client.Secret = service.ClientTypeConfidential, "secret-"+client.Id
+	if client.RedirectUri == "" {
+		client.RedirectUri = "http://localhost/" + client.Id
+	}
+	if err := ctx.Storage.CreateClient(client); err != nil {
+		t.Fatalf("CreateClient(%s): %v", client.Id, err)
+	}
+	return client
+}
+
+// postForm 以客户端的Basic认证提交表单
+func postForm(handler http.HandlerFunc, client *service.Client, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(client.Id, client.Secret)
+	w := httptest.NewRecorder()
+	handler(w, req)
+	return w
+}
+
+func TestPushedAuthorizationPKCE(t *testing.T) {
+	ctx := newServiceContext(t)
+	client := newClient(t, ctx, &service.Client{Id: "par", RequirePkce: true})
+	handler := oauth.PushedAuthorizationHandler(ctx)
+
+	challenge := strings.Repeat("a", 43)
+	for _, tt := range []struct {
+		name   string
+		params url.Values
+		status int
+	}{
+		{"missing challenge", url.Values{}, http.StatusBadRequest},
+		{"short challenge", url.Values{"code_challenge": {"short"}}, http.StatusBadRequest},
+		{"unsupported method", url.Values{"code_challenge": {challenge}, "code_challenge_method": {"S512"}}, http.StatusBadRequest},
+		{"plain", url.Values{"code_challenge": {challenge}}, http.StatusCreated},
+		{"S256", url.Values{"code_challenge": {challenge}, "code_challenge_method": {"S256"}}, http.StatusCreated},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.params.Set("response_type", "code")
+			w := postForm(handler, client, tt.params)
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+		})
+	}
+}
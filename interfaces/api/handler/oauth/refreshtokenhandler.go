@@ -3,9 +3,11 @@ package oauth
 import (
 	"encoding/json"
 	"net/http"
+	"oauth2/application/service"
 	"oauth2/infrastructure/svc"
 
 	"github.com/openshift/osin"
+	"github.com/zeromicro/go-zero/core/logx"
 )
 
 // RefreshTokenHandler 处理刷新token的请求
@@ -35,7 +37,17 @@ func RefreshTokenHandler(svc *svc.ServiceContext) http.HandlerFunc {
 		}
 
 		// 初始化 OAuth 服务器
-		server := newOAuthServer(svc)
+		server := svc.OAuthServer
+		resp := server.NewResponse()
+		defer resp.Close()
+
+		// 与令牌接口一样先认证客户端，泄露的刷新令牌没有客户端密钥无法使用
+		client, ok := authenticateClient(svc, r)
+		if !ok {
+			resp.SetError(osin.E_INVALID_CLIENT, "客户端认证失败")
+			writeTokenResponse(w, r, resp)
+			return
+		}
 
 		// 加载refresh token对应的访问数据
 		accessData, err := server.Storage.LoadRefresh(refreshToken)
@@ -47,45 +59,87 @@ func RefreshTokenHandler(svc *svc.ServiceContext) http.HandlerFunc {
 			})
 			return
 		}
+		// 刷新令牌只能由签发时的客户端使用
+		if accessData.Client.GetId() != client.GetId() {
+			resp.SetError(osin.E_INVALID_CLIENT, "客户端认证失败")
+			writeTokenResponse(w, r, resp)
+			return
+		}
 
-		// 创建新的访问令牌请求
+		if !checkClient(resp, client, osin.REFRESH_TOKEN) {
+			writeTokenResponse(w, r, resp)
+			return
+		}
+		// 刷新只能缩小原有的权限范围，且权限范围仍须已注册、客户端允许
+		scope, err := service.NarrowScope(accessData.Scope, r.Form.Get("scope"))
+		if err == nil {
+			err = svc.Scopes.Validate(r.Context(), client, scope)
+		}
+		// 受众和授权详情只能从授权的范围中选择
+		var userData interface{}
+		if err == nil {
+			userData, err = narrowGrant(r.Context(), svc, accessData.UserData, r.Form, scope)
+		}
+		if err != nil {
+			setRequestError(resp, err)
+			writeTokenResponse(w, r, resp)
+			return
+		}
+
+		// 创建新的访问令牌请求，带上旧的令牌使新令牌沿用原授权
 		ar := &osin.AccessRequest{
 			Type:            osin.REFRESH_TOKEN,
 			Code:            "",
 			Client:          accessData.Client,
+			AccessData:      accessData,
 			RedirectUri:     accessData.RedirectUri,
-			Scope:           r.Form.Get("scope"),
+			Scope:           scope,
+			UserData:        userData,
 			GenerateRefresh: true,
 			Authorized:      true,
-			Expiration:      server.Config.AccessExpiration,
-		}
-
-		// 处理访问令牌请求
-		resp := server.NewResponse()
-		defer resp.Close()
-
-		// 移除旧的访问令牌
-		if err := server.Storage.RemoveAccess(accessData.AccessToken); err != nil {
-			resp.SetError("server_error", "无法移除旧的访问令牌")
-			osin.OutputJSON(resp, w, r)
-			return
+			Expiration:      accessExpiration(client, server.Config.AccessExpiration),
 		}
 
-		// 移除旧的刷新令牌
-		if err := server.Storage.RemoveRefresh(refreshToken); err != nil {
-			resp.SetError("server_error", "无法移除旧的刷新令牌")
-			osin.OutputJSON(resp, w, r)
-			return
-		}
-
-		// 生成新的访问令牌
+		// 生成新的访问令牌，再撤销旧的刷新令牌及同时签发的访问令牌
 		server.FinishAccessRequest(resp, r, ar)
+		redeemRefresh(svc, r, resp, ar)
+		outputAuthorizationDetails(resp, ar.UserData)
 		if resp.IsError {
-			osin.OutputJSON(resp, w, r)
+			writeTokenResponse(w, r, resp)
 			return
 		}
 
 		// 返回新的令牌
-		osin.OutputJSON(resp, w, r)
+		writeTokenResponse(w, r, resp)
+	}
+}
+
+// redeemRefresh 撤销刷新所用的刷新令牌及同时签发的访问令牌。同一刷新令牌被并发使用时只有实际撤销了它的请求
+// 得到新令牌，其余请求刚签发的令牌随即撤销并返回invalid_grant
+func redeemRefresh(svc *svc.ServiceContext, r *http.Request, resp *osin.Response, ar *osin.AccessRequest) {
+	if resp.IsError || ar.AccessData == nil {
+		return
+	}
+	result, err := svc.Storage.RevokeRefresh(r.Context(), ar.AccessData.RefreshToken)
+	if err == nil && result.RefreshRevoked {
+		return
+	}
+
+	logger := logx.WithContext(r.Context())
+	if refresh, ok := resp.Output["refresh_token"].(string); ok {
+		if _, err := svc.Storage.RevokeRefresh(r.Context(), refresh); err != nil {
+			logger.Errorf("撤销新签发的刷新令牌失败: %v", err)
+		}
+	}
+	if access, ok := resp.Output["access_token"].(string); ok {
+		if err := svc.Storage.RemoveAccess(access); err != nil {
+			logger.Errorf("撤销新签发的访问令牌失败: %v", err)
+		}
+	}
+	if err != nil {
+		resp.SetError(osin.E_SERVER_ERROR, "撤销刷新令牌失败")
+		resp.InternalError = err
+		return
 	}
+	resp.SetError(osin.E_INVALID_GRANT, "刷新令牌已被使用")
 }
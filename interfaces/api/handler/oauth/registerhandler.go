@@ -0,0 +1,108 @@
+package oauth
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"oauth2/application/service"
+	"oauth2/common/util"
+	"oauth2/infrastructure/svc"
+	"strings"
+
+	"github.com/zeromicro/go-zero/rest/httpx"
+	"github.com/zeromicro/go-zero/rest/pathvar"
+)
+
+// RegisterHandler 处理RFC 7591动态客户端注册请求
+func RegisterHandler(svc *svc.ServiceContext) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		noStore(w)
+
+		var metadata service.ClientMetadata
+		if err := json.NewDecoder(r.Body).Decode(&metadata); err != nil {
+			util.WriteError(w, r, http.StatusBadRequest, service.ErrInvalidClientMetadata, "无法解析客户端元数据")
+			return
+		}
+
+		client, err := svc.Registrar.Register(r.Context(), metadata, bearerToken(r))
+		if err != nil {
+			writeRegistrationError(w, r, err)
+			return
+		}
+
+		httpx.WriteJsonCtx(r.Context(), w, http.StatusCreated, client)
+	}
+}
+
+// ClientConfigurationHandler 处理RFC 7592客户端配置请求，GET读取、PUT更新、DELETE删除注册信息
+func ClientConfigurationHandler(svc *svc.ServiceContext) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		noStore(w)
+		clientID := pathvar.Vars(r)["client_id"]
+		token := bearerToken(r)
+
+		var (
+			client *service.RegisteredClient
+			err    error
+		)
+		switch r.Method {
+		case http.MethodGet:
+			client, err = svc.Registrar.Read(r.Context(), clientID, token)
+		case http.MethodPut:
+			var body struct {
+				service.ClientMetadata
+				ClientId     string `json:"client_id"`
+				ClientSecret string `json:"client_secret"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				util.WriteError(w, r, http.StatusBadRequest, service.ErrInvalidClientMetadata, "无法解析客户端元数据")
+				return
+			}
+			// 请求体中的client_id必须与地址中的一致
+			if body.ClientId != clientID {
+				util.WriteError(w, r, http.StatusBadRequest, service.ErrInvalidClientMetadata, "client_id与请求地址不一致")
+				return
+			}
+			client, err = svc.Registrar.Update(r.Context(), clientID, token, body.ClientMetadata, body.ClientSecret)
+		case http.MethodDelete:
+			if err = svc.Registrar.Delete(r.Context(), clientID, token); err == nil {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		if err != nil {
+			writeRegistrationError(w, r, err)
+			return
+		}
+
+		httpx.OkJsonCtx(r.Context(), w, client)
+	}
+}
+
+// noStore 响应中包含客户端密钥和注册访问令牌，不允许缓存
+func noStore(w http.ResponseWriter) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+}
+
+// bearerToken 返回Authorization头中的Bearer令牌
+func bearerToken(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return auth[len("Bearer "):]
+	}
+	return ""
+}
+
+// writeRegistrationError 按RFC 7591第3.2.2节输出注册错误
+func writeRegistrationError(w http.ResponseWriter, r *http.Request, err error) {
+	var regErr *service.RegistrationError
+	switch {
+	case errors.As(err, &regErr) && regErr.Code == service.ErrInvalidInitialAccessToken:
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		util.WriteError(w, r, http.StatusUnauthorized, regErr.Code, regErr.Description)
+	case errors.As(err, &regErr):
+		util.WriteError(w, r, http.StatusBadRequest, regErr.Code, regErr.Description)
+	default:
+		util.WriteError(w, r, http.StatusInternalServerError, "server_error", err.Error())
+	}
+}
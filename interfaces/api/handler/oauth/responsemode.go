@@ -0,0 +1,125 @@
+package oauth
+
+import (
+	"crypto/sha256"
+	_ "embed"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"net/http"
+	"oauth2/application/service"
+	"oauth2/common/util"
+	"oauth2/infrastructure/svc"
+
+	"github.com/openshift/osin"
+	"github.com/zeromicro/go-zero/core/logx"
+)
+
+//go:embed formpost.html
+var formPostHTML string
+
+var formPostTemplate = template.Must(template.New("form_post").Parse(formPostHTML))
+
+// formPostScript 自动提交表单的脚本，页面的CSP只允许执行该脚本
+const formPostScript = "document.forms[0].submit()"
+
+// formPostCSP 自动提交表单页面的CSP
+var formPostCSP = func() string {
+	sum := sha256.Sum256([]byte(formPostScript))
+	return fmt.Sprintf("default-src 'none'; script-src 'sha256-%s'; frame-ancestors 'none'",
+		base64.StdEncoding.EncodeToString(sum[:]))
+}()
+
+// formPost 自动提交表单页面的内容
+type formPost struct {
+	Action template.URL
+	Params map[string]string
+	Script template.JS
+}
+
+// responseModeOf 返回授权请求的响应模式：未指定时为query，jwt即query.jwt。响应模式不受支持、
+// 客户端不允许或未配置JARM签名密钥时返回错误描述
+func responseModeOf(svc *svc.ServiceContext, client *service.Client, requested string) (string, string) {
+	mode := service.NormalizeResponseMode(requested)
+	switch {
+	case !util.InArray(service.ResponseModes(true), mode):
+		return service.ResponseModeQuery, "不支持的response_mode"
+	case service.IsJWTResponseMode(mode) && svc.Responses == nil:
+		return service.ResponseModeQuery, "未启用JWT授权响应(JARM)"
+	case !client.AllowsResponseMode(mode):
+		return service.ResponseModeQuery, "客户端不允许使用该response_mode"
+	}
+	return mode, ""
+}
+
+// redirectErrorMode 返回osin校验重定向URI之后发现的错误(如PKCE参数错误)的响应模式：客户端请求的响应模式可用时
+// 按该模式返回，否则按query模式返回
+func redirectErrorMode(svc *svc.ServiceContext, r *http.Request) (string, string) {
+	client, err := svc.OAuthServer.Storage.GetClient(r.Form.Get("client_id"))
+	if err != nil || client == nil {
+		return "", service.ResponseModeQuery
+	}
+	mode, description := responseModeOf(svc, service.AsClient(client), r.Form.Get("response_mode"))
+	if description != "" {
+		return "", service.ResponseModeQuery
+	}
+	return client.GetId(), mode
+}
+
+// writeAuthorizeResponse 按响应模式输出授权接口的响应：重定向到客户端的响应以查询参数、片段或自动提交的表单返回，
+// JWT响应模式(JARM)下先将响应参数签名为JWT，只以response参数返回。重定向URI校验通过前的错误不重定向，
+// 以错误页面告知用户；重定向的错误按RFC 6749第4.1.2.1节带上请求的state
+func writeAuthorizeResponse(w http.ResponseWriter, r *http.Request, svc *svc.ServiceContext, resp *osin.Response,
+	clientID, mode, state string) {
+	if resp.Type != osin.REDIRECT {
+		if resp.IsError {
+			renderAuthorizeError(w, r, resp)
+			return
+		}
+		osin.OutputJSON(resp, w, r)
+		return
+	}
+	if resp.IsError && state != "" {
+		resp.Output["state"] = state
+	}
+
+	if service.IsJWTResponseMode(mode) {
+		response, err := svc.Responses.Sign(clientID, resp.Output)
+		if err != nil {
+			logx.WithContext(r.Context()).Errorf("Sign authorization response failed: %v", err)
+			util.WriteError(w, r, http.StatusInternalServerError, osin.E_SERVER_ERROR, "签名授权响应失败")
+			return
+		}
+		resp.Output = osin.ResponseData{"response": response}
+	}
+
+	switch service.BaseResponseMode(mode) {
+	case service.ResponseModeFormPost:
+		renderFormPost(w, r, resp)
+	case service.ResponseModeFragment:
+		resp.SetRedirectFragment(true)
+		osin.OutputJSON(resp, w, r)
+	default:
+		resp.SetRedirectFragment(false)
+		osin.OutputJSON(resp, w, r)
+	}
+}
+
+// renderFormPost 以自动提交到重定向URI的表单返回授权响应，即OAuth 2.0 Form Post Response Mode。
+// 重定向URI已校验为客户端注册的地址
+func renderFormPost(w http.ResponseWriter, r *http.Request, resp *osin.Response) {
+	page := formPost{
+		Action: template.URL(resp.URL),
+		Params: make(map[string]string, len(resp.Output)),
+		Script: template.JS(formPostScript),
+	}
+	for name, value := range resp.Output {
+		page.Params[name] = fmt.Sprint(value)
+	}
+	noStore(w)
+	w.Header().Set("Content-Security-Policy", formPostCSP)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := formPostTemplate.Execute(w, page); err != nil {
+		logx.WithContext(r.Context()).Errorf("渲染授权响应表单失败: %v", err)
+	}
+}
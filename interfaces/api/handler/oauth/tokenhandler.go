@@ -2,6 +2,7 @@ package oauth
 
 import (
 	"net/http"
+	"oauth2/application/service"
 	"oauth2/infrastructure/svc"
 
 	"github.com/openshift/osin"
@@ -12,58 +13,92 @@ import (
 func TokenHandler(svc *svc.ServiceContext) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		logger := logx.WithContext(r.Context())
-		server := newOAuthServer(svc)
+		server := svc.OAuthServer
 		resp := server.NewResponse()
 		defer resp.Close()
 
+		var clientID string
 		if ar := server.HandleAccessRequest(resp, r); ar != nil {
 			// 验证客户端
 			if ar.Client == nil {
 				resp.SetError("unauthorized_client", "客户端未授权")
-				osin.OutputJSON(resp, w, r)
+				writeTokenResponse(w, r, resp)
 				return
 			}
 
+			client := service.AsClient(ar.Client)
+			clientID = client.GetId()
+			if !checkClient(resp, client, ar.Type) {
+				writeTokenResponse(w, r, resp)
+				return
+			}
+			// 刷新时osin已确保不扩大原有权限范围，这里再校验权限范围仍已注册且客户端允许
+			if err := svc.Scopes.Validate(r.Context(), client, ar.Scope); err != nil {
+				setRequestError(resp, err)
+				writeTokenResponse(w, r, resp)
+				return
+			}
+			// 令牌请求的resource和authorization_details只能从授权时指定的范围中选择，未指定时为授权的全部
+			userData, err := narrowGrant(r.Context(), svc, ar.UserData, r.Form, ar.Scope)
+			if err != nil {
+				setRequestError(resp, err)
+				writeTokenResponse(w, r, resp)
+				return
+			}
+			ar.UserData = userData
+
 			// 根据不同的授权类型进行处理
 			switch ar.Type {
 			case osin.AUTHORIZATION_CODE:
 				// 验证授权码
 				if ar.AuthorizeData == nil {
 					resp.SetError("invalid_grant", "授权码无效或已过期")
-					osin.OutputJSON(resp, w, r)
+					writeTokenResponse(w, r, resp)
 					return
 				}
 			case osin.REFRESH_TOKEN:
 				// 验证刷新令牌
 				if r.FormValue("refresh_token") == "" {
 					resp.SetError("invalid_grant", "刷新令牌无效")
-					osin.OutputJSON(resp, w, r)
+					writeTokenResponse(w, r, resp)
 					return
 				}
 			case osin.CLIENT_CREDENTIALS:
-				// 验证客户端凭证
-				if ar.Client.GetSecret() == "" {
+				// 验证客户端凭证，公开客户端不能使用客户端凭证授权
+				if client.IsPublic() || !client.HasSecret() {
 					resp.SetError("invalid_client", "客户端密钥无效")
-					osin.OutputJSON(resp, w, r)
+					writeTokenResponse(w, r, resp)
 					return
 				}
 			default:
 				resp.SetError("unsupported_grant_type", "不支持的授权类型")
-				osin.OutputJSON(resp, w, r)
+				writeTokenResponse(w, r, resp)
 				return
 			}
 
 			// 授权请求
 			ar.Authorized = true
+			ar.Expiration = accessExpiration(client, ar.Expiration)
 			server.FinishAccessRequest(resp, r, ar)
+			if ar.Type == osin.REFRESH_TOKEN {
+				redeemRefresh(svc, r, resp, ar)
+			}
+			outputAuthorizationDetails(resp, ar.UserData)
+		} else if resp.ErrorId == osin.E_ACCESS_DENIED && r.FormValue("grant_type") == string(osin.REFRESH_TOKEN) {
+			// osin以access_denied拒绝扩大权限范围的刷新请求，RFC 6749第6节要求返回invalid_scope
+			resp.SetError("invalid_scope", "刷新令牌不能扩大权限范围")
+		} else if resp.ErrorId == osin.E_UNAUTHORIZED_CLIENT {
+			// osin以unauthorized_client拒绝未知客户端或密钥错误，RFC 6749第5.2节要求客户端认证失败返回invalid_client
+			resp.SetError(osin.E_INVALID_CLIENT, "客户端认证失败")
 		}
 
 		if resp.IsError {
 			logger.Errorf("Token error: %v", resp.InternalError)
 		} else {
-			logger.Infof("Token granted: %s", resp.Output["access_token"])
+			// 令牌只以摘要保存，日志中也不能出现令牌本身
+			logger.Infof("Token granted: client=%s grant_type=%s", clientID, r.FormValue("grant_type"))
 		}
 
-		osin.OutputJSON(resp, w, r)
+		writeTokenResponse(w, r, resp)
 	}
 }
@@ -0,0 +1,323 @@
+package oauth_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"oauth2/application/service"
+	"oauth2/infrastructure/svc"
+	"oauth2/interfaces/api/handler/account"
+	"oauth2/interfaces/api/handler/oauth"
+
+	"github.com/openshift/osin"
+	"github.com/zeromicro/go-zero/rest/pathvar"
+)
+
+// tokenResponse 令牌接口的响应
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	Error        string `json:"error"`
+}
+
+// issueTokens 为用户签发授权码并通过令牌接口换取令牌
+func issueTokens(t *testing.T, ctx *svc.ServiceContext, client *service.Client, userID string) tokenResponse {
+	t.Helper()
+	code := "code-" + userID + "-" + time.Now().Format(time.RFC3339Nano)
+	if err := ctx.Storage.SaveAuthorize(&osin.AuthorizeData{
+		Client:      client,
+		Code:        code,
+		ExpiresIn:   600,
+		RedirectUri: client.RedirectUri,
+		CreatedAt:   time.Now(),
+		UserData:    userID,
+	}); err != nil {
+		t.Fatalf("SaveAuthorize: %v", err)
+	}
+	return requestToken(t, oauth.TokenHandler(ctx), client, url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {client.RedirectUri},
+	})
+}
+
+// serve 执行请求并返回响应
+func serve(handler http.HandlerFunc, req *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	handler(w, req)
+	return w
+}
+
+// requestToken 请求令牌接口，要求成功
+func requestToken(t *testing.T, handler http.HandlerFunc, client *service.Client, form url.Values) tokenResponse {
+	t.Helper()
+	w := postForm(handler, client, form)
+	var resp tokenResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusOK || resp.AccessToken == "" {
+		t.Fatalf("token request %v: status %d, %s", form, w.Code, w.Body)
+	}
+	return resp
+}
+
+// 两个刷新入口都必须认证客户端，并让旧的访问令牌和刷新令牌失效
+func TestRefreshRevokesPreviousTokens(t *testing.T) {
+	ctx := newServiceContext(t)
+	client := newClient(t, ctx, &service.Client{Id: "refresh"})
+	other := newClient(t, ctx, &service.Client{Id: "other"})
+
+	for name, handler := range map[string]http.HandlerFunc{
+		"token":   oauth.TokenHandler(ctx),
+		"refresh": oauth.RefreshTokenHandler(ctx),
+	} {
+		t.Run(name, func(t *testing.T) {
+			issued := issueTokens(t, ctx, client, "alice")
+			form := url.Values{
+				"grant_type":    {"refresh_token"},
+				"refresh_token": {issued.RefreshToken},
+			}
+
+			// 密钥错误、缺少密钥或使用其他客户端的凭据时拒绝，刷新令牌仍然有效
+			noSecret := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(form.Encode()+"&client_id="+client.Id))
+			noSecret.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			for attempt, w := range map[string]*httptest.ResponseRecorder{
+				"wrong secret": postForm(handler, &service.Client{Id: client.Id, Secret: "wrong"}, form),
+				"no secret":    serve(handler, noSecret),
+				"other client": postForm(handler, other, form),
+			} {
+				var resp tokenResponse
+				if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.AccessToken != "" || resp.Error == "" {
+					t.Fatalf("%s: status %d, %s", attempt, w.Code, w.Body)
+				}
+				// osin对未发送客户端认证的令牌请求返回invalid_request，刷新接口统一返回invalid_client
+				if name == "refresh" && (w.Code != http.StatusUnauthorized || resp.Error != osin.E_INVALID_CLIENT) {
+					t.Fatalf("%s: status %d, %s", attempt, w.Code, w.Body)
+				}
+			}
+			if _, err := ctx.Storage.LoadRefresh(issued.RefreshToken); err != nil {
+				t.Fatalf("LoadRefresh after rejected attempts: %v", err)
+			}
+
+			refreshed := requestToken(t, handler, client, url.Values{
+				"grant_type":    {"refresh_token"},
+				"refresh_token": {issued.RefreshToken},
+			})
+
+			if _, err := ctx.Storage.LoadAccess(issued.AccessToken); !errors.Is(err, osin.ErrNotFound) {
+				t.Fatalf("the previous access token still loads: %v", err)
+			}
+			if _, err := ctx.Storage.LoadRefresh(issued.RefreshToken); !errors.Is(err, osin.ErrNotFound) {
+				t.Fatalf("the previous refresh token still loads: %v", err)
+			}
+			if _, err := ctx.Storage.LoadAccess(refreshed.AccessToken); err != nil {
+				t.Fatalf("LoadAccess of the refreshed token: %v", err)
+			}
+		})
+	}
+}
+
+// 同一刷新令牌被并发使用时只有一个请求得到新令牌，其余请求签发的令牌随即撤销
+func TestConcurrentRefresh(t *testing.T) {
+	ctx := newServiceContext(t)
+	client := newClient(t, ctx, &service.Client{Id: "concurrent"})
+
+	for name, handler := range map[string]http.HandlerFunc{
+		"token":   oauth.TokenHandler(ctx),
+		"refresh": oauth.RefreshTokenHandler(ctx),
+	} {
+		t.Run(name, func(t *testing.T) {
+			userID := "user-" + name
+			issued := issueTokens(t, ctx, client, userID)
+			form := url.Values{
+				"grant_type":    {"refresh_token"},
+				"refresh_token": {issued.RefreshToken},
+			}
+
+			var (
+				wg        sync.WaitGroup
+				mu        sync.Mutex
+				start     = make(chan struct{})
+				refreshed []tokenResponse
+			)
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					<-start
+					w := postForm(handler, client, form)
+					var resp tokenResponse
+					if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+						t.Errorf("status %d, %s", w.Code, w.Body)
+						return
+					}
+					if w.Code == http.StatusOK {
+						mu.Lock()
+						refreshed = append(refreshed, resp)
+						mu.Unlock()
+					} else if resp.Error != osin.E_INVALID_GRANT {
+						t.Errorf("status %d, %s", w.Code, w.Body)
+					}
+				}()
+			}
+			close(start)
+			wg.Wait()
+
+			if len(refreshed) != 1 {
+				t.Fatalf("%d concurrent refreshes succeeded", len(refreshed))
+			}
+			if _, err := ctx.Storage.LoadAccess(refreshed[0].AccessToken); err != nil {
+				t.Fatalf("LoadAccess of the refreshed token: %v", err)
+			}
+			grants, err := ctx.Storage.ListUserTokens(context.Background(), userID)
+			if err != nil {
+				t.Fatalf("ListUserTokens: %v", err)
+			}
+			if len(grants) != 1 || len(grants[0].Tokens) != 2 {
+				t.Fatalf("tokens left after concurrent refreshes: %+v", grants)
+			}
+		})
+	}
+}
+
+// 刷新得到的令牌沿用原授权，按授权列出和撤销时仍是同一个授权
+func TestRefreshKeepsGrant(t *testing.T) {
+	ctx := newServiceContext(t)
+	client := newClient(t, ctx, &service.Client{Id: "grant"})
+
+	for name, handler := range map[string]http.HandlerFunc{
+		"token":   oauth.TokenHandler(ctx),
+		"refresh": oauth.RefreshTokenHandler(ctx),
+	} {
+		t.Run(name, func(t *testing.T) {
+			userID := "user-" + name
+			issued := issueTokens(t, ctx, client, userID)
+			grantID := userGrant(t, ctx, userID)
+			requestToken(t, handler, client, url.Values{
+				"grant_type":    {"refresh_token"},
+				"refresh_token": {issued.RefreshToken},
+			})
+			if got := userGrant(t, ctx, userID); got != grantID {
+				t.Fatalf("refresh created grant %s, want %s", got, grantID)
+			}
+		})
+	}
+}
+
+// userGrant 返回用户唯一的授权，用户的令牌必须都属于同一个授权
+func userGrant(t *testing.T, ctx *svc.ServiceContext, userID string) string {
+	t.Helper()
+	list, err := ctx.Storage.ListUserTokens(context.Background(), userID)
+	if err != nil {
+		t.Fatalf("ListUserTokens: %v", err)
+	}
+	grants := make(map[string]struct{})
+	for _, client := range list {
+		for _, token := range client.Tokens {
+			grants[token.GrantId] = struct{}{}
+		}
+	}
+	if len(grants) != 1 {
+		t.Fatalf("user %s has grants %v, want exactly one: %+v", userID, grants, list)
+	}
+	for grantID := range grants {
+		return grantID
+	}
+	return ""
+}
+
+// 刷新后用户仍只看到一个授权，撤销该授权后刷新得到的令牌立即失效
+func TestRevokeGrantAfterRefresh(t *testing.T) {
+	ctx := newServiceContext(t)
+	client := newClient(t, ctx, &service.Client{Id: "revoke"})
+	token := oauth.TokenHandler(ctx)
+
+	tokens := issueTokens(t, ctx, client, "alice")
+	for i := 0; i < 2; i++ {
+		tokens = requestToken(t, token, client, url.Values{
+			"grant_type":    {"refresh_token"},
+			"refresh_token": {tokens.RefreshToken},
+		})
+	}
+
+	userCtx := context.WithValue(context.Background(), "user_id", "alice")
+	w := httptest.NewRecorder()
+	account.ConnectionsHandler(ctx)(w, httptest.NewRequest(http.MethodGet, "/connections", nil).WithContext(userCtx))
+	var connections struct {
+		Connections []service.Connection `json:"connections"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &connections); err != nil || len(connections.Connections) != 1 {
+		t.Fatalf("connections after refresh: %v, %s", err, w.Body)
+	}
+
+	grantID := connections.Connections[0].GrantId
+	req := httptest.NewRequest(http.MethodDelete, "/connections/"+grantID, nil).WithContext(userCtx)
+	w = httptest.NewRecorder()
+	account.RevokeConnectionHandler(ctx)(w, pathvar.WithVars(req, map[string]string{"grant_id": grantID}))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("revoke connection: status %d, %s", w.Code, w.Body)
+	}
+
+	if _, err := ctx.Storage.LoadAccess(tokens.AccessToken); !errors.Is(err, osin.ErrNotFound) {
+		t.Fatalf("the refreshed access token survives revoking its grant: %v", err)
+	}
+	if _, err := ctx.Storage.LoadRefresh(tokens.RefreshToken); !errors.Is(err, osin.ErrNotFound) {
+		t.Fatalf("the refreshed refresh token survives revoking its grant: %v", err)
+	}
+	if list, err := ctx.Storage.ListUserTokens(context.Background(), "alice"); err != nil || len(list) != 0 {
+		t.Fatalf("ListUserTokens after revoke: %+v, %v", list, err)
+	}
+}
+
+// 授权的最近使用记录在刷新后保留，刷新得到的令牌继续更新同一条记录
+func TestUsageSurvivesRefresh(t *testing.T) {
+	ctx := newServiceContext(t)
+	client := newClient(t, ctx, &service.Client{Id: "usage"})
+	verify := oauth.VerifyTokenHandler(ctx)
+	use := func(accessToken, ip string) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/verify", nil)
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+		req.Header.Set("X-Forwarded-For", ip)
+		w := httptest.NewRecorder()
+		verify(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("verify: status %d, %s", w.Code, w.Body)
+		}
+	}
+	lastUsedIp := func() string {
+		t.Helper()
+		list, err := ctx.Storage.ListUserTokens(context.Background(), "alice")
+		if err != nil || len(list) != 1 {
+			t.Fatalf("ListUserTokens: %+v, %v", list, err)
+		}
+		ip := list[0].Tokens[0].LastUsedIp
+		for _, token := range list[0].Tokens {
+			if token.LastUsedAt == nil || token.LastUsedIp != ip {
+				t.Fatalf("tokens of one grant report different usage: %+v", list[0].Tokens)
+			}
+		}
+		return ip
+	}
+
+	issued := issueTokens(t, ctx, client, "alice")
+	use(issued.AccessToken, "203.0.113.7")
+	refreshed := requestToken(t, oauth.TokenHandler(ctx), client, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {issued.RefreshToken},
+	})
+	if ip := lastUsedIp(); ip != "203.0.113.7" {
+		t.Fatalf("usage after refresh: last used from %q", ip)
+	}
+
+	use(refreshed.AccessToken, "198.51.100.2")
+	if ip := lastUsedIp(); ip != "198.51.100.2" {
+		t.Fatalf("usage of the refreshed token: last used from %q", ip)
+	}
+}
@@ -2,6 +2,8 @@ package oauth
 
 import (
 	"net/http"
+	"oauth2/application/service"
+	"oauth2/common/util"
 	"oauth2/infrastructure/svc"
 	"time"
 
@@ -12,7 +14,7 @@ import (
 func VerifyTokenHandler(svc *svc.ServiceContext) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// 初始化 OAuth 服务器
-		server := newOAuthServer(svc)
+		server := svc.OAuthServer
 		resp := server.NewResponse()
 		defer resp.Close()
 
@@ -26,8 +28,8 @@ func VerifyTokenHandler(svc *svc.ServiceContext) http.HandlerFunc {
 		}
 		accessToken := authHeader[7:]
 
-		// 加载访问令牌
-		accessData, err := server.Storage.LoadAccess(accessToken)
+		// 加载访问令牌，并记录令牌的使用时间和调用方转发的用户IP
+		accessData, err := loadAccess(svc, accessToken, r)
 		if err != nil {
 			resp.SetError("invalid_token", "访问令牌无效或已过期")
 			resp.StatusCode = http.StatusUnauthorized
@@ -44,6 +46,15 @@ func VerifyTokenHandler(svc *svc.ServiceContext) http.HandlerFunc {
 			return
 		}
 
+		// 资源服务器传入resource时，只接受受众包含该资源的令牌
+		aud := service.GrantDataOf(accessData.UserData).TokenAudience()
+		if resource := r.URL.Query().Get("resource"); resource != "" && !util.InArray(aud, resource) {
+			resp.SetError("invalid_token", "访问令牌的受众不包含该资源")
+			resp.StatusCode = http.StatusUnauthorized
+			osin.OutputJSON(resp, w, r)
+			return
+		}
+
 		// 设置响应
 		resp.Output = map[string]interface{}{
 			"valid":       true,
@@ -52,7 +63,27 @@ func VerifyTokenHandler(svc *svc.ServiceContext) http.HandlerFunc {
 			"expires_in":  expiresIn,
 			"create_time": accessData.CreatedAt.Format(time.RFC3339),
 		}
+		if len(aud) > 0 {
+			resp.Output["aud"] = audience(aud)
+		}
 
 		osin.OutputJSON(resp, w, r)
 	}
 }
+
+// loadAccess 加载访问令牌，启用使用记录时同时记录令牌的使用时间和用户IP，
+// 用户IP只从可信代理转发的请求头中获取
+func loadAccess(svc *svc.ServiceContext, token string, r *http.Request) (*osin.AccessData, error) {
+	if svc.Usage != nil {
+		return svc.Usage.LoadAccessFrom(token, svc.Proxies.ClientIp(r))
+	}
+	return svc.OAuthServer.Storage.LoadAccess(token)
+}
+
+// audience 按JWT的aud惯例输出受众，只有一个资源时为字符串
+func audience(resources []string) interface{} {
+	if len(resources) == 1 {
+		return resources[0]
+	}
+	return resources
+}
@@ -6,6 +6,8 @@ import (
 	"github.com/zeromicro/go-zero/rest"
 
 	"oauth2/infrastructure/svc"
+	"oauth2/interfaces/api/handler/account"
+	"oauth2/interfaces/api/handler/admin"
 	"oauth2/interfaces/api/handler/oauth"
 )
 
@@ -14,11 +16,6 @@ func RegisterHandlers(server *rest.Server, svc *svc.ServiceContext) {
 	// 工具
 	server.AddRoutes(
 		[]rest.Route{
-			{
-				Method:  http.MethodGet,
-				Path:    "/v1/oauth/authorize",
-				Handler: oauth.AuthorizeHandler(svc),
-			},
 			{
 				Method:  http.MethodPost,
 				Path:    "/v1/oauth/token",
@@ -44,6 +41,165 @@ func RegisterHandlers(server *rest.Server, svc *svc.ServiceContext) {
 				Path:    "/v1/oauth/verify",
 				Handler: oauth.VerifyTokenHandler(svc),
 			},
+			{
+				Method:  http.MethodPost,
+				Path:    "/v1/oauth/introspect",
+				Handler: oauth.IntrospectHandler(svc),
+			},
+			{
+				Method:  http.MethodPost,
+				Path:    "/v1/oauth/par",
+				Handler: oauth.PushedAuthorizationHandler(svc),
+			},
+		},
+	)
+
+	// RFC 8414 授权服务器元数据和服务端公钥集
+	server.AddRoutes(
+		[]rest.Route{
+			{
+				Method:  http.MethodGet,
+				Path:    "/.well-known/oauth-authorization-server",
+				Handler: oauth.MetadataHandler(svc),
+			},
+			{
+				Method:  http.MethodGet,
+				Path:    "/.well-known/jwks.json",
+				Handler: oauth.JwksHandler(svc),
+			},
 		},
 	)
+
+	// 动态客户端注册及客户端配置
+	if svc.Registrar != nil {
+		server.AddRoutes([]rest.Route{
+			{
+				Method:  http.MethodPost,
+				Path:    "/v1/oauth/register",
+				Handler: oauth.RegisterHandler(svc),
+			},
+			{
+				Method:  http.MethodGet,
+				Path:    "/v1/oauth/register/:client_id",
+				Handler: oauth.ClientConfigurationHandler(svc),
+			},
+			{
+				Method:  http.MethodPut,
+				Path:    "/v1/oauth/register/:client_id",
+				Handler: oauth.ClientConfigurationHandler(svc),
+			},
+			{
+				Method:  http.MethodDelete,
+				Path:    "/v1/oauth/register/:client_id",
+				Handler: oauth.ClientConfigurationHandler(svc),
+			},
+		})
+	}
+
+	// 配置用户JWT后，授权需要用户登录，签发的令牌记录所属用户
+	authorize := rest.Route{
+		Method:  http.MethodGet,
+		Path:    "/v1/oauth/authorize",
+		Handler: oauth.AuthorizeHandler(svc),
+	}
+	if svc.Config.Auth.AccessSecret == "" {
+		server.AddRoutes([]rest.Route{authorize})
+	} else {
+		server.AddRoutes([]rest.Route{authorize}, rest.WithJwt(svc.Config.Auth.AccessSecret))
+	}
+	// 同意页面以表单提交用户的决定，用户由页面中签名的票据确定，不经过JWT认证
+	if svc.Consent != nil {
+		server.AddRoutes([]rest.Route{
+			{
+				Method:  http.MethodPost,
+				Path:    "/v1/oauth/authorize",
+				Handler: oauth.AuthorizeHandler(svc),
+			},
+		})
+	}
+
+	// 用户接口，需要使用 Auth.AccessSecret 签发、带有 user_id 的JWT
+	if svc.Config.Auth.AccessSecret != "" {
+		server.AddRoutes(
+			[]rest.Route{
+				{
+					Method:  http.MethodGet,
+					Path:    "/connections",
+					Handler: account.ConnectionsHandler(svc),
+				},
+				{
+					Method:  http.MethodDelete,
+					Path:    "/connections/:grant_id",
+					Handler: account.RevokeConnectionHandler(svc),
+				},
+			},
+			rest.WithJwt(svc.Config.Auth.AccessSecret),
+			rest.WithPrefix("/v1/account"),
+		)
+	}
+
+	// 管理接口，需要使用 Admin.AccessSecret 签发的JWT
+	if svc.Config.Admin.AccessSecret != "" {
+		server.AddRoutes(
+			[]rest.Route{
+				{
+					Method:  http.MethodGet,
+					Path:    "/users/tokens",
+					Handler: admin.ListUserTokensHandler(svc),
+				},
+				{
+					Method:  http.MethodPost,
+					Path:    "/users/revoke",
+					Handler: admin.RevokeUserTokensHandler(svc),
+				},
+				{
+					Method:  http.MethodGet,
+					Path:    "/scopes",
+					Handler: admin.ListScopesHandler(svc),
+				},
+				{
+					Method:  http.MethodPost,
+					Path:    "/scopes",
+					Handler: admin.SaveScopeHandler(svc),
+				},
+				{
+					Method:  http.MethodDelete,
+					Path:    "/scopes/:name",
+					Handler: admin.RemoveScopeHandler(svc),
+				},
+				{
+					Method:  http.MethodGet,
+					Path:    "/resources",
+					Handler: admin.ListResourcesHandler(svc),
+				},
+				{
+					Method:  http.MethodPost,
+					Path:    "/resources",
+					Handler: admin.SaveResourceHandler(svc),
+				},
+				{
+					Method:  http.MethodDelete,
+					Path:    "/resources",
+					Handler: admin.RemoveResourceHandler(svc),
+				},
+				{
+					Method:  http.MethodGet,
+					Path:    "/authorization-detail-types",
+					Handler: admin.ListDetailTypesHandler(svc),
+				},
+				{
+					Method:  http.MethodPost,
+					Path:    "/authorization-detail-types",
+					Handler: admin.SaveDetailTypeHandler(svc),
+				},
+				{
+					Method:  http.MethodDelete,
+					Path:    "/authorization-detail-types/:type",
+					Handler: admin.RemoveDetailTypeHandler(svc),
+				},
+			},
+			rest.WithJwt(svc.Config.Admin.AccessSecret),
+			rest.WithPrefix("/v1/admin"),
+		)
+	}
 }